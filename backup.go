@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/notifications"
+)
+
+// Scheduled backups are named by time, so that sorting by name sorts by age
+const BACKUP_TIME_FORMAT = "20060102-150405"
+
+// RunScheduledBackups writes a backup of the DB every -backup-interval, keeping
+// the newest -backup-keep of them
+func (bb *BakinBacon) RunScheduledBackups(shutdownChannel <-chan interface{}, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if bb.backupInterval <= 0 {
+		log.Info("Scheduled backups disabled")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Dir": bb.backupDir, "Interval": bb.backupInterval, "Keep": bb.backupKeep,
+	}).Info("Scheduled backups enabled")
+
+	ticker := time.NewTicker(bb.backupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := bb.scheduledBackup(); err != nil {
+				log.WithError(err).Error("Scheduled backup failed")
+				bb.SendNotification(fmt.Sprintf("Scheduled database backup failed: %s", err), notifications.BACKUP)
+			}
+
+		case <-shutdownChannel:
+			return
+		}
+	}
+}
+
+func (bb *BakinBacon) scheduledBackup() error {
+
+	if err := os.MkdirAll(bb.backupDir, 0700); err != nil {
+		return errors.Wrap(err, "Unable to create backup directory")
+	}
+
+	backupFile := filepath.Join(bb.backupDir, fmt.Sprintf("bakinbacon-%s-%s.db", bb.network, time.Now().UTC().Format(BACKUP_TIME_FORMAT)))
+
+	if err := bb.Storage.BackupToFile(backupFile); err != nil {
+		return errors.Wrap(err, "Unable to write backup")
+	}
+
+	log.WithField("File", backupFile).Info("Backed up database")
+
+	// Retention; Only our own backups for this network are considered
+	backups, err := filepath.Glob(filepath.Join(bb.backupDir, fmt.Sprintf("bakinbacon-%s-*.db", bb.network)))
+	if err != nil {
+		return errors.Wrap(err, "Unable to list backups")
+	}
+
+	sort.Strings(backups)
+
+	for len(backups) > bb.backupKeep && bb.backupKeep > 0 {
+
+		if err := os.Remove(backups[0]); err != nil {
+			return errors.Wrap(err, "Unable to remove old backup")
+		}
+
+		log.WithField("File", backups[0]).Debug("Removed old backup")
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
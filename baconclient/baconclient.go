@@ -1,6 +1,7 @@
 package baconclient
 
 import (
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -10,9 +11,12 @@ import (
 
 	"github.com/bakingbacon/go-tezos/v4/forge"
 	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/go-resty/resty/v2"
 	log "github.com/sirupsen/logrus"
 
 	"bakinbacon/baconsigner"
+	"bakinbacon/events"
+	"bakinbacon/metrics"
 	"bakinbacon/notifications"
 	"bakinbacon/storage"
 	"bakinbacon/util"
@@ -32,6 +36,7 @@ type BaconSlice struct {
 type BaconClient struct {
 	NewBlockNotifier    chan *rpc.Block
 	NotificationHandler *notifications.NotificationHandler
+	Events              *events.EventBus
 	Storage             *storage.Storage
 	Current             *BaconSlice
 	rpcClients          []*BaconSlice
@@ -46,15 +51,16 @@ type BaconClient struct {
 	waitGroup         *sync.WaitGroup
 }
 
-func New(nh *notifications.NotificationHandler, db *storage.Storage, nc *util.NetworkConstants, shutdown chan interface{}, wg *sync.WaitGroup) (*BaconClient, error) {
+func New(nh *notifications.NotificationHandler, eb *events.EventBus, db *storage.Storage, nc *util.NetworkConstants, shutdown chan interface{}, wg *sync.WaitGroup) (*BaconClient, error) {
 
 	// Make new client manager
 	newBaconClient := &BaconClient{
 		NewBlockNotifier:    make(chan *rpc.Block, 1),
 		NotificationHandler: nh,
+		Events:              eb,
 		Storage:             db,
 		rpcClients:          make([]*BaconSlice, 0),
-		Status:              &BaconStatus{},
+		Status:              &BaconStatus{events: eb},
 		timeBetweenBlocks:   nc.TimeBetweenBlocks,
 		globalShutdown:      shutdown,
 		waitGroup:           wg,
@@ -102,6 +108,20 @@ func (b *BaconClient) AddRpc(rpcId int, rpcEndpointUrl string) {
 		log.WithField("Endpoint", rpcEndpointUrl).Info("Connected to RPC")
 	}
 
+	// Instrument all requests to this endpoint; keep the same headers/timeout as go-tezos
+	if gtRpc != nil {
+		restyClient := resty.NewWithClient(&http.Client{
+			Transport: &metrics.InstrumentedTransport{
+				Endpoint: gtRpc.Host,
+				Base:     http.DefaultTransport,
+			},
+		})
+		restyClient.SetHeader("User-Agent", "BakinBacon/1.0.1")
+		restyClient.SetTimeout(30 * time.Second)
+
+		gtRpc.OverrideClient(restyClient)
+	}
+
 	newBaconSlice := &BaconSlice{
 		gtRpc,
 		rpcId,
@@ -112,6 +132,11 @@ func (b *BaconClient) AddRpc(rpcId int, rpcEndpointUrl string) {
 	// Add client to list
 	b.rpcClients = append(b.rpcClients, newBaconSlice)
 
+	b.Events.Publish(events.ENDPOINT_HEALTH, events.EndpointEvent{
+		Endpoint: rpcEndpointUrl,
+		Active:   active,
+	})
+
 	// Launch client
 	b.waitGroup.Add(1)
 	go b.blockWatch(newBaconSlice)
@@ -158,9 +183,14 @@ func (b *BaconClient) blockWatch(client *BaconSlice) {
 		var block *rpc.Block
 		var err error
 
-		if lostTicks > 4 {
+		if lostTicks > 4 && client.isActive {
 			log.WithField("Endpoint", client.Host).Warn("Lost Sync, Marking inactive")
 			client.isActive = false
+
+			b.Events.Publish(events.ENDPOINT_HEALTH, events.EndpointEvent{
+				Endpoint: client.Host,
+				Active:   false,
+			})
 		}
 
 		// watch for new head block
@@ -184,10 +214,20 @@ func (b *BaconClient) blockWatch(client *BaconSlice) {
 				block.Hash != b.Status.Hash {
 
 				lostTicks = 0
+
+				if !client.isActive {
+					b.Events.Publish(events.ENDPOINT_HEALTH, events.EndpointEvent{
+						Endpoint: client.Host,
+						Active:   true,
+					})
+				}
+
 				client.isActive = true
 
 				if client.isActive {
 
+					metrics.HeadLevel.Set(float64(block.Metadata.Level.Level))
+
 					// notify new block
 					b.NewBlockNotifier <- block
 
@@ -211,6 +251,12 @@ func (b *BaconClient) blockWatch(client *BaconSlice) {
 						"Hash":    block.Hash,
 						"ChainID": block.ChainID,
 					}).Info("New Block")
+
+					b.Events.Publish(events.NEW_HEAD, events.HeadEvent{
+						Level: block.Metadata.Level.Level,
+						Cycle: block.Metadata.Level.Cycle,
+						Hash:  block.Hash,
+					})
 				}
 
 			} else {
@@ -235,6 +281,63 @@ func (b *BaconClient) blockWatch(client *BaconSlice) {
 	}
 }
 
+// HealthyClients returns all RPC clients which are currently in sync with the
+// network. The current client is always first. Used for spreading large numbers
+// of RPC requests across multiple endpoints.
+func (b *BaconClient) HealthyClients() []*BaconSlice {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	healthy := make([]*BaconSlice, 0, len(b.rpcClients))
+	if b.Current != nil {
+		healthy = append(healthy, b.Current)
+	}
+
+	for _, bslice := range b.rpcClients {
+		if bslice.isActive && bslice != b.Current {
+			healthy = append(healthy, bslice)
+		}
+	}
+
+	return healthy
+}
+
+// EndpointStatus is the health of one RPC endpoint
+type EndpointStatus struct {
+	Id      int    `json:"id"`
+	Url     string `json:"url"`
+	Active  bool   `json:"active"`
+	Current bool   `json:"current"`
+}
+
+// Endpoints returns the health of each RPC endpoint, in the order they were added
+func (b *BaconClient) Endpoints() []EndpointStatus {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	endpoints := make([]EndpointStatus, 0, len(b.rpcClients))
+
+	for _, bslice := range b.rpcClients {
+
+		status := EndpointStatus{
+			Id:      bslice.clientId,
+			Active:  bslice.isActive,
+			Current: bslice == b.Current,
+		}
+
+		// Nil if the URL could not be parsed
+		if bslice.Client != nil {
+			status.Url = bslice.Host
+		}
+
+		endpoints = append(endpoints, status)
+	}
+
+	return endpoints
+}
+
 func (b *BaconClient) HeadHash() string {
 	return b.Status.Hash
 }
@@ -371,6 +474,8 @@ func (b *BaconClient) GetSpendableBalance() (int, error) {
 		return 0, errors.Wrap(err, "Unable to parse baker balance")
 	}
 
+	metrics.SpendableBalance.Set(float64(balance))
+
 	return balance, nil
 }
 
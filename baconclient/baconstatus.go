@@ -1,5 +1,9 @@
 package baconclient
 
+import (
+	"bakinbacon/events"
+)
+
 const (
 
 	// Various states for the UI to take action
@@ -33,6 +37,8 @@ type BaconStatus struct {
 
 	State    string `json:"state"`
 	ErrorMsg string `json:"error"`
+
+	events *events.EventBus
 }
 
 func (b *BaconStatus) SetNextEndorsement(level, cycle int) {
@@ -60,6 +66,7 @@ func (b *BaconStatus) SetRecentBake(level, cycle int, hash string) {
 
 func (b *BaconStatus) SetError(e error) {
 	b.ErrorMsg = e.Error()
+	b.events.Publish(events.ERROR, events.ErrorEvent{Message: b.ErrorMsg})
 }
 
 func (b *BaconStatus) ClearError() {
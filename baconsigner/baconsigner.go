@@ -3,12 +3,14 @@ package baconsigner
 import (
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/Messer4/base58check"
 	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/metrics"
 	"bakinbacon/storage"
 )
 
@@ -242,6 +244,8 @@ func (s *BaconSigner) signGeneric(opPrefix prefix, incOpHex, chainID string) (Si
 	// fmt.Println("ToSignBytes: ", opBytes)
 	// fmt.Println("ToSignByHex: ", finalOpHex)
 
+	signStart := time.Now()
+
 	edSig, err := func(b []byte) (string, error) {
 		switch s.signerType {
 		case SIGNER_WALLET:
@@ -252,6 +256,13 @@ func (s *BaconSigner) signGeneric(opPrefix prefix, incOpHex, chainID string) (Si
 		return "", NO_SIGNER_TYPE
 	}(opBytes)
 
+	switch s.signerType {
+	case SIGNER_WALLET:
+		metrics.SignerDuration.WithLabelValues("wallet").Observe(metrics.SinceSeconds(signStart))
+	case SIGNER_LEDGER:
+		metrics.SignerDuration.WithLabelValues("ledger").Observe(metrics.SinceSeconds(signStart))
+	}
+
 	if err != nil {
 		return SignOperationOutput{}, errors.Wrap(err, "Failed sign bytes")
 	}
@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"bakinbacon/baconclient"
+	"bakinbacon/config"
+	"bakinbacon/events"
 	"bakinbacon/notifications"
 	"bakinbacon/payouts"
 	"bakinbacon/storage"
@@ -25,11 +29,15 @@ var (
 
 type BakinBacon struct {
 	*baconclient.BaconClient
+	*events.EventBus
 	*notifications.NotificationHandler
 	*payouts.PayoutsHandler
 	*storage.Storage
 	*util.NetworkConstants
 	Flags
+
+	// Settings from config file and environment
+	config *config.Config
 }
 
 //nolint:structcheck
@@ -42,7 +50,25 @@ type Flags struct {
 	noPayouts         bool
 	webUiAddr         string
 	webUiPort         int
+	webUiOrigins      string
+	tls               bool
+	tlsCert           string
+	tlsKey            string
+	tlsClientCA       string
+	httpRedirectPort  int
 	dataDir           string
+	configFile        string
+	backupDir         string
+	backupInterval    time.Duration
+	backupKeep        int
+	retentionCycles   int
+	digestDaily       string
+	digestCycle       bool
+	autoPayouts       bool
+	autoPayDelay      int
+	autoPayMaxPct     int
+	autoPayMaxReward  int
+	autoPayBondCycles int
 }
 
 // TODO: Translations (https://www.transifex.com/bakinbacon/bakinbacon-core/content/)
@@ -56,6 +82,11 @@ func main() {
 		ctx context.Context
 	)
 
+	// Subcommands, eg 'bakinbacon status', run instead of the baker
+	if isCliCommand(os.Args[1:]) {
+		os.Exit(runCli(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	// Init the main server
 	bakinbacon = &BakinBacon{}
 	bakinbacon.parseArgs()
@@ -72,6 +103,11 @@ func main() {
 		log.WithError(err).Fatal("Could not open storage")
 	}
 
+	// Config file and environment take precedence over settings in the DB
+	if err := bakinbacon.config.Apply(bakinbacon.Storage); err != nil {
+		log.WithError(err).Fatal("Could not apply config")
+	}
+
 	// Start
 	startMsg := fmt.Sprintf("=== BakinBacon %s (%s) ===", version, commitHash)
 	log.Infof(startMsg)
@@ -84,6 +120,9 @@ func main() {
 	}
 	bakinbacon.SendNotification(startMsg, notifications.STARTUP)
 
+	// Internal event bus; published to by baking components, consumed by web UI
+	bakinbacon.EventBus = events.NewEventBus()
+
 	// Network constants
 	bakinbacon.NetworkConstants, err = util.GetNetworkConstants(bakinbacon.network)
 	if err != nil {
@@ -98,7 +137,7 @@ func main() {
 
 	// Set up RPC polling-monitoring
 	bakinbacon.BaconClient, err = baconclient.New(
-		bakinbacon.NotificationHandler, bakinbacon.Storage, bakinbacon.NetworkConstants, shutdownChannel, &wg)
+		bakinbacon.NotificationHandler, bakinbacon.EventBus, bakinbacon.Storage, bakinbacon.NetworkConstants, shutdownChannel, &wg)
 	if err != nil {
 		log.WithError(err).Fatalf("Cannot create BaconClient")
 	}
@@ -109,10 +148,35 @@ func main() {
 	if err != nil {
 		log.WithError(err).Fatalf("Cannot create payouts handler")
 	}
+	bakinbacon.PayoutsHandler.AutoPay = bakinbacon.autoPayPolicy()
 
 	// Version checking
 	go bakinbacon.RunVersionCheck()
 
+	// Backups
+	wg.Add(1)
+	go bakinbacon.RunScheduledBackups(shutdownChannel, &wg)
+
+	// Retention
+	wg.Add(1)
+	go bakinbacon.RunPruner(shutdownChannel, &wg)
+
+	// Summaries of suppressed notifications
+	wg.Add(1)
+	go bakinbacon.NotificationHandler.RunSummaries(shutdownChannel, &wg)
+
+	// Deliver, and retry, notifications
+	wg.Add(1)
+	go bakinbacon.NotificationHandler.RunOutbox(shutdownChannel, &wg)
+
+	// Digest reports
+	wg.Add(1)
+	go bakinbacon.RunDigests(shutdownChannel, &wg)
+
+	// Telegram bot commands, when enabled
+	wg.Add(1)
+	go bakinbacon.NotificationHandler.RunTelegramBot(botCommands{bakinbacon}, shutdownChannel, &wg)
+
 	// Start web UI
 	// Template variables for the UI
 	templateVars := webserver.TemplateVars{
@@ -126,11 +190,21 @@ func main() {
 	webServerArgs := webserver.WebServerArgs{
 		Client:              bakinbacon.BaconClient,
 		NotificationHandler: bakinbacon.NotificationHandler,
+		EventBus:            bakinbacon.EventBus,
 		PayoutsHandler:      bakinbacon.PayoutsHandler,
 		Storage:             bakinbacon.Storage,
 		BindAddr:            bakinbacon.webUiAddr,
 		BindPort:            bakinbacon.webUiPort,
 		TemplateVars:        templateVars,
+		AllowedOrigins:      util.SplitList(bakinbacon.webUiOrigins),
+		Tls: webserver.TlsArgs{
+			Enabled:      bakinbacon.tls || bakinbacon.tlsCert != "" || bakinbacon.tlsKey != "",
+			CertFile:     bakinbacon.tlsCert,
+			KeyFile:      bakinbacon.tlsKey,
+			DataDir:      bakinbacon.dataDir,
+			ClientCAFile: bakinbacon.tlsClientCA,
+			RedirectPort: bakinbacon.httpRedirectPort,
+		},
 		ShutdownChannel:     shutdownChannel,
 		WG:                  &wg,
 	}
@@ -162,6 +236,8 @@ func main() {
 			// Create a new context for this run
 			ctx, ctxCancel = context.WithCancel(context.Background())
 
+			bakinbacon.NotificationHandler.SetCycle(block.Metadata.Level.Cycle)
+
 			// If we can't bake, no need to do try and do anything else
 			// This check is silent = true on success
 			if !bakinbacon.CanBake(true) {
@@ -180,6 +256,12 @@ func main() {
 			wg.Add(1)
 			go bakinbacon.PayoutsHandler.HandlePayouts(ctx, &wg, *block)
 
+			wg.Add(1)
+			go bakinbacon.PayoutsHandler.HandleAutoPayouts(&wg, *block)
+
+			wg.Add(1)
+			go bakinbacon.verifyInclusions(&wg, *block)
+
 			//
 			// Utility
 			//
@@ -237,15 +319,52 @@ func (bb *BakinBacon) parseArgs() {
 
 	flag.BoolVar(&bb.noPayouts, "no-payouts", false, "Disable payouts within BakinBacon")
 
+	flag.BoolVar(&bb.autoPayouts, "auto-payouts", false, "Send calculated payouts automatically, if they pass the auto-pay checks")
+	flag.IntVar(&bb.autoPayDelay, "auto-payouts-delay", payouts.AUTO_PAY_DELAY, "Blocks after calculation before payouts are sent automatically")
+	flag.IntVar(&bb.autoPayMaxPct, "auto-payouts-max-pct", payouts.AUTO_PAY_MAX_PCT, "Only auto-pay if payouts total at most this percent of the cycle's unfrozen rewards")
+	flag.IntVar(&bb.autoPayMaxReward, "auto-payouts-max-reward", payouts.AUTO_PAY_MAX_REWARD, "Only auto-pay if no delegator is owed more than this many XTZ; 0 for no cap")
+	flag.IntVar(&bb.autoPayBondCycles, "auto-payouts-bond-cycles", payouts.AUTO_PAY_BOND_CYCLES, "Only auto-pay if the spendable balance still covers bonds for this many cycles after the current one")
+
 	flag.StringVar(&bb.webUiAddr, "webuiaddr", "127.0.0.1", "Address on which to bind web UI server")
 	flag.IntVar(&bb.webUiPort, "webuiport", 8082, "Port on which to bind web UI server")
+	flag.StringVar(&bb.webUiOrigins, "webuiorigins", "", "Comma-separated list of additional origins allowed to make cross-origin requests to web UI API")
+
+	flag.BoolVar(&bb.tls, "tls", false, "Serve web UI over HTTPS; Uses a self-signed certificate unless -tls-cert and -tls-key are given")
+	flag.StringVar(&bb.tlsCert, "tls-cert", "", "TLS certificate file for web UI; Implies -tls")
+	flag.StringVar(&bb.tlsKey, "tls-key", "", "TLS key file for web UI; Implies -tls")
+	flag.StringVar(&bb.tlsClientCA, "tls-client-ca", "", "Require web UI clients to present a certificate signed by this CA")
+	flag.IntVar(&bb.httpRedirectPort, "http-redirect-port", 0, "When using TLS, redirect plain HTTP on this port to HTTPS")
 
 	flag.StringVar(&bb.dataDir, "datadir", "./", "Location of database")
 
+	flag.StringVar(&bb.backupDir, "backup-dir", "", "Directory for scheduled backups; Defaults to 'backups' in -datadir")
+	flag.DurationVar(&bb.backupInterval, "backup-interval", 24*time.Hour, "How often to back up the database; 0 to disable")
+	flag.IntVar(&bb.backupKeep, "backup-keep", 7, "Number of scheduled backups to keep; 0 to keep all")
+
+	flag.IntVar(&bb.retentionCycles, "retention-cycles", 0, "Delete rights, history, nonces and payouts records older than this many cycles; 0 to keep all")
+
+	flag.StringVar(&bb.digestDaily, "digest-daily", "", "Send a digest report each day at this time, HH:MM UTC; Empty to disable")
+	flag.BoolVar(&bb.digestCycle, "digest-cycle", true, "Send a digest report at the start of each cycle")
+
+	flag.StringVar(&bb.configFile, "config", os.Getenv(config.CONFIG_FILE_ENV), fmt.Sprintf("YAML config file; Also read from %s", config.CONFIG_FILE_ENV))
+
 	printVersion := flag.Bool("version", false, "Show version and exit")
 
 	flag.Parse()
 
+	// Config file and environment settings apply unless given on the command line
+	var err error
+	if bb.config, err = config.Load(bb.configFile); err != nil {
+		log.WithError(err).Error("Unable to load config")
+		os.Exit(1)
+	}
+
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	bb.applyConfig(setFlags)
+
 	// Sanity
 	if !util.IsValidNetwork(bb.network) {
 		log.Errorf("Unknown network: %s", bb.network)
@@ -253,6 +372,18 @@ func (bb *BakinBacon) parseArgs() {
 		os.Exit(1)
 	}
 
+	if bb.digestDaily != "" {
+		if _, err := time.Parse(config.DIGEST_TIME_FORMAT, bb.digestDaily); err != nil {
+			log.Errorf("Invalid -digest-daily %q; Expected HH:MM", bb.digestDaily)
+			os.Exit(1)
+		}
+	}
+
+	if err := bb.autoPayPolicy().Validate(); err != nil {
+		log.WithError(err).Error("Invalid auto payouts")
+		os.Exit(1)
+	}
+
 	// Handle print version and exit
 	if *printVersion {
 		log.Printf("Bakin'Bacon %s (%s)", version, commitHash)
@@ -260,3 +391,81 @@ func (bb *BakinBacon) parseArgs() {
 		os.Exit(0)
 	}
 }
+
+// applyConfig sets flags from the config file and environment, unless they were
+// given on the command line, ie are in setFlags
+func (bb *BakinBacon) applyConfig(setFlags map[string]bool) {
+
+	c := bb.config
+
+	setString := func(name string, dst *string, value string) {
+		if !setFlags[name] && value != "" {
+			*dst = value
+		}
+	}
+
+	setInt := func(name string, dst *int, value *int) {
+		if !setFlags[name] && value != nil {
+			*dst = *value
+		}
+	}
+
+	setBool := func(name string, dst *bool, value *bool) {
+		if !setFlags[name] && value != nil {
+			*dst = *value
+		}
+	}
+
+	setString("network", &bb.network, c.Network)
+	setString("datadir", &bb.dataDir, c.DataDir)
+	setBool("debug", &bb.logDebug, c.Debug)
+	setBool("trace", &bb.logTrace, c.Trace)
+
+	setString("webuiaddr", &bb.webUiAddr, c.WebUi.Addr)
+	setInt("webuiport", &bb.webUiPort, c.WebUi.Port)
+	setString("webuiorigins", &bb.webUiOrigins, strings.Join(c.WebUi.Origins, ","))
+	setBool("tls", &bb.tls, c.WebUi.Tls)
+	setString("tls-cert", &bb.tlsCert, c.WebUi.TlsCert)
+	setString("tls-key", &bb.tlsKey, c.WebUi.TlsKey)
+	setString("tls-client-ca", &bb.tlsClientCA, c.WebUi.TlsClientCA)
+	setInt("http-redirect-port", &bb.httpRedirectPort, c.WebUi.HttpRedirectPort)
+
+	if !setFlags["no-payouts"] && c.Payouts.Enabled != nil {
+		bb.noPayouts = !*c.Payouts.Enabled
+	}
+
+	setBool("auto-payouts", &bb.autoPayouts, c.Payouts.Auto.Enabled)
+	setInt("auto-payouts-delay", &bb.autoPayDelay, c.Payouts.Auto.Delay)
+	setInt("auto-payouts-max-pct", &bb.autoPayMaxPct, c.Payouts.Auto.MaxPct)
+	setInt("auto-payouts-max-reward", &bb.autoPayMaxReward, c.Payouts.Auto.MaxReward)
+	setInt("auto-payouts-bond-cycles", &bb.autoPayBondCycles, c.Payouts.Auto.BondCycles)
+
+	setString("backup-dir", &bb.backupDir, c.Backup.Dir)
+	setInt("backup-keep", &bb.backupKeep, c.Backup.Keep)
+	setInt("retention-cycles", &bb.retentionCycles, c.RetentionCycles)
+	if !setFlags["backup-interval"] && c.Backup.Interval != nil {
+		bb.backupInterval = *c.Backup.Interval
+	}
+
+	setString("digest-daily", &bb.digestDaily, c.Digest.Daily)
+	setBool("digest-cycle", &bb.digestCycle, c.Digest.Cycle)
+
+	// Storage expects a trailing separator
+	if !strings.HasSuffix(bb.dataDir, "/") {
+		bb.dataDir += "/"
+	}
+
+	if bb.backupDir == "" {
+		bb.backupDir = bb.dataDir + "backups"
+	}
+}
+
+func (bb *BakinBacon) autoPayPolicy() payouts.AutoPayPolicy {
+	return payouts.AutoPayPolicy{
+		Enabled:    bb.autoPayouts,
+		Delay:      bb.autoPayDelay,
+		MaxPct:     bb.autoPayMaxPct,
+		MaxReward:  bb.autoPayMaxReward * 1e6,
+		BondCycles: bb.autoPayBondCycles,
+	}
+}
@@ -4,9 +4,13 @@ import (
 	_ "bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"bakinbacon/config"
 	"bakinbacon/nonce"
 	"bakinbacon/util"
 
@@ -117,3 +121,52 @@ func TestNonce(t *testing.T) {
 		t.Errorf("Encoded bytes do not match")
 	}
 }
+
+// Command-line flags beat environment variables, which beat the config file
+func TestApplyConfigPrecedence(t *testing.T) {
+
+	configFile := filepath.Join(t.TempDir(), "bakinbacon.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(`
+webui:
+  port: 8100
+  addr: 0.0.0.0
+backup:
+  keep: 3
+  interval: 1h
+retention_cycles: 20
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BAKINBACON_WEBUI_PORT", "9000")
+	t.Setenv("BAKINBACON_BACKUP_KEEP", "5")
+	t.Setenv("BAKINBACON_DATADIR", "/data")
+
+	c, err := config.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As parsed, with -webuiport and -retention-cycles given
+	bb := &BakinBacon{config: c}
+	bb.webUiAddr, bb.webUiPort, bb.dataDir = "127.0.0.1", 9100, "./"
+	bb.backupKeep, bb.backupInterval, bb.retentionCycles = 7, 24*time.Hour, 0
+
+	bb.applyConfig(map[string]bool{"webuiport": true, "retention-cycles": true})
+
+	if bb.webUiPort != 9100 || bb.retentionCycles != 0 {
+		t.Errorf("Flags overridden: port %d, retention %d", bb.webUiPort, bb.retentionCycles)
+	}
+
+	if bb.backupKeep != 5 || bb.dataDir != "/data/" {
+		t.Errorf("Environment not applied: keep %d, datadir %q", bb.backupKeep, bb.dataDir)
+	}
+
+	if bb.webUiAddr != "0.0.0.0" || bb.backupInterval != time.Hour {
+		t.Errorf("File not applied: addr %q, interval %v", bb.webUiAddr, bb.backupInterval)
+	}
+
+	if bb.backupDir != "/data/backups" {
+		t.Errorf("Backup dir %q", bb.backupDir)
+	}
+}
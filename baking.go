@@ -17,8 +17,11 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"bakinbacon/baconsigner"
+	"bakinbacon/events"
+	"bakinbacon/metrics"
 	"bakinbacon/nonce"
 	"bakinbacon/notifications"
+	"bakinbacon/storage"
 	"bakinbacon/util"
 )
 
@@ -75,9 +78,15 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 	// 8. Inject
 	// 9. If needed, reveal nonce
 
+	// For measuring time from new head to injection
+	headStart := time.Now()
+
 	// look for baking rights for next level because that's what we will inject
 	nextLevelToBake := block.Header.Level + 1
 
+	// Check if our previous bake made it to the chain
+	bb.checkLostBake(block)
+
 	// Check watermark to ensure we have not baked at this level before
 	watermark, err := bb.Storage.GetBakingWatermark()
 	if err != nil {
@@ -104,9 +113,11 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 
 	resp, bakingRights, err := bb.Current.BakingRights(bakingRightsFilter)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"Request": resp.Request.URL, "Response": resp.Body(),
-		}).Error("Unable to fetch baking rights")
+
+		// resp may be nil if RPC is down
+		bb.recordMissedKnownBake(nextLevelToBake, storage.REASON_RPC)
+
+		log.WithError(err).WithField("Level", nextLevelToBake).Error("Unable to fetch baking rights")
 
 		return
 	}
@@ -144,14 +155,30 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		"CurrentTS": time.Now().UTC().Format(time.RFC3339),
 	}).Info("Baking slot found")
 
+	bb.Publish(events.RIGHTS_FOUND, events.RightsEvent{
+		Kind:     storage.BAKING_RIGHTS_BUCKET,
+		Level:    nextLevelToBake,
+		Priority: priority,
+	})
+
 	// Ignore baking rights of priority higher than what we care about
 	if bakingRight.Priority > MAX_BAKE_PRIORITY {
 		log.Infof("Priority higher than %d; Ignoring", MAX_BAKE_PRIORITY)
 		return
 	}
 
+	metrics.BakeAttempts.Inc()
+
+	// If a new block arrives before we inject, someone else baked this level. If
+	// our priority is > 0, that was a baker with lower priority. Otherwise, we were late.
+	canceledOutcome, canceledReason := storage.OUTCOME_MISSED, storage.REASON_LATE
+	if priority > 0 {
+		canceledOutcome, canceledReason = storage.OUTCOME_STOLEN, ""
+	}
+
 	// Check if we have enough bond to cover the bake
 	requiredBond := bb.NetworkConstants.BlockSecurityDeposit
+	metrics.RequiredBond.WithLabelValues("block").Set(float64(requiredBond))
 
 	if spendableBalance, err := bb.GetSpendableBalance(); err != nil {
 		log.WithError(err).Error("Unable to get spendable balance")
@@ -170,6 +197,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 
 		bb.Status.SetError(errors.New(msg))
 		bb.SendNotification(msg, notifications.BALANCE)
+		bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_MISSED, storage.REASON_LOW_BOND, "")
 
 		return
 	}
@@ -187,6 +215,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		select {
 		case <-ctx.Done():
 			log.Info("New block arrived; Canceling current bake")
+			bb.recordBakeOutcome(nextLevelToBake, priority, canceledOutcome, canceledReason, "")
 			return
 		case <-time.After(priorityDiffSeconds * time.Second):
 			break
@@ -243,6 +272,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		select {
 		case <-ctx.Done():
 			log.Info("New block arrived; Canceling current bake")
+			bb.recordBakeOutcome(nextLevelToBake, priority, canceledOutcome, canceledReason, "")
 			return
 		case <-time.After(mempoolSleepDuration):
 			break
@@ -252,6 +282,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		_, mempoolOps, err := bb.Current.Mempool(mempoolInput)
 		if err != nil {
 			log.WithError(err).Error("Failed to fetch mempool ops")
+			bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_MISSED, storage.REASON_RPC, "")
 			return
 		}
 
@@ -277,6 +308,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 	select {
 	case <-ctx.Done():
 		log.Info("New block arrived; Canceling current bake")
+		bb.recordBakeOutcome(nextLevelToBake, priority, canceledOutcome, canceledReason, "")
 		return
 	default:
 		break
@@ -297,6 +329,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		log.WithError(err).WithFields(log.Fields{
 			"Request": resp.Request.URL, "Response": string(resp.Body()),
 		}).Error("Unable to get minimal valid timestamp")
+		bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_MISSED, storage.REASON_RPC, "")
 		return
 	}
 
@@ -315,6 +348,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		select {
 		case <-ctx.Done():
 			log.Info("New block arrived; Canceling current bake")
+			bb.recordBakeOutcome(nextLevelToBake, priority, canceledOutcome, canceledReason, "")
 			return
 		case <-time.After(sleepDuration * time.Second):
 			break
@@ -351,6 +385,8 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 			"Request": resp.Request.URL, "Response": string(resp.Body()),
 		}).Error("Unable to preapply block")
 
+		bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_MISSED, storage.REASON_PREAPPLY, "")
+
 		return
 	}
 
@@ -406,6 +442,8 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 	})
 	if err != nil {
 		log.WithError(err).Error("Unable to locally forge block header")
+		bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_MISSED, storage.REASON_FORGE, "")
+		return
 	}
 
 	localForgedBlockHex := hex.EncodeToString(locallyForgedBlock)
@@ -420,15 +458,19 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 	select {
 	case <-ctx.Done():
 		log.Info("New block arrived; Canceling current bake")
+		bb.recordBakeOutcome(nextLevelToBake, priority, canceledOutcome, canceledReason, "")
 		return
 	default:
 		break
 	}
 
 	// Perform a lame proof-of-work computation
+	powStart := time.Now()
 	blockBytes, attempts, err := bb.powLoop(localForgedBlockHex, protocolDataLength)
+	metrics.PowDuration.Observe(metrics.SinceSeconds(powStart))
 	if err != nil {
 		log.WithError(err).Error("Unable to POW!")
+		bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_MISSED, storage.REASON_POW, "")
 		return
 	}
 
@@ -443,8 +485,8 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 
 	for i := 1; i < 3; i++ {
 		signedBlock, signedErr = bb.Signer.SignBlock(blockBytes, block.ChainID)
-		if err != nil {
-			log.WithField("Attempt", i).WithError(err).Error("Failed to sign block")
+		if signedErr != nil {
+			log.WithField("Attempt", i).WithError(signedErr).Error("Failed to sign block")
 			time.Sleep(1 * time.Second)
 			continue
 		}
@@ -456,6 +498,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		msg := "Unable to sign block bytes; Cannot inject block"
 		log.Error(msg)
 		bb.SendNotification(msg, notifications.BAKING_FAIL)
+		bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_MISSED, storage.REASON_SIGNER, "")
 		return
 	}
 
@@ -471,6 +514,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 	select {
 	case <-ctx.Done():
 		log.Info("New block arrived; Canceling current bake")
+		bb.recordBakeOutcome(nextLevelToBake, priority, canceledOutcome, canceledReason, "")
 		return
 	default:
 		break
@@ -488,6 +532,7 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		log.WithError(err).WithFields(log.Fields{
 			"Request": resp.Request.URL, "Response": string(resp.Body()), "P": priority,
 		}).Error("Block Injection Failure")
+		bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_MISSED, storage.REASON_INJECTION, "")
 		return
 	}
 
@@ -495,11 +540,21 @@ func (bb *BakinBacon) handleBake(ctx context.Context, wg *sync.WaitGroup, block
 		"BlockHash": blockHash, "CurrentTS": time.Now().UTC().Format(time.RFC3339Nano), "P": priority,
 	}).Info("Block Injected")
 
+	metrics.HeadToInjection.WithLabelValues("block").Observe(metrics.SinceSeconds(headStart))
+
+	bb.Publish(events.BAKE_INJECTED, events.InjectedEvent{
+		Level:    nextLevelToBake,
+		Hash:     blockHash,
+		Priority: priority,
+	})
+
 	// Save watermark to DB
 	if err := bb.Storage.RecordBakedBlock(nextLevelToBake, blockHash); err != nil {
 		log.WithError(err).Error("Unable to save block; Watermark compromised")
 	}
 
+	bb.recordBakeOutcome(nextLevelToBake, priority, storage.OUTCOME_BAKED, "", blockHash)
+
 	// Save nonce to DB for reveal in next cycle
 	withNonce := ""
 	if nonce.EncodedNonce != "" {
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"bakinbacon/payouts"
+)
+
+// botCommands answers the Telegram bot's commands; See notifications.RunTelegramBot
+type botCommands struct {
+	bb *BakinBacon
+}
+
+// digest collects the DB's view of the current cycle, as for a daily digest
+func (c botCommands) digest() (*Digest, error) {
+
+	status := c.bb.BaconClient.Status
+	if status.Cycle == 0 {
+		return nil, errors.New("Waiting for the first block")
+	}
+
+	return c.bb.buildDigest(DIGEST_DAILY, status.Cycle, status.Level)
+}
+
+func (c botCommands) Status() (string, error) {
+
+	_, delegate, err := c.bb.Storage.GetDelegate()
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to get delegate")
+	}
+
+	s := c.bb.BaconClient.Status
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Baker %s\n", delegate)
+	fmt.Fprintf(&b, "Network %s, level %d, cycle %d (block %d)\n", s.Network, s.Level, s.Cycle, s.CyclePosition+1)
+
+	fmt.Fprintf(&b, "State: %s", s.State)
+	if s.ErrorMsg != "" {
+		fmt.Fprintf(&b, " (%s)", s.ErrorMsg)
+	}
+	b.WriteString("\n")
+
+	if s.PreviousBakeLevel > 0 {
+		fmt.Fprintf(&b, "Last bake: level %d\n", s.PreviousBakeLevel)
+	}
+
+	if s.PreviousEndorsementLevel > 0 {
+		fmt.Fprintf(&b, "Last endorsement: level %d\n", s.PreviousEndorsementLevel)
+	}
+
+	d := &Digest{Endpoints: c.bb.BaconClient.Endpoints()}
+	d.writeEndpoints(&b)
+
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+func (c botCommands) Rights() (string, error) {
+
+	d, err := c.digest()
+	if err != nil {
+		return "", err
+	}
+
+	s := c.bb.BaconClient.Status
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Cycle %d so far: %s\n", d.ThisCycle.Cycle, summaryLine(*d.ThisCycle))
+
+	if s.NextBakingLevel > s.Level {
+		fmt.Fprintf(&b, "Next bake: level %d, priority %d\n", s.NextBakingLevel, s.NextBakingPriority)
+	} else {
+		fmt.Fprintf(&b, "Next bake: none in cycle %d\n", s.Cycle)
+	}
+
+	if s.NextEndorsementLevel > s.Level {
+		fmt.Fprintf(&b, "Next endorsement: level %d\n", s.NextEndorsementLevel)
+	} else {
+		fmt.Fprintf(&b, "Next endorsement: none in cycle %d\n", s.Cycle)
+	}
+
+	d.writeRights(&b)
+
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+func (c botCommands) Balance() (string, error) {
+
+	d, err := c.digest()
+	if err != nil {
+		return "", err
+	}
+
+	d.SpendableBalance, d.BalanceErr = c.bb.GetSpendableBalance()
+
+	var b strings.Builder
+	d.writeBalance(&b)
+
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+func (c botCommands) Payouts() (string, error) {
+
+	if c.bb.PayoutsHandler.Disabled {
+		return "Payouts are disabled", nil
+	}
+
+	d, err := c.digest()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	d.writePayouts(&b)
+
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// CheckPayouts makes the same checks as the API before sending payouts
+func (c botCommands) CheckPayouts(cycle int) (string, error) {
+
+	if c.bb.PayoutsHandler.Disabled {
+		return "", errors.New("Payouts are disabled")
+	}
+
+	metadata, err := c.bb.PayoutsHandler.GetRewardMetadataForCycle(cycle)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to get cycle payouts")
+	}
+
+	switch metadata.Status {
+	case "":
+		return "", errors.New("No payouts calculated")
+	case payouts.IN_PROGRESS, payouts.DONE:
+		return "", errors.New("Already sent")
+	}
+
+	rewards, err := c.bb.PayoutsHandler.GetDelegatorRewardAllForCycle(cycle)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to get delegator rewards")
+	}
+
+	var total, unpaid int
+	for _, r := range rewards {
+		if r.OpHash == "" && r.Reward > 0 {
+			total += r.Reward
+			unpaid++
+		}
+	}
+
+	return fmt.Sprintf("Payouts for cycle %d: %d delegators, %s in total, after a %.2f%% fee",
+		cycle, unpaid, mutezToXtz(total), metadata.BakerFee), nil
+}
+
+func (c botCommands) SendPayouts(cycle int) error {
+
+	// Checked again; Payouts may have been sent from the UI since
+	if _, err := c.CheckPayouts(cycle); err != nil {
+		return err
+	}
+
+	return c.bb.PayoutsHandler.SendCyclePayouts(cycle)
+}
@@ -0,0 +1,1535 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"bakinbacon/baconsigner"
+	"bakinbacon/config"
+	"bakinbacon/nonce"
+	"bakinbacon/payouts"
+	"bakinbacon/storage"
+	"bakinbacon/util"
+	"bakinbacon/webserver"
+)
+
+// Command-line client for headless operation. Each command goes through the running
+// daemon's /api/v1 when given an API token; otherwise it opens the database directly,
+// which is only possible while the daemon is stopped.
+
+const (
+	CLI_DEFAULT_API = "http://127.0.0.1:8082"
+	CLI_TOKEN_ENV   = "BAKINBACON_TOKEN"
+)
+
+type cliCommand struct {
+	args  string
+	help  string
+	run   func(c *cliContext, args []string) error
+	flags func(fs *flag.FlagSet)
+
+	// Commands which inject operations cannot run without the daemon
+	needsDaemon bool
+
+	// Commands which change what the daemon relies on cannot run alongside it
+	offlineOnly bool
+
+	// Commands which replace the database open it themselves; Implies offlineOnly
+	ownsDb bool
+}
+
+type cliContext struct {
+	// Exactly one of these is set
+	db  *storage.Storage
+	api *cliApi
+
+	network string
+	dataDir string
+	jsonOut bool
+	out     io.Writer
+	fs      *flag.FlagSet
+}
+
+type cliApi struct {
+	baseUrl string
+	token   string
+	client  *http.Client
+}
+
+var cliCommands = map[string]cliCommand{
+	"status": {
+		help: "Show baker status",
+		run:  cliStatus,
+	},
+	"rights": {
+		help:  "List baking and endorsing rights for a cycle",
+		run:   cliRights,
+		flags: cycleFlag,
+	},
+	"key import": {
+		help: "Import a secret key (read from stdin) as the baker's wallet",
+		run:  cliKeyImport,
+	},
+	"ledger confirm": {
+		help: "Authorize baking with the attached ledger",
+		run:  cliLedgerConfirm,
+		flags: func(fs *flag.FlagSet) {
+			fs.String("bip", "", "BIP path to authorize; defaults to the path already authorized on the device")
+		},
+	},
+	"endpoint list": {
+		help: "List RPC endpoints",
+		run:  cliEndpointList,
+	},
+	"endpoint add": {
+		args: "<url>",
+		help: "Add an RPC endpoint",
+		run:  cliEndpointAdd,
+	},
+	"endpoint delete": {
+		args: "<id>",
+		help: "Delete an RPC endpoint",
+		run:  cliEndpointDelete,
+	},
+	"fee show": {
+		help: "Show the baker fee",
+		run:  cliFeeShow,
+	},
+	"fee set": {
+		args: "<percent>",
+		help: "Set the baker fee",
+		run:  cliFeeSet,
+		flags: func(fs *flag.FlagSet) {
+			fs.String("explorer", "", "Also set the block explorer used by the web UI")
+		},
+	},
+	"payouts list": {
+		help: "List payout status of all cycles",
+		run:  cliPayoutsList,
+	},
+	"payouts show": {
+		help:  "Show delegator rewards for a cycle",
+		run:   cliPayoutsShow,
+		flags: cycleFlag,
+	},
+	"payouts send": {
+		help:        "Send payouts for a cycle",
+		run:         cliPayoutsSend,
+		flags:       cycleFlag,
+		needsDaemon: true,
+	},
+	"vote": {
+		help: "Upvote a proposal",
+		run:  cliVote,
+		flags: func(fs *flag.FlagSet) {
+			fs.String("proposal", "", "Proposal hash")
+			fs.Int("period", 0, "Voting period index")
+		},
+		needsDaemon: true,
+	},
+	"backup": {
+		help: "Write a copy of the database",
+		run:  cliBackup,
+		flags: func(fs *flag.FlagSet) {
+			fs.String("out", "", "File to write; Defaults to bakinbacon-<network>-<time>.db")
+		},
+	},
+	"export": {
+		help: "Export the database as JSON",
+		run:  cliExport,
+		flags: func(fs *flag.FlagSet) {
+			fs.String("out", "-", "File to write, or - for stdout")
+		},
+	},
+	"restore": {
+		args: "<file>",
+		help: "Replace the database with a backup or export",
+		run:  cliRestore,
+		flags: func(fs *flag.FlagSet) {
+			fs.String("rpc", "", "RPC node to check the chain head against; Defaults to the backup's endpoints")
+			fs.Bool("raise-watermarks", false, "Raise watermarks below the chain head to the head level, instead of refusing")
+			fs.Bool("offline", false, "Do not check watermarks against the chain head; Only the current database is checked")
+		},
+		ownsDb: true,
+	},
+	"compact": {
+		help:   "Rewrite the database to reclaim space freed by pruning",
+		run:    cliCompact,
+		ownsDb: true,
+	},
+	"octez import": {
+		help: "Import watermarks and unrevealed nonces from an Octez baker",
+		run:  cliOctezImport,
+		flags: func(fs *flag.FlagSet) {
+			fs.String("dir", os.ExpandEnv("$HOME/.tezos-client"), "Octez client directory, containing blocks, endorsements and nonces")
+			fs.String("delegate", "", "Delegate to import watermarks for; Defaults to the baker's")
+			fs.String("rpc", "", "RPC node to look up nonce levels; Defaults to the database's endpoints")
+		},
+		offlineOnly: true,
+	},
+}
+
+func cycleFlag(fs *flag.FlagSet) {
+	fs.Int("cycle", -1, "Cycle; defaults to the current cycle when using the daemon")
+}
+
+// isCliCommand returns true if the arguments start with a command rather than a flag
+func isCliCommand(args []string) bool {
+	return len(args) > 0 && !strings.HasPrefix(args[0], "-")
+}
+
+// runCli runs the command in args, writing to stdout and stderr, and returns the exit code
+func runCli(args []string, stdout, stderr io.Writer) int {
+
+	// Keep output clean; only problems are logged
+	log.SetLevel(log.WarnLevel)
+
+	if args[0] == "help" {
+		printCliUsage(stdout)
+		return 0
+	}
+
+	// Commands are one or two words
+	name := args[0]
+	if len(args) > 1 {
+		if _, ok := cliCommands[args[0]+" "+args[1]]; ok {
+			name = args[0] + " " + args[1]
+		}
+	}
+
+	cmd, ok := cliCommands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command: %s\n\n", strings.Join(args, " "))
+		printCliUsage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: bakinbacon %s [flags] %s\n\n%s\n\n", name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+
+	configFile := fs.String("config", os.Getenv(config.CONFIG_FILE_ENV), fmt.Sprintf("YAML config file, for -datadir and -network; Also read from %s", config.CONFIG_FILE_ENV))
+	dataDir := fs.String("datadir", "./", "Location of database, when the daemon is stopped")
+	network := fs.String("network", util.NETWORK_HANGZHOUNET, fmt.Sprintf("Which network to use: %s", util.AvailableNetworks()))
+	apiUrl := fs.String("api", CLI_DEFAULT_API, "URL of the running daemon's web UI")
+	token := fs.String("token", os.Getenv(CLI_TOKEN_ENV), fmt.Sprintf("API token for the running daemon; Also read from %s", CLI_TOKEN_ENV))
+	insecure := fs.Bool("insecure", false, "Do not verify the daemon's TLS certificate; For self-signed certificates")
+	jsonOut := fs.Bool("json", false, "Output JSON")
+
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+
+	if err := fs.Parse(args[len(strings.Fields(name)):]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	// Same precedence as the daemon; flags, environment, then config file
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return 1
+	}
+
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	if !setFlags["datadir"] && cfg.DataDir != "" {
+		*dataDir = cfg.DataDir
+	}
+
+	if !setFlags["network"] && cfg.Network != "" {
+		*network = cfg.Network
+	}
+
+	if !strings.HasSuffix(*dataDir, "/") {
+		*dataDir += "/"
+	}
+
+	c := &cliContext{
+		network: *network,
+		dataDir: *dataDir,
+		jsonOut: *jsonOut,
+		out:     stdout,
+		fs:      fs,
+	}
+
+	if (cmd.offlineOnly || cmd.ownsDb) && *token != "" {
+		fmt.Fprintf(stderr, "Error: %s requires the daemon to be stopped, and cannot use -token\n", name)
+		return 1
+	}
+
+	switch {
+	case cmd.ownsDb:
+		// Opened by the command
+
+	case *token != "":
+		c.api = newCliApi(*apiUrl, *token, *insecure)
+
+	default:
+		if cmd.needsDaemon {
+			fmt.Fprintf(stderr, "Error: %s requires the running daemon; Use -token or %s\n", name, CLI_TOKEN_ENV)
+			return 1
+		}
+
+		db, err := openCliStorage(*dataDir, *network)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %s\n", err)
+			return 1
+		}
+		defer db.CloseDb()
+
+		c.db = db
+	}
+
+	if err := cmd.run(c, fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func printCliUsage(w io.Writer) {
+
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  bakinbacon [flags]                    Run the baker")
+	fmt.Fprintln(w, "  bakinbacon <command> [flags] [args]   Run a command")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", name, cliCommands[name].args, cliCommands[name].help)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Commands use the running daemon's API when given -token (or %s),\n", CLI_TOKEN_ENV)
+	fmt.Fprintln(w, "otherwise the database in -datadir, which requires the daemon to be stopped.")
+	fmt.Fprintln(w, "Use 'bakinbacon <command> -h' for command flags.")
+}
+
+// openCliStorage opens an existing database, refusing to create a new one
+func openCliStorage(dataDir, network string) (*storage.Storage, error) {
+
+	if _, err := os.Stat(dataDir + storage.DATABASE_FILE); err != nil {
+		return nil, errors.Errorf("No database found in %s", dataDir)
+	}
+
+	db, err := storage.InitStorage(dataDir, network)
+	if err != nil {
+		if errors.Cause(err) == bolt.ErrTimeout {
+			return nil, errors.Errorf("Database is in use by the running daemon; Use -token or %s to go through its API", CLI_TOKEN_ENV)
+		}
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func newCliApi(baseUrl, token string, insecure bool) *cliApi {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+
+	return &cliApi{
+		baseUrl: strings.TrimSuffix(baseUrl, "/") + "/api/v1",
+		token:   token,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   30 * time.Second,
+		},
+	}
+}
+
+// call makes a request to the daemon's API, decoding any response into out
+func (a *cliApi) call(method, path string, body, out interface{}) error {
+
+	resp, err := a.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "Unable to decode response")
+	}
+
+	return nil
+}
+
+// download copies the response of a GET request to w, as is
+func (a *cliApi) download(path string, w io.Writer) error {
+
+	resp, err := a.request("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return errors.Wrap(err, "Unable to download")
+	}
+
+	return nil
+}
+
+// request makes a request to the daemon's API; Errors returned by the daemon become errors
+func (a *cliApi) request(method, path string, body interface{}) (*http.Response, error) {
+
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to encode request")
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+
+	req, err := http.NewRequest(method, a.baseUrl+path, reqBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to reach daemon")
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		var apiErr webserver.ErrorV1
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return nil, errors.Errorf("Daemon returned %s", resp.Status)
+		}
+		return nil, errors.New(apiErr.Error)
+	}
+
+	return resp, nil
+}
+
+// print writes v as JSON if requested, otherwise calls human
+func (c *cliContext) print(v interface{}, human func(w io.Writer)) error {
+
+	if c.jsonOut {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	human(tw)
+
+	return tw.Flush()
+}
+
+func (c *cliContext) intFlag(name string) int {
+	return c.fs.Lookup(name).Value.(flag.Getter).Get().(int)
+}
+
+func (c *cliContext) stringFlag(name string) string {
+	return c.fs.Lookup(name).Value.String()
+}
+
+// cycleArg returns the -cycle flag; Only the daemon knows the current cycle
+func (c *cliContext) cycleArg() (int, error) {
+
+	cycle := c.intFlag("cycle")
+	if cycle < 0 && c.db != nil {
+		return 0, errors.New("-cycle is required when the daemon is stopped")
+	}
+
+	return cycle, nil
+}
+
+func cliStatus(c *cliContext, args []string) error {
+
+	var status webserver.StatusV1
+
+	if c.api != nil {
+		if err := c.api.call("GET", "/status", nil, &status); err != nil {
+			return err
+		}
+	} else {
+
+		// Without the daemon, only what is recorded in the DB is known
+		_, pkh, err := c.db.GetDelegate()
+		if err != nil {
+			return errors.Wrap(err, "Cannot get delegate")
+		}
+
+		bakeLevel, bakeHash, err := c.db.GetRecentBake()
+		if err != nil {
+			return errors.Wrap(err, "Cannot get recent bake")
+		}
+
+		endorseLevel, endorseHash, err := c.db.GetRecentEndorsement()
+		if err != nil {
+			return errors.Wrap(err, "Cannot get recent endorsement")
+		}
+
+		status = webserver.StatusV1{
+			Network:             c.network,
+			Delegate:            pkh,
+			State:               "stopped",
+			PreviousBake:        webserver.RightRefV1{Level: bakeLevel, Hash: bakeHash},
+			PreviousEndorsement: webserver.RightRefV1{Level: endorseLevel, Hash: endorseHash},
+			Timestamp:           time.Now().UTC(),
+		}
+	}
+
+	return c.print(status, func(w io.Writer) {
+
+		fmt.Fprintf(w, "Network:\t%s\n", status.Network)
+		fmt.Fprintf(w, "Delegate:\t%s\n", status.Delegate)
+		fmt.Fprintf(w, "State:\t%s\n", status.State)
+
+		if status.Error != "" {
+			fmt.Fprintf(w, "Error:\t%s\n", status.Error)
+		}
+
+		if status.Level > 0 {
+			fmt.Fprintf(w, "Head:\t%d (cycle %d, position %d) %s\n", status.Level, status.Cycle, status.CyclePosition, status.HeadHash)
+			fmt.Fprintf(w, "Next bake:\t%s\n", formatRightRef(status.NextBake))
+			fmt.Fprintf(w, "Next endorsement:\t%s\n", formatRightRef(status.NextEndorsement))
+		}
+
+		fmt.Fprintf(w, "Last bake:\t%s\n", formatRightRef(status.PreviousBake))
+		fmt.Fprintf(w, "Last endorsement:\t%s\n", formatRightRef(status.PreviousEndorsement))
+	})
+}
+
+func formatRightRef(r webserver.RightRefV1) string {
+
+	if r.Level == 0 {
+		return "none"
+	}
+
+	s := fmt.Sprintf("level %d", r.Level)
+	if r.Cycle > 0 {
+		s += fmt.Sprintf(", cycle %d", r.Cycle)
+	}
+	if r.Priority > 0 {
+		s += fmt.Sprintf(", priority %d", r.Priority)
+	}
+	if r.Hash != "" {
+		s += " " + r.Hash
+	}
+
+	return s
+}
+
+func cliRights(c *cliContext, args []string) error {
+
+	cycle, err := c.cycleArg()
+	if err != nil {
+		return err
+	}
+
+	var rights webserver.RightsV1
+
+	if c.api != nil {
+
+		path := "/rights"
+		if cycle >= 0 {
+			path += fmt.Sprintf("?cycle=%d", cycle)
+		}
+
+		if err := c.api.call("GET", path, nil, &rights); err != nil {
+			return err
+		}
+	} else {
+
+		bakingRights, err := c.db.GetBakingRightsForCycle(cycle)
+		if err != nil {
+			return errors.Wrap(err, "Unable to get baking rights")
+		}
+
+		endorsingRights, err := c.db.GetEndorsingRightsForCycle(cycle)
+		if err != nil {
+			return errors.Wrap(err, "Unable to get endorsing rights")
+		}
+
+		rights = webserver.RightsV1{
+			Cycle:     cycle,
+			Baking:    make([]webserver.BakingRightV1, 0, len(bakingRights)),
+			Endorsing: make([]webserver.EndorsingRightV1, 0, len(endorsingRights)),
+		}
+
+		for _, b := range bakingRights {
+			rights.Baking = append(rights.Baking, webserver.BakingRightV1{
+				Level: b.Level, Cycle: b.Cycle, Priority: b.Priority, EstimatedTime: b.EstimatedTime,
+			})
+		}
+
+		for _, e := range endorsingRights {
+			rights.Endorsing = append(rights.Endorsing, webserver.EndorsingRightV1{
+				Level: e.Level, Cycle: e.Cycle, Slots: e.Slots, EstimatedTime: e.EstimatedTime,
+			})
+		}
+	}
+
+	return c.print(rights, func(w io.Writer) {
+
+		fmt.Fprintf(w, "Cycle %d: %d baking, %d endorsing\n\n", rights.Cycle, len(rights.Baking), len(rights.Endorsing))
+		fmt.Fprintln(w, "TYPE\tLEVEL\tPRIORITY/SLOTS\tESTIMATED TIME")
+
+		for _, b := range rights.Baking {
+			fmt.Fprintf(w, "bake\t%d\t%d\t%s\n", b.Level, b.Priority, b.EstimatedTime.Local().Format(time.RFC1123))
+		}
+
+		for _, e := range rights.Endorsing {
+			fmt.Fprintf(w, "endorse\t%d\t%d\t%s\n", e.Level, len(e.Slots), e.EstimatedTime.Local().Format(time.RFC1123))
+		}
+	})
+}
+
+func cliKeyImport(c *cliContext, args []string) error {
+
+	// Read from stdin, rather than args, to keep the key out of shell history
+	fmt.Fprint(os.Stderr, "Secret key (edsk...): ")
+
+	secretKey, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "Unable to read secret key")
+	}
+	secretKey = strings.TrimSpace(secretKey)
+
+	if secretKey == "" {
+		return errors.New("No secret key given")
+	}
+
+	var key webserver.KeyV1
+
+	if c.api != nil {
+		if err := c.api.call("POST", "/key/import", webserver.ImportKeyRequestV1{SecretKey: secretKey}, &key); err != nil {
+			return err
+		}
+	} else {
+
+		// Also sets the signer type to wallet
+		if _, key.Pkh, err = baconsigner.ImportSecretKey(secretKey, c.db); err != nil {
+			return errors.Wrap(err, "Cannot import secret key")
+		}
+	}
+
+	return c.print(key, func(w io.Writer) {
+		fmt.Fprintf(w, "Imported key for %s\n", key.Pkh)
+	})
+}
+
+func cliLedgerConfirm(c *cliContext, args []string) error {
+
+	var ledger webserver.LedgerV1
+
+	bipPath := c.stringFlag("bip")
+
+	fmt.Fprintln(os.Stderr, "Confirm the baking key on your ledger device...")
+
+	if c.api != nil {
+		if err := c.api.call("POST", "/ledger/confirm", webserver.ConfirmLedgerRequestV1{BipPath: bipPath}, &ledger); err != nil {
+			return err
+		}
+	} else {
+
+		ledgerInfo, err := baconsigner.TestLedger(c.db)
+		if err != nil {
+			return errors.Wrap(err, "Unable to access ledger")
+		}
+
+		if bipPath == "" {
+			bipPath = ledgerInfo.BipPath
+		}
+
+		// Saves to DB on success
+		if err := baconsigner.L.ConfirmBakingPkh(ledgerInfo.Pkh, bipPath); err != nil {
+			return err
+		}
+		baconsigner.L.Close()
+
+		ledger = webserver.LedgerV1{
+			Pkh:     ledgerInfo.Pkh,
+			BipPath: bipPath,
+			Version: ledgerInfo.Version,
+		}
+	}
+
+	return c.print(ledger, func(w io.Writer) {
+		fmt.Fprintf(w, "Authorized baking for %s\t(%s, app %s)\n", ledger.Pkh, ledger.BipPath, ledger.Version)
+	})
+}
+
+func cliEndpointList(c *cliContext, args []string) error {
+
+	var endpoints webserver.EndpointsV1
+
+	if c.api != nil {
+		if err := c.api.call("GET", "/endpoints", nil, &endpoints); err != nil {
+			return err
+		}
+	} else {
+
+		dbEndpoints, err := c.db.GetRPCEndpoints()
+		if err != nil {
+			return errors.Wrap(err, "Cannot get endpoints")
+		}
+
+		endpoints.Endpoints = make([]webserver.EndpointV1, 0, len(dbEndpoints))
+
+		for id, url := range dbEndpoints {
+			endpoints.Endpoints = append(endpoints.Endpoints, webserver.EndpointV1{Id: id, Url: url})
+		}
+
+		sort.Slice(endpoints.Endpoints, func(i, j int) bool {
+			return endpoints.Endpoints[i].Id < endpoints.Endpoints[j].Id
+		})
+	}
+
+	return c.print(endpoints, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tURL")
+		for _, e := range endpoints.Endpoints {
+			fmt.Fprintf(w, "%d\t%s\n", e.Id, e.Url)
+		}
+	})
+}
+
+func cliEndpointAdd(c *cliContext, args []string) error {
+
+	if len(args) != 1 {
+		return errors.New("Expected one endpoint URL")
+	}
+
+	var endpoint webserver.EndpointV1
+
+	if c.api != nil {
+		if err := c.api.call("POST", "/endpoints", webserver.AddEndpointRequestV1{Url: args[0]}, &endpoint); err != nil {
+			return err
+		}
+	} else {
+
+		id, err := c.db.AddRPCEndpoint(args[0])
+		if err != nil {
+			return errors.Wrap(err, "Cannot add endpoint")
+		}
+
+		if id == 0 {
+			return errors.New("Endpoint already exists")
+		}
+
+		endpoint = webserver.EndpointV1{Id: id, Url: args[0]}
+	}
+
+	return c.print(endpoint, func(w io.Writer) {
+		fmt.Fprintf(w, "Added endpoint %d: %s\n", endpoint.Id, endpoint.Url)
+	})
+}
+
+func cliEndpointDelete(c *cliContext, args []string) error {
+
+	if len(args) != 1 {
+		return errors.New("Expected one endpoint id")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errors.Errorf("Invalid endpoint id %q", args[0])
+	}
+
+	if c.api != nil {
+		if err := c.api.call("DELETE", fmt.Sprintf("/endpoints/%d", id), nil, nil); err != nil {
+			return err
+		}
+	} else {
+
+		endpoints, err := c.db.GetRPCEndpoints()
+		if err != nil {
+			return errors.Wrap(err, "Cannot get endpoints")
+		}
+
+		if _, ok := endpoints[id]; !ok {
+			return errors.Errorf("Unknown endpoint %d", id)
+		}
+
+		if err := c.db.DeleteRPCEndpoint(id); err != nil {
+			return errors.Wrap(err, "Cannot delete endpoint")
+		}
+	}
+
+	if !c.jsonOut {
+		fmt.Fprintf(c.out, "Deleted endpoint %d\n", id)
+	}
+
+	return nil
+}
+
+func (c *cliContext) getBakerSettings() (webserver.BakerSettingsV1, error) {
+
+	var settings webserver.BakerSettingsV1
+
+	if c.api != nil {
+		return settings, c.api.call("GET", "/settings/baker", nil, &settings)
+	}
+
+	dbSettings, err := c.db.GetBakerSettings()
+	if err != nil {
+		return settings, errors.Wrap(err, "Cannot get baker settings")
+	}
+
+	settings.BakerFee, _ = strconv.Atoi(dbSettings[storage.BAKER_FEE].(string))
+	settings.UiExplorer, _ = dbSettings[storage.UI_EXPLORER].(string)
+
+	return settings, nil
+}
+
+func cliFeeShow(c *cliContext, args []string) error {
+
+	settings, err := c.getBakerSettings()
+	if err != nil {
+		return err
+	}
+
+	return c.print(settings, func(w io.Writer) {
+		fmt.Fprintf(w, "Baker fee:\t%d%%\n", settings.BakerFee)
+		fmt.Fprintf(w, "Explorer:\t%s\n", settings.UiExplorer)
+	})
+}
+
+func cliFeeSet(c *cliContext, args []string) error {
+
+	if len(args) != 1 {
+		return errors.New("Expected the fee percentage")
+	}
+
+	bakerFee, err := strconv.Atoi(strings.TrimSuffix(args[0], "%"))
+	if err != nil || bakerFee < 1 || bakerFee > 99 {
+		return errors.New("Baker fee must be between 1 and 99")
+	}
+
+	// Keep the existing explorer unless changing it
+	settings, err := c.getBakerSettings()
+	if err != nil {
+		return err
+	}
+
+	settings.BakerFee = bakerFee
+	if explorer := c.stringFlag("explorer"); explorer != "" {
+		settings.UiExplorer = explorer
+	}
+
+	if c.api != nil {
+		if err := c.api.call("PUT", "/settings/baker", settings, &settings); err != nil {
+			return err
+		}
+	} else {
+		if err := c.db.SaveBakerSettings(map[string]string{
+			storage.BAKER_FEE:   strconv.Itoa(settings.BakerFee),
+			storage.UI_EXPLORER: settings.UiExplorer,
+		}); err != nil {
+			return errors.Wrap(err, "Cannot save baker settings")
+		}
+	}
+
+	return c.print(settings, func(w io.Writer) {
+		fmt.Fprintf(w, "Baker fee set to %d%%\n", settings.BakerFee)
+	})
+}
+
+// payoutsHandler reads payouts records from the DB; It cannot send payouts
+func (c *cliContext) payoutsHandler() (*payouts.PayoutsHandler, error) {
+	return payouts.NewPayoutsHandler(nil, c.db, nil, nil, true)
+}
+
+func cliPayoutsList(c *cliContext, args []string) error {
+
+	var list webserver.PayoutsV1
+
+	if c.api != nil {
+		if err := c.api.call("GET", "/payouts", nil, &list); err != nil {
+			return err
+		}
+	} else {
+
+		ph, err := c.payoutsHandler()
+		if err != nil {
+			return err
+		}
+
+		metadata, err := ph.GetPayoutsMetadataAll()
+		if err != nil {
+			return errors.Wrap(err, "Unable to get payouts")
+		}
+
+		list.Cycles = make([]webserver.PayoutCycleV1, 0, len(metadata))
+
+		for cycle, m := range metadata {
+			list.Cycles = append(list.Cycles, webserver.NewPayoutCycleV1(cycle, m))
+		}
+
+		sort.Slice(list.Cycles, func(i, j int) bool {
+			return list.Cycles[i].Cycle < list.Cycles[j].Cycle
+		})
+	}
+
+	return c.print(list, func(w io.Writer) {
+		fmt.Fprintln(w, "CYCLE\tSTATUS\tDELEGATORS\tREWARDS\tFEE")
+		for _, p := range list.Cycles {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%.0f%%\n", p.Cycle, p.Status, p.NumDelegators, p.BlockRewards+p.FeeRewards, p.BakerFee*100)
+		}
+	})
+}
+
+func cliPayoutsShow(c *cliContext, args []string) error {
+
+	cycle, err := c.cycleArg()
+	if err != nil {
+		return err
+	}
+
+	if cycle < 0 {
+		return errors.New("-cycle is required")
+	}
+
+	var detail webserver.PayoutCycleDetailV1
+
+	if c.api != nil {
+		if err := c.api.call("GET", fmt.Sprintf("/payouts/%d", cycle), nil, &detail); err != nil {
+			return err
+		}
+	} else {
+
+		ph, err := c.payoutsHandler()
+		if err != nil {
+			return err
+		}
+
+		metadata, err := ph.GetRewardMetadataForCycle(cycle)
+		if err != nil {
+			return errors.Wrap(err, "Unable to get cycle payouts")
+		}
+
+		if metadata.Status == "" {
+			return errors.Errorf("No payouts for cycle %d", cycle)
+		}
+
+		rewards, err := ph.GetDelegatorRewardAllForCycle(cycle)
+		if err != nil {
+			return errors.Wrap(err, "Unable to get cycle rewards")
+		}
+
+		detail = webserver.PayoutCycleDetailV1{
+			Cycle:   webserver.NewPayoutCycleV1(cycle, metadata),
+			Rewards: make([]webserver.DelegatorRewardV1, 0, len(rewards)),
+		}
+
+		for _, d := range rewards {
+			detail.Rewards = append(detail.Rewards, webserver.DelegatorRewardV1{
+				Delegator: d.Delegator, Balance: d.Balance, SharePct: d.SharePct, Reward: d.Reward, OpHash: d.OpHash,
+			})
+		}
+
+		sort.Slice(detail.Rewards, func(i, j int) bool {
+			return detail.Rewards[i].Delegator < detail.Rewards[j].Delegator
+		})
+	}
+
+	return c.print(detail, func(w io.Writer) {
+		fmt.Fprintf(w, "Cycle %d: %s\n\n", detail.Cycle.Cycle, detail.Cycle.Status)
+		fmt.Fprintln(w, "DELEGATOR\tBALANCE\tSHARE\tREWARD\tOPERATION")
+		for _, d := range detail.Rewards {
+			fmt.Fprintf(w, "%s\t%d\t%.4f%%\t%d\t%s\n", d.Delegator, d.Balance, d.SharePct*100, d.Reward, d.OpHash)
+		}
+	})
+}
+
+func cliPayoutsSend(c *cliContext, args []string) error {
+
+	cycle := c.intFlag("cycle")
+	if cycle < 0 {
+		return errors.New("-cycle is required")
+	}
+
+	var resp webserver.SendPayoutsResponseV1
+	if err := c.api.call("POST", fmt.Sprintf("/payouts/%d/send", cycle), nil, &resp); err != nil {
+		return err
+	}
+
+	return c.print(resp, func(w io.Writer) {
+		fmt.Fprintf(w, "Sending payouts for cycle %d; Check progress with 'bakinbacon payouts show -cycle %d'\n", resp.Cycle, resp.Cycle)
+	})
+}
+
+func cliVote(c *cliContext, args []string) error {
+
+	var op webserver.OperationV1
+	if err := c.api.call("POST", "/voting/upvote", webserver.UpvoteRequestV1{
+		Proposal: c.stringFlag("proposal"),
+		Period:   c.intFlag("period"),
+	}, &op); err != nil {
+		return err
+	}
+
+	return c.print(op, func(w io.Writer) {
+		fmt.Fprintf(w, "Injected vote %s\n", op.OpHash)
+	})
+}
+
+func cliBackup(c *cliContext, args []string) error {
+
+	out := c.stringFlag("out")
+	if out == "" {
+		out = fmt.Sprintf("bakinbacon-%s-%s.db", c.network, time.Now().UTC().Format(BACKUP_TIME_FORMAT))
+	}
+
+	if c.api != nil {
+
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return errors.Wrap(err, "Unable to create backup file")
+		}
+
+		if err := c.api.download("/backup", f); err != nil {
+			f.Close()
+			os.Remove(out)
+			return err
+		}
+
+		if err := f.Close(); err != nil {
+			return errors.Wrap(err, "Unable to write backup file")
+		}
+
+	} else {
+		if err := c.db.BackupToFile(out); err != nil {
+			return errors.Wrap(err, "Unable to write backup file")
+		}
+	}
+
+	return c.print(map[string]string{"file": out}, func(w io.Writer) {
+		fmt.Fprintf(w, "Backed up database to %s\n", out)
+	})
+}
+
+func cliExport(c *cliContext, args []string) error {
+
+	w := c.out
+
+	if out := c.stringFlag("out"); out != "-" {
+
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return errors.Wrap(err, "Unable to create export file")
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	if c.api != nil {
+		return c.api.download("/export", w)
+	}
+
+	return c.db.Export(w)
+}
+
+type cliRestoreResult struct {
+	File               string `json:"file"`
+	SchemaVersion      int    `json:"schemaVersion"`
+	BakingWatermark    int    `json:"bakingWatermark"`
+	EndorsingWatermark int    `json:"endorsingWatermark"`
+	PreviousDatabase   string `json:"previousDatabase,omitempty"`
+}
+
+// cliRestore replaces the database with a backup. An old backup does not know about
+// blocks and endorsements signed since it was made, so its watermarks must not be below
+// those of the database it replaces, nor below the chain head.
+func cliRestore(c *cliContext, args []string) error {
+
+	if len(args) != 1 {
+		return errors.New("Expected the backup file")
+	}
+
+	dbFile := c.dataDir + storage.DATABASE_FILE
+
+	// Floor for watermarks
+	floor := 0
+	floorSource := ""
+
+	_, err := os.Stat(dbFile)
+	hasCurrent := err == nil
+
+	if hasCurrent {
+
+		current, err := storage.OpenReadOnly(c.dataDir, c.network)
+		if err != nil {
+			if errors.Cause(err) == bolt.ErrTimeout {
+				return errors.New("Database is in use by the running daemon; Stop it before restoring")
+			}
+			return err
+		}
+
+		floor, err = highestWatermark(current)
+		current.Close()
+
+		if err != nil {
+			return errors.Wrap(err, "Unable to get current watermarks")
+		}
+		floorSource = "the current database"
+	}
+
+	// Restore next to the DB, so it can be renamed into place
+	tmpDir, err := ioutil.TempDir(c.dataDir, "restore-")
+	if err != nil {
+		return errors.Wrap(err, "Unable to create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpDir += "/"
+
+	if err := storage.RestoreToFile(args[0], tmpDir+storage.DATABASE_FILE); err != nil {
+		return err
+	}
+
+	// Upgrades older backups, and refuses newer ones
+	restored, err := storage.InitStorage(tmpDir, c.network)
+	if err != nil {
+		return err
+	}
+	defer restored.Close()
+
+	if !c.fs.Lookup("offline").Value.(flag.Getter).Get().(bool) {
+
+		endpoints, err := c.rpcEndpoints(restored)
+		if err != nil {
+			return errors.Wrap(err, "Unable to get endpoints from backup")
+		}
+
+		headLevel, err := getBlockLevel(endpoints, "head")
+		if err != nil {
+			return errors.Wrap(err, "Unable to check watermarks against the chain; Use -rpc, or -offline to skip")
+		}
+
+		if headLevel > floor {
+			floor = headLevel
+			floorSource = "the chain head"
+		}
+	}
+
+	bakingWatermark, err := restored.GetBakingWatermark()
+	if err != nil {
+		return err
+	}
+
+	endorsingWatermark, err := restored.GetEndorsingWatermark()
+	if err != nil {
+		return err
+	}
+
+	if bakingWatermark < floor || endorsingWatermark < floor {
+
+		if !c.fs.Lookup("raise-watermarks").Value.(flag.Getter).Get().(bool) {
+			return errors.Errorf("Backup watermarks (baking %d, endorsing %d) are below level %d of %s; "+
+				"Restoring them could cause double baking or endorsing. Use -raise-watermarks to raise them to %d",
+				bakingWatermark, endorsingWatermark, floor, floorSource, floor)
+		}
+
+		if err := restored.RaiseWatermarks(floor); err != nil {
+			return errors.Wrap(err, "Unable to raise watermarks")
+		}
+
+		bakingWatermark = maxInt(bakingWatermark, floor)
+		endorsingWatermark = maxInt(endorsingWatermark, floor)
+	}
+
+	result := cliRestoreResult{
+		File:               dbFile,
+		BakingWatermark:    bakingWatermark,
+		EndorsingWatermark: endorsingWatermark,
+	}
+
+	if result.SchemaVersion, err = restored.GetSchemaVersion(); err != nil {
+		return err
+	}
+
+	if err := restored.Close(); err != nil {
+		return errors.Wrap(err, "Unable to close restored database")
+	}
+
+	// Keep the replaced DB, in case of mistakes
+	if hasCurrent {
+		result.PreviousDatabase = fmt.Sprintf("%s.pre-restore-%s.bak", dbFile, time.Now().UTC().Format(BACKUP_TIME_FORMAT))
+		if err := os.Rename(dbFile, result.PreviousDatabase); err != nil {
+			return errors.Wrap(err, "Unable to move current database aside")
+		}
+	}
+
+	if err := os.Rename(tmpDir+storage.DATABASE_FILE, dbFile); err != nil {
+		return errors.Wrap(err, "Unable to move restored database into place")
+	}
+
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Restored database to %s\n", result.File)
+		fmt.Fprintf(w, "Schema version:\t%d\n", result.SchemaVersion)
+		fmt.Fprintf(w, "Baking watermark:\t%d\n", result.BakingWatermark)
+		fmt.Fprintf(w, "Endorsing watermark:\t%d\n", result.EndorsingWatermark)
+		if result.PreviousDatabase != "" {
+			fmt.Fprintf(w, "Previous database:\t%s\n", result.PreviousDatabase)
+		}
+	})
+}
+
+// rpcEndpoints returns the -rpc flag, or else the endpoints in db
+func (c *cliContext) rpcEndpoints(db *storage.Storage) ([]string, error) {
+
+	if endpoints := util.SplitList(c.stringFlag("rpc")); len(endpoints) > 0 {
+		return endpoints, nil
+	}
+
+	dbEndpoints, err := db.GetRPCEndpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]string, 0, len(dbEndpoints))
+	for _, e := range dbEndpoints {
+		endpoints = append(endpoints, e)
+	}
+
+	return endpoints, nil
+}
+
+func highestWatermark(db *storage.Storage) (int, error) {
+
+	bakingWatermark, err := db.GetBakingWatermark()
+	if err != nil {
+		return 0, err
+	}
+
+	endorsingWatermark, err := db.GetEndorsingWatermark()
+	if err != nil {
+		return 0, err
+	}
+
+	return maxInt(bakingWatermark, endorsingWatermark), nil
+}
+
+// getBlockLevel returns the level of block, a hash or "head", from the first endpoint which answers
+func getBlockLevel(endpoints []string, block string) (int, error) {
+
+	var header struct {
+		Level int `json:"level"`
+	}
+
+	err := getBlockPart(endpoints, block, "header", &header)
+
+	return header.Level, err
+}
+
+// getBlockLevelInfo returns the level and cycle of block as the chain has them
+func getBlockLevelInfo(endpoints []string, block string) (int, int, error) {
+
+	var metadata struct {
+		LevelInfo struct {
+			Level int `json:"level"`
+			Cycle int `json:"cycle"`
+		} `json:"level_info"`
+	}
+
+	err := getBlockPart(endpoints, block, "metadata", &metadata)
+
+	return metadata.LevelInfo.Level, metadata.LevelInfo.Cycle, err
+}
+
+// getBlockPart decodes part of block, eg its header, from the first endpoint which has it
+func getBlockPart(endpoints []string, block, part string, out interface{}) error {
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	err := errors.New("No RPC endpoints")
+
+	for _, e := range endpoints {
+
+		err = func() error {
+			resp, err := client.Get(strings.TrimSuffix(e, "/") + "/chains/main/blocks/" + block + "/" + part)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errors.Errorf("%s returned %s", e, resp.Status)
+			}
+
+			return json.NewDecoder(resp.Body).Decode(out)
+		}()
+
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+type cliOctezImportResult struct {
+	Delegate           string `json:"delegate"`
+	BakingWatermark    int    `json:"bakingWatermark"`
+	EndorsingWatermark int    `json:"endorsingWatermark"`
+	NoncesImported     int    `json:"noncesImported"`
+	NoncesSkipped      int    `json:"noncesSkipped"`
+}
+
+// cliOctezImport takes over from an Octez baker. Its watermarks stop us signing anything
+// it already signed, and its nonces are needed to reveal for blocks it baked.
+func cliOctezImport(c *cliContext, args []string) error {
+
+	dir := c.stringFlag("dir")
+
+	result := cliOctezImportResult{
+		Delegate: c.stringFlag("delegate"),
+	}
+
+	if result.Delegate == "" {
+
+		_, pkh, err := c.db.GetDelegate()
+		if err != nil {
+			return errors.Wrap(err, "Cannot get delegate")
+		}
+
+		if pkh == "" {
+			return errors.New("No baker key set up; Use -delegate")
+		}
+		result.Delegate = pkh
+	}
+
+	// Watermarks; Existing ones are only raised
+	for _, w := range []struct {
+		file  string
+		raise func(int) error
+		get   func() (int, error)
+		out   *int
+	}{
+		{OCTEZ_BLOCKS_FILE, c.db.RaiseBakingWatermark, c.db.GetBakingWatermark, &result.BakingWatermark},
+		{OCTEZ_ENDORSEMENTS_FILE, c.db.RaiseEndorsingWatermark, c.db.GetEndorsingWatermark, &result.EndorsingWatermark},
+	} {
+
+		file := filepath.Join(dir, w.file)
+		if !octezFileExists(file) {
+			fmt.Fprintf(os.Stderr, "No %s file in %s; Skipping\n", w.file, dir)
+		} else {
+
+			level, found, err := readOctezWatermark(file, result.Delegate)
+			if err != nil {
+				return err
+			}
+
+			if !found {
+				fmt.Fprintf(os.Stderr, "No watermark for %s in %s\n", result.Delegate, file)
+			} else if err := w.raise(level); err != nil {
+				return errors.Wrap(err, "Unable to save watermark")
+			}
+		}
+
+		var err error
+		if *w.out, err = w.get(); err != nil {
+			return err
+		}
+	}
+
+	// Nonces
+	noncesFile := filepath.Join(dir, OCTEZ_NONCES_FILE)
+	if !octezFileExists(noncesFile) {
+		fmt.Fprintf(os.Stderr, "No %s file in %s; Skipping\n", OCTEZ_NONCES_FILE, dir)
+	} else if err := c.importOctezNonces(noncesFile, &result); err != nil {
+		return err
+	}
+
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Delegate:\t%s\n", result.Delegate)
+		fmt.Fprintf(w, "Baking watermark:\t%d\n", result.BakingWatermark)
+		fmt.Fprintf(w, "Endorsing watermark:\t%d\n", result.EndorsingWatermark)
+		fmt.Fprintf(w, "Nonces imported:\t%d\n", result.NoncesImported)
+		fmt.Fprintf(w, "Nonces skipped:\t%d\n", result.NoncesSkipped)
+	})
+}
+
+// importOctezNonces saves nonces which may still need revealing. Octez only records the
+// block each nonce was committed in, so the level comes from the chain.
+func (c *cliContext) importOctezNonces(file string, result *cliOctezImportResult) error {
+
+	octezNonces, err := readOctezNonces(file)
+	if err != nil {
+		return err
+	}
+
+	if len(octezNonces) == 0 {
+		return nil
+	}
+
+	endpoints, err := c.rpcEndpoints(c.db)
+	if err != nil {
+		return errors.Wrap(err, "Cannot get endpoints")
+	}
+
+	_, headCycle, err := getBlockLevelInfo(endpoints, "head")
+	if err != nil {
+		return errors.Wrap(err, "Unable to get chain head; Use -rpc")
+	}
+
+	// Nonces are revealed during the cycle after they were committed
+	minCycle := headCycle - 1
+
+	for _, on := range octezNonces {
+
+		// The cycle comes from the block's metadata, as when baking
+		level, cycle, err := getBlockLevelInfo(endpoints, on.Block)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping nonce for block %s; Not found: %s\n", on.Block, err)
+			result.NoncesSkipped++
+			continue
+		}
+
+		if cycle < minCycle {
+			result.NoncesSkipped++
+			continue
+		}
+
+		// Keep nonces we already have, which may have been revealed
+		existing, err := c.db.GetNoncesForCycle(cycle)
+		if err != nil {
+			return errors.Wrap(err, "Unable to get nonces")
+		}
+
+		if hasNonceForLevel(existing, level) {
+			result.NoncesSkipped++
+			continue
+		}
+
+		n, err := nonce.FromSeed(on.Seed)
+		if err != nil {
+			return errors.Wrap(err, "Unable to create nonce")
+		}
+		n.Level = level
+
+		nonceBytes, err := json.Marshal(n)
+		if err != nil {
+			return errors.Wrap(err, "Unable to encode nonce")
+		}
+
+		if err := c.db.SaveNonce(cycle, level, nonceBytes); err != nil {
+			return errors.Wrap(err, "Unable to save nonce")
+		}
+
+		result.NoncesImported++
+	}
+
+	return nil
+}
+
+func hasNonceForLevel(nonces []json.RawMessage, level int) bool {
+
+	for _, b := range nonces {
+		var n nonce.Nonce
+		if err := json.Unmarshal(b, &n); err == nil && n.Level == level {
+			return true
+		}
+	}
+
+	return false
+}
+
+func cliCompact(c *cliContext, args []string) error {
+
+	dbFile := c.dataDir + storage.DATABASE_FILE
+
+	before, err := os.Stat(dbFile)
+	if err != nil {
+		return errors.Errorf("No database found in %s", c.dataDir)
+	}
+
+	db, err := storage.OpenReadOnly(c.dataDir, c.network)
+	if err != nil {
+		if errors.Cause(err) == bolt.ErrTimeout {
+			return errors.New("Database is in use by the running daemon; Stop it before compacting")
+		}
+		return err
+	}
+
+	// Left behind by an interrupted compaction
+	compactFile := dbFile + ".compact"
+	os.Remove(compactFile)
+
+	err = db.CompactTo(compactFile)
+	db.Close()
+
+	if err != nil {
+		return err
+	}
+
+	after, err := os.Stat(compactFile)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(compactFile, dbFile); err != nil {
+		return errors.Wrap(err, "Unable to move compacted database into place")
+	}
+
+	sizes := map[string]int64{"before": before.Size(), "after": after.Size()}
+
+	return c.print(sizes, func(w io.Writer) {
+		fmt.Fprintf(w, "Compacted %s from %d to %d bytes\n", dbFile, sizes["before"], sizes["after"])
+	})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bakinbacon/storage"
+	"bakinbacon/util"
+	"bakinbacon/webserver"
+)
+
+// cliDataDir returns a data dir with a new DB, after letting setup change it
+func cliDataDir(t *testing.T, setup func(db *storage.Storage)) string {
+
+	t.Helper()
+
+	dir := t.TempDir() + "/"
+
+	db, err := storage.InitStorage(dir, util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if setup != nil {
+		setup(db)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// cli runs a command, returning the exit code, stdout and stderr
+func cli(t *testing.T, args ...string) (int, string, string) {
+
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := runCli(args, &stdout, &stderr)
+
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCliEndpoints(t *testing.T) {
+
+	t.Setenv(CLI_TOKEN_ENV, "")
+
+	dir := cliDataDir(t, nil)
+
+	code, out, errOut := cli(t, "endpoint", "add", "-datadir", dir, "-json", "http://127.0.0.1:8732")
+	if code != 0 {
+		t.Fatalf("Add: %d %s", code, errOut)
+	}
+
+	var added webserver.EndpointV1
+	if err := json.Unmarshal([]byte(out), &added); err != nil || added.Id == 0 || added.Url != "http://127.0.0.1:8732" {
+		t.Fatalf("Added %q: %v", out, err)
+	}
+
+	if code, _, errOut := cli(t, "endpoint", "add", "-datadir", dir, "http://127.0.0.1:8732"); code != 1 || !strings.Contains(errOut, "already exists") {
+		t.Errorf("Duplicate add: %d %s", code, errOut)
+	}
+
+	// Table output
+	if code, out, _ := cli(t, "endpoint", "list", "-datadir", dir); code != 0 || !strings.Contains(out, "ID") || !strings.Contains(out, "http://127.0.0.1:8732") {
+		t.Errorf("List: %d %s", code, out)
+	}
+
+	if code, out, errOut := cli(t, "endpoint", "delete", "-datadir", dir, "999"); code != 1 || !strings.Contains(errOut, "Unknown endpoint") {
+		t.Errorf("Delete unknown: %d %s %s", code, out, errOut)
+	}
+
+	if code, _, errOut := cli(t, "endpoint", "delete", "-datadir", dir, strconv.Itoa(added.Id)); code != 0 {
+		t.Fatalf("Delete: %d %s", code, errOut)
+	}
+
+	code, out, _ = cli(t, "endpoint", "list", "-datadir", dir, "-json")
+
+	var endpoints webserver.EndpointsV1
+	if err := json.Unmarshal([]byte(out), &endpoints); code != 0 || err != nil {
+		t.Fatalf("List: %d %s %v", code, out, err)
+	}
+
+	for _, e := range endpoints.Endpoints {
+		if e.Id == added.Id {
+			t.Errorf("Endpoint %d not deleted", e.Id)
+		}
+	}
+}
+
+func TestCliFee(t *testing.T) {
+
+	t.Setenv(CLI_TOKEN_ENV, "")
+
+	dir := cliDataDir(t, func(db *storage.Storage) {
+		if err := db.SaveBakerSettings(map[string]string{storage.BAKER_FEE: "5", storage.UI_EXPLORER: "tzstats"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if code, _, errOut := cli(t, "fee", "set", "-datadir", dir, "12%"); code != 0 {
+		t.Fatalf("Set: %d %s", code, errOut)
+	}
+
+	for _, fee := range []string{"0", "100", "ten"} {
+		if code, _, errOut := cli(t, "fee", "set", "-datadir", dir, fee); code != 1 || !strings.Contains(errOut, "between 1 and 99") {
+			t.Errorf("Set %s: %d %s", fee, code, errOut)
+		}
+	}
+
+	code, out, _ := cli(t, "fee", "show", "-datadir", dir, "-json")
+
+	var settings webserver.BakerSettingsV1
+	if err := json.Unmarshal([]byte(out), &settings); code != 0 || err != nil || settings.BakerFee != 12 || settings.UiExplorer != "tzstats" {
+		t.Errorf("Show: %d %s %v", code, out, err)
+	}
+
+	if code, out, _ := cli(t, "fee", "show", "-datadir", dir); code != 0 || !strings.Contains(out, "12%") {
+		t.Errorf("Show: %d %s", code, out)
+	}
+}
+
+// With a token, commands go through the daemon's API instead of the DB
+func TestCliApi(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "Invalid token"}`))
+			return
+		}
+
+		if r.Method != http.MethodGet || r.URL.Path != "/api/v1/endpoints" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"endpoints": [{"id": 3, "url": "http://node:8732"}]}`))
+	}))
+	defer srv.Close()
+
+	// No DB in the data dir, so this could only come from the API
+	dir := t.TempDir()
+
+	if code, out, errOut := cli(t, "endpoint", "list", "-datadir", dir, "-api", srv.URL, "-token", "secret"); code != 0 || !strings.Contains(out, "http://node:8732") {
+		t.Errorf("List: %d %s %s", code, out, errOut)
+	}
+
+	if code, _, errOut := cli(t, "endpoint", "list", "-datadir", dir, "-api", srv.URL, "-token", "wrong"); code != 1 || !strings.Contains(errOut, "Invalid token") {
+		t.Errorf("Wrong token: %d %s", code, errOut)
+	}
+}
+
+func TestCliGuards(t *testing.T) {
+
+	t.Setenv(CLI_TOKEN_ENV, "")
+
+	dir := cliDataDir(t, nil)
+
+	if code, _, errOut := cli(t, "payouts", "send", "-datadir", dir, "-cycle", "10"); code != 1 || !strings.Contains(errOut, "requires the running daemon") {
+		t.Errorf("Needs daemon: %d %s", code, errOut)
+	}
+
+	for _, args := range [][]string{
+		{"octez", "import", "-token", "secret"},
+		{"restore", "-token", "secret", "backup.db"},
+	} {
+		if code, _, errOut := cli(t, args...); code != 1 || !strings.Contains(errOut, "requires the daemon to be stopped") {
+			t.Errorf("%v: %d %s", args, code, errOut)
+		}
+	}
+
+	if code, _, errOut := cli(t, "fee", "show", "-datadir", t.TempDir()); code != 1 || !strings.Contains(errOut, "No database found") {
+		t.Errorf("No DB: %d %s", code, errOut)
+	}
+
+	if code, _, _ := cli(t, "no", "such", "command"); code != 2 {
+		t.Errorf("Unknown command: %d", code)
+	}
+}
+
+// An old backup must not lower the watermarks of the database it replaces
+func TestCliRestoreWatermarks(t *testing.T) {
+
+	t.Setenv(CLI_TOKEN_ENV, "")
+
+	backupDir := cliDataDir(t, func(db *storage.Storage) {
+		if err := db.RecordBakedBlock(4000, "BLockOld"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	backup := filepath.Join(backupDir, storage.DATABASE_FILE)
+
+	dir := cliDataDir(t, func(db *storage.Storage) {
+		if err := db.RecordEndorsement(5000, "ooNew"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	watermarks := func() (int, int) {
+		t.Helper()
+
+		db, err := storage.OpenReadOnly(dir, util.NETWORK_HANGZHOUNET)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		baking, _ := db.GetBakingWatermark()
+		endorsing, _ := db.GetEndorsingWatermark()
+
+		return baking, endorsing
+	}
+
+	code, _, errOut := cli(t, "restore", "-datadir", dir, "-offline", backup)
+	if code != 1 || !strings.Contains(errOut, "below level 5000 of the current database") {
+		t.Fatalf("Restore: %d %s", code, errOut)
+	}
+
+	// Refused, so nothing changed
+	if baking, endorsing := watermarks(); baking != 0 || endorsing != 5000 {
+		t.Errorf("After refusal, watermarks %d, %d", baking, endorsing)
+	}
+
+	code, out, errOut := cli(t, "restore", "-datadir", dir, "-offline", "-raise-watermarks", "-json", backup)
+	if code != 0 {
+		t.Fatalf("Restore: %d %s", code, errOut)
+	}
+
+	var result cliRestoreResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil || result.PreviousDatabase == "" {
+		t.Errorf("Result %s: %v", out, err)
+	}
+
+	if baking, endorsing := watermarks(); baking != 5000 || endorsing != 5000 {
+		t.Errorf("Restored watermarks %d, %d", baking, endorsing)
+	}
+
+	// The backup's own records were restored
+	db, err := storage.OpenReadOnly(dir, util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if level, hash, err := db.GetRecentBake(); err != nil || level != 4000 || hash != "BLockOld" {
+		t.Errorf("Recent bake %d %s %v", level, hash, err)
+	}
+}
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/baconsigner"
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+// Apply writes the settings held in the DB which are managed by the config
+// file or environment. Must be called before anything loads them from the DB.
+func (c *Config) Apply(db *storage.Storage) error {
+
+	if err := c.applyEndpoints(db); err != nil {
+		return errors.Wrap(err, "Unable to apply endpoints")
+	}
+
+	if err := c.applyBakerSettings(db); err != nil {
+		return errors.Wrap(err, "Unable to apply baker settings")
+	}
+
+	if err := c.applySigner(db); err != nil {
+		return errors.Wrap(err, "Unable to apply signer")
+	}
+
+	if err := c.applyNotifications(db); err != nil {
+		return errors.Wrap(err, "Unable to apply notifications")
+	}
+
+	return nil
+}
+
+func (c *Config) applyEndpoints(db *storage.Storage) error {
+
+	if len(c.Endpoints) == 0 {
+		return nil
+	}
+
+	current, err := db.GetRPCEndpoints()
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool)
+	for _, e := range c.Endpoints {
+		wanted[e] = true
+	}
+
+	for id, e := range current {
+		if wanted[e] {
+			delete(wanted, e)
+			continue
+		}
+
+		if err := db.DeleteRPCEndpoint(id); err != nil {
+			return err
+		}
+
+		log.WithField("Endpoint", e).Info("Config: Removed RPC endpoint")
+	}
+
+	// Add in the configured order
+	for _, e := range c.Endpoints {
+		if !wanted[e] {
+			continue
+		}
+
+		if _, err := db.AddRPCEndpoint(e); err != nil {
+			return err
+		}
+		delete(wanted, e)
+
+		log.WithField("Endpoint", e).Info("Config: Added RPC endpoint")
+	}
+
+	return nil
+}
+
+func (c *Config) applyBakerSettings(db *storage.Storage) error {
+
+	if c.Payouts.BakerFee == nil && c.Explorer == "" {
+		return nil
+	}
+
+	settings, err := db.GetBakerSettings()
+	if err != nil {
+		return err
+	}
+
+	bakerFee, _ := settings[storage.BAKER_FEE].(string)
+	explorer, _ := settings[storage.UI_EXPLORER].(string)
+
+	if c.Payouts.BakerFee != nil {
+		bakerFee = strconv.Itoa(*c.Payouts.BakerFee)
+	}
+
+	if c.Explorer != "" {
+		explorer = c.Explorer
+	}
+
+	return db.SaveBakerSettings(map[string]string{
+		storage.BAKER_FEE:   bakerFee,
+		storage.UI_EXPLORER: explorer,
+	})
+}
+
+func (c *Config) applySigner(db *storage.Storage) error {
+
+	if c.Signer == nil {
+		return nil
+	}
+
+	currentSk, currentPkh, err := db.GetDelegate()
+	if err != nil {
+		return err
+	}
+
+	switch c.Signer.Type {
+	case SIGNER_TYPE_WALLET:
+
+		secretKey := c.Signer.SecretKey
+		if c.Signer.SecretKeyFile != "" {
+			skBytes, err := ioutil.ReadFile(c.Signer.SecretKeyFile)
+			if err != nil {
+				return errors.Wrap(err, "Unable to read secret key file")
+			}
+			secretKey = strings.TrimSpace(string(skBytes))
+		}
+
+		if secretKey == currentSk {
+			return nil
+		}
+
+		// Validates the key, and saves it with the wallet signer type
+		_, pkh, err := baconsigner.ImportSecretKey(secretKey, db)
+		if err != nil {
+			return err
+		}
+
+		log.WithField("PKH", pkh).Info("Config: Imported wallet secret key")
+
+	case SIGNER_TYPE_LEDGER:
+
+		signerType, err := db.GetSignerType()
+		if err != nil {
+			return err
+		}
+
+		if signerType == baconsigner.SIGNER_LEDGER && c.Signer.Pkh == currentPkh {
+			return nil
+		}
+
+		if err := db.SaveLedgerToDB(c.Signer.Pkh, c.Signer.BipPath, baconsigner.SIGNER_LEDGER); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"PKH": c.Signer.Pkh, "BipPath": c.Signer.BipPath,
+		}).Info("Config: Set ledger signer")
+	}
+
+	return nil
+}
+
+func (c *Config) applyNotifications(db *storage.Storage) error {
+
+	if t := c.Notifications.Telegram; t != nil {
+
+		telegramConfig, err := json.Marshal(notifications.NotifyTelegram{
+			Enabled:        t.Enabled,
+			ApiKey:         t.ApiKey,
+			ChatIds:        t.ChatIds,
+			Commands:       t.Commands,
+			PayoutCommands: t.PayoutCommands,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := db.SaveNotifiersConfig(notifications.TELEGRAM, telegramConfig); err != nil {
+			return err
+		}
+	}
+
+	if e := c.Notifications.Email; e != nil {
+
+		emailConfig, err := json.Marshal(e.notifier())
+		if err != nil {
+			return err
+		}
+
+		if err := db.SaveNotifiersConfig(notifications.EMAIL, emailConfig); err != nil {
+			return err
+		}
+	}
+
+	if wh := c.Notifications.Webhook; wh != nil {
+
+		webhookConfig, err := json.Marshal(wh.notifier())
+		if err != nil {
+			return err
+		}
+
+		if err := db.SaveNotifiersConfig(notifications.WEBHOOK, webhookConfig); err != nil {
+			return err
+		}
+	}
+
+	if r := c.Notifications.Routing; r != nil {
+
+		routing, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		if err := db.SaveNotificationRouting(routing); err != nil {
+			return err
+		}
+	}
+
+	// These share their JSON layout with the notifiers
+	if sl := c.Notifications.Slack; sl != nil {
+		if err := saveNotifierConfig(db, notifications.SLACK, sl); err != nil {
+			return err
+		}
+	}
+
+	if d := c.Notifications.Discord; d != nil {
+		if err := saveNotifierConfig(db, notifications.DISCORD, d); err != nil {
+			return err
+		}
+	}
+
+	if m := c.Notifications.Matrix; m != nil {
+		if err := saveNotifierConfig(db, notifications.MATRIX, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func saveNotifierConfig(db *storage.Storage, notifier string, config interface{}) error {
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return db.SaveNotifiersConfig(notifier, configBytes)
+}
+
+func (e *EmailConfig) notifier() *notifications.NotifyEmail {
+	return &notifications.NotifyEmail{
+		Enabled:   e.Enabled,
+		Username:  e.Username,
+		Password:  e.Password,
+		Smtp_host: e.SmtpHost,
+		Smtp_port: e.SmtpPort,
+		Security:  e.Security,
+		Auth:      e.Auth,
+		From:      e.From,
+		To:        e.To,
+	}
+}
+
+func (wh *WebhookConfig) notifier() *notifications.NotifyWebhook {
+	return &notifications.NotifyWebhook{
+		Enabled:  wh.Enabled,
+		Urls:     wh.Urls,
+		Headers:  wh.Headers,
+		Template: wh.Template,
+		Secret:   wh.Secret,
+		Retries:  wh.Retries,
+	}
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"bakinbacon/baconsigner"
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+func testDB(t *testing.T) *storage.Storage {
+
+	t.Helper()
+
+	db, err := storage.InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// Configured endpoints replace those in the DB; Ones already there are kept as they are
+func TestApplyEndpoints(t *testing.T) {
+
+	db := testDB(t)
+
+	for _, e := range []string{"http://a:8732", "http://b:8732"} {
+		if _, err := db.AddRPCEndpoint(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, _ := db.GetRPCEndpoints()
+
+	c := &Config{Endpoints: []string{"http://b:8732", "http://c:8732"}}
+	if err := c.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := db.GetRPCEndpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(after) != 2 {
+		t.Fatalf("Endpoints = %v", after)
+	}
+
+	found := make(map[string]int)
+	for id, e := range after {
+		found[e] = id
+	}
+
+	if _, ok := found["http://c:8732"]; !ok {
+		t.Errorf("Endpoints = %v", after)
+	}
+
+	if id, ok := found["http://b:8732"]; !ok || before[id] != "http://b:8732" {
+		t.Errorf("Existing endpoint not kept; Before %v, after %v", before, after)
+	}
+
+	// None configured leaves the DB alone
+	if err := (&Config{}).Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if unchanged, _ := db.GetRPCEndpoints(); len(unchanged) != 2 {
+		t.Errorf("Endpoints = %v", unchanged)
+	}
+}
+
+func TestApplyBakerSettings(t *testing.T) {
+
+	db := testDB(t)
+
+	if err := db.SaveBakerSettings(map[string]string{storage.BAKER_FEE: "5", storage.UI_EXPLORER: "tzstats"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fee := 12
+	c := &Config{Payouts: PayoutsConfig{BakerFee: &fee}}
+	if err := c.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, _ := db.GetBakerSettings()
+	if settings[storage.BAKER_FEE] != "12" || settings[storage.UI_EXPLORER] != "tzstats" {
+		t.Errorf("Settings = %v", settings)
+	}
+}
+
+func TestApplyWalletSigner(t *testing.T) {
+
+	// A key to import
+	sk, pkh, err := baconsigner.GenerateNewKey(testDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skFile := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(skFile, []byte(sk+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db := testDB(t)
+
+	c := &Config{Signer: &SignerConfig{Type: SIGNER_TYPE_WALLET, SecretKeyFile: skFile}}
+	if err := c.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSigner := func() {
+		t.Helper()
+
+		dbSk, dbPkh, _ := db.GetDelegate()
+		signerType, _ := db.GetSignerType()
+
+		if dbSk != sk || dbPkh != pkh || signerType != baconsigner.SIGNER_WALLET {
+			t.Errorf("Signer %s, type %d; Expected %s", dbPkh, signerType, pkh)
+		}
+	}
+
+	checkSigner()
+
+	// The same key again changes nothing
+	if err := c.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSigner()
+
+	bad := &Config{Signer: &SignerConfig{Type: SIGNER_TYPE_WALLET, SecretKey: "edsknotakey"}}
+	if err := bad.Apply(db); err == nil {
+		t.Error("Imported an invalid key")
+	}
+
+	checkSigner()
+}
+
+func TestApplyLedgerSigner(t *testing.T) {
+
+	db := testDB(t)
+
+	c := &Config{Signer: &SignerConfig{Type: SIGNER_TYPE_LEDGER, Pkh: "tz1RMmSzPSWPSSaKU193Voh4PosWSZx1C7Hs", BipPath: "/44'/1729'/0'/1'"}}
+	if err := c.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	_, pkh, _ := db.GetDelegate()
+	signerType, _ := db.GetSignerType()
+
+	if pkh != c.Signer.Pkh || signerType != baconsigner.SIGNER_LEDGER {
+		t.Errorf("Signer %s, type %d", pkh, signerType)
+	}
+}
+
+// Notifier configs replace those from the web UI, in the notifiers' own format
+func TestApplyNotifications(t *testing.T) {
+
+	db := testDB(t)
+
+	c := &Config{Notifications: NotificationsConfig{
+		Telegram: &TelegramConfig{Enabled: true, ApiKey: "123:ABC", ChatIds: []int{1, 2}},
+		Webhook:  &WebhookConfig{Enabled: true, Urls: []string{"https://example.com/hook"}, Retries: 2},
+		Matrix:   &MatrixConfig{Enabled: true, Homeserver: "https://matrix.example.com", AccessToken: "token", RoomIds: []string{"!a:x"}},
+	}}
+
+	if err := c.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var telegram notifications.NotifyTelegram
+	if config, _ := db.GetNotifiersConfig(notifications.TELEGRAM); json.Unmarshal(config, &telegram) != nil ||
+		!telegram.Enabled || telegram.ApiKey != "123:ABC" || len(telegram.ChatIds) != 2 {
+		t.Errorf("Telegram = %+v", telegram)
+	}
+
+	var webhook notifications.NotifyWebhook
+	if config, _ := db.GetNotifiersConfig(notifications.WEBHOOK); json.Unmarshal(config, &webhook) != nil ||
+		!webhook.Enabled || len(webhook.Urls) != 1 || webhook.Retries != 2 {
+		t.Errorf("Webhook = %+v", webhook)
+	}
+
+	var matrix notifications.NotifyMatrix
+	if config, _ := db.GetNotifiersConfig(notifications.MATRIX); json.Unmarshal(config, &matrix) != nil ||
+		!matrix.Enabled || matrix.AccessToken != "token" || len(matrix.RoomIds) != 1 {
+		t.Errorf("Matrix = %+v", matrix)
+	}
+
+	// Not configured, so not written
+	if config, _ := db.GetNotifiersConfig(notifications.EMAIL); config != nil {
+		t.Errorf("Email = %s", config)
+	}
+}
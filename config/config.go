@@ -0,0 +1,409 @@
+package config
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"bakinbacon/notifications"
+	"bakinbacon/util"
+)
+
+// Settings come from, in order of precedence: command-line flags, BAKINBACON_*
+// environment variables, the config file, and lastly the DB. Whatever is set in the
+// config file or environment is written to the DB at startup, replacing changes made
+// through the web UI.
+
+const (
+	ENV_PREFIX = "BAKINBACON_"
+
+	// Location of the config file, if not given by -config
+	CONFIG_FILE_ENV = ENV_PREFIX + "CONFIG"
+
+	SIGNER_TYPE_WALLET = "wallet"
+	SIGNER_TYPE_LEDGER = "ledger"
+
+	DIGEST_TIME_FORMAT = "15:04"
+)
+
+// Each field's environment variable is ENV_PREFIX followed by the env tags
+// of it and its parents, joined by underscores. An empty env tag on a section
+// adds nothing to the names of its fields; A "-" tag is config file only.
+type Config struct {
+	Network string `yaml:"network" env:"NETWORK"`
+	DataDir string `yaml:"datadir" env:"DATADIR"`
+	Debug   *bool  `yaml:"debug" env:"DEBUG"`
+	Trace   *bool  `yaml:"trace" env:"TRACE"`
+
+	WebUi WebUiConfig `yaml:"webui" env:"WEBUI"`
+
+	// Replaces all RPC endpoints in the DB
+	Endpoints []string `yaml:"endpoints" env:"ENDPOINTS"`
+
+	// Block explorer used by the web UI
+	Explorer string `yaml:"explorer" env:"EXPLORER"`
+
+	Signer        *SignerConfig       `yaml:"signer" env:"SIGNER"`
+	Notifications NotificationsConfig `yaml:"notifications" env:""`
+	Payouts       PayoutsConfig       `yaml:"payouts" env:"PAYOUTS"`
+	Backup        BackupConfig        `yaml:"backup" env:"BACKUP"`
+	Digest        DigestConfig        `yaml:"digest" env:"DIGEST"`
+
+	// Cycles of history to keep; Older data is pruned
+	RetentionCycles *int `yaml:"retention_cycles" env:"RETENTION_CYCLES"`
+}
+
+type WebUiConfig struct {
+	Addr             string   `yaml:"addr" env:"ADDR"`
+	Port             *int     `yaml:"port" env:"PORT"`
+	Origins          []string `yaml:"origins" env:"ORIGINS"`
+	Tls              *bool    `yaml:"tls" env:"TLS"`
+	TlsCert          string   `yaml:"tls_cert" env:"TLS_CERT"`
+	TlsKey           string   `yaml:"tls_key" env:"TLS_KEY"`
+	TlsClientCA      string   `yaml:"tls_client_ca" env:"TLS_CLIENT_CA"`
+	HttpRedirectPort *int     `yaml:"http_redirect_port" env:"HTTP_REDIRECT_PORT"`
+}
+
+type SignerConfig struct {
+	Type string `yaml:"type" env:"TYPE"`
+
+	// Wallet; The file is preferred so the key stays out of the config and environment
+	SecretKey     string `yaml:"secret_key" env:"SECRET_KEY"`
+	SecretKeyFile string `yaml:"secret_key_file" env:"SECRET_KEY_FILE"`
+
+	// Ledger; The device must already be authorized for baking with this key
+	Pkh     string `yaml:"pkh" env:"PKH"`
+	BipPath string `yaml:"bip_path" env:"BIP_PATH"`
+}
+
+type NotificationsConfig struct {
+	Telegram *TelegramConfig `yaml:"telegram" env:"TELEGRAM"`
+	Email    *EmailConfig    `yaml:"email" env:"EMAIL"`
+	Webhook  *WebhookConfig  `yaml:"webhook" env:"WEBHOOK"`
+	Slack    *SlackConfig    `yaml:"slack" env:"SLACK"`
+	Discord  *DiscordConfig  `yaml:"discord" env:"DISCORD"`
+	Matrix   *MatrixConfig   `yaml:"matrix" env:"MATRIX"`
+
+	// Replaces the routing rules from the web UI
+	Routing *notifications.Routing `yaml:"routing" env:"-"`
+}
+
+type TelegramConfig struct {
+	Enabled bool   `yaml:"enabled" env:"ENABLED"`
+	ApiKey  string `yaml:"api_key" env:"API_KEY"`
+	ChatIds []int  `yaml:"chat_ids" env:"CHAT_IDS"`
+
+	// Answer bot commands from ChatIds, optionally including /sendpayouts
+	Commands       bool `yaml:"commands" env:"COMMANDS"`
+	PayoutCommands bool `yaml:"payout_commands" env:"PAYOUT_COMMANDS"`
+}
+
+type EmailConfig struct {
+	Enabled  bool     `yaml:"enabled" env:"ENABLED"`
+	Username string   `yaml:"username" env:"USERNAME"`
+	Password string   `yaml:"password" env:"PASSWORD"`
+	SmtpHost string   `yaml:"smtp_host" env:"SMTP_HOST"`
+	SmtpPort int      `yaml:"smtp_port" env:"SMTP_PORT"`
+	Security string   `yaml:"security" env:"SECURITY"`
+	Auth     string   `yaml:"auth" env:"AUTH"`
+	From     string   `yaml:"from" env:"FROM"`
+	To       []string `yaml:"to" env:"TO"`
+}
+
+type WebhookConfig struct {
+	Enabled  bool              `yaml:"enabled" env:"ENABLED"`
+	Urls     []string          `yaml:"urls" env:"URLS"`
+	Headers  map[string]string `yaml:"headers" env:"HEADERS"`
+	Template string            `yaml:"template" env:"TEMPLATE"`
+	Secret   string            `yaml:"secret" env:"SECRET"`
+	Retries  int               `yaml:"retries" env:"RETRIES"`
+}
+
+// The json tags match the notifiers' configs, which these are saved as
+
+type SlackConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"ENABLED" json:"enabled"`
+	WebhookUrl string `yaml:"webhook_url" env:"WEBHOOK_URL" json:"webhookurl"`
+}
+
+type DiscordConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"ENABLED" json:"enabled"`
+	WebhookUrl string `yaml:"webhook_url" env:"WEBHOOK_URL" json:"webhookurl"`
+	Username   string `yaml:"username" env:"USERNAME" json:"username"`
+}
+
+type MatrixConfig struct {
+	Enabled     bool     `yaml:"enabled" env:"ENABLED" json:"enabled"`
+	Homeserver  string   `yaml:"homeserver" env:"HOMESERVER" json:"homeserver"`
+	AccessToken string   `yaml:"access_token" env:"ACCESS_TOKEN" json:"accesstoken"`
+	RoomIds     []string `yaml:"room_ids" env:"ROOM_IDS" json:"roomids"`
+}
+
+type PayoutsConfig struct {
+	Enabled  *bool             `yaml:"enabled" env:"ENABLED"`
+	BakerFee *int              `yaml:"baker_fee" env:"BAKER_FEE"`
+	Auto     AutoPayoutsConfig `yaml:"auto" env:"AUTO"`
+}
+
+type AutoPayoutsConfig struct {
+	Enabled    *bool `yaml:"enabled" env:"ENABLED"`
+	Delay      *int  `yaml:"delay" env:"DELAY"`           // Blocks
+	MaxPct     *int  `yaml:"max_pct" env:"MAX_PCT"`       // Percent of unfrozen rewards
+	MaxReward  *int  `yaml:"max_reward" env:"MAX_REWARD"` // XTZ
+	BondCycles *int  `yaml:"bond_cycles" env:"BOND_CYCLES"`
+}
+
+type BackupConfig struct {
+	Dir      string         `yaml:"dir" env:"DIR"`
+	Interval *time.Duration `yaml:"interval" env:"INTERVAL"`
+	Keep     *int           `yaml:"keep" env:"KEEP"`
+}
+
+type DigestConfig struct {
+	Daily string `yaml:"daily" env:"DAILY"` // Time of day, HH:MM UTC
+	Cycle *bool  `yaml:"cycle" env:"CYCLE"`
+}
+
+// Load reads the config file, if any, then applies environment overrides
+func Load(configFile string) (*Config, error) {
+
+	c := &Config{}
+
+	if configFile != "" {
+
+		configBytes, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read config file")
+		}
+
+		// Strict, so that typos are not silently ignored
+		if err := yaml.UnmarshalStrict(configBytes, c); err != nil {
+			return nil, errors.Wrap(err, "Unable to parse config file")
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(c).Elem(), ENV_PREFIX); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid config")
+	}
+
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+
+	if c.Network != "" && !util.IsValidNetwork(c.Network) {
+		return errors.Errorf("Unknown network: %s", c.Network)
+	}
+
+	for _, e := range c.Endpoints {
+		if u, err := url.Parse(e); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("Invalid endpoint URL %q", e)
+		}
+	}
+
+	if c.Signer != nil {
+		switch c.Signer.Type {
+		case SIGNER_TYPE_WALLET:
+			if (c.Signer.SecretKey == "") == (c.Signer.SecretKeyFile == "") {
+				return errors.New("Wallet signer requires one of secret_key or secret_key_file")
+			}
+		case SIGNER_TYPE_LEDGER:
+			if c.Signer.Pkh == "" || c.Signer.BipPath == "" {
+				return errors.New("Ledger signer requires pkh and bip_path")
+			}
+		default:
+			return errors.Errorf("Signer type must be %s or %s", SIGNER_TYPE_WALLET, SIGNER_TYPE_LEDGER)
+		}
+	}
+
+	if e := c.Notifications.Email; e != nil {
+		if err := e.notifier().Validate(); err != nil {
+			return errors.Wrap(err, "Invalid email config")
+		}
+	}
+
+	if r := c.Notifications.Routing; r != nil {
+		if err := r.Validate(); err != nil {
+			return errors.Wrap(err, "Invalid notification routing")
+		}
+	}
+
+	if wh := c.Notifications.Webhook; wh != nil {
+		if err := wh.notifier().Validate(); err != nil {
+			return errors.Wrap(err, "Invalid webhook config")
+		}
+	}
+
+	if fee := c.Payouts.BakerFee; fee != nil && (*fee < 1 || *fee > 99) {
+		return errors.New("Baker fee must be between 1 and 99")
+	}
+
+	if a := c.Payouts.Auto; (a.Delay != nil && *a.Delay < 0) || (a.MaxReward != nil && *a.MaxReward < 0) || (a.BondCycles != nil && *a.BondCycles < 0) {
+		return errors.New("Auto payouts delay, max reward and bond cycles cannot be negative")
+	}
+
+	if p := c.Payouts.Auto.MaxPct; p != nil && (*p < 1 || *p > 100) {
+		return errors.New("Auto payouts max percent must be between 1 and 100")
+	}
+
+	if keep := c.Backup.Keep; keep != nil && *keep < 0 {
+		return errors.New("Backup keep cannot be negative")
+	}
+
+	if d := c.Digest.Daily; d != "" {
+		if _, err := time.Parse(DIGEST_TIME_FORMAT, d); err != nil {
+			return errors.Errorf("Digest daily time must be HH:MM, eg 08:00; Got %q", d)
+		}
+	}
+
+	if r := c.RetentionCycles; r != nil && *r < 0 {
+		return errors.New("Retention cycles cannot be negative")
+	}
+
+	return nil
+}
+
+// applyEnv walks the config struct, setting any field whose environment variable is set
+func applyEnv(v reflect.Value, prefix string) error {
+
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+
+		field := v.Field(i)
+
+		name := prefix
+		tag := t.Field(i).Tag.Get("env")
+
+		// Only from the config file
+		if tag == "-" {
+			continue
+		}
+
+		if tag != "" {
+			name += tag + "_"
+		}
+
+		// Sections; Optional sections are only created if something in them is set
+		switch {
+		case field.Kind() == reflect.Struct:
+			if err := applyEnv(field, name); err != nil {
+				return err
+			}
+			continue
+
+		case field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct:
+			section := reflect.New(field.Type().Elem())
+			if !field.IsNil() {
+				section.Elem().Set(field.Elem())
+			}
+
+			if err := applyEnv(section.Elem(), name); err != nil {
+				return err
+			}
+
+			if !field.IsNil() || !section.Elem().IsZero() {
+				field.Set(section)
+			}
+			continue
+		}
+
+		name = strings.TrimSuffix(name, "_")
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if err := setField(field, value); err != nil {
+			return errors.Wrapf(err, "Invalid value for %s", name)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+
+	// Optional values
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setField(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	// Durations are int64, but written as eg "24h"
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+
+	case reflect.Slice:
+		// Comma-separated lists
+		items := util.SplitList(value)
+		list := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(list.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(list)
+
+	case reflect.Map:
+		// Comma-separated key=value pairs
+		if field.Type().Key().Kind() != reflect.String || field.Type().Elem().Kind() != reflect.String {
+			return errors.Errorf("Unsupported type %s", field.Type())
+		}
+
+		m := reflect.MakeMap(field.Type())
+		for _, item := range util.SplitList(value) {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return errors.Errorf("Expected key=value, got %q", item)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(kv[0])), reflect.ValueOf(strings.TrimSpace(kv[1])))
+		}
+		field.Set(m)
+
+	default:
+		return errors.Errorf("Unsupported type %s", field.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, yaml string) string {
+
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), "bakinbacon.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return configFile
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+
+	configFile := writeConfig(t, `
+network: granadanet
+datadir: /var/lib/bakinbacon
+webui:
+  addr: 0.0.0.0
+  port: 8082
+payouts:
+  baker_fee: 10
+backup:
+  interval: 24h
+  keep: 3
+`)
+
+	t.Setenv("BAKINBACON_NETWORK", "hangzhounet")
+	t.Setenv("BAKINBACON_WEBUI_PORT", "9000")
+	t.Setenv("BAKINBACON_WEBUI_TLS", "true")
+	t.Setenv("BAKINBACON_PAYOUTS_AUTO_MAX_PCT", "50")
+	t.Setenv("BAKINBACON_BACKUP_INTERVAL", "12h")
+
+	c, err := Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// From the environment
+	if c.Network != "hangzhounet" || *c.WebUi.Port != 9000 || !*c.WebUi.Tls || *c.Payouts.Auto.MaxPct != 50 || *c.Backup.Interval != 12*time.Hour {
+		t.Errorf("Environment not applied: %+v", c)
+	}
+
+	// From the file
+	if c.DataDir != "/var/lib/bakinbacon" || c.WebUi.Addr != "0.0.0.0" || *c.Payouts.BakerFee != 10 || *c.Backup.Keep != 3 {
+		t.Errorf("File not applied: %+v", c)
+	}
+
+	// Neither; Left for the flag defaults
+	if c.Debug != nil || c.RetentionCycles != nil || c.Payouts.Auto.Enabled != nil {
+		t.Errorf("Unset values were set: %+v", c)
+	}
+}
+
+func TestLoadEnvOnly(t *testing.T) {
+
+	t.Setenv("BAKINBACON_DATADIR", "/data")
+
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DataDir != "/data" {
+		t.Errorf("DataDir = %q", c.DataDir)
+	}
+}
+
+func TestLoadEnvTypes(t *testing.T) {
+
+	// Notifications has an empty env tag, so adds nothing to the names
+	t.Setenv("BAKINBACON_WEBHOOK_ENABLED", "true")
+	t.Setenv("BAKINBACON_WEBHOOK_URLS", "https://one.example.com/hook, https://two.example.com/hook")
+	t.Setenv("BAKINBACON_WEBHOOK_HEADERS", "Authorization=Bearer abc, X-Team = bakers")
+	t.Setenv("BAKINBACON_WEBHOOK_RETRIES", "3")
+	t.Setenv("BAKINBACON_TELEGRAM_CHAT_IDS", "1,-2")
+	t.Setenv("BAKINBACON_WEBUI_ORIGINS", "https://a.example.com,https://b.example.com")
+	t.Setenv("BAKINBACON_NOTIFICATIONS_SLACK_ENABLED", "true")
+
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wh := c.Notifications.Webhook
+	if wh == nil || !wh.Enabled || wh.Retries != 3 {
+		t.Fatalf("Webhook = %+v", wh)
+	}
+
+	if len(wh.Urls) != 2 || wh.Urls[1] != "https://two.example.com/hook" {
+		t.Errorf("Urls = %q", wh.Urls)
+	}
+
+	if len(wh.Headers) != 2 || wh.Headers["Authorization"] != "Bearer abc" || wh.Headers["X-Team"] != "bakers" {
+		t.Errorf("Headers = %q", wh.Headers)
+	}
+
+	if tg := c.Notifications.Telegram; tg == nil || len(tg.ChatIds) != 2 || tg.ChatIds[1] != -2 {
+		t.Errorf("Telegram = %+v", tg)
+	}
+
+	if len(c.WebUi.Origins) != 2 {
+		t.Errorf("Origins = %q", c.WebUi.Origins)
+	}
+
+	// Optional sections are only created if something in them is set
+	if c.Signer != nil || c.Notifications.Email != nil || c.Notifications.Slack != nil || c.Notifications.Matrix != nil {
+		t.Errorf("Unset sections were created: %+v", c)
+	}
+}
+
+// A section from the file keeps its other values when the environment sets one
+func TestLoadEnvIntoFileSection(t *testing.T) {
+
+	configFile := writeConfig(t, `
+signer:
+  type: ledger
+  pkh: tz1RMmSzPSWPSSaKU193Voh4PosWSZx1C7Hs
+  bip_path: "/44'/1729'/0'/1'"
+`)
+
+	t.Setenv("BAKINBACON_SIGNER_BIP_PATH", "/44'/1729'/0'/2'")
+
+	c, err := Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := c.Signer; s == nil || s.Type != SIGNER_TYPE_LEDGER || s.Pkh == "" || s.BipPath != "/44'/1729'/0'/2'" {
+		t.Errorf("Signer = %+v", s)
+	}
+}
+
+func TestLoadRoutingFileOnly(t *testing.T) {
+
+	t.Setenv("BAKINBACON_ROUTING", "anything")
+	t.Setenv("BAKINBACON_NOTIFICATIONS_ROUTING", "anything")
+
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Notifications.Routing != nil {
+		t.Errorf("Routing = %+v", c.Notifications.Routing)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+
+	for name, tc := range map[string]struct {
+		env, value, yaml, contains string
+	}{
+		"int":       {env: "BAKINBACON_WEBUI_PORT", value: "http", contains: "BAKINBACON_WEBUI_PORT"},
+		"bool":      {env: "BAKINBACON_DEBUG", value: "maybe", contains: "BAKINBACON_DEBUG"},
+		"duration":  {env: "BAKINBACON_BACKUP_INTERVAL", value: "1 day", contains: "BAKINBACON_BACKUP_INTERVAL"},
+		"map":       {env: "BAKINBACON_WEBHOOK_HEADERS", value: "Authorization", contains: "key=value"},
+		"list item": {env: "BAKINBACON_TELEGRAM_CHAT_IDS", value: "1,two", contains: "BAKINBACON_TELEGRAM_CHAT_IDS"},
+		"validated": {env: "BAKINBACON_PAYOUTS_BAKER_FEE", value: "100", contains: "Baker fee"},
+		"typo":      {yaml: "netwrok: mainnet\n", contains: "netwrok"},
+		"signer":    {yaml: "signer:\n  type: wallet\n", contains: "secret_key"},
+	} {
+		t.Run(name, func(t *testing.T) {
+
+			if tc.env != "" {
+				t.Setenv(tc.env, tc.value)
+			}
+
+			configFile := ""
+			if tc.yaml != "" {
+				configFile = writeConfig(t, tc.yaml)
+			}
+
+			if _, err := Load(configFile); err == nil || !strings.Contains(err.Error(), tc.contains) {
+				t.Errorf("Error = %v, expected to contain %q", err, tc.contains)
+			}
+		})
+	}
+}
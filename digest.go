@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/baconclient"
+	"bakinbacon/config"
+	"bakinbacon/notifications"
+	"bakinbacon/payouts"
+	"bakinbacon/storage"
+)
+
+const (
+	DIGEST_CHECK_INTERVAL = time.Minute
+
+	// Blocks into a cycle before its per-cycle digest, so the last blocks of the
+	// previous cycle have been checked for inclusion
+	DIGEST_CYCLE_DELAY = 10
+
+	DIGEST_MAX_MISSED    = 10 // Missed bakes listed by level
+	DIGEST_PAYOUT_CYCLES = 3
+	DIGEST_DAILY         = "Daily"
+	DIGEST_CYCLE         = "Cycle"
+)
+
+// Digest is a report of the last cycle, and what is coming in the next
+type Digest struct {
+	Kind     string
+	Delegate string
+	Cycle    int // Current cycle
+
+	// The last completed cycle, and the current cycle so far
+	LastCycle   storage.CycleSummary
+	MissedBakes []int
+	ThisCycle   *storage.CycleSummary
+
+	// Rights, and the bonds they need, for the next cycle
+	NextBakes       int
+	NextEndorses    int
+	NextSlots       int
+	NextFirstBake   storage.BakingRight
+	RequiredBond    int
+	NextRightsKnown bool
+
+	// Not known if BalanceErr is set
+	SpendableBalance int
+	BalanceErr       error
+
+	Payouts   []payouts.CycleRewardMetadata // Newest first
+	Endpoints []baconclient.EndpointStatus
+}
+
+// RunDigests sends a digest each day at -digest-daily, and near the start of each cycle
+// if -digest-cycle. Each is sent once, even across restarts.
+func (bb *BakinBacon) RunDigests(shutdownChannel <-chan interface{}, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if bb.digestDaily == "" && !bb.digestCycle {
+		return
+	}
+
+	// Validated by parseArgs
+	dailyAt, _ := time.Parse(config.DIGEST_TIME_FORMAT, bb.digestDaily)
+
+	log.WithFields(log.Fields{
+		"Daily": bb.digestDaily, "Cycle": bb.digestCycle,
+	}).Info("Digests enabled")
+
+	ticker := time.NewTicker(DIGEST_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+
+		sentDay, sentCycle, err := bb.Storage.GetDigestsSent()
+		if err != nil {
+			log.WithError(err).Error("Unable to get last digests sent")
+		}
+
+		now := time.Now().UTC()
+		today := now.Format("2006-01-02")
+		dueAt := time.Date(now.Year(), now.Month(), now.Day(), dailyAt.Hour(), dailyAt.Minute(), 0, 0, time.UTC)
+
+		// Unknown until the first block is seen
+		status := bb.BaconClient.Status
+
+		if err == nil && status.Cycle > 0 {
+
+			if bb.digestDaily != "" && sentDay != today && !now.Before(dueAt) {
+				bb.sendDigest(DIGEST_DAILY, status.Cycle, status.Level)
+				if err := bb.Storage.SetDigestDaySent(today); err != nil {
+					log.WithError(err).Error("Unable to save daily digest sent")
+				}
+			}
+
+			if bb.digestCycle && sentCycle != status.Cycle && status.CyclePosition >= DIGEST_CYCLE_DELAY {
+				bb.sendDigest(DIGEST_CYCLE, status.Cycle, status.Level)
+				if err := bb.Storage.SetDigestCycleSent(status.Cycle); err != nil {
+					log.WithError(err).Error("Unable to save cycle digest sent")
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-shutdownChannel:
+			return
+		}
+	}
+}
+
+func (bb *BakinBacon) sendDigest(kind string, cycle, level int) {
+
+	digest, err := bb.buildDigest(kind, cycle, level)
+	if err != nil {
+		log.WithError(err).Error("Unable to build digest")
+		return
+	}
+
+	// From the node and endpoints, rather than the DB
+	digest.SpendableBalance, digest.BalanceErr = bb.GetSpendableBalance()
+	digest.Endpoints = bb.BaconClient.Endpoints()
+
+	log.WithFields(log.Fields{"Kind": kind, "Cycle": cycle}).Info("Sending digest")
+
+	bb.SendNotification(digest.String(), notifications.DIGEST)
+}
+
+// buildDigest collects everything in the digest that is kept in the DB
+func (bb *BakinBacon) buildDigest(kind string, cycle, level int) (*Digest, error) {
+
+	d := &Digest{
+		Kind:  kind,
+		Cycle: cycle,
+	}
+
+	var err error
+
+	if _, d.Delegate, err = bb.Storage.GetDelegate(); err != nil {
+		return nil, errors.Wrap(err, "Unable to get delegate")
+	}
+
+	// Last completed cycle
+	if d.LastCycle, err = bb.Storage.GetCycleSummary(cycle-1, level); err != nil {
+		return nil, errors.Wrap(err, "Unable to get summary of last cycle")
+	}
+
+	bakingRights, err := bb.Storage.GetBakingLevelsForCycle(cycle - 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get baking rights")
+	}
+
+	bakingOutcomes, err := bb.Storage.GetBakingOutcomesForCycle(cycle - 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get baking history")
+	}
+
+	for _, r := range bakingRights {
+		if o, ok := bakingOutcomes[r.Level]; !ok || o.Outcome != storage.OUTCOME_BAKED {
+			d.MissedBakes = append(d.MissedBakes, r.Level)
+		}
+	}
+
+	sort.Ints(d.MissedBakes)
+
+	// The daily digest may come at any time in a cycle
+	if kind == DIGEST_DAILY {
+		thisCycle, err := bb.Storage.GetCycleSummary(cycle, level)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to get summary of this cycle")
+		}
+		d.ThisCycle = &thisCycle
+	}
+
+	// Next cycle, if its rights have been fetched yet
+	nextBakes, err := bb.Storage.GetBakingLevelsForCycle(cycle + 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get next cycle baking rights")
+	}
+
+	nextEndorses, err := bb.Storage.GetEndorsingRightsForCycle(cycle + 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get next cycle endorsing rights")
+	}
+
+	fetchedCycle, err := bb.Storage.GetHighestFetchedRightsCycle(storage.ENDORSING_RIGHTS_BUCKET)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get fetched rights")
+	}
+
+	d.NextRightsKnown = fetchedCycle > cycle || len(nextBakes) > 0 || len(nextEndorses) > 0
+
+	for _, r := range nextBakes {
+		if d.NextBakes == 0 || r.Level < d.NextFirstBake.Level {
+			d.NextFirstBake = r
+		}
+		d.NextBakes++
+	}
+
+	for _, r := range nextEndorses {
+		d.NextEndorses++
+		d.NextSlots += r.NumSlots
+	}
+
+	// As checked before each bake and endorsement
+	d.RequiredBond = d.NextBakes*bb.NetworkConstants.BlockSecurityDeposit + d.NextEndorses*bb.NetworkConstants.EndorsementSecurityDeposit
+
+	// Most recent payouts
+	payoutsMetadata, err := bb.PayoutsHandler.GetPayoutsMetadataAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get payouts")
+	}
+
+	payoutCycles := make([]int, 0, len(payoutsMetadata))
+	for c := range payoutsMetadata {
+		payoutCycles = append(payoutCycles, c)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(payoutCycles)))
+
+	for i, c := range payoutCycles {
+		if i == DIGEST_PAYOUT_CYCLES {
+			break
+		}
+		d.Payouts = append(d.Payouts, payoutsMetadata[c])
+	}
+
+	return d, nil
+}
+
+// String formats the digest as plain text; The first line is the title, eg an email subject
+func (d *Digest) String() string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "BakinBacon %s Digest, Cycle %d\n", d.Kind, d.Cycle)
+	if d.Delegate != "" {
+		fmt.Fprintf(&b, "Baker %s\n", d.Delegate)
+	}
+
+	// Performance
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "Cycle %d: %s\n", d.LastCycle.Cycle, summaryLine(d.LastCycle))
+
+	if missed := missedLine(d.LastCycle); missed != "" {
+		fmt.Fprintf(&b, "Missed: %s\n", missed)
+	}
+
+	if len(d.MissedBakes) > 0 {
+		levels := make([]string, 0, DIGEST_MAX_MISSED)
+		for i, l := range d.MissedBakes {
+			if i == DIGEST_MAX_MISSED {
+				levels = append(levels, fmt.Sprintf("and %d more", len(d.MissedBakes)-DIGEST_MAX_MISSED))
+				break
+			}
+			levels = append(levels, fmt.Sprintf("%d", l))
+		}
+		fmt.Fprintf(&b, "Missed bakes at levels %s\n", strings.Join(levels, ", "))
+	}
+
+	if d.ThisCycle != nil {
+		fmt.Fprintf(&b, "Cycle %d so far: %s\n", d.ThisCycle.Cycle, summaryLine(*d.ThisCycle))
+		if missed := missedLine(*d.ThisCycle); missed != "" {
+			fmt.Fprintf(&b, "Missed: %s\n", missed)
+		}
+	}
+
+	// Next cycle
+	b.WriteString("\n")
+	d.writeRights(&b)
+	d.writeBalance(&b)
+
+	// Payouts
+	b.WriteString("\n")
+	d.writePayouts(&b)
+
+	if len(d.Endpoints) > 0 {
+		b.WriteString("\n")
+		d.writeEndpoints(&b)
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// The sections below are also the Telegram bot's replies
+
+func (d *Digest) writeRights(b *strings.Builder) {
+
+	if !d.NextRightsKnown {
+		fmt.Fprintf(b, "Cycle %d rights: not yet fetched\n", d.Cycle+1)
+		return
+	}
+
+	fmt.Fprintf(b, "Cycle %d rights: bakes %d, endorsements %d (%d slots)\n", d.Cycle+1, d.NextBakes, d.NextEndorses, d.NextSlots)
+
+	if d.NextBakes > 0 {
+		fmt.Fprintf(b, "First bake at level %d, priority %d", d.NextFirstBake.Level, d.NextFirstBake.Priority)
+		if !d.NextFirstBake.EstimatedTime.IsZero() {
+			fmt.Fprintf(b, ", about %s", d.NextFirstBake.EstimatedTime.UTC().Format("Jan 2 15:04 MST"))
+		}
+		b.WriteString("\n")
+	}
+}
+
+func (d *Digest) writeBalance(b *strings.Builder) {
+
+	if d.BalanceErr != nil {
+		fmt.Fprintf(b, "Spendable balance: unknown (%s)\n", d.BalanceErr)
+		return
+	}
+
+	fmt.Fprintf(b, "Spendable balance: %s\n", mutezToXtz(d.SpendableBalance))
+
+	if d.NextRightsKnown {
+		if headroom := d.SpendableBalance - d.RequiredBond; headroom >= 0 {
+			fmt.Fprintf(b, "Bonds for cycle %d: %s; Headroom %s\n", d.Cycle+1, mutezToXtz(d.RequiredBond), mutezToXtz(headroom))
+		} else {
+			fmt.Fprintf(b, "WARNING: Bonds for cycle %d need %s; %s short\n", d.Cycle+1, mutezToXtz(d.RequiredBond), mutezToXtz(-headroom))
+		}
+	}
+}
+
+func (d *Digest) writePayouts(b *strings.Builder) {
+
+	if len(d.Payouts) == 0 {
+		b.WriteString("Payouts: none yet\n")
+	}
+
+	for _, p := range d.Payouts {
+		fmt.Fprintf(b, "Payouts for cycle %d: %s, %d delegators, rewards %s\n",
+			p.PayoutCycle, payoutStatus(p.Status), p.NumDelegators, mutezToXtz(p.BlockRewards+p.FeeRewards))
+	}
+}
+
+func (d *Digest) writeEndpoints(b *strings.Builder) {
+
+	var active int
+	var down []string
+
+	for _, e := range d.Endpoints {
+		if e.Active {
+			active++
+		} else {
+			down = append(down, e.Url)
+		}
+	}
+
+	fmt.Fprintf(b, "Endpoints: %d of %d healthy", active, len(d.Endpoints))
+	if len(down) > 0 {
+		fmt.Fprintf(b, "; Down: %s", strings.Join(down, ", "))
+	}
+	b.WriteString("\n")
+}
+
+func summaryLine(s storage.CycleSummary) string {
+
+	line := fmt.Sprintf("baked %d of %d blocks", s.Baked, s.BakingRights)
+	if s.BakingRights > 0 {
+		line += fmt.Sprintf(" (%.1f%%)", s.BakingEff*100)
+	}
+
+	line += fmt.Sprintf(", endorsed %d of %d slots", s.EndorsedSlots, s.EndorsingSlots)
+	if s.EndorsingSlots > 0 {
+		line += fmt.Sprintf(" (%.1f%%)", s.EndorsingEff*100)
+	}
+
+	return line
+}
+
+// missedLine lists the reasons for missed rights, eg "2 bakes (late), 1 endorsement (signer)"
+func missedLine(s storage.CycleSummary) string {
+
+	var parts []string
+
+	add := func(missed map[string]int, what string) {
+
+		reasons := make([]string, 0, len(missed))
+		total := 0
+
+		for reason, n := range missed {
+			reasons = append(reasons, fmt.Sprintf("%d %s", n, reason))
+			total += n
+		}
+
+		if total == 0 {
+			return
+		}
+
+		sort.Strings(reasons)
+
+		if total > 1 {
+			what += "s"
+		}
+
+		parts = append(parts, fmt.Sprintf("%d %s (%s)", total, what, strings.Join(reasons, ", ")))
+	}
+
+	add(s.BakesMissed, "bake")
+	add(s.EndorsesMissed, "endorsement")
+
+	return strings.Join(parts, "; ")
+}
+
+func payoutStatus(status string) string {
+	switch status {
+	case payouts.CALCULATED:
+		return "calculated, not sent"
+	case payouts.IN_PROGRESS:
+		return "in progress"
+	case payouts.DONE:
+		return "sent"
+	case payouts.ERROR:
+		return "FAILED"
+	}
+	return "unknown"
+}
+
+func mutezToXtz(mutez int) string {
+	return fmt.Sprintf("%.2f XTZ", float64(mutez)/1e6)
+}
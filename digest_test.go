@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/pkg/errors"
+
+	"bakinbacon/baconclient"
+	"bakinbacon/payouts"
+	"bakinbacon/storage"
+	"bakinbacon/util"
+)
+
+func TestDigest(t *testing.T) {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	nc, _ := util.GetNetworkConstants(util.NETWORK_HANGZHOUNET)
+	ph, _ := payouts.NewPayoutsHandler(nil, db, nc, nil, false)
+
+	bb := &BakinBacon{Storage: db, NetworkConstants: nc, PayoutsHandler: ph}
+
+	if err := db.SetDelegate("", "tz1RMmSzPSWPSSaKU193Voh4PosWSZx1C7Hs"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cycle 10 is complete; Baked one of two blocks, endorsed one of two rights
+	first := nc.FirstLevelOfCycle(10)
+
+	if err := db.SaveBakingRightsForLevels(10, nil, []rpc.BakingRights{
+		{Level: first + 10}, {Level: first + 40, Priority: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SaveEndorsingRightsForLevels(10, nil, []rpc.EndorsingRights{
+		{Level: first + 20, Slots: []int{3, 7}}, {Level: first + 30, Slots: []int{1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, o := range []storage.RightOutcome{
+		{Level: first + 10, Cycle: 10, Outcome: storage.OUTCOME_BAKED},
+		{Level: first + 40, Cycle: 10, Outcome: storage.OUTCOME_MISSED, Reason: storage.REASON_LATE},
+	} {
+		if err := db.RecordBakingOutcome(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.RecordEndorsingOutcome(storage.RightOutcome{
+		Level: first + 20, Cycle: 10, NumSlots: 2, Outcome: storage.OUTCOME_ENDORSED,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cycle 12 rights
+	next := nc.FirstLevelOfCycle(12)
+	bakeTime := time.Date(2022, 3, 4, 5, 6, 0, 0, time.UTC)
+
+	if err := db.SaveBakingRightsForLevels(12, nil, []rpc.BakingRights{
+		{Level: next + 900}, {Level: next + 100, EstimatedTime: bakeTime},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SaveEndorsingRightsForLevels(12, nil, []rpc.EndorsingRights{
+		{Level: next + 5, Slots: []int{1, 2, 3}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for c, status := range map[int]string{3: payouts.DONE, 4: payouts.ERROR, 5: payouts.DONE, 6: payouts.CALCULATED} {
+		if err := ph.SaveRewardMetadataForCycle(c, payouts.CycleRewardMetadata{
+			PayoutCycle: c, NumDelegators: 7, BlockRewards: 40e6, FeeRewards: 2500000, Status: status,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := bb.buildDigest(DIGEST_CYCLE, 11, nc.FirstLevelOfCycle(11)+20)
+	if err != nil {
+		t.Fatalf("buildDigest: %v", err)
+	}
+
+	if d.LastCycle.Baked != 1 || d.LastCycle.BakingRights != 2 || d.LastCycle.EndorsedSlots != 2 || d.LastCycle.EndorsingSlots != 3 {
+		t.Errorf("LastCycle = %+v", d.LastCycle)
+	}
+
+	if len(d.MissedBakes) != 1 || d.MissedBakes[0] != first+40 {
+		t.Errorf("MissedBakes = %v", d.MissedBakes)
+	}
+
+	if d.ThisCycle != nil {
+		t.Error("Cycle digest includes the current cycle")
+	}
+
+	if !d.NextRightsKnown || d.NextBakes != 2 || d.NextEndorses != 1 || d.NextSlots != 3 || d.NextFirstBake.Level != next+100 {
+		t.Errorf("Next cycle = %d bakes, %d endorses, %d slots, first %+v", d.NextBakes, d.NextEndorses, d.NextSlots, d.NextFirstBake)
+	}
+
+	if want := 2*nc.BlockSecurityDeposit + nc.EndorsementSecurityDeposit; d.RequiredBond != want {
+		t.Errorf("RequiredBond = %d, want %d", d.RequiredBond, want)
+	}
+
+	if len(d.Payouts) != DIGEST_PAYOUT_CYCLES || d.Payouts[0].PayoutCycle != 6 || d.Payouts[2].PayoutCycle != 4 {
+		t.Errorf("Payouts = %+v", d.Payouts)
+	}
+
+	// Added by sendDigest
+	d.SpendableBalance = d.RequiredBond - 1e6
+	d.Endpoints = []baconclient.EndpointStatus{
+		{Url: "http://127.0.0.1:8732", Active: true, Current: true},
+		{Url: "https://rpc.example.com", Active: false},
+	}
+
+	text := d.String()
+
+	for _, want := range []string{
+		"BakinBacon Cycle Digest, Cycle 11\nBaker tz1RMmSzPSWPSSaKU193Voh4PosWSZx1C7Hs\n",
+		"Cycle 10: baked 1 of 2 blocks (50.0%), endorsed 2 of 3 slots (66.7%)",
+		"Missed: 1 bake (1 late); 1 endorsement (1 unknown)",
+		"Missed bakes at levels " + strconv.Itoa(first+40),
+		"Cycle 12 rights: bakes 2, endorsements 1 (3 slots)",
+		"First bake at level " + strconv.Itoa(next+100) + ", priority 0, about Mar 4 05:06 UTC",
+		"WARNING: Bonds for cycle 12 need 1282.50 XTZ; 1.00 XTZ short",
+		"Payouts for cycle 6: calculated, not sent, 7 delegators, rewards 42.50 XTZ",
+		"Payouts for cycle 4: FAILED",
+		"Endpoints: 1 of 2 healthy; Down: https://rpc.example.com",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Digest missing %q:\n%s", want, text)
+		}
+	}
+
+	// Daily digests include the current cycle; Nothing is known about cycle 13
+	d, err = bb.buildDigest(DIGEST_DAILY, 12, next+10)
+	if err != nil {
+		t.Fatalf("buildDigest: %v", err)
+	}
+
+	d.BalanceErr = errors.New("RPC unavailable")
+	text = d.String()
+
+	for _, want := range []string{
+		"Cycle 12 so far: baked 0 of 0 blocks, endorsed 0 of 3 slots (0.0%)",
+		"Cycle 13 rights: not yet fetched",
+		"Spendable balance: unknown (RPC unavailable)",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Daily digest missing %q:\n%s", want, text)
+		}
+	}
+}
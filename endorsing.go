@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -14,7 +15,10 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/events"
+	"bakinbacon/metrics"
 	"bakinbacon/notifications"
+	"bakinbacon/storage"
 )
 
 /*
@@ -44,6 +48,9 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 		}
 	}()
 
+	// For measuring time from new head to injection
+	headStart := time.Now()
+
 	endorsingLevel := block.Header.Level
 
 	// Check watermark to ensure we have not endorsed at this level before
@@ -70,36 +77,42 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 	}
 
 	resp, endorsingRights, err := bb.Current.EndorsingRights(endorsingRightsFilter)
+	if err != nil {
+		// resp may be nil if RPC is down
+		bb.recordMissedKnownEndorsement(endorsingLevel, storage.REASON_RPC)
+		log.WithError(err).WithField("Level", endorsingLevel).Error("Unable to fetch endorsing rights")
+		return
+	}
 
 	log.WithFields(log.Fields{
 		"Level": endorsingLevel, "Request": resp.Request.URL, "Response": string(resp.Body()),
 	}).Trace("Fetching endorsing rights")
 
-	if err != nil {
-		log.WithError(err).Error("Unable to fetch endorsing rights")
-		return
-	}
-
 	if len(endorsingRights) == 0 {
 		log.WithField("Level", endorsingLevel).Info("No endorsing rights for this level")
 		return
 	}
 
+	// Join up all endorsing slots for sorting
+	var allSlots []int
+	for _, e := range endorsingRights {
+		allSlots = append(allSlots, e.Slots...)
+	}
+
+	numSlots := len(allSlots)
+
+	metrics.EndorseAttempts.Inc()
+
 	// Check for new block
 	select {
 	case <-ctx.Done():
 		log.Warn("New block arrived; Canceling endorsement")
+		bb.recordEndorseOutcome(endorsingLevel, numSlots, storage.OUTCOME_MISSED, storage.REASON_LATE, "")
 		return
 	default:
 		break
 	}
 
-	// Join up all endorsing slots for sorting
-	var allSlots []int
-	for _, e := range endorsingRights {
-		allSlots = append(allSlots, e.Slots...)
-	}
-
 	// 009 requires the lowest slot be submitted
 	sort.Ints(allSlots)
 
@@ -108,9 +121,16 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 		"Level": endorsingLevel, "Slots": slotString,
 	}).Info("Endorsing rights found")
 
+	bb.Publish(events.RIGHTS_FOUND, events.RightsEvent{
+		Kind:     storage.ENDORSING_RIGHTS_BUCKET,
+		Level:    endorsingLevel,
+		NumSlots: numSlots,
+	})
+
 	// Continue since we have at least 1 endorsing right
 	// Check if we can pay bond
 	requiredBond := bb.NetworkConstants.EndorsementSecurityDeposit
+	metrics.RequiredBond.WithLabelValues("endorsement").Set(float64(requiredBond))
 
 	if spendableBalance, err := bb.GetSpendableBalance(); err != nil {
 		log.WithError(err).Error("Unable to get spendable balance")
@@ -129,6 +149,7 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 
 		bb.Status.SetError(errors.New(msg))
 		bb.SendNotification(msg, notifications.BALANCE)
+		bb.recordEndorseOutcome(endorsingLevel, numSlots, storage.OUTCOME_MISSED, storage.REASON_LOW_BOND, "")
 
 		return
 	}
@@ -145,6 +166,7 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 	endorsementBytes, err := forge.Encode(block.Hash, endorsementContent)
 	if err != nil {
 		log.WithError(err).Error("Error Forging Inner Endorsement")
+		bb.recordEndorseOutcome(endorsingLevel, numSlots, storage.OUTCOME_MISSED, storage.REASON_FORGE, "")
 		return
 	}
 
@@ -154,6 +176,7 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 	signedInnerEndorsement, err := bb.Signer.SignEndorsement(endorsementBytes, block.ChainID)
 	if err != nil {
 		log.WithError(err).Error("Signer endorsement failure")
+		bb.recordEndorseOutcome(endorsingLevel, numSlots, storage.OUTCOME_MISSED, storage.REASON_SIGNER, "")
 		return
 	}
 
@@ -175,6 +198,7 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 	endorseWithSlotBytes, err := forge.Encode(block.Hash, endorseWithSlot)
 	if err != nil {
 		log.WithError(err).Error("Error Forging Outer Endorsement")
+		bb.recordEndorseOutcome(endorsingLevel, numSlots, storage.OUTCOME_MISSED, storage.REASON_FORGE, "")
 		return
 	}
 
@@ -192,6 +216,7 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 	select {
 	case <-ctx.Done():
 		log.Warn("New block arrived; Canceling endorsement")
+		bb.recordEndorseOutcome(endorsingLevel, numSlots, storage.OUTCOME_MISSED, storage.REASON_LATE, "")
 		return
 	default:
 		break
@@ -210,16 +235,27 @@ func (bb *BakinBacon) handleEndorsement(ctx context.Context, wg *sync.WaitGroup,
 			"Request": resp.Request.URL, "Response": string(resp.Body()),
 		}).Error("Endorsement Injection Failure")
 
+		bb.recordEndorseOutcome(endorsingLevel, numSlots, storage.OUTCOME_MISSED, storage.REASON_INJECTION, "")
+
 		return
 	}
 
 	log.WithField("Operation", opHash).Info("Endorsement Injected")
 
+	metrics.HeadToInjection.WithLabelValues("endorsement").Observe(metrics.SinceSeconds(headStart))
+
+	bb.Publish(events.ENDORSE_INJECTED, events.InjectedEvent{
+		Level: endorsingLevel,
+		Hash:  opHash,
+	})
+
 	// Save endorsement to DB for watermarking
 	if err := bb.RecordEndorsement(endorsingLevel, opHash); err != nil {
 		log.WithError(err).Error("Unable to save endorsement; Watermark compromised")
 	}
 
+	bb.recordEndorseOutcome(endorsingLevel, numSlots, storage.OUTCOME_ENDORSED, "", opHash)
+
 	// Update status for UI
 	bb.Status.SetRecentEndorsement(endorsingLevel, block.Metadata.Level.Cycle, opHash)
 }
@@ -0,0 +1,137 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Event types
+	NEW_HEAD         = "head"
+	RIGHTS_FOUND     = "rights"
+	BAKE_INJECTED    = "bake"
+	ENDORSE_INJECTED = "endorse"
+	NONCE_REVEALED   = "nonce"
+	ERROR            = "error"
+	PAYOUT_PROGRESS  = "payout"
+	ENDPOINT_HEALTH  = "endpoint"
+
+	// Events buffered per subscriber; slow subscribers miss events rather than block publishers
+	SUBSCRIBER_BUFFER = 64
+)
+
+type Event struct {
+	Id        uint64      `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp int64       `json:"ts"`
+	Data      interface{} `json:"data"`
+}
+
+type HeadEvent struct {
+	Level int    `json:"level"`
+	Cycle int    `json:"cycle"`
+	Hash  string `json:"hash"`
+}
+
+type RightsEvent struct {
+	Kind     string `json:"kind"`
+	Level    int    `json:"level"`
+	Priority int    `json:"priority,omitempty"`
+	NumSlots int    `json:"numSlots,omitempty"`
+}
+
+type InjectedEvent struct {
+	Level    int    `json:"level"`
+	Hash     string `json:"hash"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+type ErrorEvent struct {
+	Message string `json:"message"`
+}
+
+type PayoutEvent struct {
+	Cycle      int    `json:"cycle"`
+	Batch      int    `json:"batch"`
+	NumBatches int    `json:"numBatches"`
+	OpHash     string `json:"opHash,omitempty"`
+	Status     string `json:"status"`
+}
+
+type EndpointEvent struct {
+	Endpoint string `json:"endpoint"`
+	Active   bool   `json:"active"`
+}
+
+// EventBus fans out events published by the baking, payouts and RPC
+// components to any number of subscribers, such as the web UI
+type EventBus struct {
+	lock        sync.Mutex
+	lastId      uint64
+	nextSubId   int
+	subscribers map[int]chan Event
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[int]chan Event),
+	}
+}
+
+// Publish sends an event to all current subscribers. Never blocks.
+// Safe to call on a nil EventBus.
+func (e *EventBus) Publish(eventType string, data interface{}) {
+
+	if e == nil {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.lastId++
+
+	event := Event{
+		Id:        e.lastId,
+		Type:      eventType,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+
+	for id, ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.WithFields(log.Fields{
+				"Subscriber": id, "Type": eventType,
+			}).Debug("Event subscriber too slow; Dropped event")
+		}
+	}
+}
+
+// Subscribe returns a channel of all future events, and the id needed to unsubscribe
+func (e *EventBus) Subscribe() (int, <-chan Event) {
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.nextSubId++
+
+	ch := make(chan Event, SUBSCRIBER_BUFFER)
+	e.subscribers[e.nextSubId] = ch
+
+	return e.nextSubId, ch
+}
+
+func (e *EventBus) Unsubscribe(id int) {
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if ch, ok := e.subscribers[id]; ok {
+		close(ch)
+		delete(e.subscribers, id)
+	}
+}
@@ -7,13 +7,16 @@ require (
 	github.com/bakingbacon/go-tezos/v4 v4.1.6
 	github.com/bakingbacon/goledger/ledger-apps/tezos v0.0.0-20210820040404-44e1e16330dd
 	github.com/btcsuite/btcutil v1.0.2
+	github.com/go-resty/resty/v2 v2.3.0
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sirupsen/logrus v1.8.1
 	go.etcd.io/bbolt v1.3.5
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/mod v0.5.0
 	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4 // indirect
 	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,94 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/metrics"
+	"bakinbacon/storage"
+)
+
+// recordBakeOutcome saves what happened to our baking right at level
+func (bb *BakinBacon) recordBakeOutcome(level, priority int, outcome, reason, blockHash string) {
+
+	metrics.BakeOutcomes.WithLabelValues(outcome, reason).Inc()
+
+	if err := bb.Storage.RecordBakingOutcome(storage.RightOutcome{
+		Level:    level,
+		Cycle:    bb.getCycleFromLevel(level),
+		Priority: priority,
+		Outcome:  outcome,
+		Reason:   reason,
+		Hash:     blockHash,
+	}); err != nil {
+		log.WithError(err).WithField("Level", level).Error("Unable to record baking outcome")
+	}
+}
+
+// recordEndorseOutcome saves what happened to our endorsing right at level
+func (bb *BakinBacon) recordEndorseOutcome(level, numSlots int, outcome, reason, opHash string) {
+
+	metrics.EndorseOutcomes.WithLabelValues(outcome, reason).Inc()
+
+	if err := bb.Storage.RecordEndorsingOutcome(storage.RightOutcome{
+		Level:    level,
+		Cycle:    bb.getCycleFromLevel(level),
+		NumSlots: numSlots,
+		Outcome:  outcome,
+		Reason:   reason,
+		Hash:     opHash,
+	}); err != nil {
+		log.WithError(err).WithField("Level", level).Error("Unable to record endorsing outcome")
+	}
+}
+
+// recordMissedKnownBake is used when we cannot determine our rights at level from
+// the RPC. If a prefetched right exists for level, record it as missed.
+func (bb *BakinBacon) recordMissedKnownBake(level int, reason string) {
+
+	bakingRight, found, err := bb.Storage.GetBakingRight(level)
+	if err != nil || !found {
+		return
+	}
+
+	bb.recordBakeOutcome(level, bakingRight.Priority, storage.OUTCOME_MISSED, reason, "")
+}
+
+// recordMissedKnownEndorsement is used when we cannot determine our rights at level
+// from the RPC. If a prefetched right exists for level, record it as missed.
+func (bb *BakinBacon) recordMissedKnownEndorsement(level int, reason string) {
+
+	endorsingRight, found, err := bb.Storage.GetEndorsingRight(level)
+	if err != nil || !found {
+		return
+	}
+
+	bb.recordEndorseOutcome(level, endorsingRight.NumSlots, storage.OUTCOME_MISSED, reason, "")
+}
+
+// checkLostBake is called on each new block. If we injected a block at this
+// level, but the new head is not our block, then our block was lost.
+func (bb *BakinBacon) checkLostBake(block rpc.Block) {
+
+	outcome, err := bb.Storage.GetBakingOutcome(block.Header.Level)
+	if err != nil {
+		log.WithError(err).Error("Unable to get baking outcome")
+		return
+	}
+
+	if outcome.Outcome != storage.OUTCOME_BAKED || outcome.Hash == block.Hash {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Level": block.Header.Level, "Ours": outcome.Hash, "Head": block.Hash,
+	}).Warn("Injected block is not the head block; Block lost")
+
+	outcome.Outcome = storage.OUTCOME_LOST
+	metrics.BakeOutcomes.WithLabelValues(outcome.Outcome, outcome.Reason).Inc()
+
+	if err := bb.Storage.RecordBakingOutcome(outcome); err != nil {
+		log.WithError(err).Error("Unable to record baking outcome")
+	}
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	NAMESPACE = "bakinbacon"
+)
+
+var (
+	HeadLevel = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "head_level",
+		Help:      "Level of the most recent head block",
+	})
+
+	RpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "rpc_request_duration_seconds",
+		Help:      "Latency of RPC requests, per endpoint",
+		Buckets:   []float64{.025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"endpoint"})
+
+	RpcErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed RPC requests, per endpoint",
+	}, []string{"endpoint"})
+
+	BakeAttempts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "bake_attempts_total",
+		Help:      "Number of baking rights acted upon",
+	})
+
+	BakeOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "bake_outcomes_total",
+		Help:      "Outcomes of baking rights, by outcome and reason",
+	}, []string{"outcome", "reason"})
+
+	EndorseAttempts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "endorse_attempts_total",
+		Help:      "Number of endorsing rights acted upon",
+	})
+
+	EndorseOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "endorse_outcomes_total",
+		Help:      "Outcomes of endorsing rights, by outcome and reason",
+	}, []string{"outcome", "reason"})
+
+	HeadToInjection = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "head_to_injection_seconds",
+		Help:      "Time from receiving a new head block to injecting an operation",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 15, 30, 60, 120},
+	}, []string{"operation"})
+
+	PowDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "pow_duration_seconds",
+		Help:      "Time spent computing proof-of-work for a block",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	})
+
+	SignerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "signer_duration_seconds",
+		Help:      "Latency of signing operations, per signer backend",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"backend"})
+
+	SpendableBalance = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "spendable_balance_mutez",
+		Help:      "Spendable balance of the baker",
+	})
+
+	RequiredBond = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "required_bond_mutez",
+		Help:      "Security deposit required for the most recent right, per operation",
+	}, []string{"operation"})
+
+	PayoutBatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "payout_batches_total",
+		Help:      "Number of payout batches, by result",
+	}, []string{"result"})
+
+	NotificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "notification_failures_total",
+		Help:      "Number of notifications which failed to send, per notifier",
+	}, []string{"notifier"})
+)
+
+// SinceSeconds is a helper for observing durations
+func SinceSeconds(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// InstrumentedTransport records latency and errors of every request
+// made through it, labeled with the given endpoint
+type InstrumentedTransport struct {
+	Endpoint string
+	Base     http.RoundTripper
+}
+
+func (t *InstrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	start := time.Now()
+
+	resp, err := t.Base.RoundTrip(req)
+
+	RpcDuration.WithLabelValues(t.Endpoint).Observe(SinceSeconds(start))
+
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		RpcErrors.WithLabelValues(t.Endpoint).Inc()
+	}
+
+	return resp, err
+}
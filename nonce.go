@@ -3,20 +3,19 @@ package main
 import (
 	"context"
 	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
 	"regexp"
 	"strings"
 	"sync"
 
-	"github.com/bakingbacon/go-tezos/v4/crypto"
 	"github.com/bakingbacon/go-tezos/v4/forge"
 	"github.com/bakingbacon/go-tezos/v4/rpc"
 
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/events"
 	"bakinbacon/nonce"
-	"bakinbacon/util"
+	"bakinbacon/storage"
 )
 
 var previouslyInjectedErr = regexp.MustCompile(`while applying operation (o[a-zA-Z0-9]{50}).*previously revealed`)
@@ -30,22 +29,12 @@ func (bb *BakinBacon) generateNonce() (nonce.Nonce, error) {
 		return nonce.Nonce{}, err
 	}
 
-	nonceHash, err := util.CryptoGenericHash(randBytes, []byte{})
+	n, err := nonce.FromSeed(randBytes)
 	if err != nil {
 		log.WithError(err).Error("Unable to hash rand bytes for nonce")
 		return nonce.Nonce{}, err
 	}
 
-	// B58 encode seed hash with nonce prefix
-	encodedNonce := crypto.B58cencode(nonceHash, nonce.Prefix_nonce)
-
-	n := nonce.Nonce{
-		Seed:          hex.EncodeToString(randBytes),
-		Nonce:         nonceHash,
-		EncodedNonce:  encodedNonce,
-		NoPrefixNonce: hex.EncodeToString(nonceHash),
-	}
-
 	return n, nil
 }
 
@@ -189,6 +178,9 @@ func (bb *BakinBacon) revealNonces(ctx context.Context, wg *sync.WaitGroup, bloc
 			Operation: nonceRevelationBytes,
 		}
 
+		// Reset verification of any previous reveal of this nonce
+		nonce.RevealVerified = ""
+
 		resp, revealOpHash, err := bb.Current.InjectionOperation(injectionInput)
 		if err != nil {
 
@@ -198,6 +190,9 @@ func (bb *BakinBacon) revealNonces(ctx context.Context, wg *sync.WaitGroup, bloc
 			parts := previouslyInjectedErr.FindStringSubmatch(resp.String())
 			if len(parts) > 0 {
 				revealOpHash = parts[1]
+
+				// The chain says this was previously revealed; no need to verify
+				nonce.RevealVerified = storage.VERIFIED_INCLUDED
 			} else {
 
 				log.WithError(err).WithFields(log.Fields{
@@ -210,8 +205,14 @@ func (bb *BakinBacon) revealNonces(ctx context.Context, wg *sync.WaitGroup, bloc
 
 		log.WithField("OperationHash", revealOpHash).Info("Nonce Reveal Injected")
 
+		bb.Publish(events.NONCE_REVEALED, events.InjectedEvent{
+			Level: nonce.Level,
+			Hash:  revealOpHash,
+		})
+
 		// Update DB with hash of reveal operation
 		nonce.RevealOp = revealOpHash
+		nonce.RevealLevel = block.Header.Level
 
 		// Marshal for DB
 		nonceBytes, err := json.Marshal(nonce)
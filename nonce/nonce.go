@@ -0,0 +1,25 @@
+package nonce
+
+import (
+	"encoding/hex"
+
+	"github.com/bakingbacon/go-tezos/v4/crypto"
+
+	"bakinbacon/util"
+)
+
+// FromSeed creates the nonce committed to by seed, which is 32 random bytes
+func FromSeed(seed []byte) (Nonce, error) {
+
+	nonceHash, err := util.CryptoGenericHash(seed, []byte{})
+	if err != nil {
+		return Nonce{}, err
+	}
+
+	return Nonce{
+		Seed:          hex.EncodeToString(seed),
+		Nonce:         nonceHash,
+		EncodedNonce:  crypto.B58cencode(nonceHash, Prefix_nonce),
+		NoPrefixNonce: hex.EncodeToString(nonceHash),
+	}, nil
+}
@@ -10,4 +10,8 @@ type Nonce struct {
 
 	Level    int    `json:"level"`
 	RevealOp string `json:"revealed"`
+
+	// Level at which the reveal was injected, and if it was later found in a block
+	RevealLevel    int    `json:"revealedlevel"`
+	RevealVerified string `json:"revealverified"`
 }
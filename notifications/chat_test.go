@@ -0,0 +1,259 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"bakinbacon/storage"
+)
+
+type chatRequest struct {
+	method string
+	path   string
+	auth   string
+	body   map[string]interface{}
+}
+
+// chatStub records JSON requests, answering with status
+func chatStub(t *testing.T, status int) (*httptest.Server, func() []chatRequest) {
+	return newChatStub(t, status, false)
+}
+
+// chatStubTLS is like chatStub, but over https, which the Slack and Discord configs require.
+// Replaces httpClient with one that trusts the stub until the test ends.
+func chatStubTLS(t *testing.T, status int) (*httptest.Server, func() []chatRequest) {
+
+	srv, requests := newChatStub(t, status, true)
+
+	client := httpClient
+	httpClient = srv.Client()
+	t.Cleanup(func() { httpClient = client })
+
+	return srv, requests
+}
+
+func newChatStub(t *testing.T, status int, useTLS bool) (*httptest.Server, func() []chatRequest) {
+
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []chatRequest
+	)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		req := chatRequest{method: r.Method, path: r.URL.EscapedPath(), auth: r.Header.Get("Authorization")}
+		if err := json.NewDecoder(r.Body).Decode(&req.body); err != nil {
+			t.Errorf("Body is not JSON: %v", err)
+		}
+
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"error": "stub"}`))
+	})
+
+	srv := httptest.NewUnstartedServer(handler)
+	if useTLS {
+		srv.StartTLS()
+	} else {
+		srv.Start()
+	}
+
+	t.Cleanup(srv.Close)
+
+	return srv, func() []chatRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]chatRequest{}, requests...)
+	}
+}
+
+func TestSlack(t *testing.T) {
+
+	srv, requests := chatStub(t, http.StatusOK)
+	ns := &NotifySlack{WebhookUrl: srv.URL + "/services/T0/B0/X", Enabled: true}
+
+	if err := ns.TestSend(Notification{Message: "Baked block 1234"}); err != nil {
+		t.Fatalf("TestSend: %v", err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 1 || reqs[0].method != http.MethodPost || reqs[0].path != "/services/T0/B0/X" {
+		t.Fatalf("Unexpected requests %+v", reqs)
+	}
+
+	if reqs[0].body["text"] != "Baked block 1234" {
+		t.Errorf("text = %v", reqs[0].body["text"])
+	}
+}
+
+func TestDiscord(t *testing.T) {
+
+	srv, requests := chatStub(t, http.StatusNoContent)
+	nd := &NotifyDiscord{WebhookUrl: srv.URL + "/api/webhooks/1/abc", Enabled: true}
+
+	if err := nd.TestSend(Notification{Message: strings.Repeat("x", 2500)}); err != nil {
+		t.Fatalf("TestSend: %v", err)
+	}
+
+	body := requests()[0].body
+
+	if body["username"] != DISCORD_USERNAME {
+		t.Errorf("username = %v", body["username"])
+	}
+
+	if content, _ := body["content"].(string); len(content) != DISCORD_MAX_LENGTH {
+		t.Errorf("content length = %d, want %d", len(content), DISCORD_MAX_LENGTH)
+	}
+
+	if _, ok := body["allowed_mentions"]; !ok {
+		t.Error("allowed_mentions not set")
+	}
+}
+
+func TestMatrix(t *testing.T) {
+
+	srv, requests := chatStub(t, http.StatusOK)
+	nm := &NotifyMatrix{
+		Homeserver:  srv.URL + "/",
+		AccessToken: "syt_token",
+		RoomIds:     []string{"!one:example.org", "!two:example.org"},
+		Enabled:     true,
+	}
+
+	if err := nm.TestSend(Notification{Message: "Missed endorsement"}); err != nil {
+		t.Fatalf("TestSend: %v", err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 2 {
+		t.Fatalf("Got %d requests, want 2", len(reqs))
+	}
+
+	for i, room := range []string{"%21one:example.org", "%21two:example.org"} {
+
+		req := reqs[i]
+
+		prefix := "/_matrix/client/v3/rooms/" + room + "/send/m.room.message/"
+		if req.method != http.MethodPut || !strings.HasPrefix(req.path, prefix) {
+			t.Errorf("%s %s, want PUT %s<txnId>", req.method, req.path, prefix)
+		}
+
+		if req.auth != "Bearer syt_token" {
+			t.Errorf("Authorization = %q", req.auth)
+		}
+
+		if req.body["msgtype"] != "m.notice" || req.body["body"] != "Missed endorsement" {
+			t.Errorf("Body = %v", req.body)
+		}
+	}
+
+	// Transaction ids must differ, or the server drops the repeat
+	if reqs[0].path[strings.LastIndex(reqs[0].path, "/"):] == reqs[1].path[strings.LastIndex(reqs[1].path, "/"):] {
+		t.Error("Transaction ids are not unique")
+	}
+}
+
+func TestChatErrors(t *testing.T) {
+
+	srv, _ := chatStub(t, http.StatusForbidden)
+
+	for name, tester := range map[string]Tester{
+		SLACK:   &NotifySlack{WebhookUrl: srv.URL},
+		DISCORD: &NotifyDiscord{WebhookUrl: srv.URL},
+		MATRIX:  &NotifyMatrix{Homeserver: srv.URL, RoomIds: []string{"!a:b"}},
+	} {
+		err := tester.TestSend(Notification{Message: "test"})
+		if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "stub") {
+			t.Errorf("%s: expected 403 error with reply, got %v", name, err)
+		}
+	}
+}
+
+// Webhook URLs are credentials, and errors are logged
+func TestChatErrorsHideUrl(t *testing.T) {
+
+	srv, _ := chatStub(t, http.StatusOK)
+	srv.Close()
+
+	for name, tester := range map[string]Tester{
+		SLACK:   &NotifySlack{WebhookUrl: srv.URL + "/services/T000/B000/hooksecret"},
+		DISCORD: &NotifyDiscord{WebhookUrl: srv.URL + "/api/webhooks/1/hooksecret"},
+	} {
+		err := tester.TestSend(Notification{Message: "test"})
+		if err == nil || strings.Contains(err.Error(), "hooksecret") {
+			t.Errorf("%s: expected error without the URL, got %v", name, err)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+
+	want := []string{DISCORD, EMAIL, MATRIX, SLACK, TELEGRAM, WEBHOOK}
+	if got := RegisteredNotifiers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RegisteredNotifiers = %v, want %v", got, want)
+	}
+
+	db, err := storage.InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	n, err := NewHandler(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every registered notifier is loaded, even without config
+	for _, name := range want {
+		if _, ok := n.notifiers[name]; !ok {
+			t.Errorf("%s not loaded", name)
+		}
+	}
+
+	// Configure through the registry, save, then reload from the DB
+	srv, requests := chatStubTLS(t, http.StatusOK)
+
+	if err := n.Configure(SLACK, []byte(`{"webhookurl": "`+srv.URL+`", "enabled": true}`), true); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	if err := n.LoadNotifiers(); err != nil {
+		t.Fatalf("LoadNotifiers: %v", err)
+	}
+
+	if !n.notifiers[SLACK].IsEnabled() {
+		t.Error("Saved Slack config was not loaded")
+	}
+
+	if err := n.TestSend(SLACK, "Test message"); err != nil {
+		t.Errorf("TestSend: %v", err)
+	}
+
+	if len(requests()) != 1 {
+		t.Errorf("Got %d requests, want 1", len(requests()))
+	}
+
+	// Rejected before saving
+	if err := n.Configure(SLACK, []byte(`{"webhookurl": "http://insecure", "enabled": true}`), true); err == nil {
+		t.Error("Expected error for http webhook URL")
+	}
+
+	if err := n.Configure("carrier-pigeon", nil, false); err == nil {
+		t.Error("Expected error for unknown notifier")
+	}
+
+	if err := n.TestSend("carrier-pigeon", "test"); err == nil {
+		t.Error("Expected error for unknown notifier")
+	}
+}
@@ -0,0 +1,118 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/metrics"
+	"bakinbacon/storage"
+)
+
+const (
+	DISCORD_USERNAME = "BakinBacon"
+
+	// Longer messages are rejected
+	DISCORD_MAX_LENGTH = 2000
+)
+
+// NotifyDiscord posts to a Discord channel webhook, https://discord.com/developers/docs/resources/webhook
+type NotifyDiscord struct {
+	WebhookUrl string `json:"webhookurl"`
+	Username   string `json:"username"`
+	Enabled    bool   `json:"enabled"`
+
+	storage *storage.Storage
+}
+
+func init() {
+	RegisterNotifier(DISCORD, func(n *NotificationHandler, config []byte, saveConfig bool) (Notifier, error) {
+		return n.NewDiscord(config, saveConfig)
+	})
+}
+
+// NewDiscord creates a new NotifyDiscord object using a JSON byte-stream
+// provided from either DB lookup or web UI. See NewTelegram.
+func (n *NotificationHandler) NewDiscord(config []byte, saveConfig bool) (*NotifyDiscord, error) {
+
+	nd := &NotifyDiscord{}
+
+	// empty config from db?
+	if config != nil {
+		if err := json.Unmarshal(config, nd); err != nil {
+			return nd, errors.Wrap(err, "Unable to unmarshal discord config")
+		}
+	}
+
+	// save local reference to database
+	nd.storage = n.storage
+
+	if saveConfig {
+		if nd.Enabled && !validHttpsUrl(nd.WebhookUrl) {
+			return nd, errors.New("Discord webhook URL must be an https URL")
+		}
+
+		if err := nd.SaveConfig(); err != nil {
+			return nd, err
+		}
+	}
+
+	return nd, nil
+}
+
+func (n *NotifyDiscord) IsEnabled() bool {
+	return n.Enabled
+}
+
+func (n *NotifyDiscord) Send(msg string) {
+
+	if err := n.post(msg); err != nil {
+		log.WithError(err).Error("Unable to send Discord message")
+		metrics.NotificationFailures.WithLabelValues(DISCORD).Inc()
+		return
+	}
+
+	log.WithField("MSG", msg).Info("Sent Discord Message")
+}
+
+func (n *NotifyDiscord) TestSend(notification Notification) error {
+	return n.post(notification.Message)
+}
+
+func (n *NotifyDiscord) post(msg string) error {
+
+	username := n.Username
+	if username == "" {
+		username = DISCORD_USERNAME
+	}
+
+	if r := []rune(msg); len(r) > DISCORD_MAX_LENGTH {
+		msg = string(r[:DISCORD_MAX_LENGTH-3]) + "..."
+	}
+
+	return sendJSON(http.MethodPost, n.WebhookUrl, map[string]interface{}{
+		"content":  msg,
+		"username": username,
+
+		// Don't let messages ping @everyone
+		"allowed_mentions": map[string][]string{"parse": {}},
+	}, nil)
+}
+
+func (n *NotifyDiscord) SaveConfig() error {
+
+	// Marshal ourselves to []byte and send to storage manager
+	config, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal discord config")
+	}
+
+	if err := n.storage.SaveNotifiersConfig(DISCORD, config); err != nil {
+		return errors.Wrap(err, "Unable to save discord config")
+	}
+
+	return nil
+}
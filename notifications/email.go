@@ -1,31 +1,151 @@
 package notifications
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/metrics"
 	"bakinbacon/storage"
 )
 
+const (
+	// Connection security
+	EMAIL_STARTTLS = "starttls" // Plain connection upgraded with STARTTLS, usually port 587
+	EMAIL_TLS      = "tls"      // Implicit TLS, usually port 465
+	EMAIL_NONE     = "none"     // No encryption; Credentials are only sent to localhost
+
+	// Authentication mechanisms; Empty picks one the server offers
+	EMAIL_AUTH_PLAIN = "plain"
+	EMAIL_AUTH_LOGIN = "login"
+
+	EMAIL_TIMEOUT = 30 * time.Second
+)
+
 type NotifyEmail struct {
-	Username  string `json:"username"`
-	Password  string `json:"password"`
-	Smtp_host string `json:"smtphost"`
-	Smtp_port int    `json:"smtpport"`
-	Enabled   bool   `json:"enabled"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	Smtp_host string   `json:"smtphost"`
+	Smtp_port int      `json:"smtpport"`
+	Security  string   `json:"security"`
+	Auth      string   `json:"auth"`
+	From      string   `json:"from"`
+	To        []string `json:"to"`
+	Enabled   bool     `json:"enabled"`
 
 	storage *storage.Storage
+
+	// Used by tests to trust a local server
+	tlsConfig *tls.Config
 }
 
+var emailTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<p>{{ .Message }}</p>
+<p style="color: #888888; font-size: small;">Sent by BakinBacon from {{ .Host }} at {{ .Time }}</p>
+</body>
+</html>
+`))
+
+func init() {
+	RegisterNotifier(EMAIL, func(n *NotificationHandler, config []byte, saveConfig bool) (Notifier, error) {
+		return n.NewEmail(config, saveConfig)
+	})
+}
+
+// NewEmail creates a new NotifyEmail object using a JSON byte-stream
+// provided from either DB lookup or web UI. See NewTelegram.
 func (n *NotificationHandler) NewEmail(config []byte, saveConfig bool) (*NotifyEmail, error) {
 
-	return &NotifyEmail{
-		Enabled: false,
-		storage: n.storage,
-	}, nil
+	ne := &NotifyEmail{}
+
+	// empty config from db?
+	if config != nil {
+		if err := json.Unmarshal(config, ne); err != nil {
+			return ne, errors.Wrap(err, "Unable to unmarshal email config")
+		}
+	}
+
+	if ne.Security == "" {
+		ne.Security = EMAIL_STARTTLS
+	}
+
+	// save local reference to database
+	ne.storage = n.storage
+
+	if saveConfig {
+		if err := ne.Validate(); err != nil {
+			return ne, err
+		}
+
+		if err := ne.SaveConfig(); err != nil {
+			return ne, err
+		}
+	}
+
+	return ne, nil
+}
+
+// Validate checks an enabled config is complete; A disabled one can be saved half-filled
+func (n *NotifyEmail) Validate() error {
+
+	if !n.Enabled {
+		return nil
+	}
+
+	if n.Smtp_host == "" {
+		return errors.New("SMTP host is required")
+	}
+
+	if n.Smtp_port < 1 || n.Smtp_port > 65535 {
+		return errors.Errorf("Invalid SMTP port %d", n.Smtp_port)
+	}
+
+	switch n.Security {
+	case "", EMAIL_STARTTLS, EMAIL_TLS, EMAIL_NONE:
+	default:
+		return errors.Errorf("Unknown SMTP security '%s'", n.Security)
+	}
+
+	switch n.Auth {
+	case "", EMAIL_AUTH_PLAIN, EMAIL_AUTH_LOGIN:
+	default:
+		return errors.Errorf("Unknown SMTP auth '%s'", n.Auth)
+	}
+
+	// Sending would fail at AUTH, after connecting, so refuse it here
+	if n.Security == EMAIL_NONE && (n.Username != "" || n.Auth != "") && !isLocalhost(n.Smtp_host) {
+		return errors.New("SMTP credentials are only sent unencrypted to localhost; Use STARTTLS or TLS")
+	}
+
+	if len(n.To) == 0 {
+		return errors.New("At least one recipient is required")
+	}
+
+	if n.from() == "" {
+		return errors.New("From address is required")
+	}
+
+	return nil
 }
 
 func (n *NotifyEmail) IsEnabled() bool {
@@ -33,9 +153,327 @@ func (n *NotifyEmail) IsEnabled() bool {
 }
 
 func (n *NotifyEmail) Send(msg string) {
-	// TODO Not implemented yet
-	log.Warn("Email notifications not yet implemented")
 
+	if err := n.SendEmail(msg); err != nil {
+		log.WithError(err).Error("Unable to send email")
+		metrics.NotificationFailures.WithLabelValues(EMAIL).Inc()
+		return
+	}
+
+	log.WithField("MSG", msg).Info("Sent Email")
+}
+
+func (n *NotifyEmail) TestSend(notification Notification) error {
+	return n.SendEmail(notification.Message)
+}
+
+// SendEmail sends msg to all recipients in one message, with plain-text and HTML bodies
+func (n *NotifyEmail) SendEmail(msg string) error {
+
+	body, err := n.buildMessage(msg)
+	if err != nil {
+		return errors.Wrap(err, "Unable to build message")
+	}
+
+	c, err := n.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if n.Username != "" {
+		auth, err := n.auth(c)
+		if err != nil {
+			return err
+		}
+
+		if err := c.Auth(auth); err != nil {
+			return errors.Wrap(err, "SMTP authentication failed")
+		}
+	}
+
+	if err := c.Mail(n.from()); err != nil {
+		return errors.Wrap(err, "SMTP server rejected sender")
+	}
+
+	for _, to := range n.To {
+		if err := c.Rcpt(to); err != nil {
+			return errors.Wrapf(err, "SMTP server rejected recipient %s", to)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "SMTP server rejected message")
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return errors.Wrap(err, "Unable to send message")
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "SMTP server rejected message")
+	}
+
+	return c.Quit()
+}
+
+// dial connects to the server and, unless disabled, makes sure the connection is encrypted
+func (n *NotifyEmail) dial() (*smtp.Client, error) {
+
+	addr := net.JoinHostPort(n.Smtp_host, strconv.Itoa(n.Smtp_port))
+	dialer := &net.Dialer{Timeout: EMAIL_TIMEOUT}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	if n.Security == EMAIL_TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, n.getTlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to connect to %s", addr)
+	}
+
+	// Covers the whole conversation, in case the server stops responding
+	if err := conn.SetDeadline(time.Now().Add(EMAIL_TIMEOUT)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c, err := smtp.NewClient(conn, n.Smtp_host)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "Unable to connect to %s", addr)
+	}
+
+	if err := c.Hello(localName()); err != nil {
+		c.Close()
+		return nil, errors.Wrap(err, "SMTP server rejected greeting")
+	}
+
+	// Unset is STARTTLS, the default
+	if n.Security != EMAIL_TLS && n.Security != EMAIL_NONE {
+
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, errors.New("SMTP server does not support STARTTLS")
+		}
+
+		if err := c.StartTLS(n.getTlsConfig()); err != nil {
+			c.Close()
+			return nil, errors.Wrap(err, "Unable to start TLS")
+		}
+	}
+
+	return c, nil
+}
+
+func (n *NotifyEmail) getTlsConfig() *tls.Config {
+
+	if n.tlsConfig != nil {
+		return n.tlsConfig
+	}
+
+	return &tls.Config{
+		ServerName: n.Smtp_host,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// auth returns the configured mechanism, or the first of PLAIN and LOGIN the server supports
+func (n *NotifyEmail) auth(c *smtp.Client) (smtp.Auth, error) {
+
+	mechanism := n.Auth
+
+	if mechanism == "" {
+
+		ok, params := c.Extension("AUTH")
+		if !ok {
+			return nil, errors.New("SMTP server does not support authentication")
+		}
+
+		for _, m := range strings.Fields(strings.ToLower(params)) {
+			if m == EMAIL_AUTH_PLAIN || m == EMAIL_AUTH_LOGIN {
+				mechanism = m
+				break
+			}
+		}
+
+		if mechanism == "" {
+			return nil, errors.Errorf("SMTP server does not support PLAIN or LOGIN authentication, only %s", params)
+		}
+	}
+
+	if mechanism == EMAIL_AUTH_LOGIN {
+		return &loginAuth{username: n.Username, password: n.Password, host: n.Smtp_host}, nil
+	}
+
+	return smtp.PlainAuth("", n.Username, n.Password, n.Smtp_host), nil
+}
+
+func (n *NotifyEmail) from() string {
+
+	if n.From != "" {
+		return n.From
+	}
+
+	// Most providers use the address as the username
+	if strings.Contains(n.Username, "@") {
+		return n.Username
+	}
+
+	return ""
+}
+
+// buildMessage returns the headers and a multipart/alternative body with text and HTML versions of msg
+func (n *NotifyEmail) buildMessage(msg string) ([]byte, error) {
+
+	now := time.Now()
+
+	var html bytes.Buffer
+	if err := emailTemplate.Execute(&html, map[string]string{
+		"Message": msg,
+		"Host":    localName(),
+		"Time":    now.UTC().Format(time.RFC1123),
+	}); err != nil {
+		return nil, err
+	}
+
+	var (
+		buf  bytes.Buffer
+		body bytes.Buffer
+	)
+
+	mw := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=UTF-8", []byte(msg + "\r\n")},
+		{"text/html; charset=UTF-8", html.Bytes()},
+	} {
+
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write(part.content); err != nil {
+			return nil, err
+		}
+
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	headers := [][2]string{
+		{"From", n.from()},
+		{"To", strings.Join(n.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("UTF-8", subject(msg))},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", messageId(n.from())},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h[0], h[1])
+	}
+
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// subject is the first line of msg, shortened to fit on one line
+func subject(msg string) string {
+
+	line := strings.TrimSpace(strings.SplitN(msg, "\n", 2)[0])
+
+	if r := []rune(line); len(r) > 60 {
+		line = string(r[:57]) + "..."
+	}
+
+	return "BakinBacon: " + line
+}
+
+func messageId(from string) string {
+
+	domain := localName()
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("<%d@%s>", time.Now().UnixNano(), domain)
+	}
+
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
+
+func localName() string {
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "localhost"
+	}
+
+	return host
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp does not. Like smtp.PlainAuth,
+// it refuses to send the password over an unencrypted connection, except to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, errors.Errorf("Unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
 }
 
 func (n *NotifyEmail) SaveConfig() error {
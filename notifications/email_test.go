@@ -0,0 +1,480 @@
+package notifications
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStub is a minimal SMTP server that records what it receives
+type smtpStub struct {
+	listener   net.Listener
+	tlsConfig  *tls.Config
+	implicit   bool     // TLS from the start, rather than STARTTLS
+	noStartTLS bool     // Do not offer STARTTLS
+	authTypes  []string // Advertised AUTH mechanisms
+
+	mu     sync.Mutex
+	auth   string // "PLAIN user pass" or "LOGIN user pass"
+	from   string
+	rcpts  []string
+	data   string
+	sawTLS bool
+	errors []string
+}
+
+func newSmtpStub(t *testing.T, implicit bool, authTypes ...string) (*smtpStub, *x509.CertPool) {
+
+	t.Helper()
+
+	cert, pool := selfSignedCert(t)
+
+	s := &smtpStub{
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		implicit:  implicit,
+		authTypes: authTypes,
+	}
+
+	var err error
+	if implicit {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { s.listener.Close() })
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s, pool
+}
+
+func (s *smtpStub) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+
+	defer conn.Close()
+
+	isTLS := s.implicit
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	readLine := func() (string, bool) {
+		l, err := r.ReadString('\n')
+		return strings.TrimRight(l, "\r\n"), err == nil
+	}
+
+	reply("220 stub ESMTP")
+
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := ""
+		if i := strings.Index(line, " "); i >= 0 {
+			arg = line[i+1:]
+		}
+
+		switch cmd {
+		case "EHLO":
+			reply("250-stub")
+			if !isTLS && !s.noStartTLS {
+				reply("250-STARTTLS")
+			}
+			if len(s.authTypes) > 0 {
+				reply("250-AUTH " + strings.Join(s.authTypes, " "))
+			}
+			reply("250 8BITMIME")
+
+		case "STARTTLS":
+			reply("220 Ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r, isTLS = tlsConn, bufio.NewReader(tlsConn), true
+
+		case "AUTH":
+			parts := strings.Fields(arg)
+			switch strings.ToUpper(parts[0]) {
+			case "PLAIN":
+				b, _ := base64.StdEncoding.DecodeString(parts[1])
+				f := strings.Split(string(b), "\x00")
+				s.record(func() { s.auth = "PLAIN " + f[1] + " " + f[2] })
+			case "LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				u, _ := readLine()
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				p, _ := readLine()
+				ub, _ := base64.StdEncoding.DecodeString(u)
+				pb, _ := base64.StdEncoding.DecodeString(p)
+				s.record(func() { s.auth = "LOGIN " + string(ub) + " " + string(pb) })
+			}
+			reply("235 Authenticated")
+
+		case "MAIL":
+			s.record(func() { s.from = addrArg(arg) })
+			reply("250 OK")
+
+		case "RCPT":
+			s.record(func() { s.rcpts = append(s.rcpts, addrArg(arg)) })
+			reply("250 OK")
+
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				l, ok := readLine()
+				if !ok || l == "." {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, ".") + "\r\n")
+			}
+			s.record(func() { s.data, s.sawTLS = data.String(), isTLS })
+			reply("250 Queued")
+
+		case "QUIT":
+			reply("221 Bye")
+			return
+
+		default:
+			s.record(func() { s.errors = append(s.errors, line) })
+			reply("502 Unknown command")
+		}
+	}
+}
+
+// addrArg returns the address from "FROM:<addr> BODY=8BITMIME" or "TO:<addr>"
+func addrArg(arg string) string {
+
+	start, end := strings.Index(arg, "<"), strings.Index(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return arg[start+1 : end]
+}
+
+func (s *smtpStub) record(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func testEmailNotifier(port int, security, auth string, pool *x509.CertPool) *NotifyEmail {
+	return &NotifyEmail{
+		Username:  "baker@example.com",
+		Password:  "secret",
+		Smtp_host: "127.0.0.1",
+		Smtp_port: port,
+		Security:  security,
+		Auth:      auth,
+		To:        []string{"one@example.com", "two@example.com"},
+		Enabled:   true,
+		tlsConfig: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+	}
+}
+
+func TestEmailSend(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		implicit bool
+		security string
+		auth     string
+		offered  []string
+		wantAuth string
+	}{
+		{"starttls plain", false, EMAIL_STARTTLS, EMAIL_AUTH_PLAIN, []string{"PLAIN", "LOGIN"}, "PLAIN"},
+		{"starttls login", false, EMAIL_STARTTLS, EMAIL_AUTH_LOGIN, []string{"PLAIN", "LOGIN"}, "LOGIN"},
+		{"implicit tls auto", true, EMAIL_TLS, "", []string{"CRAM-MD5", "LOGIN"}, "LOGIN"},
+		{"implicit tls plain", true, EMAIL_TLS, EMAIL_AUTH_PLAIN, []string{"PLAIN"}, "PLAIN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			stub, pool := newSmtpStub(t, tt.implicit, tt.offered...)
+			ne := testEmailNotifier(stub.port(), tt.security, tt.auth, pool)
+
+			if err := ne.SendEmail("Baked block 1234\nPriority 0"); err != nil {
+				t.Fatalf("SendEmail: %v", err)
+			}
+
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+
+			if !stub.sawTLS {
+				t.Error("Message was sent without TLS")
+			}
+
+			if want := tt.wantAuth + " baker@example.com secret"; stub.auth != want {
+				t.Errorf("auth = %q, want %q", stub.auth, want)
+			}
+
+			if stub.from != "baker@example.com" {
+				t.Errorf("from = %q", stub.from)
+			}
+
+			if strings.Join(stub.rcpts, ",") != "one@example.com,two@example.com" {
+				t.Errorf("rcpts = %v", stub.rcpts)
+			}
+
+			if len(stub.errors) > 0 {
+				t.Errorf("Unexpected commands: %v", stub.errors)
+			}
+
+			checkMessage(t, stub.data)
+		})
+	}
+}
+
+func checkMessage(t *testing.T, data string) {
+
+	t.Helper()
+
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unable to parse message: %v", err)
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "BakinBacon: Baked block 1234" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+
+	if to := msg.Header.Get("To"); to != "one@example.com, two@example.com" {
+		t.Errorf("To = %q", to)
+	}
+
+	parts := messageParts(t, msg)
+
+	if !strings.Contains(parts["text/plain"], "Baked block 1234\r\nPriority 0") {
+		t.Errorf("text/plain part = %q", parts["text/plain"])
+	}
+
+	if !strings.Contains(parts["text/html"], "<p>Baked block 1234\r\nPriority 0</p>") {
+		t.Errorf("text/html part = %q", parts["text/html"])
+	}
+}
+
+// messageParts returns the decoded parts of a multipart/alternative message, by content type
+func messageParts(t *testing.T, msg *mail.Message) map[string]string {
+
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v)", mediaType, err)
+	}
+
+	parts := make(map[string]string)
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+
+		// multipart.Reader decodes quoted-printable
+		b, _ := ioutil.ReadAll(p)
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[ct] = string(b)
+	}
+
+	return parts
+}
+
+func TestEmailHtmlEscaped(t *testing.T) {
+
+	stub, pool := newSmtpStub(t, true, "PLAIN")
+	ne := testEmailNotifier(stub.port(), EMAIL_TLS, "", pool)
+
+	if err := ne.SendEmail("<script>alert(1)</script>"); err != nil {
+		t.Fatalf("SendEmail: %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	msg, err := mail.ReadMessage(strings.NewReader(stub.data))
+	if err != nil {
+		t.Fatalf("Unable to parse message: %v", err)
+	}
+
+	html := messageParts(t, msg)["text/html"]
+	if strings.Contains(html, "<script>") || !strings.Contains(html, "&lt;script&gt;") {
+		t.Errorf("Message was not HTML escaped: %q", html)
+	}
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+
+	stub, pool := newSmtpStub(t, false, "PLAIN")
+	stub.noStartTLS = true
+	ne := testEmailNotifier(stub.port(), EMAIL_STARTTLS, "", pool)
+
+	if err := ne.SendEmail("test"); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Expected STARTTLS failure, got %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	if stub.auth != "" {
+		t.Error("Credentials were sent without TLS")
+	}
+}
+
+func TestEmailUntrustedCertificate(t *testing.T) {
+
+	stub, _ := newSmtpStub(t, false, "PLAIN")
+	ne := testEmailNotifier(stub.port(), EMAIL_STARTTLS, "", x509.NewCertPool())
+
+	if err := ne.SendEmail("test"); err == nil || !strings.Contains(err.Error(), "TLS") {
+		t.Errorf("Expected TLS failure, got %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	if stub.auth != "" {
+		t.Error("Credentials were sent after a failed TLS handshake")
+	}
+}
+
+func TestEmailNoEncryptionLocalhost(t *testing.T) {
+
+	// No AUTH offered, and no username, so nothing sensitive is sent in the clear
+	stub, _ := newSmtpStub(t, false)
+	ne := testEmailNotifier(stub.port(), EMAIL_NONE, "", nil)
+	ne.Username, ne.Password, ne.From = "", "", "bacon@localhost"
+
+	if err := ne.SendEmail("test"); err != nil {
+		t.Fatalf("SendEmail: %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	if stub.sawTLS || stub.from != "bacon@localhost" || len(stub.rcpts) != 2 {
+		t.Errorf("Unexpected delivery: tls=%v from=%q rcpts=%v", stub.sawTLS, stub.from, stub.rcpts)
+	}
+}
+
+func TestEmailValidate(t *testing.T) {
+
+	valid := testEmailNotifier(587, EMAIL_STARTTLS, "", nil)
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	for name, modify := range map[string]func(*NotifyEmail){
+		"no host":       func(n *NotifyEmail) { n.Smtp_host = "" },
+		"bad port":      func(n *NotifyEmail) { n.Smtp_port = 0 },
+		"bad security":  func(n *NotifyEmail) { n.Security = "ssl" },
+		"bad auth":      func(n *NotifyEmail) { n.Auth = "cram-md5" },
+		"no recipients": func(n *NotifyEmail) { n.To = nil },
+		"no from":       func(n *NotifyEmail) { n.Username = "baker" },
+		"unencrypted credentials": func(n *NotifyEmail) {
+			n.Security, n.Smtp_host = EMAIL_NONE, "smtp.example.com"
+		},
+		"unencrypted auth": func(n *NotifyEmail) {
+			n.Security, n.Smtp_host, n.Username, n.Auth = EMAIL_NONE, "smtp.example.com", "", EMAIL_AUTH_PLAIN
+		},
+	} {
+		n := testEmailNotifier(587, EMAIL_STARTTLS, "", nil)
+		modify(n)
+		if err := n.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Unencrypted is fine without credentials, or to localhost
+	for _, host := range []string{"smtp.example.com", "localhost", "::1"} {
+		n := testEmailNotifier(25, EMAIL_NONE, "", nil)
+		n.Smtp_host = host
+		if host == "smtp.example.com" {
+			n.Username, n.Password, n.From = "", "", "bacon@example.com"
+		}
+		if err := n.Validate(); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+	}
+
+	// Disabled config is not checked, so it can be saved half-filled
+	disabled := &NotifyEmail{}
+	if err := disabled.Validate(); err != nil {
+		t.Errorf("disabled: %v", err)
+	}
+}
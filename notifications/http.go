@@ -0,0 +1,68 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Used by the Slack, Discord and Matrix notifiers
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// sendJSON sends payload as JSON, returning an error, including the start of the reply, for anything but 2xx
+func sendJSON(method, u string, payload interface{}, headers map[string]string) error {
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal payload")
+	}
+
+	// Webhook URLs are credentials; Errors are logged and shown to the UI, so keep them out
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(withoutUrl(err), "Unable to create request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "BakinBacon")
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(withoutUrl(err), "Unable to reach %s", req.URL.Hostname())
+	}
+	defer resp.Body.Close()
+
+	reply, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+
+	return nil
+}
+
+// withoutUrl returns the cause of a *url.Error, which quotes the URL
+func withoutUrl(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
+
+func validHttpsUrl(u string) bool {
+	p, err := url.Parse(u)
+	return err == nil && p.Scheme == "https" && p.Host != ""
+}
@@ -0,0 +1,177 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/metrics"
+	"bakinbacon/storage"
+)
+
+// NotifyMatrix sends to Matrix rooms using the client-server API,
+// https://spec.matrix.org/latest/client-server-api/#put_matrixclientv3roomsroomidsendeventtypetxnid
+// The access token's user must already have joined the rooms.
+type NotifyMatrix struct {
+	Homeserver  string   `json:"homeserver"`
+	AccessToken string   `json:"accesstoken"`
+	RoomIds     []string `json:"roomids"`
+	Enabled     bool     `json:"enabled"`
+
+	storage *storage.Storage
+}
+
+// Makes transaction ids unique within this run; The server ignores repeats of the same id
+var matrixTxnCounter uint64
+
+func init() {
+	RegisterNotifier(MATRIX, func(n *NotificationHandler, config []byte, saveConfig bool) (Notifier, error) {
+		return n.NewMatrix(config, saveConfig)
+	})
+}
+
+// NewMatrix creates a new NotifyMatrix object using a JSON byte-stream
+// provided from either DB lookup or web UI. See NewTelegram.
+func (n *NotificationHandler) NewMatrix(config []byte, saveConfig bool) (*NotifyMatrix, error) {
+
+	nm := &NotifyMatrix{}
+
+	// empty config from db?
+	if config != nil {
+		if err := json.Unmarshal(config, nm); err != nil {
+			return nm, errors.Wrap(err, "Unable to unmarshal matrix config")
+		}
+	}
+
+	// save local reference to database
+	nm.storage = n.storage
+
+	if saveConfig {
+		if err := nm.validate(); err != nil {
+			return nm, err
+		}
+
+		if err := nm.SaveConfig(); err != nil {
+			return nm, err
+		}
+	}
+
+	return nm, nil
+}
+
+func (n *NotifyMatrix) validate() error {
+
+	if !n.Enabled {
+		return nil
+	}
+
+	if p, err := url.Parse(n.Homeserver); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return errors.Errorf("Invalid Matrix homeserver URL %q", n.Homeserver)
+	}
+
+	if n.AccessToken == "" {
+		return errors.New("Matrix access token is required")
+	}
+
+	if len(n.RoomIds) == 0 {
+		return errors.New("At least one Matrix room is required")
+	}
+
+	for _, r := range n.RoomIds {
+		if !strings.HasPrefix(r, "!") || !strings.Contains(r, ":") {
+			return errors.Errorf("Invalid Matrix room id %q; Expected eg !abc123:matrix.org", r)
+		}
+	}
+
+	return nil
+}
+
+func (n *NotifyMatrix) IsEnabled() bool {
+	return n.Enabled
+}
+
+func (n *NotifyMatrix) Send(msg string) {
+
+	if err := n.post(msg); err != nil {
+		log.WithError(err).Error("Unable to send Matrix message")
+		metrics.NotificationFailures.WithLabelValues(MATRIX).Inc()
+		return
+	}
+
+	log.WithField("MSG", msg).Info("Sent Matrix Message")
+}
+
+func (n *NotifyMatrix) TestSend(notification Notification) error {
+	return n.post(notification.Message)
+}
+
+func (n *NotifyMatrix) post(msg string) error {
+
+	var failed []string
+
+	for _, roomId := range n.RoomIds {
+		if err := n.sendToRoom(roomId, msg); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func (n *NotifyMatrix) targets() []string {
+	return n.RoomIds
+}
+
+func (n *NotifyMatrix) sendTo(roomId string, notification Notification) error {
+	return n.sendToRoom(roomId, notification.Message)
+}
+
+func (n *NotifyMatrix) sendToRoom(roomId, msg string) error {
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + n.AccessToken,
+	}
+
+	content := map[string]string{
+		"msgtype": "m.notice",
+		"body":    msg,
+	}
+
+	txnId := fmt.Sprintf("bakinbacon-%d-%d", time.Now().UnixNano(), atomic.AddUint64(&matrixTxnCounter, 1))
+
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(n.Homeserver, "/"), url.PathEscape(roomId), txnId)
+
+	if err := sendJSON(http.MethodPut, u, content, headers); err != nil {
+		return errors.Errorf("%s: %s", roomId, err)
+	}
+
+	return nil
+}
+
+func (n *NotifyMatrix) SaveConfig() error {
+
+	// Marshal ourselves to []byte and send to storage manager
+	config, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal matrix config")
+	}
+
+	if err := n.storage.SaveNotifiersConfig(MATRIX, config); err != nil {
+		return errors.Wrap(err, "Unable to save matrix config")
+	}
+
+	return nil
+}
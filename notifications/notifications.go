@@ -1,7 +1,10 @@
 package notifications
 
 import (
+	"bytes"
 	"encoding/json"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -23,34 +26,152 @@ const (
 	VERSION
 	NONCE
 	PAYOUTS
+	BACKUP
+	DIGEST
 
 	TELEGRAM = "telegram"
 	EMAIL    = "email"
+	WEBHOOK  = "webhook"
+	SLACK    = "slack"
+	DISCORD  = "discord"
+	MATRIX   = "matrix"
+
+	LEVEL_INFO    = "info"
+	LEVEL_WARNING = "warning"
+	LEVEL_ERROR   = "error"
 )
 
+var categoryNames = map[Category]string{
+	STARTUP:      "startup",
+	BALANCE:      "balance",
+	SIGNER:       "signer",
+	BAKING_OK:    "baking_ok",
+	BAKING_FAIL:  "baking_fail",
+	ENDORSE_FAIL: "endorse_fail",
+	VERSION:      "version",
+	NONCE:        "nonce",
+	PAYOUTS:      "payouts",
+	BACKUP:       "backup",
+	DIGEST:       "digest",
+}
+
+var categoryLevels = map[Category]string{
+	BALANCE:      LEVEL_WARNING,
+	SIGNER:       LEVEL_ERROR,
+	BAKING_FAIL:  LEVEL_ERROR,
+	ENDORSE_FAIL: LEVEL_ERROR,
+	NONCE:        LEVEL_ERROR,
+	BACKUP:       LEVEL_ERROR,
+}
+
+func (c Category) String() string {
+	if name, ok := categoryNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func (c Category) Level() string {
+	if level, ok := categoryLevels[c]; ok {
+		return level
+	}
+	return LEVEL_INFO
+}
+
+// Notification is a message with the context it was sent in, for notifiers that
+// send structured data
+type Notification struct {
+	Category  string    `json:"category"`
+	Message   string    `json:"message"`
+	Level     string    `json:"level"`
+	Cycle     int       `json:"cycle"`
+	Delegate  string    `json:"delegate"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 type Notifier interface {
 	Send(string)
 	IsEnabled() bool
 }
 
+// EventNotifier is implemented by notifiers that want the whole Notification, not only the message
+type EventNotifier interface {
+	SendEvent(Notification)
+}
+
+// Tester is implemented by notifiers that can report whether a test message was delivered
+type Tester interface {
+	TestSend(Notification) error
+}
+
+// NotifierFactory creates a notifier from its JSON config, which is empty for a new notifier.
+// If saveConfig is true, the config is saved to the DB.
+type NotifierFactory func(n *NotificationHandler, config []byte, saveConfig bool) (Notifier, error)
+
+// Notifiers register themselves, from init(), so only those compiled in are available
+var notifierFactories = make(map[string]NotifierFactory)
+
+func RegisterNotifier(name string, factory NotifierFactory) {
+
+	if _, ok := notifierFactories[name]; ok {
+		panic("Notifier registered twice: " + name)
+	}
+
+	notifierFactories[name] = factory
+}
+
+// RegisteredNotifiers returns the names of all available notifiers, sorted
+func RegisteredNotifiers() []string {
+
+	names := make([]string, 0, len(notifierFactories))
+	for name := range notifierFactories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
 type NotificationHandler struct {
-	notifiers        map[string]Notifier
-	lastSentCategory map[Category]time.Time
-	storage          *storage.Storage
+	notifiers   map[string]Notifier
+	notifiersMu sync.RWMutex
+	storage     *storage.Storage
+
+	routing   *Routing
+	routingMu sync.RWMutex
+	throttler throttler
+
+	outboxWake chan struct{}
+
+	cycle   int
+	cycleMu sync.RWMutex
 }
 
 func NewHandler(db *storage.Storage) (*NotificationHandler, error) {
 
 	n := &NotificationHandler{
-		notifiers:        make(map[string]Notifier),
-		lastSentCategory: make(map[Category]time.Time),
-		storage:          db,
+		notifiers:  make(map[string]Notifier),
+		storage:    db,
+		routing:    &Routing{},
+		throttler:  throttler{states: make(map[string]*throttleState)},
+		outboxWake: make(chan struct{}, 1),
 	}
 
 	if err := n.LoadNotifiers(); err != nil {
 		return nil, errors.Wrap(err, "Failed to instantiate notification handler")
 	}
 
+	routing, err := db.GetNotificationRouting()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load notification routing")
+	}
+
+	// Don't prevent startup, eg if a notifier named in the rules is no longer compiled in
+	if err := n.SetRouting(routing, false); err != nil {
+		log.WithError(err).Error("Invalid notification routing; Sending all notifications to all notifiers")
+	}
+
 	log.Debug("Loaded notifications handler")
 
 	return n, nil
@@ -58,26 +179,18 @@ func NewHandler(db *storage.Storage) (*NotificationHandler, error) {
 
 func (n *NotificationHandler) LoadNotifiers() error {
 
-	// Get telegram notifications config from DB, as []byte string
-	telegramConfig, err := n.storage.GetNotifiersConfig(TELEGRAM)
-	if err != nil {
-		return errors.Wrap(err, "Unable to load telegram config")
-	}
-
-	// Configure telegram; Don't save what we just loaded
-	if err := n.Configure(TELEGRAM, telegramConfig, false); err != nil {
-		return errors.Wrap(err, "Unable to init telegram")
-	}
+	for _, name := range RegisteredNotifiers() {
 
-	// Get email notifications config from DB
-	emailConfig, err := n.storage.GetNotifiersConfig(EMAIL)
-	if err != nil {
-		return errors.Wrap(err, "Unable to load email config")
-	}
+		// Get notifier config from DB, as []byte string
+		config, err := n.storage.GetNotifiersConfig(name)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to load %s config", name)
+		}
 
-	// Configure email; Don't save what we just loaded
-	if err := n.Configure(EMAIL, emailConfig, false); err != nil {
-		return errors.Wrap(err, "Unable to init email")
+		// Don't save what we just loaded
+		if err := n.Configure(name, config, false); err != nil {
+			return errors.Wrapf(err, "Unable to init %s", name)
+		}
 	}
 
 	return nil
@@ -85,69 +198,156 @@ func (n *NotificationHandler) LoadNotifiers() error {
 
 func (n *NotificationHandler) Configure(notifier string, config []byte, saveConfig bool) error {
 
-	switch notifier {
-	case TELEGRAM:
-		nt, err := n.NewTelegram(config, saveConfig)
+	factory, ok := notifierFactories[notifier]
+	if !ok {
+		return errors.New("Unknown notification type")
+	}
+
+	// Configs from the web UI have masked credentials; Keep those already stored
+	if saveConfig && bytes.Contains(config, []byte(SECRET_MASK)) {
+
+		n.notifiersMu.RLock()
+		current, err := json.Marshal(n.notifiers[notifier])
+		n.notifiersMu.RUnlock()
+
 		if err != nil {
-			return err
+			return errors.Wrap(err, "Unable to encode current config")
 		}
-		n.notifiers[TELEGRAM] = nt
 
-	case EMAIL:
-		ne, err := n.NewEmail(config, saveConfig)
-		if err != nil {
+		if config, err = unmaskSecrets(notifier, config, current); err != nil {
 			return err
 		}
-		n.notifiers[EMAIL] = ne
+	}
 
-	default:
-		return errors.New("Unknown notification type")
+	nt, err := factory(n, config, saveConfig)
+	if err != nil {
+		return err
 	}
 
+	n.notifiersMu.Lock()
+	n.notifiers[notifier] = nt
+	n.notifiersMu.Unlock()
+
 	return nil
 }
 
 func (n *NotificationHandler) SendNotification(message string, category Category) {
 
-	// Check that we haven't sent a message from this category
-	// within the past 10 minutes
-	if lastSentTime, ok := n.lastSentCategory[category]; ok {
-		if lastSentTime.After(time.Now().UTC().Add(time.Minute * -10)) {
-			log.Info("Notification last sent within 10 minutes")
-			return
-		}
+	window := n.GetRouting().throttle(category)
+
+	message, ok := n.throttle(message, category, window, time.Now())
+	if !ok {
+		log.WithFields(log.Fields{
+			"Category": category.String(), "Window": window,
+		}).Info("Suppressed repeat notification")
+		n.recordSuppressed(message, category)
+		return
 	}
 
-	// Add/update notification timestamp for category
-	n.lastSentCategory[category] = time.Now().UTC()
+	n.dispatch(message, category)
+}
 
-	for k, n := range n.notifiers {
-		if n.IsEnabled() {
-			n.Send(message)
-		} else {
+// dispatch sends to each enabled notifier that the routing rules allow
+func (n *NotificationHandler) dispatch(message string, category Category) {
+
+	routing := n.GetRouting()
+	notification := n.newNotification(message, category)
+
+	n.notifiersMu.RLock()
+	defer n.notifiersMu.RUnlock()
+
+	for k, nt := range n.notifiers {
+
+		if !nt.IsEnabled() {
 			log.Infof("Notifications for '%s' are disabled", k)
+			continue
+		}
+
+		if !routing.wants(k, category, notification.Level) {
+			continue
+		}
+
+		// Notifiers that report failures are retried from the outbox
+		if _, ok := nt.(Tester); ok {
+			n.enqueue(k, nt, notification)
+		} else if en, ok := nt.(EventNotifier); ok {
+			en.SendEvent(notification)
+		} else {
+			nt.Send(message)
 		}
 	}
 }
 
+func (n *NotificationHandler) newNotification(message string, category Category) Notification {
+
+	// Not an error; No delegate is configured in a new setup. Never the secret key.
+	_, delegate, _ := n.storage.GetDelegate()
+
+	return Notification{
+		Category:  category.String(),
+		Message:   message,
+		Level:     n.GetRouting().level(category),
+		Cycle:     n.getCycle(),
+		Delegate:  delegate,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// SetCycle records the current cycle, which is included in notifications
+func (n *NotificationHandler) SetCycle(cycle int) {
+	n.cycleMu.Lock()
+	defer n.cycleMu.Unlock()
+	n.cycle = cycle
+}
+
+func (n *NotificationHandler) getCycle() int {
+	n.cycleMu.RLock()
+	defer n.cycleMu.RUnlock()
+	return n.cycle
+}
+
 func (n *NotificationHandler) TestSend(notifier string, message string) error {
 
-	switch notifier {
-	case TELEGRAM:
-		n.notifiers[TELEGRAM].Send(message)
-	case EMAIL:
-		n.notifiers[EMAIL].Send(message)
-	default:
+	n.notifiersMu.RLock()
+	nt, ok := n.notifiers[notifier]
+	n.notifiersMu.RUnlock()
+
+	if !ok {
 		return errors.New("Unknown notification type")
 	}
 
+	// Report failures, eg bad credentials, to the settings page
+	if t, ok := nt.(Tester); ok {
+		return t.TestSend(n.newNotification(message, STARTUP))
+	}
+
+	nt.Send(message)
+
 	return nil
 }
 
 func (n *NotificationHandler) GetConfig() (json.RawMessage, error) {
 
-	// Marshal the current Notifiers as the current config
+	// Marshal the current Notifiers as the current config, without credentials
 	// Return RawMessage so as not to double Marshal
-	bts, err := json.Marshal(n.notifiers)
+	n.notifiersMu.RLock()
+	defer n.notifiersMu.RUnlock()
+
+	configs := make(map[string]json.RawMessage, len(n.notifiers))
+
+	for name, nt := range n.notifiers {
+
+		bts, err := json.Marshal(nt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to encode %s config", name)
+		}
+
+		if configs[name], err = maskSecrets(name, bts); err != nil {
+			return nil, errors.Wrapf(err, "Unable to mask %s config", name)
+		}
+	}
+
+	bts, err := json.Marshal(configs)
+
 	return json.RawMessage(bts), err
 }
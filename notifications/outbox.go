@@ -0,0 +1,280 @@
+package notifications
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/metrics"
+	"bakinbacon/storage"
+)
+
+const (
+	// How often the outbox is checked for retries; New notifications are delivered at once
+	OUTBOX_INTERVAL = 5 * time.Second
+
+	// Failed deliveries are retried after OUTBOX_BACKOFF, doubling each time
+	OUTBOX_BACKOFF     = 15 * time.Second
+	OUTBOX_MAX_BACKOFF = 15 * time.Minute
+	OUTBOX_RETRIES     = 6
+
+	NOTIFICATION_HISTORY_LIMIT = 100
+)
+
+// retrier is implemented by notifiers with their own number of retries
+type retrier interface {
+	retries() int
+}
+
+// multiTarget is implemented by notifiers which send to several chats or rooms. The
+// outbox sends to each separately, so retries only go to those which failed.
+type multiTarget interface {
+	targets() []string
+	sendTo(target string, notification Notification) error
+}
+
+// enqueue saves the notification to the outbox, to be delivered by RunOutbox, and
+// retried until it succeeds. Only notifiers which report failures, ie Testers, can be retried.
+func (n *NotificationHandler) enqueue(notifier string, nt Notifier, notification Notification) {
+
+	notificationBytes, err := json.Marshal(notification)
+	if err == nil {
+		err = n.storage.AddToOutbox(&storage.OutboxEntry{
+			Notifier:     notifier,
+			Notification: notificationBytes,
+			NextAttempt:  notification.Timestamp,
+			Created:      notification.Timestamp,
+		})
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("Notifier", notifier).Error("Unable to save notification to outbox; Sending without retries")
+		go nt.Send(notification.Message)
+		return
+	}
+
+	// Wake RunOutbox, unless already woken
+	select {
+	case n.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutbox delivers notifications from the outbox, including any left from before a restart
+func (n *NotificationHandler) RunOutbox(shutdownChannel <-chan interface{}, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	ticker := time.NewTicker(OUTBOX_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		n.deliverOutbox(time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-n.outboxWake:
+		case <-shutdownChannel:
+			return
+		}
+	}
+}
+
+// deliverOutbox attempts each entry that is due. Notifiers are attempted in parallel, so
+// one slow mail server doesn't hold up the rest, but each notifier's entries are in order.
+func (n *NotificationHandler) deliverOutbox(now time.Time) {
+
+	entries, err := n.storage.GetOutbox()
+	if err != nil {
+		log.WithError(err).Error("Unable to read notification outbox")
+		return
+	}
+
+	due := make(map[string][]storage.OutboxEntry)
+
+	for _, e := range entries {
+		if !e.NextAttempt.After(now) {
+			due[e.Notifier] = append(due[e.Notifier], e)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for _, notifierEntries := range due {
+		wg.Add(1)
+		go func(entries []storage.OutboxEntry) {
+			defer wg.Done()
+			for _, e := range entries {
+				n.deliverEntry(e, now)
+			}
+		}(notifierEntries)
+	}
+
+	wg.Wait()
+}
+
+func (n *NotificationHandler) deliverEntry(e storage.OutboxEntry, now time.Time) {
+
+	var notification Notification
+	decodeErr := json.Unmarshal(e.Notification, &notification)
+
+	record := storage.NotificationRecord{
+		Notifier: e.Notifier,
+		Category: notification.Category,
+		Level:    notification.Level,
+		Message:  notification.Message,
+		Attempts: e.Attempts + 1,
+	}
+
+	n.notifiersMu.RLock()
+	nt, ok := n.notifiers[e.Notifier]
+	n.notifiersMu.RUnlock()
+
+	t, isTester := nt.(Tester)
+
+	switch {
+	case decodeErr != nil:
+		record.Status, record.Error = storage.NOTIFICATION_FAILED, "Unable to decode notification: "+decodeErr.Error()
+	case !ok || !isTester:
+		record.Status, record.Error = storage.NOTIFICATION_FAILED, "Unknown notifier"
+	case !nt.IsEnabled():
+		record.Status, record.Error = storage.NOTIFICATION_FAILED, "Notifier was disabled"
+	default:
+		if err := sendEntry(&e, t, notification); err != nil {
+			record.Status, record.Error = storage.NOTIFICATION_FAILED, err.Error()
+		} else {
+			record.Status = storage.NOTIFICATION_SENT
+		}
+	}
+
+	logger := log.WithFields(log.Fields{
+		"Notifier": e.Notifier, "Category": notification.Category, "Attempt": record.Attempts,
+	})
+
+	if record.Status == storage.NOTIFICATION_FAILED {
+
+		metrics.NotificationFailures.WithLabelValues(e.Notifier).Inc()
+
+		retries := OUTBOX_RETRIES
+		if r, ok := nt.(retrier); ok {
+			retries = r.retries()
+		}
+
+		// Retry, unless retrying can't help, or out of retries
+		if decodeErr == nil && ok && isTester && nt.IsEnabled() && e.Attempts < retries {
+
+			e.Attempts++
+			e.LastError = record.Error
+			e.NextAttempt = now.Add(outboxBackoff(e.Attempts))
+
+			if err := n.storage.UpdateOutboxEntry(e); err != nil {
+				logger.WithError(err).Error("Unable to update outbox entry")
+			}
+
+			logger.WithFields(log.Fields{
+				"Error": record.Error, "Retry": e.NextAttempt.Format(time.RFC3339),
+			}).Warn("Unable to send notification; Will retry")
+
+			return
+		}
+
+		logger.WithField("Error", record.Error).Error("Unable to send notification; Giving up")
+
+	} else {
+		logger.WithField("MSG", notification.Message).Info("Sent notification")
+	}
+
+	if err := n.storage.CompleteOutboxEntry(e.Id, record); err != nil {
+		logger.WithError(err).Error("Unable to record notification outcome")
+	}
+}
+
+// sendEntry sends the entry's notification; For a multiTarget, only to targets not yet
+// delivered to, adding those which succeed to e.Delivered
+func sendEntry(e *storage.OutboxEntry, t Tester, notification Notification) error {
+
+	mt, ok := t.(multiTarget)
+	if !ok {
+		return t.TestSend(notification)
+	}
+
+	delivered := make(map[string]bool)
+	for _, target := range e.Delivered {
+		delivered[target] = true
+	}
+
+	var failed []string
+
+	for _, target := range mt.targets() {
+
+		if delivered[target] {
+			continue
+		}
+
+		if err := mt.sendTo(target, notification); err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+
+		e.Delivered = append(e.Delivered, target)
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// outboxBackoff returns the wait before retrying after the given number of failed attempts
+func outboxBackoff(attempts int) time.Duration {
+
+	backoff := OUTBOX_BACKOFF
+	for i := 1; i < attempts && backoff < OUTBOX_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+
+	if backoff > OUTBOX_MAX_BACKOFF {
+		backoff = OUTBOX_MAX_BACKOFF
+	}
+
+	return backoff
+}
+
+// recordSuppressed adds a suppressed notification to the history
+func (n *NotificationHandler) recordSuppressed(message string, category Category) {
+
+	if err := n.storage.AddNotificationRecord(storage.NotificationRecord{
+		Category: category.String(),
+		Level:    n.GetRouting().level(category),
+		Message:  message,
+		Status:   storage.NOTIFICATION_SUPPRESSED,
+	}); err != nil {
+		log.WithError(err).Error("Unable to record suppressed notification")
+	}
+}
+
+// GetHistory returns up to limit delivery records, newest first, optionally only those
+// with status, and the notifications still waiting in the outbox
+func (n *NotificationHandler) GetHistory(limit int, status string) ([]storage.NotificationRecord, []storage.OutboxEntry, error) {
+
+	if limit <= 0 {
+		limit = NOTIFICATION_HISTORY_LIMIT
+	}
+
+	history, err := n.storage.GetNotificationHistory(limit, status)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pending, err := n.storage.GetOutbox()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return history, pending, nil
+}
@@ -0,0 +1,406 @@
+package notifications
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bakinbacon/storage"
+)
+
+// flaky is a notifier which reports failure until it has failed fails times
+type flaky struct {
+	mu       sync.Mutex
+	fails    int
+	attempts int
+	sent     []string
+	disabled bool
+}
+
+func (f *flaky) Send(msg string) {
+	_ = f.TestSend(Notification{Message: msg})
+}
+
+func (f *flaky) IsEnabled() bool {
+	return !f.disabled
+}
+
+func (f *flaky) TestSend(notification Notification) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.attempts++
+
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("503 Service Unavailable")
+	}
+
+	f.sent = append(f.sent, notification.Message)
+
+	return nil
+}
+
+func outboxLen(t *testing.T, n *NotificationHandler) int {
+
+	t.Helper()
+
+	entries, err := n.storage.GetOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(entries)
+}
+
+func TestOutboxRetries(t *testing.T) {
+
+	n, _ := testHandler(t)
+	f := &flaky{fails: 2}
+	n.notifiers[SLACK] = f
+
+	n.SendNotification("Missed endorsement at 1234", ENDORSE_FAIL)
+
+	// Queued, not sent
+	if outboxLen(t, n) != 1 || f.attempts != 0 {
+		t.Fatalf("Outbox %d, attempts %d", outboxLen(t, n), f.attempts)
+	}
+
+	now := time.Now()
+
+	n.deliverOutbox(now)
+
+	entries, _ := n.storage.GetOutbox()
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].LastError != "503 Service Unavailable" {
+		t.Fatalf("After failure, outbox = %+v", entries)
+	}
+
+	if want := now.Add(OUTBOX_BACKOFF); !entries[0].NextAttempt.Equal(want) {
+		t.Errorf("Next attempt %v, want %v", entries[0].NextAttempt, want)
+	}
+
+	// Not due yet
+	n.deliverOutbox(now.Add(OUTBOX_BACKOFF - time.Second))
+	if f.attempts != 1 {
+		t.Fatalf("Retried early; %d attempts", f.attempts)
+	}
+
+	n.deliverOutbox(now.Add(OUTBOX_BACKOFF))
+	n.deliverOutbox(now.Add(OUTBOX_BACKOFF + outboxBackoff(2)))
+
+	if f.attempts != 3 || len(f.sent) != 1 || outboxLen(t, n) != 0 {
+		t.Fatalf("Attempts %d, sent %v, outbox %d", f.attempts, f.sent, outboxLen(t, n))
+	}
+
+	history, pending, err := n.GetHistory(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 0 || len(history) != 1 {
+		t.Fatalf("History %+v, pending %+v", history, pending)
+	}
+
+	h := history[0]
+	if h.Status != storage.NOTIFICATION_SENT || h.Attempts != 3 || h.Notifier != SLACK ||
+		h.Category != "endorse_fail" || h.Level != LEVEL_ERROR || h.Message != "Missed endorsement at 1234" {
+		t.Errorf("Record = %+v", h)
+	}
+}
+
+func TestOutboxGivesUp(t *testing.T) {
+
+	n, _ := testHandler(t)
+	f := &flaky{fails: 100}
+	n.notifiers[DISCORD] = f
+
+	n.SendNotification("Low balance", BALANCE)
+
+	now := time.Now()
+	for i := 0; i <= OUTBOX_RETRIES+2; i++ {
+		n.deliverOutbox(now)
+		now = now.Add(OUTBOX_MAX_BACKOFF)
+	}
+
+	if f.attempts != OUTBOX_RETRIES+1 || outboxLen(t, n) != 0 {
+		t.Fatalf("Attempts %d, outbox %d", f.attempts, outboxLen(t, n))
+	}
+
+	history, _, _ := n.GetHistory(0, storage.NOTIFICATION_FAILED)
+	if len(history) != 1 || history[0].Attempts != OUTBOX_RETRIES+1 || history[0].Error != "503 Service Unavailable" {
+		t.Errorf("History = %+v", history)
+	}
+
+	// Disabled while waiting; Not retried
+	f.disabled = false
+	n.SendNotification("Low balance again", BALANCE)
+	f.disabled = true
+	n.deliverOutbox(now)
+
+	if f.attempts != OUTBOX_RETRIES+1 || outboxLen(t, n) != 0 {
+		t.Errorf("Disabled notifier was attempted")
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+
+	for attempts, want := range map[int]time.Duration{
+		1:  OUTBOX_BACKOFF,
+		2:  2 * OUTBOX_BACKOFF,
+		3:  4 * OUTBOX_BACKOFF,
+		50: OUTBOX_MAX_BACKOFF,
+	} {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+
+	n, _ := testHandler(t)
+	n.notifiers[MATRIX] = &flaky{}
+
+	n.SendNotification("Baked block 100", BAKING_OK)
+
+	// As if restarted before delivery
+	n2, err := NewHandler(n.storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &flaky{}
+	n2.notifiers = map[string]Notifier{MATRIX: f}
+
+	n2.deliverOutbox(time.Now())
+
+	if len(f.sent) != 1 || f.sent[0] != "Baked block 100" {
+		t.Errorf("Sent %v after restart", f.sent)
+	}
+}
+
+func TestHistorySuppressed(t *testing.T) {
+
+	n, _ := testHandler(t, TELEGRAM)
+
+	n.SendNotification("Signer unreachable", SIGNER)
+	n.SendNotification("Signer unreachable", SIGNER)
+
+	history, _, err := n.GetHistory(10, storage.NOTIFICATION_SUPPRESSED)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 || history[0].Category != "signer" || history[0].Notifier != "" {
+		t.Errorf("History = %+v", history)
+	}
+
+	// Recorders don't report failures, so are sent directly, without records
+	if all, _, _ := n.GetHistory(10, ""); len(all) != 1 {
+		t.Errorf("History = %+v", all)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+
+	n, _ := testHandler(t)
+	n.storage.NoSync = true
+
+	for i := 0; i < storage.NOTIFICATION_HISTORY_MAX+5; i++ {
+		if err := n.storage.AddNotificationRecord(storage.NotificationRecord{Status: storage.NOTIFICATION_SENT}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, _, _ := n.GetHistory(storage.NOTIFICATION_HISTORY_MAX+5, "")
+	if len(history) != storage.NOTIFICATION_HISTORY_MAX {
+		t.Fatalf("Kept %d records, want %d", len(history), storage.NOTIFICATION_HISTORY_MAX)
+	}
+
+	// Newest first
+	if history[0].Id != storage.NOTIFICATION_HISTORY_MAX+5 || history[len(history)-1].Id != 6 {
+		t.Errorf("Ids %d..%d", history[0].Id, history[len(history)-1].Id)
+	}
+}
+
+func TestTelegram(t *testing.T) {
+
+	var (
+		mu    sync.Mutex
+		chats []string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != "/bot123:ABC/sendMessage" {
+			t.Errorf("Path = %s", r.URL.Path)
+		}
+
+		mu.Lock()
+		chats = append(chats, r.URL.Query().Get("chat_id")+":"+r.URL.Query().Get("text"))
+		mu.Unlock()
+
+		if r.URL.Query().Get("chat_id") == "2" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"ok": true, "result": {}}`))
+	}))
+	defer srv.Close()
+
+	api := telegramApi
+	telegramApi = srv.URL
+	defer func() { telegramApi = api }()
+
+	nt := &NotifyTelegram{ApiKey: "123:ABC", ChatIds: []int{1}, Enabled: true}
+
+	if err := nt.TestSend(Notification{Message: "Baked & endorsed"}); err != nil {
+		t.Fatalf("TestSend: %v", err)
+	}
+
+	if len(chats) != 1 || chats[0] != "1:Baked & endorsed" {
+		t.Errorf("Requests = %v", chats)
+	}
+
+	// Every chat is tried; The error says which failed, and why
+	nt.ChatIds = []int{2, 1}
+
+	err := nt.TestSend(Notification{Message: "test"})
+	if err == nil || !strings.Contains(err.Error(), "chat 2") || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("Expected error for chat 2, got %v", err)
+	}
+
+	if len(chats) != 3 {
+		t.Errorf("Requests = %v", chats)
+	}
+
+	// Unreachable; Must not panic, nor reveal the API key
+	srv.Close()
+
+	err = nt.TestSend(Notification{Message: "test"})
+	if err == nil || strings.Contains(err.Error(), "123:ABC") {
+		t.Errorf("Expected error without API key, got %v", err)
+	}
+
+	nt.Send("test")
+}
+
+// A retry only goes to the chats or rooms which failed
+func TestOutboxRetriesFailedTargets(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		requests = make(map[string]int)
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		target := r.URL.Query().Get("chat_id")
+		if target == "" {
+			target = strings.Split(r.URL.Path, "/")[5] // /_matrix/client/v3/rooms/<room>/send/...
+		}
+
+		mu.Lock()
+		requests[target]++
+		first := requests[target] == 1
+		mu.Unlock()
+
+		if first && (target == "2" || target == "!b:x") {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(`{"ok": false, "description": "Bad Gateway"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"ok": true, "result": {}}`))
+	}))
+	defer srv.Close()
+
+	api := telegramApi
+	telegramApi = srv.URL
+	defer func() { telegramApi = api }()
+
+	n, _ := testHandler(t)
+	n.notifiers[TELEGRAM] = &NotifyTelegram{ApiKey: "123:ABC", ChatIds: []int{1, 2, 3}, Enabled: true}
+	n.notifiers[MATRIX] = &NotifyMatrix{Homeserver: srv.URL, AccessToken: "token", RoomIds: []string{"!a:x", "!b:x"}, Enabled: true}
+
+	n.SendNotification("Missed endorsement at 1234", ENDORSE_FAIL)
+
+	now := time.Now()
+	n.deliverOutbox(now)
+
+	entries, _ := n.storage.GetOutbox()
+	if len(entries) != 2 {
+		t.Fatalf("Outbox = %+v", entries)
+	}
+
+	for _, e := range entries {
+		if len(e.Delivered) == 0 || !strings.Contains(e.LastError, "Bad Gateway") {
+			t.Errorf("After failure, entry = %+v", e)
+		}
+	}
+
+	n.deliverOutbox(now.Add(OUTBOX_BACKOFF))
+
+	if outboxLen(t, n) != 0 {
+		t.Fatalf("Outbox not empty after retry")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	expected := map[string]int{"1": 1, "2": 2, "3": 1, "!a:x": 1, "!b:x": 2}
+	if len(requests) != len(expected) {
+		t.Fatalf("Requests = %v", requests)
+	}
+
+	for target, count := range expected {
+		if requests[target] != count {
+			t.Errorf("Requests = %v, expected %v", requests, expected)
+			break
+		}
+	}
+}
+
+func TestOutboxRetriesFailedWebhookUrls(t *testing.T) {
+
+	failing, failingRequests := webhookStub(t, http.StatusBadGateway)
+	working, workingRequests := webhookStub(t)
+
+	n, _ := testHandler(t)
+	n.notifiers[WEBHOOK] = testWebhookNotifier(t, &NotifyWebhook{
+		Urls:    []string{working.URL + "/hook/secret-token", failing.URL + "/hook/secret-token"},
+		Retries: 1,
+	})
+
+	n.SendNotification("Missed endorsement at 1234", ENDORSE_FAIL)
+
+	now := time.Now()
+	n.deliverOutbox(now)
+
+	entries, _ := n.storage.GetOutbox()
+	if len(entries) != 1 || len(entries[0].Delivered) != 1 {
+		t.Fatalf("Outbox = %+v", entries)
+	}
+
+	// Shown in the notification history
+	if e := entries[0].LastError; !strings.Contains(e, "502") || strings.Contains(e, "secret-token") {
+		t.Errorf("Error = %q", e)
+	}
+
+	n.deliverOutbox(now.Add(OUTBOX_BACKOFF))
+
+	if outboxLen(t, n) != 0 {
+		t.Fatalf("Outbox not empty after retry")
+	}
+
+	if len(workingRequests()) != 1 || len(failingRequests()) != 2 {
+		t.Errorf("Requests: working %d, failing %d", len(workingRequests()), len(failingRequests()))
+	}
+}
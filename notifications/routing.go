@@ -0,0 +1,377 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Repeats of a message are suppressed for this long, unless configured otherwise
+	DEFAULT_THROTTLE = 10 * time.Minute
+
+	// How often summaries of suppressed messages are checked for
+	SUMMARY_INTERVAL = time.Minute
+)
+
+var levelOrder = map[string]int{
+	LEVEL_INFO:    0,
+	LEVEL_WARNING: 1,
+	LEVEL_ERROR:   2,
+}
+
+// Routing decides which notifiers get each category, and how often.
+// All fields are keyed by category or notifier name; Anything not listed uses the default.
+type Routing struct {
+	// Notifiers each category is sent to; Default all enabled notifiers
+	Routes map[string][]string `json:"routes" yaml:"routes"`
+
+	// Least severe level each notifier is sent; Default info, ie everything
+	MinLevels map[string]string `json:"minlevels" yaml:"min_levels"`
+
+	// Severity of each category; Defaults to Category.Level()
+	Levels map[string]string `json:"levels" yaml:"levels"`
+
+	// Seconds a repeat of the same message in a category is suppressed; 0 sends every repeat
+	Throttle        map[string]int `json:"throttle" yaml:"throttle"`
+	DefaultThrottle *int           `json:"defaultthrottle" yaml:"default_throttle"`
+}
+
+// throttleState tracks one message key in one category
+type throttleState struct {
+	category   Category
+	message    string
+	lastSent   time.Time
+	suppressed int
+	since      time.Time // First suppressed repeat
+}
+
+type throttler struct {
+	sync.Mutex
+	states map[string]*throttleState
+}
+
+func CategoryFromString(name string) (Category, bool) {
+	for c, n := range categoryNames {
+		if n == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// Categories returns the names of all categories, in order
+func Categories() []string {
+
+	categories := make([]Category, 0, len(categoryNames))
+	for c := range categoryNames {
+		categories = append(categories, c)
+	}
+
+	sort.Slice(categories, func(i, j int) bool { return categories[i] < categories[j] })
+
+	names := make([]string, len(categories))
+	for i, c := range categories {
+		names[i] = c.String()
+	}
+
+	return names
+}
+
+// Validate checks that all names are known categories, notifiers and levels
+func (r *Routing) Validate() error {
+
+	isNotifier := make(map[string]bool)
+	for _, name := range RegisteredNotifiers() {
+		isNotifier[name] = true
+	}
+
+	checkCategory := func(name string) error {
+		if _, ok := CategoryFromString(name); !ok {
+			return errors.Errorf("Unknown notification category '%s'; Expected one of %s", name, strings.Join(Categories(), ", "))
+		}
+		return nil
+	}
+
+	checkLevel := func(level string) error {
+		if _, ok := levelOrder[level]; !ok {
+			return errors.Errorf("Unknown level '%s'; Expected %s, %s or %s", level, LEVEL_INFO, LEVEL_WARNING, LEVEL_ERROR)
+		}
+		return nil
+	}
+
+	for category, notifiers := range r.Routes {
+		if err := checkCategory(category); err != nil {
+			return err
+		}
+		for _, notifier := range notifiers {
+			if !isNotifier[notifier] {
+				return errors.Errorf("Unknown notifier '%s' in route for %s", notifier, category)
+			}
+		}
+	}
+
+	for notifier, level := range r.MinLevels {
+		if !isNotifier[notifier] {
+			return errors.Errorf("Unknown notifier '%s' in min levels", notifier)
+		}
+		if err := checkLevel(level); err != nil {
+			return err
+		}
+	}
+
+	for category, level := range r.Levels {
+		if err := checkCategory(category); err != nil {
+			return err
+		}
+		if err := checkLevel(level); err != nil {
+			return err
+		}
+	}
+
+	for category, seconds := range r.Throttle {
+		if err := checkCategory(category); err != nil {
+			return err
+		}
+		if seconds < 0 {
+			return errors.Errorf("Throttle for %s cannot be negative", category)
+		}
+	}
+
+	if r.DefaultThrottle != nil && *r.DefaultThrottle < 0 {
+		return errors.New("Default throttle cannot be negative")
+	}
+
+	return nil
+}
+
+func (r *Routing) level(c Category) string {
+	if level, ok := r.Levels[c.String()]; ok {
+		return level
+	}
+	return c.Level()
+}
+
+func (r *Routing) throttle(c Category) time.Duration {
+
+	if seconds, ok := r.Throttle[c.String()]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if r.DefaultThrottle != nil {
+		return time.Duration(*r.DefaultThrottle) * time.Second
+	}
+
+	return DEFAULT_THROTTLE
+}
+
+// wants returns whether notifier should get a notification of category at level
+func (r *Routing) wants(notifier string, c Category, level string) bool {
+
+	if min, ok := r.MinLevels[notifier]; ok && levelOrder[level] < levelOrder[min] {
+		return false
+	}
+
+	notifiers, ok := r.Routes[c.String()]
+	if !ok {
+		return true
+	}
+
+	for _, n := range notifiers {
+		if n == notifier {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetRouting replaces the routing rules with config, saving them to the DB if saveConfig
+func (n *NotificationHandler) SetRouting(config []byte, saveConfig bool) error {
+
+	routing := &Routing{}
+
+	// empty config from db?
+	if config != nil {
+		if err := json.Unmarshal(config, routing); err != nil {
+			return errors.Wrap(err, "Unable to unmarshal notification routing")
+		}
+	}
+
+	if err := routing.Validate(); err != nil {
+		return err
+	}
+
+	if saveConfig {
+		if err := n.storage.SaveNotificationRouting(config); err != nil {
+			return errors.Wrap(err, "Unable to save notification routing")
+		}
+	}
+
+	n.routingMu.Lock()
+	n.routing = routing
+	n.routingMu.Unlock()
+
+	return nil
+}
+
+func (n *NotificationHandler) GetRouting() *Routing {
+	n.routingMu.RLock()
+	defer n.routingMu.RUnlock()
+	return n.routing
+}
+
+// throttle returns whether message should be sent now, and if so, the message to send,
+// which mentions any repeats suppressed since it was last sent
+func (n *NotificationHandler) throttle(message string, category Category, window time.Duration, now time.Time) (string, bool) {
+
+	if window == 0 {
+		return message, true
+	}
+
+	n.throttler.Lock()
+	defer n.throttler.Unlock()
+
+	// Repeats are recognized by their text; Different messages in a category are not suppressed
+	stateKey := category.String() + "\x00" + message
+
+	state, ok := n.throttler.states[stateKey]
+	if !ok {
+		state = &throttleState{category: category}
+		n.throttler.states[stateKey] = state
+	}
+
+	if now.Sub(state.lastSent) < window {
+		if state.suppressed == 0 {
+			state.since = now
+		}
+		state.suppressed++
+		state.message = message
+		return "", false
+	}
+
+	state.message = message
+
+	if state.suppressed > 0 {
+		message = fmt.Sprintf("%s (%s)", message, state.summary())
+	}
+
+	state.lastSent = now
+	state.suppressed = 0
+
+	return message, true
+}
+
+func (s *throttleState) summary() string {
+
+	repeats := "repeat"
+	if s.suppressed > 1 {
+		repeats = "repeats"
+	}
+
+	return fmt.Sprintf("%d %s suppressed since %s", s.suppressed, repeats, s.since.UTC().Format("15:04 MST"))
+}
+
+// SuppressedSummary is a message whose repeats are being suppressed
+type SuppressedSummary struct {
+	Category   string    `json:"category"`
+	Message    string    `json:"message"`
+	Suppressed int       `json:"suppressed"`
+	Since      time.Time `json:"since"`
+	LastSent   time.Time `json:"lastSent"`
+}
+
+// GetSuppressed returns the messages with suppressed repeats not yet summarized
+func (n *NotificationHandler) GetSuppressed() []SuppressedSummary {
+
+	n.throttler.Lock()
+	defer n.throttler.Unlock()
+
+	summaries := make([]SuppressedSummary, 0)
+
+	for _, s := range n.throttler.states {
+		if s.suppressed > 0 {
+			summaries = append(summaries, SuppressedSummary{
+				Category:   s.category.String(),
+				Message:    s.message,
+				Suppressed: s.suppressed,
+				Since:      s.since,
+				LastSent:   s.lastSent,
+			})
+		}
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Since.Before(summaries[j].Since) })
+
+	return summaries
+}
+
+// RunSummaries sends a summary for each message whose repeats were suppressed, once its
+// throttle window has passed without another repeat. Otherwise the last repeats of a
+// burst would never be mentioned.
+func (n *NotificationHandler) RunSummaries(shutdownChannel <-chan interface{}, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	ticker := time.NewTicker(SUMMARY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.sendSummaries(time.Now())
+		case <-shutdownChannel:
+			return
+		}
+	}
+}
+
+func (n *NotificationHandler) sendSummaries(now time.Time) {
+
+	routing := n.GetRouting()
+
+	type summary struct {
+		message  string
+		category Category
+	}
+
+	var summaries []summary
+
+	n.throttler.Lock()
+
+	for key, s := range n.throttler.states {
+
+		window := routing.throttle(s.category)
+		if now.Sub(s.lastSent) < window {
+			continue
+		}
+
+		if s.suppressed == 0 {
+			// Forget quiet messages
+			delete(n.throttler.states, key)
+			continue
+		}
+
+		summaries = append(summaries, summary{
+			message:  fmt.Sprintf("%s (%s)", s.message, s.summary()),
+			category: s.category,
+		})
+
+		s.lastSent = now
+		s.suppressed = 0
+	}
+
+	n.throttler.Unlock()
+
+	for _, s := range summaries {
+		log.WithField("Category", s.category.String()).Info("Sending summary of suppressed notifications")
+		n.dispatch(s.message, s.category)
+	}
+}
@@ -0,0 +1,233 @@
+package notifications
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bakinbacon/storage"
+)
+
+// recorder is a notifier that keeps what it is sent
+type recorder struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (r *recorder) Send(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+}
+
+func (r *recorder) IsEnabled() bool {
+	return true
+}
+
+func (r *recorder) sent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.messages...)
+}
+
+// testHandler returns a handler whose notifiers are replaced by recorders with these names
+func testHandler(t *testing.T, names ...string) (*NotificationHandler, map[string]*recorder) {
+
+	t.Helper()
+
+	db, err := storage.InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	n, err := NewHandler(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorders := make(map[string]*recorder)
+	n.notifiers = make(map[string]Notifier)
+
+	for _, name := range names {
+		recorders[name] = &recorder{}
+		n.notifiers[name] = recorders[name]
+	}
+
+	return n, recorders
+}
+
+func TestThrottleByMessage(t *testing.T) {
+
+	n, rec := testHandler(t, TELEGRAM)
+
+	// Different messages in one category are all sent
+	n.SendNotification("Unable to sign block 100", BAKING_FAIL)
+	n.SendNotification("Unable to sign block 101", BAKING_FAIL)
+
+	// Repeats are suppressed
+	n.SendNotification("Unable to sign block 100", BAKING_FAIL)
+	n.SendNotification("Unable to sign block 100", BAKING_FAIL)
+
+	// The same text in another category is a different message
+	n.SendNotification("Unable to sign block 100", SIGNER)
+
+	sent := rec[TELEGRAM].sent()
+	if len(sent) != 3 {
+		t.Fatalf("Sent %v, want 3 messages", sent)
+	}
+
+	suppressed := n.GetSuppressed()
+	if len(suppressed) != 1 || suppressed[0].Suppressed != 2 || suppressed[0].Category != "baking_fail" {
+		t.Errorf("Suppressed = %+v", suppressed)
+	}
+}
+
+func TestThrottleWindow(t *testing.T) {
+
+	n, _ := testHandler(t)
+
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	window := 10 * time.Minute
+
+	if _, ok := n.throttle("Low balance", BALANCE, window, start); !ok {
+		t.Fatal("First message was suppressed")
+	}
+
+	for i := 1; i <= 3; i++ {
+		if _, ok := n.throttle("Low balance", BALANCE, window, start.Add(time.Duration(i)*time.Minute)); ok {
+			t.Fatalf("Repeat %d was not suppressed", i)
+		}
+	}
+
+	// After the window, the next repeat is sent, with a count of those suppressed
+	msg, ok := n.throttle("Low balance", BALANCE, window, start.Add(window))
+	if !ok {
+		t.Fatal("Message after window was suppressed")
+	}
+
+	if want := "Low balance (3 repeats suppressed since 12:01 UTC)"; msg != want {
+		t.Errorf("Message = %q, want %q", msg, want)
+	}
+
+	// A window of 0 never suppresses
+	for i := 0; i < 3; i++ {
+		if _, ok := n.throttle("Baked", BAKING_OK, 0, start); !ok {
+			t.Fatal("Message suppressed with no throttle")
+		}
+	}
+}
+
+func TestSuppressedSummaries(t *testing.T) {
+
+	n, rec := testHandler(t, EMAIL)
+
+	n.SendNotification("Endorsement missing", ENDORSE_FAIL)
+	n.SendNotification("Endorsement missing", ENDORSE_FAIL)
+	n.SendNotification("Nonce revealed", NONCE)
+
+	// Nothing to summarize until the window has passed
+	n.sendSummaries(time.Now())
+	if sent := rec[EMAIL].sent(); len(sent) != 2 {
+		t.Fatalf("Sent %v before window passed", sent)
+	}
+
+	n.sendSummaries(time.Now().Add(DEFAULT_THROTTLE))
+
+	sent := rec[EMAIL].sent()
+	if len(sent) != 3 || !strings.HasPrefix(sent[2], "Endorsement missing (1 repeat suppressed since ") {
+		t.Fatalf("Sent %v, want a summary", sent)
+	}
+
+	if len(n.GetSuppressed()) != 0 {
+		t.Error("Summary did not reset the count")
+	}
+
+	// Quiet messages are forgotten
+	n.sendSummaries(time.Now().Add(2 * DEFAULT_THROTTLE))
+
+	n.throttler.Lock()
+	states := len(n.throttler.states)
+	n.throttler.Unlock()
+
+	if states != 0 {
+		t.Errorf("%d throttle states left", states)
+	}
+}
+
+func TestRouting(t *testing.T) {
+
+	n, rec := testHandler(t, TELEGRAM, EMAIL, WEBHOOK)
+
+	noThrottle := 0
+
+	if err := n.SetRouting([]byte(`{
+		"routes": {"baking_fail": ["email", "webhook"], "baking_ok": ["telegram"]},
+		"minlevels": {"webhook": "warning"},
+		"levels": {"payouts": "warning"},
+		"defaultthrottle": 0
+	}`), true); err != nil {
+		t.Fatalf("SetRouting: %v", err)
+	}
+
+	n.SendNotification("Baked block", BAKING_OK)      // info; telegram only
+	n.SendNotification("Failed to bake", BAKING_FAIL) // error; email and webhook
+	n.SendNotification("Started", STARTUP)            // info; everyone but webhook
+	n.SendNotification("Payouts sent", PAYOUTS)       // raised to warning; everyone
+	n.SendNotification("Payouts sent", PAYOUTS)       // not throttled
+
+	want := map[string][]string{
+		TELEGRAM: {"Baked block", "Started", "Payouts sent", "Payouts sent"},
+		EMAIL:    {"Failed to bake", "Started", "Payouts sent", "Payouts sent"},
+		WEBHOOK:  {"Failed to bake", "Payouts sent", "Payouts sent"},
+	}
+
+	for name, msgs := range want {
+		if got := strings.Join(rec[name].sent(), "|"); got != strings.Join(msgs, "|") {
+			t.Errorf("%s got %q, want %q", name, got, strings.Join(msgs, "|"))
+		}
+	}
+
+	if r := n.GetRouting(); r.DefaultThrottle == nil || *r.DefaultThrottle != noThrottle {
+		t.Error("Default throttle not set")
+	}
+
+	// Saved, and loaded by a new handler
+	n2, err := NewHandler(n.storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := n2.GetRouting().Routes["baking_ok"]; len(got) != 1 || got[0] != TELEGRAM {
+		t.Errorf("Routes not reloaded: %v", n2.GetRouting().Routes)
+	}
+}
+
+func TestRoutingValidate(t *testing.T) {
+
+	n, _ := testHandler(t)
+
+	for name, config := range map[string]string{
+		"unknown category": `{"routes": {"baking_maybe": ["email"]}}`,
+		"unknown notifier": `{"routes": {"baking_ok": ["fax"]}}`,
+		"unknown level":    `{"minlevels": {"email": "panic"}}`,
+		"level category":   `{"levels": {"nope": "error"}}`,
+		"negative":         `{"throttle": {"balance": -1}}`,
+		"negative default": `{"defaultthrottle": -5}`,
+		"not json":         `{"routes": [`,
+	} {
+		if err := n.SetRouting([]byte(config), true); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Rejected rules are neither used nor saved
+	if len(n.GetRouting().Routes) != 0 {
+		t.Error("Invalid routing was applied")
+	}
+
+	if saved, _ := n.storage.GetNotificationRouting(); saved != nil {
+		t.Errorf("Invalid routing was saved: %s", saved)
+	}
+}
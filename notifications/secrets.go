@@ -0,0 +1,90 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// SECRET_MASK replaces credentials in the config shown to the web UI. Saving a config
+// with the mask in place of a credential keeps the stored credential.
+const SECRET_MASK = "********"
+
+// Fields holding credentials, by notifier; Each value of a map field, such as webhook
+// headers, is masked separately
+var secretFields = map[string][]string{
+	TELEGRAM: {"apikey"},
+	EMAIL:    {"password"},
+	WEBHOOK:  {"secret", "headers"},
+	SLACK:    {"webhookurl"},
+	DISCORD:  {"webhookurl"},
+	MATRIX:   {"accesstoken"},
+}
+
+// maskSecrets returns config with each set credential replaced by SECRET_MASK
+func maskSecrets(notifier string, config []byte) ([]byte, error) {
+
+	fields, err := decodeFields(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decode config")
+	}
+
+	for _, f := range secretFields[notifier] {
+		switch v := fields[f].(type) {
+		case string:
+			if v != "" {
+				fields[f] = SECRET_MASK
+			}
+		case map[string]interface{}:
+			for k := range v {
+				v[k] = SECRET_MASK
+			}
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// unmaskSecrets returns config with each SECRET_MASK replaced by the credential in current
+func unmaskSecrets(notifier string, config, current []byte) ([]byte, error) {
+
+	fields, err := decodeFields(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decode config")
+	}
+
+	stored, err := decodeFields(current)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decode current config")
+	}
+
+	for _, f := range secretFields[notifier] {
+		switch v := fields[f].(type) {
+		case string:
+			if v == SECRET_MASK {
+				fields[f] = stored[f]
+			}
+		case map[string]interface{}:
+			storedMap, _ := stored[f].(map[string]interface{})
+			for k, sv := range v {
+				if sv == SECRET_MASK {
+					v[k] = storedMap[k]
+				}
+			}
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// decodeFields keeps numbers as written, so large chat ids survive
+func decodeFields(config []byte) (map[string]interface{}, error) {
+
+	fields := make(map[string]interface{})
+
+	d := json.NewDecoder(bytes.NewReader(config))
+	d.UseNumber()
+
+	return fields, d.Decode(&fields)
+}
@@ -0,0 +1,94 @@
+package notifications
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"bakinbacon/storage"
+)
+
+func TestConfigSecretsMasked(t *testing.T) {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	n, err := NewHandler(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, _ := chatStubTLS(t, 200)
+
+	configs := map[string]string{
+		SLACK:    `{"webhookurl": "` + srv.URL + `/hooks/T000/B000/XXXX", "enabled": true}`,
+		TELEGRAM: `{"chatids": [-1001234567890123], "apikey": "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", "enabled": false}`,
+		WEBHOOK:  `{"urls": ["https://example.com/hook"], "headers": {"Authorization": "Bearer abc"}, "secret": "hmackey", "enabled": false}`,
+		MATRIX:   `{"homeserver": "https://matrix.example.com", "accesstoken": "syt_token", "roomids": [], "enabled": false}`,
+	}
+
+	for name, config := range configs {
+		if err := n.Configure(name, []byte(config), true); err != nil {
+			t.Fatalf("Configure %s: %v", name, err)
+		}
+	}
+
+	config, err := n.GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"/hooks/T000", "AAAAAAAA", "Bearer abc", "hmackey", "syt_token"} {
+		if strings.Contains(string(config), secret) {
+			t.Errorf("Config shows %q: %s", secret, config)
+		}
+	}
+
+	if !strings.Contains(string(config), "-1001234567890123") {
+		t.Errorf("Chat id changed: %s", config)
+	}
+
+	// Saving the masked config keeps each stored credential
+	masked := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(config, &masked); err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range configs {
+		if err := n.Configure(name, masked[name], true); err != nil {
+			t.Fatalf("Configure masked %s: %v", name, err)
+		}
+	}
+
+	if err := n.LoadNotifiers(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ns := n.notifiers[SLACK].(*NotifySlack); ns.WebhookUrl != srv.URL+"/hooks/T000/B000/XXXX" {
+		t.Errorf("Slack webhook = %q", ns.WebhookUrl)
+	}
+
+	if nt := n.notifiers[TELEGRAM].(*NotifyTelegram); nt.ApiKey != "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA" || nt.ChatIds[0] != -1001234567890123 {
+		t.Errorf("Telegram = %+v", nt)
+	}
+
+	if nw := n.notifiers[WEBHOOK].(*NotifyWebhook); nw.Secret != "hmackey" || nw.Headers["Authorization"] != "Bearer abc" {
+		t.Errorf("Webhook = %+v", nw)
+	}
+
+	if nm := n.notifiers[MATRIX].(*NotifyMatrix); nm.AccessToken != "syt_token" {
+		t.Errorf("Matrix = %+v", nm)
+	}
+
+	// A new credential replaces the stored one
+	if err := n.Configure(WEBHOOK, []byte(`{"urls": ["https://example.com/hook"], "headers": {"Authorization": "`+SECRET_MASK+`"}, "secret": "newkey"}`), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if nw := n.notifiers[WEBHOOK].(*NotifyWebhook); nw.Secret != "newkey" || nw.Headers["Authorization"] != "Bearer abc" {
+		t.Errorf("Webhook = %+v", nw)
+	}
+}
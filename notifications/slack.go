@@ -0,0 +1,94 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/metrics"
+	"bakinbacon/storage"
+)
+
+// NotifySlack posts to a Slack incoming webhook, https://api.slack.com/messaging/webhooks
+type NotifySlack struct {
+	WebhookUrl string `json:"webhookurl"`
+	Enabled    bool   `json:"enabled"`
+
+	storage *storage.Storage
+}
+
+func init() {
+	RegisterNotifier(SLACK, func(n *NotificationHandler, config []byte, saveConfig bool) (Notifier, error) {
+		return n.NewSlack(config, saveConfig)
+	})
+}
+
+// NewSlack creates a new NotifySlack object using a JSON byte-stream
+// provided from either DB lookup or web UI. See NewTelegram.
+func (n *NotificationHandler) NewSlack(config []byte, saveConfig bool) (*NotifySlack, error) {
+
+	ns := &NotifySlack{}
+
+	// empty config from db?
+	if config != nil {
+		if err := json.Unmarshal(config, ns); err != nil {
+			return ns, errors.Wrap(err, "Unable to unmarshal slack config")
+		}
+	}
+
+	// save local reference to database
+	ns.storage = n.storage
+
+	if saveConfig {
+		if ns.Enabled && !validHttpsUrl(ns.WebhookUrl) {
+			return ns, errors.New("Slack webhook URL must be an https URL")
+		}
+
+		if err := ns.SaveConfig(); err != nil {
+			return ns, err
+		}
+	}
+
+	return ns, nil
+}
+
+func (n *NotifySlack) IsEnabled() bool {
+	return n.Enabled
+}
+
+func (n *NotifySlack) Send(msg string) {
+
+	if err := n.post(msg); err != nil {
+		log.WithError(err).Error("Unable to send Slack message")
+		metrics.NotificationFailures.WithLabelValues(SLACK).Inc()
+		return
+	}
+
+	log.WithField("MSG", msg).Info("Sent Slack Message")
+}
+
+func (n *NotifySlack) TestSend(notification Notification) error {
+	return n.post(notification.Message)
+}
+
+func (n *NotifySlack) post(msg string) error {
+	return sendJSON(http.MethodPost, n.WebhookUrl, map[string]string{"text": msg}, nil)
+}
+
+func (n *NotifySlack) SaveConfig() error {
+
+	// Marshal ourselves to []byte and send to storage manager
+	config, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal slack config")
+	}
+
+	if err := n.storage.SaveNotifiersConfig(SLACK, config); err != nil {
+		return errors.Wrap(err, "Unable to save slack config")
+	}
+
+	return nil
+}
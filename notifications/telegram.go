@@ -1,28 +1,57 @@
 package notifications
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
 
+	"bakinbacon/metrics"
 	"bakinbacon/storage"
 )
 
+const (
+	// Enough for a batch of updates
+	TELEGRAM_MAX_RESPONSE = 1 << 20
+)
+
+var (
+	telegramApi = "https://api.telegram.org"
+
+	// HTTP client 10s timeout
+	telegramClient = &http.Client{
+		Timeout: time.Second * 10,
+	}
+)
+
 type NotifyTelegram struct {
 	ChatIds []int  `json:"chatids"`
 	ApiKey  string `json:"apikey"`
 	Enabled bool   `json:"enabled"`
 
+	// Answer commands from ChatIds; See RunTelegramBot
+	Commands       bool `json:"commands"`
+	PayoutCommands bool `json:"payoutcommands"`
+
 	storage *storage.Storage
 }
 
+func init() {
+	RegisterNotifier(TELEGRAM, func(n *NotificationHandler, config []byte, saveConfig bool) (Notifier, error) {
+		return n.NewTelegram(config, saveConfig)
+	})
+}
+
 // NewTelegram creates a new NotifyTelegram object using a JSON byte-stream
 // provided from either DB lookup or web UI. The stream is unmarshaled into
 // a new object which is returned.
@@ -59,56 +88,125 @@ func (n *NotifyTelegram) IsEnabled() bool {
 
 func (n *NotifyTelegram) Send(msg string) {
 
+	if err := n.sendMessage(msg); err != nil {
+		log.WithError(err).Error("Unable to send Telegram message")
+		metrics.NotificationFailures.WithLabelValues(TELEGRAM).Inc()
+		return
+	}
+
+	log.WithField("MSG", msg).Info("Sent Telegram Message")
+}
+
+func (n *NotifyTelegram) TestSend(notification Notification) error {
+	return n.sendMessage(notification.Message)
+}
+
+// sendMessage sends msg to each chat, returning an error if any fail
+func (n *NotifyTelegram) sendMessage(msg string) error {
+
 	// curl -G \
 	//  --data-urlencode "chat_id=111112233" \
 	//  --data-urlencode "text=$message" \
 	//  https://api.telegram.org/bot${TOKEN}/sendMessage
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", n.ApiKey), nil)
+	var failed []string
+
+	// Loop over chatIds, sending message
+	for _, chatId := range n.ChatIds {
+
+		if err := n.sendToChat(chatId, msg); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func (n *NotifyTelegram) targets() []string {
+
+	chats := make([]string, 0, len(n.ChatIds))
+	for _, chatId := range n.ChatIds {
+		chats = append(chats, strconv.Itoa(chatId))
+	}
+
+	return chats
+}
+
+func (n *NotifyTelegram) sendTo(chat string, notification Notification) error {
+
+	chatId, err := strconv.Atoi(chat)
 	if err != nil {
-		log.WithError(err).Error("Unable to make Telegram request")
-		return
+		return errors.Errorf("Invalid chat %q", chat)
 	}
 
-	req.Header.Add("Content-type", "application/x-www-form-urlencoded")
+	return n.sendToChat(chatId, notification.Message)
+}
+
+func (n *NotifyTelegram) sendToChat(chatId int, msg string) error {
 
-	// Query parameters
-	q := req.URL.Query()
-	q.Add("text", msg)
+	q := url.Values{}
+	q.Set("chat_id", strconv.Itoa(chatId))
+	q.Set("text", msg)
 
-	// HTTP client 10s timeout
-	client := &http.Client{
-		Timeout: time.Second * 10,
+	if err := n.call(context.Background(), telegramClient, "sendMessage", q, nil); err != nil {
+		log.WithField("ChatId", chatId).WithError(err).Error("Unable to send Telegram message")
+		return errors.Errorf("chat %d: %s", chatId, err)
 	}
 
-	// Loop over chatIds, sending message
-	for _, chatId := range n.ChatIds {
+	return nil
+}
 
-		q.Set("chat_id", strconv.Itoa(chatId))
+// call makes a Bot API request, decoding the result into result, if not nil
+func (n *NotifyTelegram) call(ctx context.Context, client *http.Client, method string, q url.Values, result interface{}) error {
 
-		// Encode URL parameters
-		req.URL.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/bot%s/%s?%s", telegramApi, n.ApiKey, method, q.Encode()), nil)
+	if err != nil {
+		return errors.New("Unable to create Telegram request")
+	}
 
-		// Execute
-		resp, err := client.Do(req)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"ChatId": chatId,
-			}).WithError(err).Error("Unable to send Telegram message")
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL contains the API key; Don't log it
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
 		}
+		return errors.Wrap(err, "Unable to reach Telegram")
+	}
+	defer resp.Body.Close()
 
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"ChatId": chatId,
-			}).WithError(err).Error("Unable to read Telegram API response")
-		}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, TELEGRAM_MAX_RESPONSE))
+	if err != nil {
+		return errors.Wrap(err, "Unable to read Telegram API response")
+	}
 
-		log.WithField("Resp", string(body)).Debug("Telegram Reply")
+	log.WithFields(log.Fields{"Method": method, "Resp": string(body)}).Debug("Telegram Reply")
+
+	// https://core.telegram.org/bots/api#making-requests
+	var reply struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
 	}
 
-	log.WithField("MSG", msg).Info("Sent Telegram Message")
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return errors.Errorf("Unexpected Telegram API response: %s", resp.Status)
+	}
+
+	if !reply.Ok {
+		return errors.Errorf("%s: %s", resp.Status, reply.Description)
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(reply.Result, result); err != nil {
+			return errors.Wrap(err, "Unable to decode Telegram API result")
+		}
+	}
+
+	return nil
 }
 
 func (n *NotifyTelegram) SaveConfig() error {
@@ -0,0 +1,315 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Seconds Telegram holds a getUpdates request open, waiting for a message
+	TELEGRAM_POLL_TIMEOUT = 25
+
+	// How often to check if commands were enabled, and to wait after an error
+	TELEGRAM_IDLE_INTERVAL = 30 * time.Second
+
+	// Time to /confirm a /sendpayouts
+	TELEGRAM_CONFIRM_TIMEOUT = 2 * time.Minute
+)
+
+// Longer than TELEGRAM_POLL_TIMEOUT
+var telegramPollClient = &http.Client{
+	Timeout: (TELEGRAM_POLL_TIMEOUT + 10) * time.Second,
+}
+
+// BotCommands answers the Telegram bot's commands
+type BotCommands interface {
+	Status() (string, error)
+	Rights() (string, error)
+	Balance() (string, error)
+	Payouts() (string, error)
+
+	// CheckPayouts describes the payouts for cycle, or returns why they cannot be sent
+	CheckPayouts(cycle int) (string, error)
+	SendPayouts(cycle int) error
+}
+
+// https://core.telegram.org/bots/api#update
+type telegramUpdate struct {
+	UpdateId int              `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	Date int64  `json:"date"`
+	Text string `json:"text"`
+	Chat struct {
+		Id int `json:"id"`
+	} `json:"chat"`
+}
+
+type pendingPayout struct {
+	cycle   int
+	expires time.Time
+}
+
+type telegramBot struct {
+	commands BotCommands
+	offset   int
+	started  time.Time
+
+	// Payouts waiting for /confirm, by chat
+	pending map[int]pendingPayout
+}
+
+func newTelegramBot(commands BotCommands) *telegramBot {
+	return &telegramBot{
+		commands: commands,
+		started:  time.Now(),
+		pending:  make(map[int]pendingPayout),
+	}
+}
+
+// RunTelegramBot answers commands sent to the Telegram bot, while the Telegram notifier
+// is enabled with Commands. Only the notifier's ChatIds are answered.
+func (n *NotificationHandler) RunTelegramBot(commands BotCommands, shutdownChannel <-chan interface{}, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	// Cancels a waiting getUpdates
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-shutdownChannel:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	bot := newTelegramBot(commands)
+
+	for {
+
+		// Config may be changed from the UI at any time
+		nt := n.telegram()
+
+		if nt != nil && nt.Enabled && nt.Commands && nt.ApiKey != "" {
+
+			err := bot.poll(ctx, nt)
+			if err == nil {
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.WithError(err).Warn("Unable to get Telegram commands")
+		}
+
+		select {
+		case <-time.After(TELEGRAM_IDLE_INTERVAL):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *NotificationHandler) telegram() *NotifyTelegram {
+
+	n.notifiersMu.RLock()
+	defer n.notifiersMu.RUnlock()
+
+	nt, _ := n.notifiers[TELEGRAM].(*NotifyTelegram)
+
+	return nt
+}
+
+// poll waits for, and answers, the next batch of messages
+func (b *telegramBot) poll(ctx context.Context, nt *NotifyTelegram) error {
+
+	q := url.Values{}
+	q.Set("offset", strconv.Itoa(b.offset))
+	q.Set("timeout", strconv.Itoa(TELEGRAM_POLL_TIMEOUT))
+	q.Set("allowed_updates", `["message"]`)
+
+	var updates []telegramUpdate
+	if err := nt.call(ctx, telegramPollClient, "getUpdates", q, &updates); err != nil {
+		return err
+	}
+
+	for _, u := range updates {
+
+		// Acknowledges this update on the next poll
+		b.offset = u.UpdateId + 1
+
+		if u.Message != nil {
+			b.handleMessage(nt, u.Message, time.Now())
+		}
+	}
+
+	return nil
+}
+
+func (b *telegramBot) handleMessage(nt *NotifyTelegram, msg *telegramMessage, now time.Time) {
+
+	chatId := msg.Chat.Id
+
+	allowed := false
+	for _, c := range nt.ChatIds {
+		allowed = allowed || c == chatId
+	}
+
+	if !allowed {
+		log.WithField("ChatId", chatId).Warn("Ignoring Telegram message from unknown chat")
+		return
+	}
+
+	// Telegram keeps messages for a day; Don't act on any sent before a restart
+	if time.Unix(msg.Date, 0).Before(b.started.Truncate(time.Second)) {
+		log.WithFields(log.Fields{"ChatId": chatId, "Text": msg.Text}).Info("Ignoring Telegram command sent before startup")
+		return
+	}
+
+	reply := b.command(nt, chatId, msg.Text, now)
+	if reply == "" {
+		return
+	}
+
+	if err := nt.sendToChat(chatId, reply); err != nil {
+		log.WithField("ChatId", chatId).WithError(err).Error("Unable to reply to Telegram command")
+	}
+}
+
+// command returns the reply to text, or "" if it is not a command
+func (b *telegramBot) command(nt *NotifyTelegram, chatId int, text string, now time.Time) string {
+
+	args := strings.Fields(text)
+	if len(args) == 0 || !strings.HasPrefix(args[0], "/") {
+		return ""
+	}
+
+	// In groups, commands may be addressed, eg /status@BaconBot
+	name := strings.ToLower(strings.SplitN(args[0], "@", 2)[0])
+	args = args[1:]
+
+	log.WithFields(log.Fields{"ChatId": chatId, "Command": name}).Info("Telegram command")
+
+	answer := func(what string, f func() (string, error)) string {
+		reply, err := f()
+		if err != nil {
+			return fmt.Sprintf("Unable to get %s: %s", what, err)
+		}
+		return reply
+	}
+
+	switch name {
+	case "/start", "/help":
+		return b.help(nt)
+	case "/status":
+		return answer("status", b.commands.Status)
+	case "/rights":
+		return answer("rights", b.commands.Rights)
+	case "/balance":
+		return answer("balance", b.commands.Balance)
+	case "/payouts":
+		return answer("payouts", b.commands.Payouts)
+	case "/sendpayouts":
+		return b.sendPayouts(nt, chatId, args, now)
+	case "/confirm":
+		return b.confirm(nt, chatId, args, now)
+	case "/cancel":
+		if _, ok := b.pending[chatId]; !ok {
+			return "Nothing to cancel"
+		}
+		delete(b.pending, chatId)
+		return "Cancelled"
+	}
+
+	return "Unknown command; Try /help"
+}
+
+func (b *telegramBot) help(nt *NotifyTelegram) string {
+
+	help := []string{
+		"/status - Baker and node status",
+		"/rights - Upcoming baking and endorsing rights",
+		"/balance - Spendable balance and bonds",
+		"/payouts - Recent payouts",
+	}
+
+	if nt.PayoutCommands {
+		help = append(help, "/sendpayouts <cycle> - Send the payouts for a cycle, after /confirm")
+	}
+
+	return strings.Join(help, "\n")
+}
+
+// sendPayouts asks for confirmation before sending payouts for a cycle
+func (b *telegramBot) sendPayouts(nt *NotifyTelegram, chatId int, args []string, now time.Time) string {
+
+	if !nt.PayoutCommands {
+		return "Sending payouts from Telegram is disabled"
+	}
+
+	if len(args) != 1 {
+		return "Usage: /sendpayouts <cycle>"
+	}
+
+	cycle, err := strconv.Atoi(args[0])
+	if err != nil || cycle < 0 {
+		return "Usage: /sendpayouts <cycle>"
+	}
+
+	summary, err := b.commands.CheckPayouts(cycle)
+	if err != nil {
+		return fmt.Sprintf("Cannot send payouts for cycle %d: %s", cycle, err)
+	}
+
+	// Replaces any earlier request from this chat
+	b.pending[chatId] = pendingPayout{
+		cycle:   cycle,
+		expires: now.Add(TELEGRAM_CONFIRM_TIMEOUT),
+	}
+
+	return fmt.Sprintf("%s\n\nReply /confirm %d within %d minutes to send, or /cancel", summary, cycle, int(TELEGRAM_CONFIRM_TIMEOUT.Minutes()))
+}
+
+func (b *telegramBot) confirm(nt *NotifyTelegram, chatId int, args []string, now time.Time) string {
+
+	p, ok := b.pending[chatId]
+
+	// Each request can be confirmed once
+	delete(b.pending, chatId)
+
+	if !ok || now.After(p.expires) {
+		return "Nothing to confirm; Use /sendpayouts <cycle>"
+	}
+
+	// Repeating the cycle guards against confirming something else
+	if len(args) != 1 || args[0] != strconv.Itoa(p.cycle) {
+		return fmt.Sprintf("Not confirmed; Reply /confirm %d to a new /sendpayouts %d", p.cycle, p.cycle)
+	}
+
+	// Disabled while waiting
+	if !nt.PayoutCommands {
+		return "Sending payouts from Telegram is disabled"
+	}
+
+	if err := b.commands.SendPayouts(p.cycle); err != nil {
+		return fmt.Sprintf("Unable to send payouts for cycle %d: %s", p.cycle, err)
+	}
+
+	log.WithFields(log.Fields{"ChatId": chatId, "Cycle": p.cycle}).Info("Sending payouts, confirmed from Telegram")
+
+	return fmt.Sprintf("Sending payouts for cycle %d; Check /payouts for progress", p.cycle)
+}
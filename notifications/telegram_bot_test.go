@@ -0,0 +1,266 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// botStub is a Bot API which answers getUpdates with updates, once, and records sendMessage
+type botStub struct {
+	mu      sync.Mutex
+	updates []telegramUpdate
+	offsets []string
+	replies map[string][]string // By chat
+}
+
+func newBotStub(t *testing.T, updates ...telegramUpdate) *botStub {
+
+	stub := &botStub{updates: updates, replies: make(map[string][]string)}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+
+		q := r.URL.Query()
+
+		switch r.URL.Path {
+		case "/bot123:ABC/getUpdates":
+			stub.offsets = append(stub.offsets, q.Get("offset"))
+			result, _ := json.Marshal(stub.updates)
+			stub.updates = nil
+			fmt.Fprintf(w, `{"ok": true, "result": %s}`, result)
+		case "/bot123:ABC/sendMessage":
+			stub.replies[q.Get("chat_id")] = append(stub.replies[q.Get("chat_id")], q.Get("text"))
+			fmt.Fprint(w, `{"ok": true, "result": {}}`)
+		default:
+			t.Errorf("Path = %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	api := telegramApi
+	telegramApi = srv.URL
+	t.Cleanup(func() { telegramApi = api })
+
+	return stub
+}
+
+func message(id, chat int, date time.Time, text string) telegramUpdate {
+
+	m := &telegramMessage{Date: date.Unix(), Text: text}
+	m.Chat.Id = chat
+
+	return telegramUpdate{UpdateId: id, Message: m}
+}
+
+// fakeCommands answers with each command's name, except Balance, which fails, and records payouts sent
+type fakeCommands struct {
+	checkErr error
+	sent     []int
+}
+
+func (f *fakeCommands) Status() (string, error)  { return "status", nil }
+func (f *fakeCommands) Rights() (string, error)  { return "rights", nil }
+func (f *fakeCommands) Balance() (string, error) { return "", errors.New("node down") }
+func (f *fakeCommands) Payouts() (string, error) { return "payouts", nil }
+
+func (f *fakeCommands) CheckPayouts(cycle int) (string, error) {
+	return fmt.Sprintf("Payouts for cycle %d: 3 delegators", cycle), f.checkErr
+}
+
+func (f *fakeCommands) SendPayouts(cycle int) error {
+	f.sent = append(f.sent, cycle)
+	return nil
+}
+
+func TestTelegramBot(t *testing.T) {
+
+	now := time.Now()
+
+	stub := newBotStub(t,
+		message(10, 1, now, "/status"),
+		message(11, 99, now, "/status"),                // Not a configured chat
+		message(12, 1, now.Add(-time.Hour), "/rights"), // Before startup
+		message(13, 1, now, "Good morning"),            // Not a command
+		message(14, -100, now, "/Balance@BaconBot"),    // Group chat
+		message(15, 1, now, "/nosuchcommand"),
+		telegramUpdate{UpdateId: 16}, // Not a message
+	)
+
+	nt := &NotifyTelegram{ApiKey: "123:ABC", ChatIds: []int{1, -100}, Enabled: true, Commands: true}
+	bot := newTelegramBot(&fakeCommands{})
+	bot.started = now.Add(-time.Minute)
+
+	if err := bot.poll(context.Background(), nt); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	// Acknowledges the updates
+	if err := bot.poll(context.Background(), nt); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	if len(stub.offsets) != 2 || stub.offsets[0] != "0" || stub.offsets[1] != "17" {
+		t.Errorf("Offsets = %v", stub.offsets)
+	}
+
+	if r := stub.replies["1"]; len(r) != 2 || r[0] != "status" || r[1] != "Unknown command; Try /help" {
+		t.Errorf("Replies to chat 1 = %q", r)
+	}
+
+	if r := stub.replies["-100"]; len(r) != 1 || r[0] != "Unable to get balance: node down" {
+		t.Errorf("Replies to group = %q", r)
+	}
+
+	if r, ok := stub.replies["99"]; ok {
+		t.Errorf("Replied to unknown chat: %q", r)
+	}
+}
+
+func TestTelegramBotPayouts(t *testing.T) {
+
+	nt := &NotifyTelegram{ChatIds: []int{1}, Commands: true}
+	commands := &fakeCommands{}
+	bot := newTelegramBot(commands)
+	now := time.Now()
+
+	if reply := bot.command(nt, 1, "/sendpayouts 5", now); reply != "Sending payouts from Telegram is disabled" {
+		t.Errorf("Disabled: %q", reply)
+	}
+
+	if strings.Contains(bot.command(nt, 1, "/help", now), "/sendpayouts") {
+		t.Error("Help includes /sendpayouts while disabled")
+	}
+
+	nt.PayoutCommands = true
+
+	for _, args := range []string{"", "five", "5 6", "-1"} {
+		if reply := bot.command(nt, 1, "/sendpayouts "+args, now); reply != "Usage: /sendpayouts <cycle>" {
+			t.Errorf("/sendpayouts %s: %q", args, reply)
+		}
+	}
+
+	// Asks for confirmation
+	reply := bot.command(nt, 1, "/sendpayouts 5", now)
+	if !strings.HasPrefix(reply, "Payouts for cycle 5: 3 delegators") || !strings.Contains(reply, "/confirm 5 within 2 minutes") {
+		t.Errorf("Reply = %q", reply)
+	}
+
+	// Another chat has nothing to confirm
+	if reply := bot.command(nt, 2, "/confirm 5", now); !strings.HasPrefix(reply, "Nothing to confirm") {
+		t.Errorf("Other chat: %q", reply)
+	}
+
+	// The wrong cycle cancels the request
+	if reply := bot.command(nt, 1, "/confirm 4", now); !strings.HasPrefix(reply, "Not confirmed") {
+		t.Errorf("Wrong cycle: %q", reply)
+	}
+
+	if reply := bot.command(nt, 1, "/confirm 5", now); !strings.HasPrefix(reply, "Nothing to confirm") {
+		t.Errorf("After wrong cycle: %q", reply)
+	}
+
+	// Too late
+	bot.command(nt, 1, "/sendpayouts 5", now)
+
+	if reply := bot.command(nt, 1, "/confirm 5", now.Add(TELEGRAM_CONFIRM_TIMEOUT+time.Second)); !strings.HasPrefix(reply, "Nothing to confirm") {
+		t.Errorf("Expired: %q", reply)
+	}
+
+	// Cancelled
+	bot.command(nt, 1, "/sendpayouts 5", now)
+
+	if reply := bot.command(nt, 1, "/cancel", now); reply != "Cancelled" {
+		t.Errorf("Cancel: %q", reply)
+	}
+
+	if len(commands.sent) != 0 {
+		t.Fatalf("Sent %v without confirmation", commands.sent)
+	}
+
+	// Confirmed, once
+	bot.command(nt, 1, "/sendpayouts 5", now)
+
+	if reply := bot.command(nt, 1, "/confirm 5", now.Add(time.Minute)); !strings.HasPrefix(reply, "Sending payouts for cycle 5") {
+		t.Errorf("Confirm: %q", reply)
+	}
+
+	if reply := bot.command(nt, 1, "/confirm 5", now.Add(time.Minute)); !strings.HasPrefix(reply, "Nothing to confirm") {
+		t.Errorf("Second confirm: %q", reply)
+	}
+
+	if len(commands.sent) != 1 || commands.sent[0] != 5 {
+		t.Errorf("Sent %v", commands.sent)
+	}
+
+	// Disabled while waiting
+	bot.command(nt, 1, "/sendpayouts 6", now)
+	nt.PayoutCommands = false
+
+	if reply := bot.command(nt, 1, "/confirm 6", now); reply != "Sending payouts from Telegram is disabled" {
+		t.Errorf("Disabled while waiting: %q", reply)
+	}
+
+	// Cannot be sent
+	nt.PayoutCommands = true
+	commands.checkErr = errors.New("Already sent")
+
+	if reply := bot.command(nt, 1, "/sendpayouts 5", now); reply != "Cannot send payouts for cycle 5: Already sent" {
+		t.Errorf("Check failed: %q", reply)
+	}
+
+	if reply := bot.command(nt, 1, "/confirm 5", now); !strings.HasPrefix(reply, "Nothing to confirm") {
+		t.Errorf("Confirmed a failed check: %q", reply)
+	}
+
+	if len(commands.sent) != 1 {
+		t.Errorf("Sent %v", commands.sent)
+	}
+}
+
+func TestTelegramBotShutdown(t *testing.T) {
+
+	// getUpdates waits, as Telegram does, until the bot gives up
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	api := telegramApi
+	telegramApi = srv.URL
+	defer func() { telegramApi = api }()
+
+	n, _ := testHandler(t)
+	n.notifiers[TELEGRAM] = &NotifyTelegram{ApiKey: "123:ABC", ChatIds: []int{1}, Enabled: true, Commands: true}
+
+	shutdown := make(chan interface{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go n.RunTelegramBot(&fakeCommands{}, shutdown, &wg)
+
+	time.Sleep(100 * time.Millisecond)
+	close(shutdown)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Bot did not stop on shutdown")
+	}
+}
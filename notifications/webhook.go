@@ -0,0 +1,339 @@
+package notifications
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/metrics"
+	"bakinbacon/storage"
+)
+
+const (
+	WEBHOOK_SIGNATURE_HEADER = "X-BakinBacon-Signature"
+	WEBHOOK_TIMESTAMP_HEADER = "X-BakinBacon-Timestamp"
+
+	WEBHOOK_TIMEOUT     = 10 * time.Second
+	WEBHOOK_BACKOFF     = 2 * time.Second
+	WEBHOOK_MAX_BACKOFF = 60 * time.Second
+	WEBHOOK_MAX_RETRIES = 10
+)
+
+type NotifyWebhook struct {
+	Urls     []string          `json:"urls"`
+	Headers  map[string]string `json:"headers"`
+	Template string            `json:"template"` // Go template for the body; Empty sends the Notification as JSON
+	Secret   string            `json:"secret"`   // Signs requests with HMAC-SHA256 when set
+	Retries  int               `json:"retries"`
+	Enabled  bool              `json:"enabled"`
+
+	storage  *storage.Storage
+	template *template.Template
+	client   *http.Client
+	backoff  time.Duration
+}
+
+// Functions available to body templates
+var webhookFuncs = template.FuncMap{
+
+	// Quotes and escapes a value for use inside a JSON document, eg {"text": {{ json .Message }}}
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func init() {
+	RegisterNotifier(WEBHOOK, func(n *NotificationHandler, config []byte, saveConfig bool) (Notifier, error) {
+		return n.NewWebhook(config, saveConfig)
+	})
+}
+
+// NewWebhook creates a new NotifyWebhook object using a JSON byte-stream
+// provided from either DB lookup or web UI. See NewTelegram.
+func (n *NotificationHandler) NewWebhook(config []byte, saveConfig bool) (*NotifyWebhook, error) {
+
+	nw := &NotifyWebhook{
+		client:  &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		backoff: WEBHOOK_BACKOFF,
+	}
+
+	// empty config from db?
+	if config != nil {
+		if err := json.Unmarshal(config, nw); err != nil {
+			return nw, errors.Wrap(err, "Unable to unmarshal webhook config")
+		}
+	}
+
+	// save local reference to database
+	nw.storage = n.storage
+
+	if err := nw.Validate(); err != nil {
+
+		// Don't prevent startup because of a bad config in the DB
+		if !saveConfig {
+			log.WithError(err).Error("Invalid webhook config; Webhook notifications disabled")
+			nw.Enabled = false
+			return nw, nil
+		}
+
+		return nw, err
+	}
+
+	if saveConfig {
+		if err := nw.SaveConfig(); err != nil {
+			return nw, err
+		}
+	}
+
+	return nw, nil
+}
+
+// Validate checks the URLs and headers, and parses the template
+func (n *NotifyWebhook) Validate() error {
+
+	if n.Enabled && len(n.Urls) == 0 {
+		return errors.New("At least one webhook URL is required")
+	}
+
+	for _, u := range n.Urls {
+		if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+			return errors.Errorf("Invalid webhook URL %q", u)
+		}
+	}
+
+	for k := range n.Headers {
+		if k == "" || strings.ContainsAny(k, " :\r\n") {
+			return errors.Errorf("Invalid header name %q", k)
+		}
+	}
+
+	if n.Retries < 0 || n.Retries > WEBHOOK_MAX_RETRIES {
+		return errors.Errorf("Webhook retries must be between 0 and %d", WEBHOOK_MAX_RETRIES)
+	}
+
+	n.template = nil
+
+	if n.Template != "" {
+		t, err := template.New("webhook").Funcs(webhookFuncs).Option("missingkey=error").Parse(n.Template)
+		if err != nil {
+			return errors.Wrap(err, "Invalid webhook template")
+		}
+		n.template = t
+	}
+
+	return nil
+}
+
+func (n *NotifyWebhook) IsEnabled() bool {
+	return n.Enabled
+}
+
+// Send posts a message without context, eg from tests
+func (n *NotifyWebhook) Send(msg string) {
+	n.SendEvent(Notification{
+		Message:   msg,
+		Level:     LEVEL_INFO,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// SendEvent posts the notification in the background, as retries can take minutes
+func (n *NotifyWebhook) SendEvent(notification Notification) {
+	go func() {
+		if err := n.Deliver(notification, true); err != nil {
+			log.WithError(err).Error("Unable to send webhook")
+			metrics.NotificationFailures.WithLabelValues(WEBHOOK).Inc()
+		}
+	}()
+}
+
+// TestSend posts the notification without retries, so the settings page gets a prompt answer
+func (n *NotifyWebhook) TestSend(notification Notification) error {
+	return n.Deliver(notification, false)
+}
+
+// retries is the number of times the outbox retries a failed notification
+func (n *NotifyWebhook) retries() int {
+	return n.Retries
+}
+
+// targets are the URLs, so the outbox only retries those which failed
+func (n *NotifyWebhook) targets() []string {
+	return n.Urls
+}
+
+// sendTo posts the notification to one URL, without retries; The outbox retries
+func (n *NotifyWebhook) sendTo(u string, notification Notification) error {
+
+	body, err := n.render(notification)
+	if err != nil {
+		return err
+	}
+
+	if err := n.post(u, body, false); err != nil {
+		log.WithError(err).WithField("URL", u).Error("Unable to send webhook")
+		return err
+	}
+
+	return nil
+}
+
+// Deliver posts the notification to each URL, retrying failures if retry is set
+func (n *NotifyWebhook) Deliver(notification Notification, retry bool) error {
+
+	body, err := n.render(notification)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+
+	for _, u := range n.Urls {
+		if err := n.post(u, body, retry); err != nil {
+			log.WithError(err).WithField("URL", u).Error("Unable to send webhook")
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	log.WithField("MSG", notification.Message).Info("Sent Webhook")
+
+	return nil
+}
+
+func (n *NotifyWebhook) render(notification Notification) ([]byte, error) {
+
+	if n.template == nil {
+		return json.Marshal(notification)
+	}
+
+	var buf bytes.Buffer
+	if err := n.template.Execute(&buf, notification); err != nil {
+		return nil, errors.Wrap(err, "Unable to render webhook template")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// post sends body to u, retrying with exponential backoff on network errors, 429 and 5xx
+func (n *NotifyWebhook) post(u string, body []byte, retry bool) error {
+
+	attempts := 1
+	if retry {
+		attempts += n.Retries
+	}
+
+	backoff := n.backoff
+
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+
+		if attempt > 1 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > WEBHOOK_MAX_BACKOFF {
+				backoff = WEBHOOK_MAX_BACKOFF
+			}
+		}
+
+		var permanent bool
+		if permanent, err = n.postOnce(u, body); err == nil || permanent {
+			return err
+		}
+
+		log.WithError(err).WithFields(log.Fields{
+			"URL": u, "Attempt": attempt, "Attempts": attempts,
+		}).Warn("Webhook failed")
+	}
+
+	return err
+}
+
+// postOnce returns whether a failure is permanent, ie retrying won't help
+func (n *NotifyWebhook) postOnce(u string, body []byte) (bool, error) {
+
+	// URLs often hold tokens, and errors are shown in the notification history, so keep them out
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return true, errors.Wrap(withoutUrl(err), "Unable to create webhook request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "BakinBacon")
+
+	// Custom headers can replace the above, but not the signature
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if n.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WEBHOOK_TIMESTAMP_HEADER, timestamp)
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, "sha256="+n.sign(timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return false, errors.Wrapf(withoutUrl(err), "Unable to post to %s", req.URL.Hostname())
+	}
+	defer resp.Body.Close()
+
+	// Drain, so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = errors.Errorf("%s returned %s", req.URL.Hostname(), resp.Status)
+	permanent := resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500
+
+	return permanent, err
+}
+
+// sign returns the hex HMAC-SHA256 of "<timestamp>.<body>". Including the timestamp lets
+// receivers reject replayed requests.
+func (n *NotifyWebhook) sign(timestamp string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(n.Secret))
+	fmt.Fprintf(mac, "%s.", timestamp)
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *NotifyWebhook) SaveConfig() error {
+
+	// Marshal ourselves to []byte and send to storage manager
+	config, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal webhook config")
+	}
+
+	if err := n.storage.SaveNotifiersConfig(WEBHOOK, config); err != nil {
+		return errors.Wrap(err, "Unable to save webhook config")
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookStub answers each request with the next status in statuses, then 200
+func webhookStub(t *testing.T, statuses ...int) (*httptest.Server, func() []webhookRequest) {
+
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []webhookRequest
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		requests = append(requests, webhookRequest{header: r.Header.Clone(), body: body})
+		n := len(requests)
+		mu.Unlock()
+
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv, func() []webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhookRequest{}, requests...)
+	}
+}
+
+func testWebhookNotifier(t *testing.T, nw *NotifyWebhook) *NotifyWebhook {
+
+	t.Helper()
+
+	nw.Enabled = true
+	nw.client = &http.Client{Timeout: time.Second}
+	nw.backoff = time.Millisecond
+
+	if err := nw.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	return nw
+}
+
+var testNotification = Notification{
+	Category:  BAKING_FAIL.String(),
+	Message:   "Failed to bake \"1234\"",
+	Level:     BAKING_FAIL.Level(),
+	Cycle:     400,
+	Delegate:  "tz1RMmSzPSWPSSaKU193Voh4PosWSZx1C7Hs",
+	Timestamp: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+func TestWebhookPayloadAndSignature(t *testing.T) {
+
+	srv, requests := webhookStub(t)
+
+	nw := testWebhookNotifier(t, &NotifyWebhook{
+		Urls:    []string{srv.URL},
+		Headers: map[string]string{"Authorization": "Bearer abc"},
+		Secret:  "s3cret",
+	})
+
+	if err := nw.Deliver(testNotification, true); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("Got %d requests, want 1", len(reqs))
+	}
+
+	req := reqs[0]
+
+	var got Notification
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("Body is not JSON: %v", err)
+	}
+
+	if got != testNotification {
+		t.Errorf("Payload = %+v, want %+v", got, testNotification)
+	}
+
+	if got.Category != "baking_fail" || got.Level != LEVEL_ERROR {
+		t.Errorf("Category/Level = %s/%s", got.Category, got.Level)
+	}
+
+	if req.header.Get("Authorization") != "Bearer abc" || req.header.Get("Content-Type") != "application/json" {
+		t.Errorf("Headers = %v", req.header)
+	}
+
+	// Receivers verify HMAC-SHA256("<timestamp>.<body>")
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(req.header.Get(WEBHOOK_TIMESTAMP_HEADER) + "."))
+	mac.Write(req.body)
+
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get(WEBHOOK_SIGNATURE_HEADER) != want {
+		t.Errorf("Signature = %q, want %q", req.header.Get(WEBHOOK_SIGNATURE_HEADER), want)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+
+	srv, requests := webhookStub(t)
+	nw := testWebhookNotifier(t, &NotifyWebhook{Urls: []string{srv.URL}})
+
+	if err := nw.Deliver(testNotification, false); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	if h := requests()[0].header; h.Get(WEBHOOK_SIGNATURE_HEADER) != "" || h.Get(WEBHOOK_TIMESTAMP_HEADER) != "" {
+		t.Errorf("Unexpected signature headers: %v", h)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+
+	srv, requests := webhookStub(t)
+
+	nw := testWebhookNotifier(t, &NotifyWebhook{
+		Urls:     []string{srv.URL},
+		Template: `{"text": {{ json (printf "[%s] %s (cycle %d)" (upper .Level) .Message .Cycle) }}}`,
+	})
+
+	if err := nw.Deliver(testNotification, false); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(requests()[0].body, &got); err != nil {
+		t.Fatalf("Rendered body is not JSON: %v, %s", err, requests()[0].body)
+	}
+
+	if want := `[ERROR] Failed to bake "1234" (cycle 400)`; got["text"] != want {
+		t.Errorf("text = %q, want %q", got["text"], want)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+
+	// Retried until success
+	srv, requests := webhookStub(t, 500, 429, 503)
+	nw := testWebhookNotifier(t, &NotifyWebhook{Urls: []string{srv.URL}, Retries: 3})
+
+	if err := nw.Deliver(testNotification, true); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	if n := len(requests()); n != 4 {
+		t.Errorf("Got %d requests, want 4", n)
+	}
+
+	// Gives up after retries
+	srv, requests = webhookStub(t, 500, 500, 500)
+	nw = testWebhookNotifier(t, &NotifyWebhook{Urls: []string{srv.URL}, Retries: 2})
+
+	if err := nw.Deliver(testNotification, true); err == nil {
+		t.Error("Expected failure after retries")
+	}
+
+	if n := len(requests()); n != 3 {
+		t.Errorf("Got %d requests, want 3", n)
+	}
+
+	// Client errors are not retried
+	srv, requests = webhookStub(t, 400)
+	nw = testWebhookNotifier(t, &NotifyWebhook{Urls: []string{srv.URL}, Retries: 3})
+
+	if err := nw.Deliver(testNotification, true); err == nil {
+		t.Error("Expected failure on 400")
+	}
+
+	if n := len(requests()); n != 1 {
+		t.Errorf("Got %d requests, want 1", n)
+	}
+
+	// Test sends are not retried
+	srv, requests = webhookStub(t, 500)
+	nw = testWebhookNotifier(t, &NotifyWebhook{Urls: []string{srv.URL}, Retries: 3})
+
+	if err := nw.Deliver(testNotification, false); err == nil {
+		t.Error("Expected failure on 500")
+	}
+
+	if n := len(requests()); n != 1 {
+		t.Errorf("Got %d requests, want 1", n)
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+
+	for name, nw := range map[string]*NotifyWebhook{
+		"no urls":      {Enabled: true},
+		"bad scheme":   {Urls: []string{"ftp://example.com"}},
+		"no host":      {Urls: []string{"https://"}},
+		"bad header":   {Urls: []string{"https://example.com"}, Headers: map[string]string{"X Bad": "1"}},
+		"bad template": {Urls: []string{"https://example.com"}, Template: "{{ .Message "},
+		"bad retries":  {Urls: []string{"https://example.com"}, Retries: WEBHOOK_MAX_RETRIES + 1},
+	} {
+		if err := nw.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Files kept by the Octez baker and endorser in the client directory, eg ~/.tezos-client
+const (
+	OCTEZ_BLOCKS_FILE       = "blocks"
+	OCTEZ_ENDORSEMENTS_FILE = "endorsements"
+	OCTEZ_NONCES_FILE       = "nonces"
+)
+
+type octezNonce struct {
+	Block string
+	Seed  []byte
+}
+
+// readOctezWatermark returns delegate's high watermark from an Octez blocks or endorsements
+// file. These are a list of [delegate, level] pairs; A list of {delegate, level} objects is also accepted.
+func readOctezWatermark(file, delegate string) (int, bool, error) {
+
+	fileBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(fileBytes, &entries); err != nil {
+		return 0, false, errors.Wrapf(err, "Unable to parse %s", file)
+	}
+
+	for _, e := range entries {
+
+		var (
+			pkh   string
+			level int
+		)
+
+		var pair []interface{}
+		if err := json.Unmarshal(e, &pair); err == nil {
+
+			if len(pair) != 2 {
+				return 0, false, errors.Errorf("Unexpected entry in %s: %s", file, e)
+			}
+
+			p, ok1 := pair[0].(string)
+			l, ok2 := pair[1].(float64)
+			if !ok1 || !ok2 {
+				return 0, false, errors.Errorf("Unexpected entry in %s: %s", file, e)
+			}
+
+			pkh, level = p, int(l)
+
+		} else {
+
+			var obj struct {
+				Delegate string `json:"delegate"`
+				Level    int    `json:"level"`
+			}
+
+			if err := json.Unmarshal(e, &obj); err != nil {
+				return 0, false, errors.Errorf("Unexpected entry in %s: %s", file, e)
+			}
+
+			pkh, level = obj.Delegate, obj.Level
+		}
+
+		if pkh == delegate {
+			return level, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// readOctezNonces returns the seeds in an Octez nonces file. This is a list of {block, nonce}
+// objects; A list of [block, nonce] pairs is also accepted.
+func readOctezNonces(file string) ([]octezNonce, error) {
+
+	fileBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(fileBytes, &entries); err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse %s", file)
+	}
+
+	nonces := make([]octezNonce, 0, len(entries))
+
+	for _, e := range entries {
+
+		var block, seedHex string
+
+		var pair []string
+		if err := json.Unmarshal(e, &pair); err == nil {
+
+			if len(pair) != 2 {
+				return nil, errors.Errorf("Unexpected entry in %s: %s", file, e)
+			}
+
+			block, seedHex = pair[0], pair[1]
+
+		} else {
+
+			var obj struct {
+				Block string `json:"block"`
+				Nonce string `json:"nonce"`
+			}
+
+			if err := json.Unmarshal(e, &obj); err != nil {
+				return nil, errors.Errorf("Unexpected entry in %s: %s", file, e)
+			}
+
+			block, seedHex = obj.Block, obj.Nonce
+		}
+
+		seed, err := hex.DecodeString(seedHex)
+		if err != nil || len(seed) != 32 {
+			return nil, errors.Errorf("Invalid nonce for block %s in %s", block, file)
+		}
+
+		nonces = append(nonces, octezNonce{
+			Block: block,
+			Seed:  seed,
+		})
+	}
+
+	return nonces, nil
+}
+
+func octezFileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bakinbacon/nonce"
+	"bakinbacon/storage"
+	"bakinbacon/util"
+)
+
+const (
+	testSeed  = "c3f49b2db5b3f0e4d5a1b7d0a7c42d5f9b54ef2ae7a0f06f8e1c3c5a4b9e6d01"
+	testBlock = "BLockGenesisGenesisGenesisGenesisGenesisf79b5d1CoW2"
+)
+
+func writeTestFile(t *testing.T, contents string) string {
+
+	file := filepath.Join(t.TempDir(), "octez")
+	if err := ioutil.WriteFile(file, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestReadOctezNonces(t *testing.T) {
+
+	for name, contents := range map[string]string{
+		"objects": `[{"block": "` + testBlock + `", "nonce": "` + testSeed + `"}]`,
+		"pairs":   `[["` + testBlock + `", "` + testSeed + `"]]`,
+	} {
+
+		nonces, err := readOctezNonces(writeTestFile(t, contents))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if len(nonces) != 1 || nonces[0].Block != testBlock || fmt.Sprintf("%x", nonces[0].Seed) != testSeed {
+			t.Errorf("%s: %+v", name, nonces)
+		}
+	}
+
+	for name, contents := range map[string]string{
+		"short seed": `[{"block": "` + testBlock + `", "nonce": "c3f4"}]`,
+		"not hex":    `[["` + testBlock + `", "` + strings.Repeat("zz", 32) + `"]]`,
+		"triple":     `[["` + testBlock + `", "` + testSeed + `", "x"]]`,
+		"not a list": `{"block": "` + testBlock + `"}`,
+	} {
+		if _, err := readOctezNonces(writeTestFile(t, contents)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReadOctezWatermark(t *testing.T) {
+
+	const delegate = "tz1NortRftucvAkD1J58L32EhSVrQEWJCEnB"
+
+	for name, contents := range map[string]string{
+		"pairs":   `[["tz1other", 5], ["` + delegate + `", 1234]]`,
+		"objects": `[{"delegate": "tz1other", "level": 5}, {"delegate": "` + delegate + `", "level": 1234}]`,
+	} {
+
+		level, found, err := readOctezWatermark(writeTestFile(t, contents), delegate)
+		if err != nil || !found || level != 1234 {
+			t.Errorf("%s: %d, %v, %v", name, level, found, err)
+		}
+	}
+
+	if _, found, err := readOctezWatermark(writeTestFile(t, `[["tz1other", 5]]`), delegate); err != nil || found {
+		t.Errorf("Other delegate: %v, %v", found, err)
+	}
+}
+
+// A nonce from the last block of a cycle is saved under the cycle in the block's metadata,
+// which level arithmetic gets wrong
+func TestImportOctezNoncesCycle(t *testing.T) {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const level, cycle = 4096 * 10, 9
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chains/main/blocks/head/metadata":
+			fmt.Fprintf(w, `{"level_info": {"level": %d, "cycle": %d}}`, level+100, cycle+1)
+		case "/chains/main/blocks/" + testBlock + "/metadata":
+			fmt.Fprintf(w, `{"level_info": {"level": %d, "cycle": %d}}`, level, cycle)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.String("rpc", srv.URL, "")
+
+	c := &cliContext{db: db, network: util.NETWORK_HANGZHOUNET, fs: fs}
+
+	var result cliOctezImportResult
+	file := writeTestFile(t, `[{"block": "`+testBlock+`", "nonce": "`+testSeed+`"}, {"block": "BMissing", "nonce": "`+testSeed+`"}]`)
+
+	if err := c.importOctezNonces(file, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.NoncesImported != 1 || result.NoncesSkipped != 1 {
+		t.Errorf("Result = %+v", result)
+	}
+
+	nonces, err := db.GetNoncesForCycle(cycle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n nonce.Nonce
+	if len(nonces) != 1 || json.Unmarshal(nonces[0], &n) != nil || n.Level != level {
+		t.Errorf("Nonces for cycle %d = %s", cycle, nonces)
+	}
+
+	// Importing again keeps the saved nonce
+	result = cliOctezImportResult{}
+	if err := c.importOctezNonces(file, &result); err != nil || result.NoncesImported != 0 {
+		t.Errorf("Reimport = %+v, %v", result, err)
+	}
+}
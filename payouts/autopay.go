@@ -0,0 +1,228 @@
+package payouts
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/notifications"
+)
+
+const (
+	// Defaults for AutoPayPolicy
+	AUTO_PAY_DELAY       = 60   // Blocks
+	AUTO_PAY_MAX_PCT     = 100  // Percent
+	AUTO_PAY_MAX_REWARD  = 1000 // XTZ
+	AUTO_PAY_BOND_CYCLES = 1
+)
+
+// AutoPayPolicy sends calculated payouts without manual approval, when they pass every check.
+// Payouts which fail a check are left for manual approval.
+type AutoPayPolicy struct {
+	Enabled bool
+	Delay   int // Blocks after calculation, giving time to review or send manually
+
+	MaxPct     int // All payouts together, as a percent of the cycle's unfrozen rewards
+	MaxReward  int // Mutez, for any one delegator; 0 for no cap
+	BondCycles int // Cycles after this one whose bonds must remain in the spendable balance
+}
+
+func (a AutoPayPolicy) Validate() error {
+
+	switch {
+	case a.Delay < 0:
+		return errors.New("Delay cannot be negative")
+	case a.MaxPct < 1 || a.MaxPct > 100:
+		return errors.New("Max percent must be between 1 and 100")
+	case a.MaxReward < 0:
+		return errors.New("Max reward cannot be negative")
+	case a.BondCycles < 0:
+		return errors.New("Bond cycles cannot be negative")
+	}
+
+	return nil
+}
+
+// HandleAutoPayouts sends the oldest calculated payouts which are due, if the policy is enabled
+func (p *PayoutsHandler) HandleAutoPayouts(wg *sync.WaitGroup, block rpc.Block) {
+
+	defer wg.Done()
+
+	if p.Disabled || !p.AutoPay.Enabled {
+		return
+	}
+
+	// Blocks may arrive before the last check is done; Payouts are marked in progress
+	// once sent, so waiting here means they are only sent once
+	p.autoPayMu.Lock()
+	defer p.autoPayMu.Unlock()
+
+	level := block.Metadata.Level.Level
+
+	allMetadata, err := p.GetPayoutsMetadataAll()
+	if err != nil {
+		log.WithError(err).Error("Unable to get payouts metadata for auto-pay")
+		return
+	}
+
+	cycles := make([]int, 0, len(allMetadata))
+	for c := range allMetadata {
+		cycles = append(cycles, c)
+	}
+
+	sort.Ints(cycles)
+
+	for _, c := range cycles {
+
+		m := allMetadata[c]
+
+		// Payouts calculated before upgrading have no CalculatedLevel; Those stay manual
+		if m.Status != CALCULATED || m.AutoPayHeld != "" || m.CalculatedLevel == 0 || level < m.CalculatedLevel+p.AutoPay.Delay {
+			continue
+		}
+
+		// One cycle per block, as each payout changes the balance the next is checked against
+		p.autoPay(c, m, block.Metadata.Level.Cycle, level)
+
+		return
+	}
+}
+
+func (p *PayoutsHandler) autoPay(rewardCycle int, metadata CycleRewardMetadata, cycle, level int) {
+
+	logger := log.WithField("RewardCycle", rewardCycle)
+
+	// Errors here may pass; Try again next block
+	rewards, err := p.GetDelegatorRewardAllForCycle(rewardCycle)
+	if err != nil {
+		logger.WithError(err).Error("Unable to get delegator rewards for auto-pay")
+		return
+	}
+
+	balance, err := p.spendableBalance()
+	if err != nil {
+		logger.WithError(err).Warn("Unable to get spendable balance for auto-pay; Will retry")
+		return
+	}
+
+	bonds, err := p.requiredBonds(cycle, level)
+	if err != nil {
+		logger.WithError(err).Error("Unable to get bond requirements for auto-pay")
+		return
+	}
+
+	total, numPayouts, reason := checkAutoPay(p.AutoPay, metadata, rewards, balance, bonds)
+	if reason != "" {
+		p.holdAutoPay(rewardCycle, reason)
+		return
+	}
+
+	if err := p.sendPayouts(rewardCycle); err != nil {
+		p.holdAutoPay(rewardCycle, fmt.Sprintf("Unable to send: %s", err))
+		return
+	}
+
+	msg := fmt.Sprintf("Sending payouts for cycle %d automatically; %s to %d delegators", rewardCycle, xtz(total), numPayouts)
+	logger.Info(msg)
+	p.notifications.SendNotification(msg, notifications.PAYOUTS)
+}
+
+// checkAutoPay returns the total and number of unpaid rewards, and why they must not be
+// sent automatically, if any reason
+func checkAutoPay(policy AutoPayPolicy, metadata CycleRewardMetadata, rewards map[string]DelegatorReward, balance, bonds int) (int, int, string) {
+
+	var total, numPayouts int
+	var largest DelegatorReward
+
+	for _, r := range rewards {
+
+		// Already sent, or withheld by a rule
+		if r.OpHash != "" || r.Reward == 0 {
+			continue
+		}
+
+		total += r.Reward
+		numPayouts++
+
+		if r.Reward > largest.Reward || (r.Reward == largest.Reward && r.Delegator < largest.Delegator) {
+			largest = r
+		}
+	}
+
+	if unfrozen := metadata.BlockRewards + metadata.FeeRewards; total*100 > unfrozen*policy.MaxPct {
+		return total, numPayouts, fmt.Sprintf("Payouts total %s, over %d%% of the cycle's rewards of %s",
+			xtz(total), policy.MaxPct, xtz(unfrozen))
+	}
+
+	if policy.MaxReward > 0 && largest.Reward > policy.MaxReward {
+		return total, numPayouts, fmt.Sprintf("Reward of %s to %s is over the cap of %s",
+			xtz(largest.Reward), largest.Delegator, xtz(policy.MaxReward))
+	}
+
+	if balance-total < bonds {
+		return total, numPayouts, fmt.Sprintf("Spendable balance of %s, less payouts of %s, would not cover bonds of %s",
+			xtz(balance), xtz(total), xtz(bonds))
+	}
+
+	return total, numPayouts, ""
+}
+
+// requiredBonds returns the deposits for rights after level, to the end of cycle + BondCycles.
+// Rights not yet fetched are not counted.
+func (p *PayoutsHandler) requiredBonds(cycle, level int) (int, error) {
+
+	var bonds int
+
+	for c := cycle; c <= cycle+p.AutoPay.BondCycles; c++ {
+
+		bakingRights, err := p.storage.GetBakingLevelsForCycle(c)
+		if err != nil {
+			return 0, errors.Wrap(err, "Unable to get baking rights")
+		}
+
+		for _, r := range bakingRights {
+			if r.Level > level {
+				bonds += p.constants.BlockSecurityDeposit
+			}
+		}
+
+		endorsingRights, err := p.storage.GetEndorsingRightsForCycle(c)
+		if err != nil {
+			return 0, errors.Wrap(err, "Unable to get endorsing rights")
+		}
+
+		for _, r := range endorsingRights {
+			if r.Level > level {
+				bonds += p.constants.EndorsementSecurityDeposit
+			}
+		}
+	}
+
+	return bonds, nil
+}
+
+// holdAutoPay leaves payouts for manual approval, notifying why
+func (p *PayoutsHandler) holdAutoPay(rewardCycle int, reason string) {
+
+	metadata, err := p.GetRewardMetadataForCycle(rewardCycle)
+	if err == nil {
+		metadata.AutoPayHeld = reason
+		err = p.SaveRewardMetadataForCycle(rewardCycle, metadata)
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("RewardCycle", rewardCycle).Error("Unable to save auto-pay hold")
+	}
+
+	msg := fmt.Sprintf("Payouts for cycle %d were not sent automatically: %s. Review and send them from the UI.", rewardCycle, reason)
+	log.WithField("RewardCycle", rewardCycle).Warn(msg)
+	p.notifications.SendNotification(msg, notifications.PAYOUTS)
+}
+
+func xtz(mutez int) string {
+	return fmt.Sprintf("%.6f XTZ", float64(mutez)/1e6)
+}
@@ -0,0 +1,276 @@
+package payouts
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+	"bakinbacon/util"
+)
+
+func testPolicy() AutoPayPolicy {
+	return AutoPayPolicy{
+		Enabled:    true,
+		Delay:      10,
+		MaxPct:     90,
+		MaxReward:  50e6,
+		BondCycles: 1,
+	}
+}
+
+func TestCheckAutoPay(t *testing.T) {
+
+	metadata := CycleRewardMetadata{BlockRewards: 90e6, FeeRewards: 10e6}
+
+	rewards := map[string]DelegatorReward{
+		"tz1a": {Delegator: "tz1a", Reward: 40e6},
+		"tz1b": {Delegator: "tz1b", Reward: 30e6},
+		"tz1c": {Delegator: "tz1c", Reward: 25e6, OpHash: "oo1"}, // Already sent
+	}
+
+	total, n, reason := checkAutoPay(testPolicy(), metadata, rewards, 1000e6, 930e6)
+	if total != 70e6 || n != 2 || reason != "" {
+		t.Errorf("Passing: total %d, %d payouts, reason %q", total, n, reason)
+	}
+
+	for name, tc := range map[string]struct {
+		policy   func(*AutoPayPolicy)
+		rewards  map[string]DelegatorReward
+		balance  int
+		bonds    int
+		contains string
+	}{
+		"over percent": {
+			rewards:  map[string]DelegatorReward{"tz1a": {Delegator: "tz1a", Reward: 45e6}, "tz1b": {Delegator: "tz1b", Reward: 45e6 + 1}},
+			contains: "over 90% of the cycle's rewards of 100.000000 XTZ",
+		},
+		"over cap": {
+			rewards:  map[string]DelegatorReward{"tz1a": {Delegator: "tz1a", Reward: 50e6 + 1}},
+			contains: "Reward of 50.000001 XTZ to tz1a is over the cap of 50.000000 XTZ",
+		},
+		"no cap": {
+			policy:  func(p *AutoPayPolicy) { p.MaxReward = 0 },
+			rewards: map[string]DelegatorReward{"tz1a": {Delegator: "tz1a", Reward: 80e6}},
+		},
+		"bonds": {
+			balance:  1000e6,
+			bonds:    930e6 + 1,
+			contains: "Spendable balance of 1000.000000 XTZ, less payouts of 70.000000 XTZ, would not cover bonds of 930.000001 XTZ",
+		},
+	} {
+
+		policy := testPolicy()
+		if tc.policy != nil {
+			tc.policy(&policy)
+		}
+
+		if tc.rewards == nil {
+			tc.rewards = rewards
+		}
+
+		if tc.balance == 0 {
+			tc.balance = 1000e6
+		}
+
+		_, _, reason := checkAutoPay(policy, metadata, tc.rewards, tc.balance, tc.bonds)
+
+		if tc.contains == "" && reason != "" {
+			t.Errorf("%s: unexpected reason %q", name, reason)
+		}
+
+		if !strings.Contains(reason, tc.contains) {
+			t.Errorf("%s: reason %q, expected %q", name, reason, tc.contains)
+		}
+	}
+}
+
+func TestHandleAutoPayouts(t *testing.T) {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	nc, _ := util.GetNetworkConstants(util.NETWORK_HANGZHOUNET)
+	nh, _ := notifications.NewHandler(db)
+
+	p, _ := NewPayoutsHandler(nil, db, nc, nh, false)
+	p.AutoPay = testPolicy()
+
+	balance := int(1000e6)
+	p.spendableBalance = func() (int, error) {
+		return balance, nil
+	}
+
+	var sent []int
+	p.sendPayouts = func(cycle int) error {
+		sent = append(sent, cycle)
+		return p.setCyclePayoutStatus(cycle, IN_PROGRESS)
+	}
+
+	block := func(level int) rpc.Block {
+		var b rpc.Block
+		b.Metadata.Level.Level = level
+		b.Metadata.Level.Cycle = 20
+		return b
+	}
+
+	handle := func(level int) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		p.HandleAutoPayouts(&wg, block(level))
+	}
+
+	// Cycle 5 is within the limits; Cycle 6 pays a delegator too much; Cycle 4 predates auto-pay
+	for cycle, reward := range map[int]int{4: 10e6, 5: 40e6, 6: 60e6} {
+
+		metadata := CycleRewardMetadata{PayoutCycle: cycle, BlockRewards: 100e6, NumDelegators: 1, Status: CALCULATED, CalculatedLevel: 1000}
+		if cycle == 4 {
+			metadata.CalculatedLevel = 0
+		}
+
+		if err := p.SaveRewardMetadataForCycle(cycle, metadata); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.SaveDelegatorReward(cycle, DelegatorReward{Delegator: "tz1a", Reward: reward}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bonds for rights left in this cycle, and the next
+	first := nc.FirstLevelOfCycle(21)
+	if err := db.SaveBakingRightsForLevels(21, nil, []rpc.BakingRights{{Level: first + 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Too soon
+	handle(1009)
+	if len(sent) != 0 {
+		t.Fatalf("Sent %v before the delay", sent)
+	}
+
+	handle(1010)
+	if len(sent) != 1 || sent[0] != 5 {
+		t.Fatalf("Sent %v, expected cycle 5", sent)
+	}
+
+	handle(1011)
+
+	m, _ := p.GetRewardMetadataForCycle(6)
+	if m.Status != CALCULATED || !strings.Contains(m.AutoPayHeld, "over the cap") {
+		t.Errorf("Cycle 6 = %+v", m)
+	}
+
+	// Held cycles stay held; Cycle 4 is never sent
+	handle(1012)
+	if len(sent) != 1 {
+		t.Errorf("Sent %v", sent)
+	}
+
+	// Not enough left for bonds
+	if err := p.SaveRewardMetadataForCycle(7, CycleRewardMetadata{PayoutCycle: 7, BlockRewards: 100e6, Status: CALCULATED, CalculatedLevel: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.SaveDelegatorReward(7, DelegatorReward{Delegator: "tz1a", Reward: 10e6}); err != nil {
+		t.Fatal(err)
+	}
+
+	balance = nc.BlockSecurityDeposit + 10e6 - 1
+	handle(1013)
+
+	if m, _ := p.GetRewardMetadataForCycle(7); !strings.Contains(m.AutoPayHeld, "would not cover bonds") {
+		t.Errorf("Cycle 7 = %+v", m)
+	}
+
+	// Unable to check the balance; Neither sent nor held, so retried
+	if err := p.SaveRewardMetadataForCycle(8, CycleRewardMetadata{PayoutCycle: 8, BlockRewards: 100e6, Status: CALCULATED, CalculatedLevel: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	p.spendableBalance = func() (int, error) {
+		return 0, errors.New("RPC unavailable")
+	}
+	handle(1014)
+
+	if m, _ := p.GetRewardMetadataForCycle(8); m.AutoPayHeld != "" || m.Status != CALCULATED || len(sent) != 1 {
+		t.Errorf("Cycle 8 = %+v, sent %v", m, sent)
+	}
+}
+
+// The UI, Telegram and auto-pay may all try to send the same cycle; Only one may go ahead
+func TestSendCyclePayoutsOnce(t *testing.T) {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	nc, _ := util.GetNetworkConstants(util.NETWORK_HANGZHOUNET)
+	nh, _ := notifications.NewHandler(db)
+
+	p, _ := NewPayoutsHandler(nil, db, nc, nh, false)
+
+	if err := p.SaveRewardMetadataForCycle(5, CycleRewardMetadata{PayoutCycle: 5, Status: CALCULATED}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed int
+	)
+
+	start := make(chan struct{})
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			if err := p.claimCyclePayouts(5); err == nil {
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	if claimed != 1 {
+		t.Fatalf("Claimed %d times", claimed)
+	}
+
+	if m, _ := p.GetRewardMetadataForCycle(5); m.Status != IN_PROGRESS {
+		t.Errorf("Status = %s", m.Status)
+	}
+
+	// Refused before reaching the node; There is no client here
+	if err := p.SendCyclePayouts(5); err == nil || !strings.Contains(err.Error(), "already sent") {
+		t.Errorf("Second send: %v", err)
+	}
+
+	if err := p.SendCyclePayouts(6); err == nil || !strings.Contains(err.Error(), "No payouts") {
+		t.Errorf("Uncalculated cycle: %v", err)
+	}
+
+	// Failed payouts may be sent again
+	if err := p.setCyclePayoutStatus(5, ERROR); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.claimCyclePayouts(5); err != nil {
+		t.Errorf("After error: %v", err)
+	}
+}
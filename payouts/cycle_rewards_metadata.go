@@ -12,7 +12,7 @@ import (
 
 const (
 	CALCULATED  = "calc"
-	DONE        = "done"
+	DONE        = storage.PAYOUTS_STATUS_DONE
 	IN_PROGRESS = "inprog"
 	ERROR       = "err"
 )
@@ -34,6 +34,9 @@ type CycleRewardMetadata struct {
 	FeeRewards         int `json:"fr"`  // Rewards for all transaction fees included in our blocks
 
 	Status             string `json:"st"`  // One of: calculated, done, or in-progress
+
+	CalculatedLevel    int    `json:"cl"`  // Level at which rewards were calculated; 0 if before auto-pay
+	AutoPayHeld        string `json:"ah"`  // Why auto-pay left these payouts for manual approval
 }
 
 // GetPayoutsMetadataAll returns a map of CycleRewardsMetadata
@@ -90,6 +93,40 @@ func (p *PayoutsHandler) setCyclePayoutStatus(cycle int, status string) error {
 	return nil
 }
 
+// claimCyclePayouts marks calculated, or failed, payouts as in progress, in one transaction, so
+// however many callers try to send a cycle's payouts, only one goes ahead
+func (p *PayoutsHandler) claimCyclePayouts(cycle int) error {
+
+	return p.storage.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB_PAYOUTS_BUCKET)).Bucket(storage.Itob(cycle))
+		if b == nil {
+			return errors.Errorf("No payouts for cycle %d", cycle)
+		}
+
+		var metadata CycleRewardMetadata
+		if err := json.Unmarshal(b.Get([]byte(DB_METADATA)), &metadata); err != nil {
+			return errors.Wrap(err, "Unable to unmarshal cycle metadata")
+		}
+
+		switch metadata.Status {
+		case CALCULATED, ERROR:
+		case IN_PROGRESS, DONE:
+			return errors.Errorf("Payouts for cycle %d already sent", cycle)
+		default:
+			return errors.Errorf("No payouts for cycle %d", cycle)
+		}
+
+		metadata.Status = IN_PROGRESS
+
+		metadataBytes, err := json.Marshal(metadata)
+		if err != nil {
+			return errors.Wrap(err, "Unable to save reward metadata for cycle")
+		}
+
+		return b.Put([]byte(DB_METADATA), metadataBytes)
+	})
+}
+
 // GetRewardMetadataForCycle returns metadata struct for a single cycle
 func (p *PayoutsHandler) GetRewardMetadataForCycle(rewardCycle int) (CycleRewardMetadata, error) {
 
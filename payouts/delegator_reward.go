@@ -14,8 +14,25 @@ type DelegatorReward struct {
 	Delegator string  `json:"d"`
 	Balance   int     `json:"b"`
 	SharePct  float64 `json:"p"`
+	Share     int     `json:"s"` // Mutez, of the cycle's rewards, before the fee
 	Reward    int     `json:"r"`
 	OpHash    string  `json:"o"`
+
+	// As applied by the delegator's rule, if any
+	Fee       float64 `json:"f"`            // Percent
+	Payee     string  `json:"pe,omitempty"` // Redirected to, instead of Delegator
+	CarriedIn int     `json:"ci,omitempty"` // From earlier cycles, included in Reward
+	Withheld  int     `json:"w,omitempty"`  // Carried to a later cycle, instead of paid
+	Excluded  bool    `json:"x,omitempty"`
+	Note      string  `json:"n,omitempty"` // Why nothing is paid
+}
+
+// payee is where the reward is sent
+func (r DelegatorReward) payee() string {
+	if r.Payee != "" {
+		return r.Payee
+	}
+	return r.Delegator
 }
 
 func (p *PayoutsHandler) GetDelegatorRewardForCycle(address string, cycle int) (DelegatorReward, error) {
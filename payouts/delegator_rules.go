@@ -0,0 +1,221 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+
+	bolt "go.etcd.io/bbolt"
+
+	"bakinbacon/storage"
+)
+
+var addressRegex = regexp.MustCompile(`^(tz1|tz2|tz3|KT1)[1-9A-HJ-NP-Za-km-z]{33}$`)
+
+// DelegatorRule overrides how one delegator's reward is calculated and paid.
+// Rules are applied when payouts are calculated; Changing a rule does not change calculated cycles.
+type DelegatorRule struct {
+	Address    string   `json:"address"`
+	Fee        *float64 `json:"fee,omitempty"` // Percent; nil for the baker's fee
+	MinBalance int      `json:"minBalance"`    // Mutez, at the snapshot, to be paid at all
+	MinPayout  int      `json:"minPayout"`     // Mutez; Smaller rewards are carried to later cycles
+	Redirect   string   `json:"redirect"`      // Pay this address instead
+	Excluded   bool     `json:"excluded"`
+}
+
+func (r DelegatorRule) Validate() error {
+
+	switch {
+	case !addressRegex.MatchString(r.Address):
+		return errors.Errorf("Invalid delegator address '%s'", r.Address)
+	case r.Fee != nil && (*r.Fee < 0 || *r.Fee > 100):
+		return errors.New("Fee must be between 0 and 100")
+	case r.MinBalance < 0:
+		return errors.New("Minimum balance cannot be negative")
+	case r.MinPayout < 0:
+		return errors.New("Minimum payout cannot be negative")
+	case r.Redirect != "" && !addressRegex.MatchString(r.Redirect):
+		return errors.Errorf("Invalid redirect address '%s'", r.Redirect)
+	case r.Redirect == r.Address:
+		return errors.New("Cannot redirect to the delegator's own address")
+	}
+
+	return nil
+}
+
+// apply sets reward's Reward from its share of the cycle's rewards, less the fee, plus what
+// was carried in from earlier cycles. The zero rule applies bakerFee and pays everything.
+func (r DelegatorRule) apply(reward *DelegatorReward, share int, bakerFee float64, carriedIn int) {
+
+	reward.Share = share
+	reward.CarriedIn = carriedIn
+	reward.Payee = r.Redirect
+
+	reward.Fee = bakerFee
+	if r.Fee != nil {
+		reward.Fee = *r.Fee
+	}
+
+	// Nothing new is earned; Anything carried in stays withheld
+	switch {
+	case r.Excluded:
+		reward.Excluded = true
+		reward.Withheld = carriedIn
+		reward.Note = "Excluded"
+		return
+	case reward.Balance < r.MinBalance:
+		reward.Withheld = carriedIn
+		reward.Note = fmt.Sprintf("Balance below the minimum of %s", xtz(r.MinBalance))
+		return
+	}
+
+	net := netOfFee(share, reward.Fee) + carriedIn
+
+	if net < r.MinPayout {
+		reward.Withheld = net
+		reward.Note = fmt.Sprintf("Reward below the minimum payout of %s; Carried to the next cycle", xtz(r.MinPayout))
+		return
+	}
+
+	reward.Reward = net
+}
+
+func (p *PayoutsHandler) GetDelegatorRules() (map[string]DelegatorRule, error) {
+
+	rules := make(map[string]DelegatorRule)
+
+	err := p.storage.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(storage.DELEGATOR_RULES_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate delegator rules bucket")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var rule DelegatorRule
+			if err := json.Unmarshal(v, &rule); err != nil {
+				return errors.Wrap(err, "Unable to decode delegator rule")
+			}
+			rules[string(k)] = rule
+
+			return nil
+		})
+	})
+
+	return rules, err
+}
+
+func (p *PayoutsHandler) SaveDelegatorRule(rule DelegatorRule) error {
+
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	ruleBytes, err := json.Marshal(rule)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode delegator rule")
+	}
+
+	return p.storage.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(storage.DELEGATOR_RULES_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate delegator rules bucket")
+		}
+
+		return b.Put([]byte(rule.Address), ruleBytes)
+	})
+}
+
+func (p *PayoutsHandler) DeleteDelegatorRule(address string) error {
+
+	return p.storage.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(storage.DELEGATOR_RULES_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate delegator rules bucket")
+		}
+
+		return b.Delete([]byte(address))
+	})
+}
+
+// CarriedReward is what a delegator's latest calculated reward withheld, for a later cycle. These
+// are kept apart from the cycles' rewards, which retention prunes.
+type CarriedReward struct {
+	Cycle     int `json:"c"`  // Of the latest reward
+	CarriedIn int `json:"ci"` // Into Cycle, from earlier cycles
+	Withheld  int `json:"w"`
+}
+
+// getCarriedRewards returns, for each delegator, what was withheld in their latest reward before cycle.
+// Recalculating a cycle gets what was carried into it the first time.
+func (p *PayoutsHandler) getCarriedRewards(cycle int) (map[string]int, error) {
+
+	carried := make(map[string]int)
+
+	err := p.storage.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(storage.CARRIED_REWARDS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate carried rewards bucket")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var c CarriedReward
+			if err := json.Unmarshal(v, &c); err != nil {
+				return errors.Wrap(err, "Unable to decode carried reward")
+			}
+
+			switch {
+			case c.Cycle < cycle:
+				carried[string(k)] = c.Withheld
+			case c.Cycle == cycle:
+				carried[string(k)] = c.CarriedIn
+			}
+
+			return nil
+		})
+	})
+
+	return carried, err
+}
+
+// saveCarriedRewards records what each of cycle's rewards withheld, unless the delegator
+// has a reward from a later cycle
+func (p *PayoutsHandler) saveCarriedRewards(cycle int, rewards []DelegatorReward) error {
+
+	return p.storage.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(storage.CARRIED_REWARDS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate carried rewards bucket")
+		}
+
+		for _, r := range rewards {
+
+			var existing CarriedReward
+			if v := b.Get([]byte(r.Delegator)); v != nil {
+				if err := json.Unmarshal(v, &existing); err != nil {
+					return errors.Wrap(err, "Unable to decode carried reward")
+				}
+
+				if existing.Cycle > cycle {
+					continue
+				}
+			}
+
+			carriedBytes, err := json.Marshal(CarriedReward{
+				Cycle:     cycle,
+				CarriedIn: r.CarriedIn,
+				Withheld:  r.Withheld,
+			})
+			if err != nil {
+				return errors.Wrap(err, "Unable to encode carried reward")
+			}
+
+			if err := b.Put([]byte(r.Delegator), carriedBytes); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
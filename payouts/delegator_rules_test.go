@@ -0,0 +1,198 @@
+package payouts
+
+import (
+	"testing"
+
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+	"bakinbacon/util"
+)
+
+func TestDelegatorRuleApply(t *testing.T) {
+
+	fee := 5.0
+
+	for name, tc := range map[string]struct {
+		rule      DelegatorRule
+		carriedIn int
+		expected  DelegatorReward
+	}{
+		"default": {
+			expected: DelegatorReward{Reward: 90e6, Fee: 10},
+		},
+		"custom fee": {
+			rule:     DelegatorRule{Fee: &fee},
+			expected: DelegatorReward{Reward: 95e6, Fee: 5},
+		},
+		"zero fee": {
+			rule:     DelegatorRule{Fee: new(float64)},
+			expected: DelegatorReward{Reward: 100e6},
+		},
+		"redirect": {
+			rule:      DelegatorRule{Redirect: "tz1other"},
+			carriedIn: 5e6,
+			expected:  DelegatorReward{Reward: 95e6, Fee: 10, Payee: "tz1other", CarriedIn: 5e6},
+		},
+		"excluded": {
+			rule:      DelegatorRule{Excluded: true},
+			carriedIn: 5e6,
+			expected:  DelegatorReward{Fee: 10, CarriedIn: 5e6, Withheld: 5e6, Excluded: true, Note: "Excluded"},
+		},
+		"below min balance": {
+			rule:     DelegatorRule{MinBalance: 1000e6 + 1},
+			expected: DelegatorReward{Fee: 10, Note: "Balance below the minimum of 1000.000001 XTZ"},
+		},
+		"below min payout": {
+			rule:      DelegatorRule{MinPayout: 100e6},
+			carriedIn: 5e6,
+			expected:  DelegatorReward{Fee: 10, CarriedIn: 5e6, Withheld: 95e6, Note: "Reward below the minimum payout of 100.000000 XTZ; Carried to the next cycle"},
+		},
+		"carried to min payout": {
+			rule:      DelegatorRule{MinPayout: 100e6},
+			carriedIn: 10e6,
+			expected:  DelegatorReward{Reward: 100e6, Fee: 10, CarriedIn: 10e6},
+		},
+	} {
+
+		reward := DelegatorReward{Balance: 1000e6}
+		tc.expected.Balance = reward.Balance
+		tc.expected.Share = 100e6
+
+		tc.rule.apply(&reward, 100e6, 10, tc.carriedIn)
+
+		if reward != tc.expected {
+			t.Errorf("%s: %+v, expected %+v", name, reward, tc.expected)
+		}
+	}
+}
+
+func TestDelegatorRuleValidate(t *testing.T) {
+
+	const delegator = "tz1NortRftucvAkD1J58L32EhSVrQEWJCEnB"
+	fee := 101.0
+
+	for name, rule := range map[string]DelegatorRule{
+		"address":     {Address: "tz1abc"},
+		"fee":         {Address: delegator, Fee: &fee},
+		"min balance": {Address: delegator, MinBalance: -1},
+		"min payout":  {Address: delegator, MinPayout: -1},
+		"redirect":    {Address: delegator, Redirect: "tz4abc"},
+		"self":        {Address: delegator, Redirect: delegator},
+	} {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if err := (DelegatorRule{Address: delegator, Redirect: "KT1GFYUFQRT4RsNbuJ4MkCqtCB6fhaE4jPB8"}).Validate(); err != nil {
+		t.Errorf("Valid rule: %s", err)
+	}
+}
+
+func testPayoutsHandler(t *testing.T) *PayoutsHandler {
+
+	t.Helper()
+
+	db, err := storage.InitStorage(t.TempDir()+"/", util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	nc, _ := util.GetNetworkConstants(util.NETWORK_HANGZHOUNET)
+	nh, _ := notifications.NewHandler(db)
+
+	p, _ := NewPayoutsHandler(nil, db, nc, nh, false)
+
+	return p
+}
+
+func TestCarriedRewards(t *testing.T) {
+
+	p := testPayoutsHandler(t)
+
+	for _, cycle := range []int{4, 5, 6} {
+		rewards := map[int][]DelegatorReward{
+			4: {{Delegator: "tz1a", Withheld: 1e6}, {Delegator: "tz1b", Withheld: 2e6}},
+			5: {{Delegator: "tz1a", Reward: 3e6, CarriedIn: 1e6}},
+			6: {{Delegator: "tz1a", Withheld: 9e6}},
+		}[cycle]
+
+		if err := p.saveCarriedRewards(cycle, rewards); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tz1a was paid in cycle 5; tz1b has had no reward since cycle 4
+	carried, err := p.getCarriedRewards(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(carried) != 2 || carried["tz1a"] != 0 || carried["tz1b"] != 2e6 {
+		t.Errorf("Carried = %v", carried)
+	}
+
+	if carried, _ := p.getCarriedRewards(7); carried["tz1a"] != 9e6 || carried["tz1b"] != 2e6 {
+		t.Errorf("Carried into 7 = %v", carried)
+	}
+
+	// Saving an earlier cycle again doesn't replace later carried rewards
+	if err := p.saveCarriedRewards(5, []DelegatorReward{{Delegator: "tz1a", Reward: 3e6, CarriedIn: 1e6}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if carried, _ := p.getCarriedRewards(7); carried["tz1a"] != 9e6 {
+		t.Errorf("Carried into 7 = %v", carried)
+	}
+}
+
+// Retention prunes the cycles' rewards, but not what they carried forward
+func TestCarriedRewardsSurvivePruning(t *testing.T) {
+
+	p := testPayoutsHandler(t)
+
+	rule := DelegatorRule{MinPayout: 10e6}
+	rules := map[string]DelegatorRule{"tz1a": rule}
+	balances := map[string]int{"tz1a": 100e6}
+
+	// 6 XTZ net each cycle, below the minimum payout until the second
+	for cycle := 20; cycle <= 21; cycle++ {
+
+		if _, err := p.storage.Prune(cycle); err != nil {
+			t.Fatal(err)
+		}
+
+		carried, err := p.getCarriedRewards(cycle)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rewards, _ := calculateRewards(100e6, 1000e6, balances, 40, rules, carried)
+
+		for _, r := range rewards {
+			if err := p.SaveDelegatorReward(cycle, r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := p.saveCarriedRewards(cycle, rewards); err != nil {
+			t.Fatal(err)
+		}
+
+		switch cycle {
+		case 20:
+			if rewards[0].Reward != 0 || rewards[0].Withheld != 6e6 {
+				t.Fatalf("Cycle 20 = %+v", rewards[0])
+			}
+		case 21:
+			if rewards[0].Reward != 12e6 || rewards[0].CarriedIn != 6e6 || rewards[0].Withheld != 0 {
+				t.Fatalf("Cycle 21 = %+v", rewards[0])
+			}
+		}
+	}
+
+	if _, err := p.GetDelegatorRewardForCycle("tz1a", 20); err == nil {
+		t.Error("Cycle 20 was not pruned")
+	}
+}
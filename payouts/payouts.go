@@ -14,6 +14,8 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"bakinbacon/baconclient"
+	"bakinbacon/events"
+	"bakinbacon/metrics"
 	"bakinbacon/notifications"
 	"bakinbacon/storage"
 	"bakinbacon/util"
@@ -25,6 +27,14 @@ type PayoutsHandler struct {
 	storage       *storage.Storage
 	notifications *notifications.NotificationHandler
 	Disabled      bool
+
+	// Send calculated payouts without manual approval; See HandleAutoPayouts
+	AutoPay       AutoPayPolicy
+	autoPayMu     sync.Mutex
+
+	// Replaced in tests
+	spendableBalance func() (int, error)
+	sendPayouts      func(int) error
 }
 
 const (
@@ -40,18 +50,25 @@ const (
 
 	// DB
 	DB_PAYOUTS_BUCKET = "payouts"
-	DB_METADATA       = "metadata"
+	DB_METADATA       = storage.PAYOUTS_METADATA_KEY
 )
 
 func NewPayoutsHandler(bc *baconclient.BaconClient, db *storage.Storage, nc *util.NetworkConstants, nh *notifications.NotificationHandler, noPayouts bool) (*PayoutsHandler, error) {
 
-	return &PayoutsHandler{
+	p := &PayoutsHandler{
 		client:        bc,
 		constants:     nc,
 		storage:       db,
 		notifications: nh,
 		Disabled:      noPayouts,
-	}, nil
+	}
+
+	p.spendableBalance = func() (int, error) {
+		return p.client.GetSpendableBalance()
+	}
+	p.sendPayouts = p.SendCyclePayouts
+
+	return p, nil
 }
 
 // HandlePayouts At the beginning of each cycle, collect information about the baker's delegators and calculate
@@ -132,6 +149,7 @@ func (p *PayoutsHandler) HandlePayouts(ctx context.Context, wg *sync.WaitGroup,
 	cycleRewardMetadata.SnapshotIndex = chosenSnapshotIndex
 	cycleRewardMetadata.SnapshotLevel = snapshotLevel
 	cycleRewardMetadata.UnfrozenLevel = lastBlockUnfrozen
+	cycleRewardMetadata.CalculatedLevel = block.Metadata.Level.Level
 
 	log.WithFields(log.Fields{
 		"ThisCycle": thisCycle, "RewardCycle": payoutCycle,
@@ -166,8 +184,12 @@ func (p *PayoutsHandler) HandlePayouts(ctx context.Context, wg *sync.WaitGroup,
 		return
 	}
 
-	totalBakerRewards := float64(blockRewards + feeRewards)
-	bakerFeePct := float64(1 - (cycleRewardMetadata.BakerFee / 100))
+	if !(bakerFee >= 0 && bakerFee <= 100) {
+		log.WithField("BakerFee", bakerFee).Error("Baker fee must be between 0 and 100 for payouts")
+		return
+	}
+
+	totalBakerRewards := blockRewards + feeRewards
 
 	cycleRewardMetadata.BakerFee = bakerFee
 	cycleRewardMetadata.BlockRewards = blockRewards
@@ -214,12 +236,25 @@ func (p *PayoutsHandler) HandlePayouts(ctx context.Context, wg *sync.WaitGroup,
 	cycleRewardMetadata.NumDelegators = len(bakerInfo.DelegateContracts) - 1
 	cycleRewardMetadata.Status = CALCULATED
 
-	log.Infof("Baker Rewards Info: B: %d FB: %s SB: %d DB: %d TR: %.0f",
+	log.Infof("Baker Rewards Info: B: %d FB: %s SB: %d DB: %d TR: %d",
 		balance, bakerInfo.FrozenBalance, stakingBalance, delegatedBalance, totalBakerRewards)
 
-	stakeBalance64 := float64(stakingBalance)
+	// Per-delegator overrides, and rewards withheld from earlier cycles
+	rules, err := p.GetDelegatorRules()
+	if err != nil {
+		log.WithError(err).Error("Unable to get delegator rules for payouts")
+		return
+	}
+
+	carriedRewards, err := p.getCarriedRewards(payoutCycle)
+	if err != nil {
+		log.WithError(err).Error("Unable to get carried rewards for payouts")
+		return
+	}
 
 	// For each delegator, get their balance as of the snapshot block
+	delegatorBalances := make(map[string]int, len(bakerInfo.DelegateContracts))
+
 	for _, delegatorAddress := range bakerInfo.DelegateContracts {
 
 		// Skip ourselves
@@ -227,11 +262,6 @@ func (p *PayoutsHandler) HandlePayouts(ctx context.Context, wg *sync.WaitGroup,
 			continue
 		}
 
-		// Reward record
-		rewardRecord := DelegatorReward{
-			Delegator: delegatorAddress,
-		}
-
 		// Fetch delegator balance from RPC
 		delegatorInput := rpc.ContractBalanceInput{
 			BlockID: &snapshotBlockID,
@@ -252,23 +282,19 @@ func (p *PayoutsHandler) HandlePayouts(ctx context.Context, wg *sync.WaitGroup,
 			log.WithError(err).Error("Cannot parse delegator balance.")
 			return
 		}
-		rewardRecord.Balance = delegatorBalance
-
-		// There's a lot of int/float business going on here. This is golang issues around
-		// division and multiplication along with attempts to keep monetary calculations
-		// based in mutez (int)
+		delegatorBalances[delegatorAddress] = delegatorBalance
+	}
 
-		// Calculate delegator share %, rounded to 6 decimal places
-		rewardRecord.SharePct = math.Round((float64(delegatorBalance) / stakeBalance64) * 1000000) / 1000000
+	// Divide the rewards in mutez, subtracting the baker fee, or the delegator's own, and apply
+	// any other rules; See splitRewards for rounding
+	delegatorRewards, bakerShare := calculateRewards(totalBakerRewards, stakingBalance, delegatorBalances, bakerFee, rules, carriedRewards)
 
-		// Calculate delegator share of the rewards in mutez
-		rewardShareRevenue := int(totalBakerRewards * rewardRecord.SharePct)
+	log.WithField("BakerShare", bakerShare).Info("Baker share of rewards, before fees")
 
-		// Subtract baker fee
-		rewardRecord.Reward = int(float64(rewardShareRevenue) * bakerFeePct)
+	for _, rewardRecord := range delegatorRewards {
 
-		log.Infof("Delegator Rewards: D: %s, Bal: %d, SharePct: %.6f, RewardShareRev: %d, RewardShareNet: %.6f",
-			rewardRecord.Delegator, rewardRecord.Balance, rewardRecord.SharePct, rewardShareRevenue, float64(rewardRecord.Reward)/1e6)
+		log.Infof("Delegator Rewards: D: %s, Bal: %d, SharePct: %.6f, RewardShareRev: %d, RewardShareNet: %.6f, Withheld: %d",
+			rewardRecord.Delegator, rewardRecord.Balance, rewardRecord.SharePct, rewardRecord.Share, float64(rewardRecord.Reward)/1e6, rewardRecord.Withheld)
 
 		// Save reward record to DB
 		if err := p.SaveDelegatorReward(payoutCycle, rewardRecord); err != nil {
@@ -277,6 +303,11 @@ func (p *PayoutsHandler) HandlePayouts(ctx context.Context, wg *sync.WaitGroup,
 		}
 	}
 
+	if err := p.saveCarriedRewards(payoutCycle, delegatorRewards); err != nil {
+		log.WithError(err).Error("Unable to save carried rewards to DB. Aborting payouts.")
+		return
+	}
+
 	// No delegators to process? Then done!
 	if cycleRewardMetadata.NumDelegators == 0 {
 		cycleRewardMetadata.Status = DONE
@@ -292,6 +323,9 @@ func (p *PayoutsHandler) HandlePayouts(ctx context.Context, wg *sync.WaitGroup,
 	}
 
 	msg := fmt.Sprintf("Rewards calculations for cycle %d are complete. Use the UI to submit transactions.", payoutCycle)
+	if p.AutoPay.Enabled {
+		msg = fmt.Sprintf("Rewards calculations for cycle %d are complete. Payouts will be sent automatically in %d blocks, if they pass the auto-pay checks.", payoutCycle, p.AutoPay.Delay)
+	}
 	log.Info(msg)
 	p.notifications.SendNotification(msg, notifications.PAYOUTS)
 }
@@ -344,14 +378,16 @@ func (p *PayoutsHandler) getUnfrozenRewards(bakerAddress string, rewardCycle int
 // rewards for rewardCycle and send them to the node for injection.
 func (p *PayoutsHandler) SendCyclePayouts(rewardCycle int) error {
 
-	// Set payouts processing state
-	if err := p.setCyclePayoutStatus(rewardCycle, IN_PROGRESS); err != nil {
-		return errors.Wrap(err, "Cannot update rewards metadata status")
+	// Set payouts processing state; Payouts may be sent from the UI, Telegram or auto-pay,
+	// and this fails for all but the first
+	if err := p.claimCyclePayouts(rewardCycle); err != nil {
+		return err
 	}
 
 	// Need baker's public key hash to create transactions
 	_, pkh, err := p.client.Signer.GetPublicKey()
 	if err != nil {
+		p.failCyclePayouts(rewardCycle)
 		return errors.Wrap(err, "Cannot get public key for payouts")
 	}
 
@@ -366,6 +402,7 @@ func (p *PayoutsHandler) SendCyclePayouts(rewardCycle int) error {
 		log.WithError(err).WithFields(log.Fields{
 			"Request": resp.Request.URL, "Response": string(resp.Body()),
 		}).Error("Baker txn counter")
+		p.failCyclePayouts(rewardCycle)
 		return errors.Wrap(err, "Cannot get baker txn counter")
 	}
 
@@ -375,22 +412,42 @@ func (p *PayoutsHandler) SendCyclePayouts(rewardCycle int) error {
 	return nil
 }
 
+// failCyclePayouts allows payouts which could not be started to be sent again
+func (p *PayoutsHandler) failCyclePayouts(rewardCycle int) {
+	if err := p.setCyclePayoutStatus(rewardCycle, ERROR); err != nil {
+		log.WithError(err).Error("Unable to update cycle status to ERROR")
+	}
+}
+
 func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string, bakerTxnCounter int) {
 
 	// A batch bucket
 	var curBatch []rpc.Content
 
+	// Delegators paid in each batch, in the same order; Redirected payouts go to another address
+	var curDelegators []string
+
 	// Fetch individual reward data
-	rewardsData, err := p.GetDelegatorRewardAllForCycle(rewardsCycle)
+	allRewardsData, err := p.GetDelegatorRewardAllForCycle(rewardsCycle)
 	if err != nil {
 		log.WithError(err).Error("Cannot get payouts from DB")
 		return
 	}
 
+	// Rewards withheld by a rule have nothing to send; Those with an opHash were sent
+	// before an error, and are not sent again
+	rewardsData := make(map[string]DelegatorReward, len(allRewardsData))
+	for d, r := range allRewardsData {
+		if r.Reward > 0 && r.OpHash == "" {
+			rewardsData[d] = r
+		}
+	}
+
 	// How many batches do we need?
 	numRewardsData := len(rewardsData)
 	numBatches := int(math.Ceil(float64(numRewardsData) / TZ1_BATCH_SIZE))
 	txnBatches := make([][]rpc.Content, numBatches)
+	batchDelegators := make([][]string, numBatches)
 
 	log.WithFields(log.Fields{
 		"RewardCycle": rewardsCycle, "NumRewards": numRewardsData, "NumBatches": numBatches, "BatchSize": TZ1_BATCH_SIZE,
@@ -406,12 +463,13 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 		storageLimit := STORAGE_LIMIT
 		gasLimit     := GAS_LIMIT
 
-		// Need the current balance of the delegator. If balance is 0,
-		// we will deduct reactivation cost from their reward before sending
+		// Need the current balance of the delegator, or where their payout is redirected.
+		// If balance is 0, we will deduct reactivation cost from their reward before sending
+		payee := r.payee()
 
 		cbi := rpc.ContractBalanceInput{
 			BlockID:    &rpc.BlockIDHead{},
-			ContractID: r.Delegator,
+			ContractID: payee,
 		}
 
 		resp, _delegatorBalance, err := p.client.Current.ContractBalance(cbi)
@@ -442,7 +500,7 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 			txnFee += REACTIVATION_FEE
 
 			log.WithFields(log.Fields{
-				"D": r.Delegator, "B": delegatorBalance,
+				"D": r.Delegator, "P": payee, "B": delegatorBalance,
 			}).Trace("Delegator needs reactivation")
 		}
 
@@ -459,7 +517,7 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 			txn := rpc.Transaction{
 				Kind:         rpc.TRANSACTION,
 				Source:       bakerPkh,
-				Destination:  r.Delegator,
+				Destination:  payee,
 				Amount:       strconv.Itoa(delegatorNetReward),
 				Fee:          strconv.Itoa(txnFee),
 				GasLimit:     strconv.Itoa(gasLimit),
@@ -469,6 +527,7 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 
 			// Convert to generic 'content' before appending to batch
 			curBatch = append(curBatch, txn.ToContent())
+			curDelegators = append(curDelegators, r.Delegator)
 			curBatchSize++
 
 		} else {
@@ -483,7 +542,9 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 		if curBatchSize == TZ1_BATCH_SIZE || rewardsCounter == numRewardsData {
 
 			txnBatches[batchCounter] = curBatch
+			batchDelegators[batchCounter] = curDelegators
 			curBatch = nil
+			curDelegators = nil
 			curBatchSize = 0
 
 			// Go to next batch
@@ -504,12 +565,25 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 
 		// Now that we have all the batches, sign and send them
 
+		// Keep the web UI informed of progress
+		publishProgress := func(batch int, opHash, status string) {
+			p.client.Events.Publish(events.PAYOUT_PROGRESS, events.PayoutEvent{
+				Cycle:      rewardsCycle,
+				Batch:      batch,
+				NumBatches: numBatches,
+				OpHash:     opHash,
+				Status:     status,
+			})
+		}
+
 		for i, batch := range txnBatches {
 
 			// Forge the entire batch as 1 operation
 			batchOp, err := forge.Encode(p.client.HeadHash(), batch...)
 			if err != nil {
 				log.WithError(err).Error("Unable to forge-encode payouts batch")
+				metrics.PayoutBatches.WithLabelValues("error").Inc()
+				publishProgress(i+1, "", ERROR)
 				if err := p.setCyclePayoutStatus(rewardsCycle, ERROR); err != nil {
 					log.WithError(err).Error("Unable to update cycle status to ERROR")
 				}
@@ -520,6 +594,8 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 			signerResult, err := p.client.Signer.SignTransaction(batchOp)
 			if err != nil {
 				log.WithError(err).Error("Unable to sign payouts batch")
+				metrics.PayoutBatches.WithLabelValues("error").Inc()
+				publishProgress(i+1, "", ERROR)
 				if err := p.setCyclePayoutStatus(rewardsCycle, ERROR); err != nil {
 					log.WithError(err).Error("Unable to update cycle status to ERROR")
 				}
@@ -532,16 +608,21 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 			})
 			if err != nil {
 				log.WithError(err).Error("Failed to inject batch transaction")
+				metrics.PayoutBatches.WithLabelValues("error").Inc()
+				publishProgress(i+1, "", ERROR)
 				if err := p.setCyclePayoutStatus(rewardsCycle, ERROR); err != nil {
 					log.WithError(err).Error("Unable to update cycle status to ERROR")
 				}
 				return
 			}
 
+			metrics.PayoutBatches.WithLabelValues("injected").Inc()
+			publishProgress(i+1, opHash, IN_PROGRESS)
+
 			// Update database with opHash for each delegator reward
-			for _, c := range batch {
+			for j, c := range batch {
 
-				if err := p.updateDelegatorRewardOpHash(c.Destination, rewardsCycle, opHash); err != nil {
+				if err := p.updateDelegatorRewardOpHash(batchDelegators[i][j], rewardsCycle, opHash); err != nil {
 
 					log.WithError(err).WithFields(log.Fields{
 						"RewardCycle": rewardsCycle, "OpHash": opHash,
@@ -555,7 +636,7 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 				}
 
 				log.WithFields(log.Fields{
-					"D": c.Destination, "A": c.Amount, "F": c.Fee,
+					"D": batchDelegators[i][j], "P": c.Destination, "A": c.Amount, "F": c.Fee,
 				}).Debugf("Cycle %d Rewards Payout Batch #%d", rewardsCycle, i+1)
 			}
 
@@ -568,4 +649,11 @@ func (p *PayoutsHandler) createInjectRewards(rewardsCycle int, bakerPkh string,
 	if err := p.setCyclePayoutStatus(rewardsCycle, DONE); err != nil {
 		log.WithError(err).Error("Unable to update cycle status to DONE")
 	}
+
+	p.client.Events.Publish(events.PAYOUT_PROGRESS, events.PayoutEvent{
+		Cycle:      rewardsCycle,
+		Batch:      numBatches,
+		NumBatches: numBatches,
+		Status:     DONE,
+	})
 }
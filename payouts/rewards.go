@@ -0,0 +1,125 @@
+package payouts
+
+import (
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// Rewards are divided in integer mutez, using exact rationals:
+//
+//   1. Each delegator's share is rewards * balance / stakingBalance, rounded down. The mutez lost
+//      to rounding the shares together go back, one each, to the delegators whose shares lost the
+//      most, ties to the lowest address (the largest remainder method). What is left, the baker's
+//      own stake and anything under 1 mutez, stays with the baker.
+//   2. The fee is a percent of the share, rounded up; The delegator is paid the rest.
+//
+// So every share is within 1 mutez of exact, and payouts plus fees plus the baker's share always
+// equal the rewards.
+
+// splitRewards returns each delegator's share of rewards, in mutez, and what is left to the baker
+func splitRewards(rewards, stakingBalance int, balances map[string]int) (map[string]int, int) {
+
+	shares := make(map[string]int, len(balances))
+
+	if stakingBalance <= 0 || rewards <= 0 {
+		for d := range balances {
+			shares[d] = 0
+		}
+		return shares, rewards
+	}
+
+	type quota struct {
+		delegator string
+		remainder *big.Rat
+	}
+
+	quotas := make([]quota, 0, len(balances))
+	exact := new(big.Rat)
+	var allocated int
+
+	for d, balance := range balances {
+
+		q := new(big.Rat).SetFrac(
+			new(big.Int).Mul(big.NewInt(int64(rewards)), big.NewInt(int64(balance))),
+			big.NewInt(int64(stakingBalance)))
+
+		share := floor(q)
+		shares[d] = share
+		allocated += share
+
+		exact.Add(exact, q)
+		quotas = append(quotas, quota{d, q.Sub(q, new(big.Rat).SetInt64(int64(share)))})
+	}
+
+	sort.Slice(quotas, func(i, j int) bool {
+		if c := quotas[i].remainder.Cmp(quotas[j].remainder); c != 0 {
+			return c > 0
+		}
+		return quotas[i].delegator < quotas[j].delegator
+	})
+
+	// Fewer than one per delegator
+	for i := 0; i < floor(exact)-allocated; i++ {
+		shares[quotas[i].delegator]++
+	}
+
+	return shares, rewards - floor(exact)
+}
+
+// netOfFee returns share less feePct percent, with the fee rounded up
+func netOfFee(share int, feePct float64) int {
+
+	// The shortest decimal which is feePct, so 7.1 is 71/10 and not its binary approximation
+	fee, ok := new(big.Rat).SetString(strconv.FormatFloat(feePct, 'f', -1, 64))
+	if !ok {
+		return 0
+	}
+
+	net := new(big.Rat).Sub(big.NewRat(100, 1), fee)
+	net.Mul(net, new(big.Rat).SetInt64(int64(share)))
+	net.Quo(net, big.NewRat(100, 1))
+
+	return floor(net)
+}
+
+// calculateRewards divides rewards among delegators, and applies their rules. Rewards are in
+// the order of their delegators' addresses.
+func calculateRewards(rewards, stakingBalance int, balances map[string]int, bakerFee float64,
+	rules map[string]DelegatorRule, carriedRewards map[string]int) ([]DelegatorReward, int) {
+
+	shares, bakerShare := splitRewards(rewards, stakingBalance, balances)
+
+	delegators := make([]string, 0, len(balances))
+	for d := range balances {
+		delegators = append(delegators, d)
+	}
+	sort.Strings(delegators)
+
+	delegatorRewards := make([]DelegatorReward, 0, len(delegators))
+
+	for _, d := range delegators {
+
+		reward := DelegatorReward{
+			Delegator: d,
+			Balance:   balances[d],
+		}
+
+		// For display only, rounded to 6 decimal places
+		if stakingBalance > 0 {
+			pct, _ := new(big.Rat).SetFrac64(int64(balances[d]), int64(stakingBalance)).Float64()
+			reward.SharePct, _ = strconv.ParseFloat(strconv.FormatFloat(pct, 'f', 6, 64), 64)
+		}
+
+		rules[d].apply(&reward, shares[d], bakerFee, carriedRewards[d])
+
+		delegatorRewards = append(delegatorRewards, reward)
+	}
+
+	return delegatorRewards, bakerShare
+}
+
+// floor of a non-negative rational
+func floor(r *big.Rat) int {
+	return int(new(big.Int).Quo(r.Num(), r.Denom()).Int64())
+}
@@ -0,0 +1,188 @@
+package payouts
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestSplitRewards(t *testing.T) {
+
+	for name, tc := range map[string]struct {
+		rewards, stakingBalance int
+		balances                map[string]int
+		shares                  map[string]int
+		bakerShare              int
+	}{
+		"exact": {
+			rewards: 100, stakingBalance: 1000,
+			balances:   map[string]int{"tz1a": 500, "tz1b": 300},
+			shares:     map[string]int{"tz1a": 50, "tz1b": 30},
+			bakerShare: 20,
+		},
+		"largest remainder": {
+			rewards: 7, stakingBalance: 10,
+			balances: map[string]int{"tz1a": 5, "tz1b": 3, "tz1c": 2},
+			shares:   map[string]int{"tz1a": 4, "tz1b": 2, "tz1c": 1},
+		},
+		"ties to lowest address": {
+			rewards: 10, stakingBalance: 3,
+			balances: map[string]int{"tz1c": 1, "tz1b": 1, "tz1a": 1},
+			shares:   map[string]int{"tz1a": 4, "tz1b": 3, "tz1c": 3},
+		},
+		"rounding stays with baker": {
+			rewards: 100, stakingBalance: 1000,
+			balances:   map[string]int{"tz1a": 333, "tz1b": 333},
+			shares:     map[string]int{"tz1a": 33, "tz1b": 33},
+			bakerShare: 34,
+		},
+		"no overflow": {
+			rewards: 40000e6, stakingBalance: 9e15,
+			balances:   map[string]int{"tz1a": 3e15},
+			shares:     map[string]int{"tz1a": 13333333333},
+			bakerShare: 26666666667,
+		},
+		"no staking balance": {
+			rewards:    100,
+			balances:   map[string]int{"tz1a": 0},
+			shares:     map[string]int{"tz1a": 0},
+			bakerShare: 100,
+		},
+	} {
+
+		shares, bakerShare := splitRewards(tc.rewards, tc.stakingBalance, tc.balances)
+
+		if fmt.Sprint(shares) != fmt.Sprint(tc.shares) || bakerShare != tc.bakerShare {
+			t.Errorf("%s: %v, baker %d; expected %v, baker %d", name, shares, bakerShare, tc.shares, tc.bakerShare)
+		}
+	}
+}
+
+func TestNetOfFee(t *testing.T) {
+
+	for _, tc := range []struct {
+		share int
+		fee   float64
+		net   int
+	}{
+		{100, 10, 90},
+		{100, 7.1, 92},     // Fee of 7.1 rounds up
+		{1e6, 0.1, 999000}, // Exactly 0.1%
+		{3, 33.33, 2},
+		{0, 10, 0},
+		{1, 0, 1},
+		{1, 100, 0},
+		{123456789, 12.5, 108024690},
+	} {
+		if net := netOfFee(tc.share, tc.fee); net != tc.net {
+			t.Errorf("%d less %v%% = %d, expected %d", tc.share, tc.fee, net, tc.net)
+		}
+	}
+}
+
+// Whatever the balances, fees and rules, payouts plus fees equal the rewards
+func TestRewardsInvariant(t *testing.T) {
+
+	random := rand.New(rand.NewSource(1))
+	fees := []float64{0, 5, 7.1, 10, 12.5, 33.33, 100}
+
+	for i := 0; i < 500; i++ {
+
+		rewards := random.Intn(50000e6)
+		bakerFee := fees[random.Intn(len(fees))]
+
+		balances := make(map[string]int)
+		rules := make(map[string]DelegatorRule)
+		carried := make(map[string]int)
+
+		stakingBalance := random.Intn(1e13) // The baker's own
+
+		for d := random.Intn(60); d > 0; d-- {
+
+			delegator := fmt.Sprintf("tz1%04d", d)
+			balances[delegator] = random.Intn(1e12) + random.Intn(2)*random.Intn(1e3)
+			stakingBalance += balances[delegator]
+
+			if random.Intn(3) == 0 {
+				carried[delegator] = random.Intn(1e6)
+			}
+
+			switch random.Intn(6) {
+			case 0:
+				rules[delegator] = DelegatorRule{Excluded: true}
+			case 1:
+				rules[delegator] = DelegatorRule{MinBalance: random.Intn(1e12)}
+			case 2:
+				rules[delegator] = DelegatorRule{MinPayout: random.Intn(1e8)}
+			case 3:
+				fee := fees[random.Intn(len(fees))]
+				rules[delegator] = DelegatorRule{Fee: &fee}
+			}
+		}
+
+		delegatorRewards, bakerShare := calculateRewards(rewards, stakingBalance, balances, bakerFee, rules, carried)
+
+		if len(delegatorRewards) != len(balances) {
+			t.Fatalf("%d rewards for %d delegators", len(delegatorRewards), len(balances))
+		}
+
+		total := bakerShare
+
+		for _, r := range delegatorRewards {
+
+			// Within 1 mutez of the exact share
+			exact := new(big.Rat).SetFrac64(int64(r.Balance), int64(stakingBalance))
+			exact.Mul(exact, new(big.Rat).SetInt64(int64(rewards)))
+
+			diff := new(big.Rat).Sub(exact, new(big.Rat).SetInt64(int64(r.Share)))
+			if diff.Abs(diff).Cmp(big.NewRat(1, 1)) >= 0 {
+				t.Fatalf("%s: share %d, exact %s", r.Delegator, r.Share, exact.FloatString(6))
+			}
+
+			// Paid from this cycle's rewards, now or later
+			paid := r.Reward - r.CarriedIn + r.Withheld
+			fee := r.Share - paid
+
+			expectedFee := r.Share
+			if !r.Excluded && r.Balance >= rules[r.Delegator].MinBalance {
+				expectedFee = ceilFee(r.Share, r.Fee)
+			}
+
+			if fee != expectedFee || paid < 0 || r.Reward < 0 || r.Withheld < 0 {
+				t.Fatalf("%+v: paid %d, fee %d; expected fee %d", r, paid, fee, expectedFee)
+			}
+
+			total += paid + fee
+		}
+
+		if total != rewards {
+			t.Fatalf("Payouts, fees and baker share = %d; rewards = %d", total, rewards)
+		}
+	}
+}
+
+func ceilFee(share int, pct float64) int {
+
+	// Fees here have at most 2 decimal places
+	fee := new(big.Rat).SetFrac64(int64(share)*int64(math.Round(pct*100)), 100*100)
+
+	// Round up
+	n := new(big.Int).Quo(fee.Num(), fee.Denom())
+	if !fee.IsInt() {
+		n.Add(n, big.NewInt(1))
+	}
+
+	return int(n.Int64())
+}
+
+// The baker fee is always applied; It was once read from the cycle's metadata before being set
+func TestCalculateRewardsBakerFee(t *testing.T) {
+
+	rewards, bakerShare := calculateRewards(100e6, 1000e6, map[string]int{"tz1a": 500e6}, 10, nil, nil)
+
+	if len(rewards) != 1 || rewards[0].Share != 50e6 || rewards[0].Reward != 45e6 || rewards[0].Fee != 10 || bakerShare != 50e6 {
+		t.Errorf("Rewards = %+v, baker %d", rewards, bakerShare)
+	}
+}
@@ -1,13 +1,47 @@
 package main
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/pkg/errors"
 
 	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/baconclient"
+	"bakinbacon/storage"
 )
 
+const (
+	PREFETCH_WORKERS      = 8
+	PREFETCH_BATCH_SIZE   = 256
+	PREFETCH_RETRY_BUDGET = 256
+	PREFETCH_RETRY_DELAY  = 1 * time.Second
+)
+
+// Tracks rights fetches which are currently running to prevent
+// duplicate fetches of the same cycle
+var rightsFetches = struct {
+	sync.Mutex
+	inProgress map[string]bool
+}{
+	inProgress: make(map[string]bool),
+}
+
+// Rights fetched for a single level
+type levelRights struct {
+	level     int
+	endorsing []rpc.EndorsingRights
+	baking    []rpc.BakingRights
+}
+
+type rightsFetcher func(client *baconclient.BaconSlice, level int) (levelRights, error)
+type rightsSaver func(cycle int, fetchedLevels []int, rights []levelRights) error
+
 // Update BaconStatus with the most recent information from DB. This
 // is done to initialize BaconStatus with values, otherwise status does
 // not update until next bake/endorse.
@@ -95,6 +129,53 @@ func (bb *BakinBacon) updateCycleRightsStatus(metadataLevel rpc.Level) {
 			go bb.fetchBakingRights(metadataLevel, nextCycle)
 		}
 	}
+
+	// An interrupted fetch may have saved some rights, so the above won't restart it
+	for _, r := range []struct {
+		rightsType string
+		fetch      func(rpc.Level, int)
+	}{
+		{storage.ENDORSING_RIGHTS_BUCKET, bb.fetchEndorsingRights},
+		{storage.BAKING_RIGHTS_BUCKET, bb.fetchBakingRights},
+	} {
+		for _, cycle := range bb.interruptedRightsCycles(r.rightsType, metadataLevel) {
+			log.WithFields(log.Fields{
+				"Type": r.rightsType, "Cycle": cycle,
+			}).Debug("Resuming rights fetch")
+
+			go r.fetch(metadataLevel, cycle)
+		}
+	}
+}
+
+// interruptedRightsCycles returns the current or next cycle, if a fetch of its rights was
+// started but not completed, and isn't running now
+func (bb *BakinBacon) interruptedRightsCycles(rightsType string, metadataLevel rpc.Level) []int {
+
+	incompleteCycles, err := bb.Storage.GetIncompleteRightsCycles(rightsType)
+	if err != nil {
+		log.WithError(err).WithField("Type", rightsType).Error("Unable to get rights fetch progress")
+		return nil
+	}
+
+	cycles := make([]int, 0)
+
+	for _, cycle := range incompleteCycles {
+
+		if cycle != metadataLevel.Cycle && cycle != metadataLevel.Cycle + 1 {
+			continue
+		}
+
+		rightsFetches.Lock()
+		inProgress := rightsFetches.inProgress[rightsFetchKey(rightsType, cycle)]
+		rightsFetches.Unlock()
+
+		if !inProgress {
+			cycles = append(cycles, cycle)
+		}
+	}
+
+	return cycles
 }
 
 // Called on each new block; Only processes every 1024 blocks
@@ -127,6 +208,7 @@ func (bb *BakinBacon) fetchEndorsingRights(metadataLevel rpc.Level, cycleToFetch
 	//
 	// Instead, we make an insane number of fast RPCs to get rights
 	// per level for the reminder of this cycle, or for the next cycle.
+	// These are spread across a pool of workers and all healthy endpoints.
 
 	blocksPerCycle := bb.NetworkConstants.BlocksPerCycle
 
@@ -136,19 +218,7 @@ func (bb *BakinBacon) fetchEndorsingRights(metadataLevel rpc.Level, cycleToFetch
 		return
 	}
 
-	// Can't have more rights than blocks per cycle; set the
-	// capacity of the slice to avoid reallocation on append
-	allEndorsingRights := make([]rpc.EndorsingRights, 0, blocksPerCycle)
-
-	// Range from start to end, fetch rights per level
-	for level := levelToStart; level < levelToEnd; level++ {
-
-		// Chill on logging
-		if level % 256 == 0 {
-			log.WithFields(log.Fields{
-				"S": levelToStart, "L": level, "E": levelToEnd,
-			}).Trace("Fetched endorsing rights")
-		}
+	fetcher := func(client *baconclient.BaconSlice, level int) (levelRights, error) {
 
 		endorsingRightsFilter := rpc.EndorsingRightsInput{
 			BlockID:  &rpc.BlockIDHead{},
@@ -156,29 +226,45 @@ func (bb *BakinBacon) fetchEndorsingRights(metadataLevel rpc.Level, cycleToFetch
 			Delegate: bb.Signer.BakerPkh,
 		}
 
-		resp, endorsingRights, err := bb.Current.EndorsingRights(endorsingRightsFilter)
+		resp, endorsingRights, err := client.EndorsingRights(endorsingRightsFilter)
 		if err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"Request": resp.Request.URL, "Response": string(resp.Body()),
-			}).Error("Unable to fetch endorsing rights")
+			if resp != nil {
+				log.WithFields(log.Fields{
+					"Request": resp.Request.URL, "Response": string(resp.Body()),
+				}).Trace("Unable to fetch endorsing rights")
+			}
 
-			return
+			return levelRights{}, err
 		}
 
-		// Append this levels' rights, if exists
-		if len(endorsingRights) > 0 {
-			allEndorsingRights = append(allEndorsingRights, endorsingRights[0])
+		return levelRights{level: level, endorsing: endorsingRights}, nil
+	}
+
+	var numRights int
+
+	saver := func(cycle int, fetchedLevels []int, rights []levelRights) error {
+
+		allEndorsingRights := make([]rpc.EndorsingRights, 0, len(rights))
+
+		// Append each levels' rights, if exists
+		for _, r := range rights {
+			if len(r.endorsing) > 0 {
+				allEndorsingRights = append(allEndorsingRights, r.endorsing[0])
+			}
 		}
+
+		numRights += len(allEndorsingRights)
+
+		return bb.Storage.SaveEndorsingRightsForLevels(cycle, fetchedLevels, allEndorsingRights)
+	}
+
+	if !bb.fetchCycleRights(storage.ENDORSING_RIGHTS_BUCKET, cycleToFetch, levelToStart, levelToEnd, fetcher, saver) {
+		return
 	}
 
 	log.WithFields(log.Fields{
-		"Cycle": cycleToFetch, "LS": levelToStart, "LE": levelToEnd, "Num": len(allEndorsingRights),
+		"Cycle": cycleToFetch, "LS": levelToStart, "LE": levelToEnd, "Num": numRights,
 	}).Debug("Prefetched Endorsing Rights")
-
-	// Save rights to DB, even if len == 0 so that it is noted we queried this cycle
-	if err := bb.Storage.SaveEndorsingRightsForCycle(cycleToFetch, allEndorsingRights); err != nil {
-		log.WithError(err).Error("Unable to save endorsing rights for cycle")
-	}
 }
 
 func (bb *BakinBacon) fetchBakingRights(metadataLevel rpc.Level, cycleToFetch int) {
@@ -196,41 +282,279 @@ func (bb *BakinBacon) fetchBakingRights(metadataLevel rpc.Level, cycleToFetch in
 		return
 	}
 
-	allBakingRights := make([]rpc.BakingRights, 0, blocksPerCycle)
-
-	// Range from start to end, fetch rights per level
-	for level := levelToStart; level < levelToEnd; level++ {
+	fetcher := func(client *baconclient.BaconSlice, level int) (levelRights, error) {
 
 		bakingRightsFilter := rpc.BakingRightsInput{
-			BlockID:  &rpc.BlockIDHead{},
-			Level:    level,
-			Delegate: bb.Signer.BakerPkh,
+			BlockID:     &rpc.BlockIDHead{},
+			Level:       level,
+			Delegate:    bb.Signer.BakerPkh,
+			MaxPriority: MAX_BAKE_PRIORITY + 1,
 		}
 
-		resp, bakingRights, err := bb.Current.BakingRights(bakingRightsFilter)
+		resp, bakingRights, err := client.BakingRights(bakingRightsFilter)
 		if err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"Request": resp.Request.URL, "Response": string(resp.Body()),
-			}).Error("Unable to fetch next cycle baking rights")
+			if resp != nil {
+				log.WithFields(log.Fields{
+					"Request": resp.Request.URL, "Response": string(resp.Body()),
+				}).Trace("Unable to fetch baking rights")
+			}
 
-			return
+			return levelRights{}, err
 		}
 
-		// If have rights and priority is < max, append to slice
-		if len(bakingRights) > 0 && bakingRights[0].Priority < MAX_BAKE_PRIORITY {
-			allBakingRights = append(allBakingRights, bakingRights[0])
+		return levelRights{level: level, baking: bakingRights}, nil
+	}
+
+	var numRights int
+
+	saver := func(cycle int, fetchedLevels []int, rights []levelRights) error {
+
+		allBakingRights := make([]rpc.BakingRights, 0, len(rights))
+
+		// Keep every right of each level with priority up to max
+		for _, r := range rights {
+			for _, br := range r.baking {
+				if br.Priority <= MAX_BAKE_PRIORITY {
+					allBakingRights = append(allBakingRights, br)
+				}
+			}
 		}
+
+		numRights += len(allBakingRights)
+
+		return bb.Storage.SaveBakingRightsForLevels(cycle, fetchedLevels, allBakingRights)
+	}
+
+	if !bb.fetchCycleRights(storage.BAKING_RIGHTS_BUCKET, cycleToFetch, levelToStart, levelToEnd, fetcher, saver) {
+		return
 	}
 
 	// Got any rights?
 	log.WithFields(log.Fields{
-		"Cycle": cycleToFetch, "LS": levelToStart, "LE": levelToEnd, "Num": len(allBakingRights), "MaxPriority": MAX_BAKE_PRIORITY,
+		"Cycle": cycleToFetch, "LS": levelToStart, "LE": levelToEnd, "Num": numRights, "MaxPriority": MAX_BAKE_PRIORITY,
 	}).Info("Prefetched Baking Rights")
+}
 
-	// Save filtered rights to DB, even if len == 0 so that it is noted we queried this cycle
-	if err := bb.Storage.SaveBakingRightsForCycle(cycleToFetch, allBakingRights); err != nil {
-		log.WithError(err).Error("Unable to save baking rights for cycle")
+// fetchCycleRights fetches rights for each level in [levelToStart, levelToEnd) using a pool of
+// workers spread across all healthy RPC endpoints. Fetched levels are checkpointed to the DB in
+// batches so that an interrupted fetch resumes where it left off. Failed levels are retried on
+// the next endpoint until the retry budget is exhausted. Returns true only if every level of the
+// cycle has been fetched, at which point the cycle is marked complete in the DB.
+func (bb *BakinBacon) fetchCycleRights(rightsType string, cycle, levelToStart, levelToEnd int, fetch rightsFetcher, save rightsSaver) bool {
+
+	// Only one fetch per type/cycle at any time; new blocks will keep
+	// asking to fetch until the cycle is marked complete
+	fetchKey := rightsFetchKey(rightsType, cycle)
+
+	rightsFetches.Lock()
+	if rightsFetches.inProgress[fetchKey] {
+		rightsFetches.Unlock()
+		log.WithFields(log.Fields{
+			"Type": rightsType, "Cycle": cycle,
+		}).Trace("Rights fetch already in progress")
+
+		return false
 	}
+	rightsFetches.inProgress[fetchKey] = true
+	rightsFetches.Unlock()
+
+	defer func() {
+		rightsFetches.Lock()
+		delete(rightsFetches.inProgress, fetchKey)
+		rightsFetches.Unlock()
+	}()
+
+	// A later cycle may have completed while this one was interrupted, so always resume
+	// an incomplete fetch, regardless of the highest fetched cycle
+	incomplete, err := bb.Storage.IsRightsFetchIncomplete(rightsType, cycle)
+	if err != nil {
+		log.WithError(err).WithField("Type", rightsType).Error("Unable to get rights fetch progress")
+		return false
+	}
+
+	// Nothing to do if this cycle was previously fetched in full
+	highestFetchedCycle, err := bb.Storage.GetHighestFetchedRightsCycle(rightsType)
+	if err != nil {
+		log.WithError(err).WithField("Type", rightsType).Error("Unable to get highest fetched cycle")
+		return false
+	}
+
+	if !incomplete && highestFetchedCycle >= cycle {
+		log.WithFields(log.Fields{
+			"Type": rightsType, "Cycle": cycle,
+		}).Debug("Rights previously fetched for cycle")
+
+		return false
+	}
+
+	if err := bb.Storage.StartRightsFetch(rightsType, cycle); err != nil {
+		log.WithError(err).WithField("Type", rightsType).Error("Unable to save rights fetch progress")
+		return false
+	}
+
+	// Resume from previous checkpoint, if any
+	fetchedLevels, err := bb.Storage.GetFetchedRightsLevels(rightsType, cycle)
+	if err != nil {
+		log.WithError(err).WithField("Type", rightsType).Error("Unable to get rights fetch progress")
+		return false
+	}
+
+	levelsToFetch := make([]int, 0, levelToEnd-levelToStart)
+	for level := levelToStart; level < levelToEnd; level++ {
+		if !fetchedLevels[level] {
+			levelsToFetch = append(levelsToFetch, level)
+		}
+	}
+
+	clients := bb.HealthyClients()
+	if len(clients) == 0 {
+		log.WithField("Type", rightsType).Error("Unable to fetch rights; No healthy RPC endpoints")
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"Type": rightsType, "Cycle": cycle, "Remaining": len(levelsToFetch),
+		"Resumed": len(fetchedLevels), "Endpoints": len(clients),
+	}).Info("Fetching cycle rights")
+
+	levelsChan := make(chan int, len(levelsToFetch))
+	for _, l := range levelsToFetch {
+		levelsChan <- l
+	}
+	close(levelsChan)
+
+	var (
+		workersWg   sync.WaitGroup
+		abortOnce   sync.Once
+		retryBudget = int32(PREFETCH_RETRY_BUDGET)
+	)
+
+	resultsChan := make(chan levelRights, PREFETCH_WORKERS)
+	abortChan := make(chan struct{})
+	abort := func() {
+		abortOnce.Do(func() { close(abortChan) })
+	}
+
+	for w := 0; w < PREFETCH_WORKERS; w++ {
+
+		workersWg.Add(1)
+
+		go func(clientIdx int) {
+
+			defer workersWg.Done()
+
+			for level := range levelsChan {
+				for {
+					select {
+					case <-abortChan:
+						return
+					default:
+					}
+
+					client := clients[clientIdx%len(clients)]
+
+					rights, err := fetch(client, level)
+					if err == nil {
+						resultsChan <- rights
+						break
+					}
+
+					// Spend from the retry budget, and try again using the next endpoint
+					if atomic.AddInt32(&retryBudget, -1) < 0 {
+						log.WithError(err).WithFields(log.Fields{
+							"Type": rightsType, "Cycle": cycle, "Level": level,
+						}).Error("Rights fetch retry budget exhausted; Will resume later")
+
+						abort()
+
+						return
+					}
+
+					log.WithError(err).WithFields(log.Fields{
+						"Type": rightsType, "Level": level, "Endpoint": client.Host,
+					}).Warn("Unable to fetch rights; Retrying")
+
+					clientIdx++
+
+					select {
+					case <-abortChan:
+						return
+					case <-time.After(PREFETCH_RETRY_DELAY):
+					}
+				}
+			}
+		}(w)
+	}
+
+	go func() {
+		workersWg.Wait()
+		close(resultsChan)
+	}()
+
+	// Collect results from workers, checkpointing to DB in batches
+	var numSaved int
+	batch := make([]levelRights, 0, PREFETCH_BATCH_SIZE)
+
+	saveBatch := func() {
+
+		select {
+		case <-abortChan:
+			// Keep draining results, but don't save after a failure
+			return
+		default:
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		levels := make([]int, len(batch))
+		for i, r := range batch {
+			levels[i] = r.level
+		}
+
+		if err := save(cycle, levels, batch); err != nil {
+			log.WithError(err).WithField("Type", rightsType).Error("Unable to save rights for cycle")
+			abort()
+
+			return
+		}
+
+		numSaved += len(batch)
+		batch = batch[:0]
+
+		log.WithFields(log.Fields{
+			"Type": rightsType, "Cycle": cycle, "Saved": numSaved, "Total": len(levelsToFetch),
+		}).Trace("Fetched rights")
+	}
+
+	for r := range resultsChan {
+		batch = append(batch, r)
+		if len(batch) == PREFETCH_BATCH_SIZE {
+			saveBatch()
+		}
+	}
+	saveBatch()
+
+	// Only mark the cycle complete if every level was fetched and saved
+	if numSaved != len(levelsToFetch) {
+		log.WithFields(log.Fields{
+			"Type": rightsType, "Cycle": cycle, "Saved": numSaved, "Total": len(levelsToFetch),
+		}).Warn("Incomplete rights fetch; Will resume later")
+
+		return false
+	}
+
+	if err := bb.Storage.SetRightsFetchComplete(rightsType, cycle); err != nil {
+		log.WithError(err).WithField("Type", rightsType).Error("Unable to mark rights fetch complete")
+		return false
+	}
+
+	return true
+}
+
+func rightsFetchKey(rightsType string, cycle int) string {
+	return fmt.Sprintf("%s-%d", rightsType, cycle)
 }
 
 func levelToStartEnd(metadataLevel rpc.Level, blocksPerCycle, cycleToFetch int) (int, int, error) {
@@ -260,16 +584,5 @@ func levelToStartEnd(metadataLevel rpc.Level, blocksPerCycle, cycleToFetch int)
 }
 
 func (bb *BakinBacon) getCycleFromLevel(l int) int {
-
-	gal := bb.NetworkConstants.GranadaActivationLevel
-	gac := bb.NetworkConstants.GranadaActivationCycle
-
-	// If level is before Granada activation, calculation is simple
-	if l <= gal {
-		return int(l / bb.NetworkConstants.BlocksPerCycle)
-	}
-
-	// If level is after Granada activation, must take in to account the
-	// change in number of blocks per cycle
-	return int(((l - gal) / bb.NetworkConstants.BlocksPerCycle) + gac)
+	return bb.NetworkConstants.CycleFromLevel(l)
 }
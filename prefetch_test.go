@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	"bakinbacon/baconclient"
+	"bakinbacon/storage"
+	"bakinbacon/util"
+)
+
+// An interrupted fetch is resumed on the next block, even though some rights were saved,
+// and only fetches the levels it didn't save
+func TestRightsFetchResumes(t *testing.T) {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	nc, _ := util.GetNetworkConstants(util.NETWORK_HANGZHOUNET)
+
+	bb := &BakinBacon{
+		BaconClient:      &baconclient.BaconClient{Current: &baconclient.BaconSlice{}},
+		Storage:          db,
+		NetworkConstants: nc,
+	}
+
+	const cycle, levelToStart, levelToEnd = 10, 40961, 45058
+
+	var (
+		mu      sync.Mutex
+		fetched = make(map[int]int)
+		saves   int
+	)
+
+	fetcher := func(client *baconclient.BaconSlice, level int) (levelRights, error) {
+
+		mu.Lock()
+		fetched[level]++
+		mu.Unlock()
+
+		var endorsing []rpc.EndorsingRights
+		if level == levelToEnd-1 {
+			endorsing = []rpc.EndorsingRights{{Level: level, Slots: []int{1}}}
+		}
+
+		return levelRights{level: level, endorsing: endorsing}, nil
+	}
+
+	// Fails after the first batch is saved
+	saver := func(cycle int, fetchedLevels []int, rights []levelRights) error {
+
+		saves++
+		if saves == 2 {
+			return errors.New("Disk full")
+		}
+
+		var allEndorsingRights []rpc.EndorsingRights
+		for _, r := range rights {
+			allEndorsingRights = append(allEndorsingRights, r.endorsing...)
+		}
+
+		return db.SaveEndorsingRightsForLevels(cycle, fetchedLevels, allEndorsingRights)
+	}
+
+	if bb.fetchCycleRights(storage.ENDORSING_RIGHTS_BUCKET, cycle, levelToStart, levelToEnd, fetcher, saver) {
+		t.Fatal("Interrupted fetch reported complete")
+	}
+
+	saved, _ := db.GetFetchedRightsLevels(storage.ENDORSING_RIGHTS_BUCKET, cycle)
+	if len(saved) != PREFETCH_BATCH_SIZE {
+		t.Fatalf("Saved %d levels, expected %d", len(saved), PREFETCH_BATCH_SIZE)
+	}
+
+	// As on the next block, where a right is known, and a fetch isn't otherwise started
+	metadataLevel := rpc.Level{Level: levelToStart + 10, Cycle: cycle, CyclePosition: 10}
+
+	if cycles := bb.interruptedRightsCycles(storage.ENDORSING_RIGHTS_BUCKET, metadataLevel); len(cycles) != 1 || cycles[0] != cycle {
+		t.Fatalf("Interrupted cycles = %v", cycles)
+	}
+
+	if cycles := bb.interruptedRightsCycles(storage.BAKING_RIGHTS_BUCKET, metadataLevel); len(cycles) != 0 {
+		t.Fatalf("Interrupted baking cycles = %v", cycles)
+	}
+
+	if !bb.fetchCycleRights(storage.ENDORSING_RIGHTS_BUCKET, cycle, levelToStart, levelToEnd, fetcher, saver) {
+		t.Fatal("Resumed fetch incomplete")
+	}
+
+	for level := range saved {
+		if fetched[level] != 1 {
+			t.Fatalf("Saved level %d fetched %d times", level, fetched[level])
+		}
+	}
+
+	if len(fetched) != levelToEnd-levelToStart {
+		t.Errorf("Fetched %d levels, expected %d", len(fetched), levelToEnd-levelToStart)
+	}
+
+	if highest, _ := db.GetHighestFetchedRightsCycle(storage.ENDORSING_RIGHTS_BUCKET); highest != cycle {
+		t.Errorf("Highest fetched cycle = %d", highest)
+	}
+
+	if r, found, _ := db.GetEndorsingRight(levelToEnd - 1); !found || r.Cycle != cycle {
+		t.Errorf("Right = %+v, %v", r, found)
+	}
+
+	if cycles := bb.interruptedRightsCycles(storage.ENDORSING_RIGHTS_BUCKET, metadataLevel); len(cycles) != 0 {
+		t.Errorf("Interrupted cycles after completing = %v", cycles)
+	}
+}
+
+// The next cycle's prefetch completing doesn't stop this cycle's fetch from resuming
+func TestRightsFetchResumesAfterNextCycle(t *testing.T) {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", util.NETWORK_HANGZHOUNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	nc, _ := util.GetNetworkConstants(util.NETWORK_HANGZHOUNET)
+
+	bb := &BakinBacon{
+		BaconClient:      &baconclient.BaconClient{Current: &baconclient.BaconSlice{}},
+		Storage:          db,
+		NetworkConstants: nc,
+	}
+
+	const cycle, levelToStart, levelToEnd = 10, 40961, 45058
+
+	fetcher := func(client *baconclient.BaconSlice, level int) (levelRights, error) {
+		return levelRights{level: level}, nil
+	}
+
+	var failSave bool
+	saver := func(cycle int, fetchedLevels []int, rights []levelRights) error {
+		if failSave {
+			return errors.New("Disk full")
+		}
+		return db.SaveEndorsingRightsForLevels(cycle, fetchedLevels, nil)
+	}
+
+	failSave = true
+	if bb.fetchCycleRights(storage.ENDORSING_RIGHTS_BUCKET, cycle, levelToStart, levelToEnd, fetcher, saver) {
+		t.Fatal("Interrupted fetch reported complete")
+	}
+
+	// Prefetch of the next cycle completes
+	failSave = false
+	if !bb.fetchCycleRights(storage.ENDORSING_RIGHTS_BUCKET, cycle+1, levelToEnd, levelToEnd+4096, fetcher, saver) {
+		t.Fatal("Next cycle fetch incomplete")
+	}
+
+	metadataLevel := rpc.Level{Level: levelToStart + 10, Cycle: cycle, CyclePosition: 10}
+
+	if cycles := bb.interruptedRightsCycles(storage.ENDORSING_RIGHTS_BUCKET, metadataLevel); len(cycles) != 1 || cycles[0] != cycle {
+		t.Fatalf("Interrupted cycles = %v", cycles)
+	}
+
+	if !bb.fetchCycleRights(storage.ENDORSING_RIGHTS_BUCKET, cycle, levelToStart, levelToEnd, fetcher, saver) {
+		t.Fatal("Resumed fetch incomplete")
+	}
+
+	if cycles := bb.interruptedRightsCycles(storage.ENDORSING_RIGHTS_BUCKET, metadataLevel); len(cycles) != 0 {
+		t.Errorf("Interrupted cycles after completing = %v", cycles)
+	}
+
+	if highest, _ := db.GetHighestFetchedRightsCycle(storage.ENDORSING_RIGHTS_BUCKET); highest != cycle+1 {
+		t.Errorf("Highest fetched cycle = %d", highest)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RunPruner deletes data from cycles older than -retention-cycles, once each cycle
+func (bb *BakinBacon) RunPruner(shutdownChannel <-chan interface{}, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if bb.retentionCycles <= 0 {
+		return
+	}
+
+	// Nonces are revealed, and payouts made, for cycles this far back
+	keep := bb.retentionCycles
+	if minKeep := bb.Storage.MinRetentionCycles(); keep < minKeep {
+		log.WithFields(log.Fields{
+			"RetentionCycles": keep, "Minimum": minKeep,
+		}).Warn("Retention too short; Using minimum")
+		keep = minKeep
+	}
+
+	log.WithField("Cycles", keep).Info("Pruning of old data enabled")
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	prunedCycle := 0
+
+	for {
+
+		// Unknown until the first block is seen
+		if cycle := bb.BaconClient.Status.Cycle; cycle > 0 && cycle != prunedCycle {
+
+			before, deleted, err := bb.Storage.PruneKeeping(cycle, keep)
+			if err != nil {
+				log.WithError(err).Error("Unable to prune old data")
+			} else if before > 0 {
+				log.WithFields(log.Fields{
+					"BeforeCycle": before, "Deleted": deleted,
+				}).Info("Pruned old data")
+			}
+
+			prunedCycle = cycle
+		}
+
+		select {
+		case <-ticker.C:
+		case <-shutdownChannel:
+			return
+		}
+	}
+}
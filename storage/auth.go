@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// User is a login to the web UI/API. The password is stored as a bcrypt hash.
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"`
+	Role         string    `json:"role"`
+	Created      time.Time `json:"created"`
+}
+
+// Session is a logged-in web UI session, keyed by the hash of the session cookie
+type Session struct {
+	Username  string    `json:"username"`
+	CsrfToken string    `json:"csrfToken"`
+	Expires   time.Time `json:"expires"`
+}
+
+// ApiToken is a long-lived bearer token, keyed by the hash of the token
+type ApiToken struct {
+	Id      string    `json:"id"`
+	Name    string    `json:"name"`
+	Role    string    `json:"role"`
+	Created time.Time `json:"created"`
+}
+
+func (s *Storage) GetUser(username string) (User, bool, error) {
+
+	var user User
+	var found bool
+
+	err := s.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(USERS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate users bucket")
+		}
+
+		userBytes := b.Get([]byte(username))
+		if userBytes == nil {
+			return nil
+		}
+
+		found = true
+
+		return json.Unmarshal(userBytes, &user)
+	})
+
+	return user, found, err
+}
+
+func (s *Storage) GetUsers() ([]User, error) {
+
+	users := make([]User, 0)
+
+	err := s.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(USERS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate users bucket")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var user User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return errors.Wrapf(err, "Unable to unmarshal user %s", k)
+			}
+
+			users = append(users, user)
+
+			return nil
+		})
+	})
+
+	return users, err
+}
+
+func (s *Storage) SaveUser(user User) error {
+
+	userBytes, err := json.Marshal(user)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal user")
+	}
+
+	return s.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(USERS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate users bucket")
+		}
+
+		return b.Put([]byte(user.Username), userBytes)
+	})
+}
+
+// DeleteUser removes the user and any of their sessions
+func (s *Storage) DeleteUser(username string) error {
+
+	if err := s.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(USERS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate users bucket")
+		}
+
+		return b.Delete([]byte(username))
+	}); err != nil {
+		return err
+	}
+
+	return s.DeleteUserSessions(username, "")
+}
+
+// GetSession returns the session stored under sessionKey. Expired sessions are
+// deleted and reported as not found.
+func (s *Storage) GetSession(sessionKey string) (Session, bool, error) {
+
+	var session Session
+	var found bool
+
+	err := s.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(SESSIONS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate sessions bucket")
+		}
+
+		sessionBytes := b.Get([]byte(sessionKey))
+		if sessionBytes == nil {
+			return nil
+		}
+
+		found = true
+
+		return json.Unmarshal(sessionBytes, &session)
+	})
+	if err != nil || !found {
+		return session, false, err
+	}
+
+	if time.Now().After(session.Expires) {
+		return session, false, s.DeleteSession(sessionKey)
+	}
+
+	return session, true, nil
+}
+
+func (s *Storage) SaveSession(sessionKey string, session Session) error {
+
+	sessionBytes, err := json.Marshal(session)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal session")
+	}
+
+	return s.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(SESSIONS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate sessions bucket")
+		}
+
+		return b.Put([]byte(sessionKey), sessionBytes)
+	})
+}
+
+func (s *Storage) DeleteSession(sessionKey string) error {
+
+	return s.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(SESSIONS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate sessions bucket")
+		}
+
+		return b.Delete([]byte(sessionKey))
+	})
+}
+
+// DeleteUserSessions logs out all sessions belonging to username, except the one under
+// exceptKey, if not empty. Expired sessions of any user are also cleaned up.
+func (s *Storage) DeleteUserSessions(username, exceptKey string) error {
+
+	return s.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(SESSIONS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate sessions bucket")
+		}
+
+		var toDelete [][]byte
+
+		now := time.Now()
+
+		if err := b.ForEach(func(k, v []byte) error {
+			var session Session
+			if string(k) == exceptKey && exceptKey != "" {
+				return nil
+			}
+
+			if err := json.Unmarshal(v, &session); err != nil || session.Username == username || now.After(session.Expires) {
+				toDelete = append(toDelete, k)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Cannot delete while iterating
+		for _, k := range toDelete {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Storage) GetApiToken(tokenKey string) (ApiToken, bool, error) {
+
+	var token ApiToken
+	var found bool
+
+	err := s.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(TOKENS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate tokens bucket")
+		}
+
+		tokenBytes := b.Get([]byte(tokenKey))
+		if tokenBytes == nil {
+			return nil
+		}
+
+		found = true
+
+		return json.Unmarshal(tokenBytes, &token)
+	})
+
+	return token, found, err
+}
+
+func (s *Storage) GetApiTokens() ([]ApiToken, error) {
+
+	tokens := make([]ApiToken, 0)
+
+	err := s.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(TOKENS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate tokens bucket")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var token ApiToken
+			if err := json.Unmarshal(v, &token); err != nil {
+				return errors.Wrap(err, "Unable to unmarshal API token")
+			}
+
+			tokens = append(tokens, token)
+
+			return nil
+		})
+	})
+
+	return tokens, err
+}
+
+func (s *Storage) SaveApiToken(tokenKey string, token ApiToken) error {
+
+	tokenBytes, err := json.Marshal(token)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal API token")
+	}
+
+	return s.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(TOKENS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate tokens bucket")
+		}
+
+		return b.Put([]byte(tokenKey), tokenBytes)
+	})
+}
+
+// DeleteApiToken revokes the token with the public id
+func (s *Storage) DeleteApiToken(id string) error {
+
+	return s.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUTH_BUCKET)).Bucket([]byte(TOKENS_BUCKET))
+		if b == nil {
+			return errors.New("Unable to locate tokens bucket")
+		}
+
+		var tokenKey []byte
+
+		if err := b.ForEach(func(k, v []byte) error {
+			var token ApiToken
+			if err := json.Unmarshal(v, &token); err == nil && token.Id == id {
+				tokenKey = k
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if tokenKey == nil {
+			return errors.Errorf("Unknown API token %s", id)
+		}
+
+		return b.Delete(tokenKey)
+	})
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	bolt "go.etcd.io/bbolt"
+
+	"bakinbacon/util"
+)
+
+const (
+	// Version of the export document; Changes when its layout changes, not the schema
+	EXPORT_FORMAT = 1
+)
+
+// Export is a portable JSON copy of the whole DB. Restoring it gives an identical DB.
+type Export struct {
+	Format        int            `json:"format"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Created       time.Time      `json:"created"`
+	Buckets       []ExportBucket `json:"buckets"`
+}
+
+type ExportBucket struct {
+	Name     ExportBytes    `json:"name"`
+	Sequence uint64         `json:"sequence,omitempty"`
+	Items    []ExportItem   `json:"items,omitempty"`
+	Buckets  []ExportBucket `json:"buckets,omitempty"`
+}
+
+type ExportItem struct {
+	Key   ExportBytes `json:"key"`
+	Value ExportBytes `json:"value"`
+}
+
+// ExportBytes are written as a plain string when printable, which covers JSON values and
+// most names, otherwise as "0x" followed by hex, such as Itob keys
+type ExportBytes []byte
+
+func (e ExportBytes) MarshalJSON() ([]byte, error) {
+
+	s := string(e)
+	if !isPrintable(s) || strings.HasPrefix(s, "0x") {
+		s = "0x" + hex.EncodeToString(e)
+	}
+
+	return json.Marshal(s)
+}
+
+func (e *ExportBytes) UnmarshalJSON(b []byte) error {
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(s, "0x") {
+		d, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return errors.Wrapf(err, "Invalid hex %q", s)
+		}
+		*e = d
+		return nil
+	}
+
+	*e = []byte(s)
+
+	return nil
+}
+
+func isPrintable(s string) bool {
+
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Backup writes a consistent copy of the DB to w. Runs in a read transaction, so
+// baking and endorsing continue while it is written.
+func (s *Storage) Backup(w io.Writer) (int64, error) {
+
+	var n int64
+
+	err := s.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+
+	return n, err
+}
+
+// BackupToFile writes a consistent copy of the DB to a new file
+func (s *Storage) BackupToFile(path string) error {
+	return s.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+}
+
+// Export writes the whole DB to w as JSON. Like Backup, it runs in a read transaction, and
+// writes each top-level bucket as it is read, rather than holding the whole DB in memory.
+func (s *Storage) Export(w io.Writer) error {
+
+	return s.View(func(tx *bolt.Tx) error {
+
+		created, err := json.Marshal(time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		bw := bufio.NewWriter(w)
+
+		// The layout of Export, written a bucket at a time
+		fmt.Fprintf(bw, "{\n  \"format\": %d,\n  \"schemaVersion\": %d,\n  \"created\": %s,\n  \"buckets\": [",
+			EXPORT_FORMAT, getSchemaVersion(tx), created)
+
+		separator := "\n    "
+
+		if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+
+			bucketBytes, err := json.MarshalIndent(exportBucket(name, b), "    ", "  ")
+			if err != nil {
+				return errors.Wrap(err, "Unable to encode bucket")
+			}
+
+			bw.WriteString(separator)
+			separator = ",\n    "
+
+			_, err = bw.Write(bucketBytes)
+
+			return err
+		}); err != nil {
+			return errors.Wrap(err, "Unable to export database")
+		}
+
+		bw.WriteString("\n  ]\n}\n")
+
+		return bw.Flush()
+	})
+}
+
+func exportBucket(name []byte, b *bolt.Bucket) ExportBucket {
+
+	eb := ExportBucket{
+		Name:     append(ExportBytes{}, name...),
+		Sequence: b.Sequence(),
+	}
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+
+		// Nil values are nested buckets
+		if v == nil {
+			eb.Buckets = append(eb.Buckets, exportBucket(k, b.Bucket(k)))
+			continue
+		}
+
+		eb.Items = append(eb.Items, ExportItem{
+			Key:   append(ExportBytes{}, k...),
+			Value: append(ExportBytes{}, v...),
+		})
+	}
+
+	return eb
+}
+
+// RestoreToFile creates a new DB at path from src, which is either a backup made by
+// Backup, or a JSON document made by Export. The restored DB is not migrated, nor
+// checked; Open it with InitStorage to do so.
+func RestoreToFile(src, path string) error {
+
+	if _, err := os.Stat(path); err == nil {
+		return errors.Errorf("%s already exists", path)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "Unable to open backup")
+	}
+	defer f.Close()
+
+	// Exports are JSON objects; Anything else should be a DB file
+	r := bufio.NewReader(f)
+
+	start, err := r.Peek(64)
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "Unable to read backup")
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(start), []byte("{")) {
+		return restoreExport(r, path)
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create database")
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return errors.Wrap(err, "Unable to copy backup")
+	}
+
+	if err := out.Close(); err != nil {
+		return errors.Wrap(err, "Unable to copy backup")
+	}
+
+	// Make sure it really is a DB
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		os.Remove(path)
+		return errors.Wrap(err, "Backup is not a valid database or export")
+	}
+
+	return db.Close()
+}
+
+func restoreExport(r io.Reader, path string) error {
+
+	var export Export
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return errors.Wrap(err, "Unable to decode export")
+	}
+
+	if export.Format != EXPORT_FORMAT {
+		return errors.Errorf("Unsupported export format %d", export.Format)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errors.Wrap(err, "Unable to create database")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, eb := range export.Buckets {
+
+			b, err := tx.CreateBucket(eb.Name)
+			if err != nil {
+				return errors.Wrapf(err, "Unable to create %s bucket", eb.Name)
+			}
+
+			if err := importBucket(b, eb); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path)
+		return errors.Wrap(err, "Unable to restore export")
+	}
+
+	return nil
+}
+
+func importBucket(b *bolt.Bucket, eb ExportBucket) error {
+
+	for _, item := range eb.Items {
+		if err := b.Put(item.Key, item.Value); err != nil {
+			return err
+		}
+	}
+
+	for _, nested := range eb.Buckets {
+
+		nb, err := b.CreateBucket(nested.Name)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to create %s bucket", nested.Name)
+		}
+
+		if err := importBucket(nb, nested); err != nil {
+			return err
+		}
+	}
+
+	// Last, as Put does not change it, but NextSequence would
+	return b.SetSequence(eb.Sequence)
+}
+
+// OpenReadOnly opens an existing DB without migrating it, eg to inspect it while
+// deciding whether to replace it. Fails with bolt.ErrTimeout if the DB is in use.
+func OpenReadOnly(dataDir, network string) (*Storage, error) {
+
+	networkConstants, err := util.GetNetworkConstants(network)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(dataDir+DATABASE_FILE, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open db")
+	}
+
+	return &Storage{DB: db, networkConstants: networkConstants}, nil
+}
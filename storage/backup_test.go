@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The export is written a bucket at a time, and must restore to the same DB
+func TestExportRestores(t *testing.T) {
+
+	s, err := InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.RecordBakedBlock(1234, "BLockHash"); err != nil {
+		t.Fatal(err)
+	}
+
+	var export bytes.Buffer
+	if err := s.Export(&export); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Export
+	if err := json.Unmarshal(export.Bytes(), &decoded); err != nil {
+		t.Fatalf("Export is not JSON: %v", err)
+	}
+
+	if decoded.Format != EXPORT_FORMAT || decoded.SchemaVersion != SCHEMA_VERSION || len(decoded.Buckets) == 0 {
+		t.Fatalf("Export = %+v", decoded)
+	}
+
+	path := filepath.Join(t.TempDir(), DATABASE_FILE)
+	if err := restoreExport(&export, path); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(BAKING_BUCKET))
+		if b == nil || b.Sequence() != 1234 || string(b.Get(Itob(1234))) != "BLockHash" {
+			t.Error("Baked block not restored")
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -18,6 +18,8 @@ const (
 	SIGNER_SK       = "signersk"
 	BAKER_FEE       = "bakerfee"
 	UI_EXPLORER     = "uiexplorer"
+
+	NOTIFICATION_ROUTING = "notifrouting"
 )
 
 func (s *Storage) GetBakerSettings() (map[string]interface{}, error) {
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DIGEST_DAY_SENT   = "digestday"   // Date, YYYY-MM-DD, of the last daily digest
+	DIGEST_CYCLE_SENT = "digestcycle" // Cycle of the last per-cycle digest
+)
+
+// GetDigestsSent returns when the last daily and per-cycle digests were sent, so
+// a restart does not repeat them
+func (s *Storage) GetDigestsSent() (string, int, error) {
+
+	var (
+		day   string
+		cycle int
+	)
+
+	err := s.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(CONFIG_BUCKET))
+		day = string(b.Get([]byte(DIGEST_DAY_SENT)))
+		cycle = Btoi(b.Get([]byte(DIGEST_CYCLE_SENT)))
+
+		return nil
+	})
+
+	return day, cycle, err
+}
+
+func (s *Storage) SetDigestDaySent(day string) error {
+	return s.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CONFIG_BUCKET)).Put([]byte(DIGEST_DAY_SENT), []byte(day))
+	})
+}
+
+func (s *Storage) SetDigestCycleSent(cycle int) error {
+	return s.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CONFIG_BUCKET)).Put([]byte(DIGEST_CYCLE_SENT), Itob(cycle))
+	})
+}
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Outcomes of a right
+	OUTCOME_BAKED    = "baked"
+	OUTCOME_ENDORSED = "endorsed"
+	OUTCOME_MISSED   = "missed"
+	OUTCOME_STOLEN   = "stolen" // Baked by a lower priority
+	OUTCOME_LOST     = "lost"   // Injected, but not part of the chain
+
+	// Reasons for a missed right
+	REASON_SIGNER    = "signer"
+	REASON_LOW_BOND  = "lowbond"
+	REASON_PREAPPLY  = "preapply"
+	REASON_LATE      = "late"
+	REASON_RPC       = "rpc"
+	REASON_FORGE     = "forge"
+	REASON_POW       = "pow"
+	REASON_INJECTION = "injection"
+	REASON_UNKNOWN   = "unknown" // No record of what happened; not running?
+
+	// Results of post-inclusion verification
+	VERIFIED_INCLUDED = "included" // Operation found in a block, or block is canonical
+	VERIFIED_MISSING  = "missing"  // Operation not found in any block
+	VERIFIED_ORPHANED = "orphaned" // Block not part of the canonical chain
+)
+
+// RightOutcome is the record of what happened to a single baking or endorsing right
+type RightOutcome struct {
+	Level     int       `json:"level"`
+	Cycle     int       `json:"cycle"`
+	Priority  int       `json:"priority"`
+	NumSlots  int       `json:"numslots"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+	Verified  string    `json:"verified,omitempty"`
+	Timestamp time.Time `json:"ts"`
+}
+
+// CycleSummary is the performance of the baker for a single cycle
+type CycleSummary struct {
+	Cycle int `json:"cycle"`
+
+	BakingRights int            `json:"bakingrights"`
+	Baked        int            `json:"baked"`
+	BakesMissed  map[string]int `json:"bakesmissed"` // Outcome/reason -> count
+	BakingEff    float64        `json:"bakingeff"`
+
+	EndorsingRights int            `json:"endorsingrights"`
+	EndorsingSlots  int            `json:"endorsingslots"`
+	Endorsed        int            `json:"endorsed"`
+	EndorsedSlots   int            `json:"endorsedslots"`
+	EndorsesMissed  map[string]int `json:"endorsesmissed"` // Reason -> count
+	EndorsingEff    float64        `json:"endorsingeff"`
+}
+
+func (s *Storage) RecordBakingOutcome(outcome RightOutcome) error {
+	return s.recordOutcome(BAKING_RIGHTS_BUCKET, outcome)
+}
+
+func (s *Storage) RecordEndorsingOutcome(outcome RightOutcome) error {
+	return s.recordOutcome(ENDORSING_RIGHTS_BUCKET, outcome)
+}
+
+func (s *Storage) GetBakingOutcome(level int) (RightOutcome, error) {
+	return s.getOutcome(BAKING_RIGHTS_BUCKET, level)
+}
+
+func (s *Storage) GetEndorsingOutcome(level int) (RightOutcome, error) {
+	return s.getOutcome(ENDORSING_RIGHTS_BUCKET, level)
+}
+
+// GetBakingOutcomesForCycle returns all baking outcomes for a cycle, keyed by level
+func (s *Storage) GetBakingOutcomesForCycle(cycle int) (map[int]RightOutcome, error) {
+	return s.getOutcomesForCycle(BAKING_RIGHTS_BUCKET, cycle)
+}
+
+// GetEndorsingOutcomesForCycle returns all endorsing outcomes for a cycle, keyed by level
+func (s *Storage) GetEndorsingOutcomesForCycle(cycle int) (map[int]RightOutcome, error) {
+	return s.getOutcomesForCycle(ENDORSING_RIGHTS_BUCKET, cycle)
+}
+
+// GetCycleSummary compares the stored rights for a cycle against the recorded outcomes.
+// Rights at, or below curLevel which have no outcome are counted as missed for an
+// unknown reason; rights above curLevel have not happened yet and are not counted.
+func (s *Storage) GetCycleSummary(cycle, curLevel int) (CycleSummary, error) {
+
+	summary := CycleSummary{
+		Cycle:          cycle,
+		BakesMissed:    make(map[string]int),
+		EndorsesMissed: make(map[string]int),
+	}
+
+	bakingRights, err := s.GetBakingLevelsForCycle(cycle)
+	if err != nil {
+		return summary, err
+	}
+
+	bakingOutcomes, err := s.GetBakingOutcomesForCycle(cycle)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, r := range bakingRights {
+
+		if r.Level > curLevel {
+			continue
+		}
+
+		summary.BakingRights++
+
+		o, ok := bakingOutcomes[r.Level]
+		switch {
+		case !ok:
+			summary.BakesMissed[REASON_UNKNOWN]++
+		case o.Outcome == OUTCOME_BAKED:
+			summary.Baked++
+		case o.Outcome == OUTCOME_MISSED:
+			summary.BakesMissed[o.Reason]++
+		default:
+			summary.BakesMissed[o.Outcome]++
+		}
+	}
+
+	endorsingRights, err := s.GetEndorsingRightsForCycle(cycle)
+	if err != nil {
+		return summary, err
+	}
+
+	endorsingOutcomes, err := s.GetEndorsingOutcomesForCycle(cycle)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, r := range endorsingRights {
+
+		if r.Level > curLevel {
+			continue
+		}
+
+		summary.EndorsingRights++
+		summary.EndorsingSlots += r.NumSlots
+
+		o, ok := endorsingOutcomes[r.Level]
+		switch {
+		case !ok:
+			summary.EndorsesMissed[REASON_UNKNOWN]++
+		case o.Outcome == OUTCOME_ENDORSED && o.Verified == VERIFIED_MISSING:
+			summary.EndorsesMissed[VERIFIED_MISSING]++
+		case o.Outcome == OUTCOME_ENDORSED:
+			summary.Endorsed++
+			summary.EndorsedSlots += r.NumSlots
+		case o.Outcome == OUTCOME_MISSED:
+			summary.EndorsesMissed[o.Reason]++
+		default:
+			summary.EndorsesMissed[o.Outcome]++
+		}
+	}
+
+	if summary.BakingRights > 0 {
+		summary.BakingEff = float64(summary.Baked) / float64(summary.BakingRights)
+	}
+
+	if summary.EndorsingSlots > 0 {
+		summary.EndorsingEff = float64(summary.EndorsedSlots) / float64(summary.EndorsingSlots)
+	} else if summary.EndorsingRights > 0 {
+		summary.EndorsingEff = float64(summary.Endorsed) / float64(summary.EndorsingRights)
+	}
+
+	return summary, nil
+}
+
+// GetUnverifiedBakingOutcomes returns all injected blocks, from fromLevel onwards,
+// which have not been verified as part of the canonical chain
+func (s *Storage) GetUnverifiedBakingOutcomes(fromLevel int) ([]RightOutcome, error) {
+	return s.getUnverifiedOutcomes(BAKING_RIGHTS_BUCKET, fromLevel)
+}
+
+// GetUnverifiedEndorsingOutcomes returns all injected endorsements, from fromLevel
+// onwards, which have not been verified as included in a block
+func (s *Storage) GetUnverifiedEndorsingOutcomes(fromLevel int) ([]RightOutcome, error) {
+	return s.getUnverifiedOutcomes(ENDORSING_RIGHTS_BUCKET, fromLevel)
+}
+
+func (s *Storage) getUnverifiedOutcomes(rightsType string, fromLevel int) ([]RightOutcome, error) {
+
+	outcomes := make([]RightOutcome, 0)
+
+	if fromLevel < 0 {
+		fromLevel = 0
+	}
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(HISTORY_BUCKET)).Bucket([]byte(rightsType))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		for k, v := c.Seek(Itob(fromLevel)); k != nil; k, v = c.Next() {
+
+			var outcome RightOutcome
+			if err := json.Unmarshal(v, &outcome); err != nil {
+				return errors.Wrap(err, "Unable to decode outcome")
+			}
+
+			// Only operations which were injected can be verified
+			if outcome.Hash != "" && outcome.Verified == "" {
+				outcomes = append(outcomes, outcome)
+			}
+		}
+
+		return nil
+	})
+
+	return outcomes, err
+}
+
+func (s *Storage) recordOutcome(rightsType string, outcome RightOutcome) error {
+
+	if outcome.Timestamp.IsZero() {
+		outcome.Timestamp = time.Now().UTC()
+	}
+
+	outcomeBytes, err := json.Marshal(outcome)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode outcome")
+	}
+
+	return s.Update(func(tx *bolt.Tx) error {
+
+		b, err := tx.Bucket([]byte(HISTORY_BUCKET)).CreateBucketIfNotExists([]byte(rightsType))
+		if err != nil {
+			return errors.Wrap(err, "Unable to create history bucket")
+		}
+
+		return b.Put(Itob(outcome.Level), outcomeBytes)
+	})
+}
+
+func (s *Storage) getOutcome(rightsType string, level int) (RightOutcome, error) {
+
+	var outcome RightOutcome
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(HISTORY_BUCKET)).Bucket([]byte(rightsType))
+		if b == nil {
+			return nil
+		}
+
+		outcomeBytes := b.Get(Itob(level))
+		if outcomeBytes == nil {
+			return nil
+		}
+
+		if err := json.Unmarshal(outcomeBytes, &outcome); err != nil {
+			return errors.Wrap(err, "Unable to decode outcome")
+		}
+
+		return nil
+	})
+
+	return outcome, err
+}
+
+func (s *Storage) getOutcomesForCycle(rightsType string, cycle int) (map[int]RightOutcome, error) {
+
+	outcomes := make(map[int]RightOutcome)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(HISTORY_BUCKET)).Bucket([]byte(rightsType))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		for k, v := c.Seek(Itob(s.networkConstants.FirstLevelOfCycle(cycle))); k != nil; k, v = c.Next() {
+
+			var outcome RightOutcome
+			if err := json.Unmarshal(v, &outcome); err != nil {
+				return errors.Wrap(err, "Unable to decode outcome")
+			}
+
+			if outcome.Cycle > cycle {
+				break
+			}
+
+			if outcome.Cycle == cycle {
+				outcomes[outcome.Level] = outcome
+			}
+		}
+
+		return nil
+	})
+
+	return outcomes, err
+}
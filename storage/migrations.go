@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"bakinbacon/util"
+)
+
+const (
+	META_BUCKET        = "meta"
+	SCHEMA_VERSION_KEY = "schemaversion"
+)
+
+// Each migration upgrades the DB schema by one version, and runs in its own transaction
+// together with the version bump. Migrations are only ever appended to this list; once
+// released, a migration must never change.
+var migrations = []struct {
+	description string
+	migrate     func(tx *bolt.Tx, nc *util.NetworkConstants) error
+}{
+	{"Create initial buckets", migrateInitialBuckets},
+	{"Convert legacy rights values to JSON", migrateLegacyRights},
+	{"Create notification outbox and history buckets", migrateNotificationOutbox},
+	{"Create delegator payout rules and carried rewards buckets", migrateDelegatorRules},
+}
+
+// SCHEMA_VERSION is the schema version this binary expects
+var SCHEMA_VERSION = len(migrations)
+
+// migrate brings the DB up to SCHEMA_VERSION, taking a backup first. Refuses to continue if
+// the DB was created by a newer binary, as older code may misread or damage newer data.
+func migrate(db *bolt.DB, nc *util.NetworkConstants) error {
+
+	var (
+		version int
+		isNew   bool
+	)
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		version = getSchemaVersion(tx)
+		isNew = tx.Bucket([]byte(CONFIG_BUCKET)) == nil
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if version > SCHEMA_VERSION {
+		return errors.Errorf("Database schema version %d is newer than this version of BakinBacon supports (%d); "+
+			"Upgrade BakinBacon, or restore a backup made by this version", version, SCHEMA_VERSION)
+	}
+
+	if version == SCHEMA_VERSION {
+		return nil
+	}
+
+	// Nothing to lose in a brand new DB
+	if !isNew {
+
+		backupFile := fmt.Sprintf("%s.v%d-%s.bak", db.Path(), version, time.Now().UTC().Format("20060102150405"))
+
+		if err := db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(backupFile, 0600)
+		}); err != nil {
+			return errors.Wrap(err, "Unable to back up database before migrating")
+		}
+
+		log.WithFields(log.Fields{
+			"From": version, "To": SCHEMA_VERSION, "Backup": backupFile,
+		}).Info("Migrating database")
+	}
+
+	for v := version; v < SCHEMA_VERSION; v++ {
+
+		m := migrations[v]
+
+		if err := db.Update(func(tx *bolt.Tx) error {
+
+			if err := m.migrate(tx, nc); err != nil {
+				return err
+			}
+
+			return setSchemaVersion(tx, v+1)
+
+		}); err != nil {
+			return errors.Wrapf(err, "Migration to schema version %d (%s) failed", v+1, m.description)
+		}
+
+		log.WithFields(log.Fields{
+			"Version": v + 1, "Migration": m.description,
+		}).Debug("Applied database migration")
+	}
+
+	return nil
+}
+
+// Un-versioned databases, from before migrations, are version 0
+func getSchemaVersion(tx *bolt.Tx) int {
+
+	b := tx.Bucket([]byte(META_BUCKET))
+	if b == nil {
+		return 0
+	}
+
+	return Btoi(b.Get([]byte(SCHEMA_VERSION_KEY)))
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+
+	b, err := tx.CreateBucketIfNotExists([]byte(META_BUCKET))
+	if err != nil {
+		return errors.Wrap(err, "Cannot create meta bucket")
+	}
+
+	return b.Put([]byte(SCHEMA_VERSION_KEY), Itob(version))
+}
+
+// GetSchemaVersion returns the schema version of the open DB
+func (s *Storage) GetSchemaVersion() (int, error) {
+
+	var version int
+
+	err := s.View(func(tx *bolt.Tx) error {
+		version = getSchemaVersion(tx)
+		return nil
+	})
+
+	return version, err
+}
+
+// 1; Buckets which, before versioning, were created on every startup
+func migrateInitialBuckets(tx *bolt.Tx, nc *util.NetworkConstants) error {
+
+	// Config bucket
+	cfgBkt, err := tx.CreateBucketIfNotExists([]byte(CONFIG_BUCKET))
+	if err != nil {
+		return errors.Wrap(err, "Cannot create config bucket")
+	}
+
+	// Nested buckets inside config
+	for _, n := range []string{ENDPOINTS_BUCKET, NOTIFICATIONS_BUCKET} {
+		if _, err := cfgBkt.CreateBucketIfNotExists([]byte(n)); err != nil {
+			return errors.Wrapf(err, "Cannot create %s bucket", n)
+		}
+	}
+
+	// Root buckets
+	for _, n := range []string{ENDORSING_BUCKET, BAKING_BUCKET, NONCE_BUCKET, RIGHTS_BUCKET, PAYOUTS_BUCKET, HISTORY_BUCKET} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(n)); err != nil {
+			return errors.Wrapf(err, "Cannot create %s bucket", n)
+		}
+	}
+
+	authBkt, err := tx.CreateBucketIfNotExists([]byte(AUTH_BUCKET))
+	if err != nil {
+		return errors.Wrap(err, "Cannot create auth bucket")
+	}
+
+	// Nested buckets inside auth
+	for _, n := range []string{USERS_BUCKET, SESSIONS_BUCKET, TOKENS_BUCKET} {
+		if _, err := authBkt.CreateBucketIfNotExists([]byte(n)); err != nil {
+			return errors.Wrapf(err, "Cannot create %s bucket", n)
+		}
+	}
+
+	return nil
+}
+
+// 2; Older versions stored only Itob(cycle) for endorsing rights, and Itob(priority)
+// for baking rights. The cycle of a baking right is derived from its level.
+func migrateLegacyRights(tx *bolt.Tx, nc *util.NetworkConstants) error {
+
+	rightsBkt := tx.Bucket([]byte(RIGHTS_BUCKET))
+
+	if b := rightsBkt.Bucket([]byte(ENDORSING_RIGHTS_BUCKET)); b != nil {
+		if err := rewriteLegacyValues(b, func(k, v []byte) (interface{}, error) {
+			return EndorsingRight{
+				Level: Btoi(k),
+				Cycle: Btoi(v),
+			}, nil
+		}); err != nil {
+			return errors.Wrap(err, "Unable to convert endorsing rights")
+		}
+	}
+
+	if b := rightsBkt.Bucket([]byte(BAKING_RIGHTS_BUCKET)); b != nil {
+		if err := rewriteLegacyValues(b, func(k, v []byte) (interface{}, error) {
+			return BakingRight{
+				Level:    Btoi(k),
+				Cycle:    nc.CycleFromLevel(Btoi(k)),
+				Priority: Btoi(v),
+			}, nil
+		}); err != nil {
+			return errors.Wrap(err, "Unable to convert baking rights")
+		}
+	}
+
+	return nil
+}
+
+// 3; Notifications waiting to be delivered, and the outcome of those delivered
+func migrateNotificationOutbox(tx *bolt.Tx, nc *util.NetworkConstants) error {
+
+	for _, n := range []string{OUTBOX_BUCKET, NOTIFICATION_HISTORY_BUCKET} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(n)); err != nil {
+			return errors.Wrapf(err, "Cannot create %s bucket", n)
+		}
+	}
+
+	return nil
+}
+
+// 4; Per-delegator payout rules, and rewards carried to later cycles, keyed by address
+func migrateDelegatorRules(tx *bolt.Tx, nc *util.NetworkConstants) error {
+
+	for _, n := range []string{DELEGATOR_RULES_BUCKET, CARRIED_REWARDS_BUCKET} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(n)); err != nil {
+			return errors.Wrapf(err, "Cannot create %s bucket", n)
+		}
+	}
+
+	return nil
+}
+
+// rewriteLegacyValues replaces each 8-byte value in b with the JSON of convert's result
+func rewriteLegacyValues(b *bolt.Bucket, convert func(k, v []byte) (interface{}, error)) error {
+
+	// Cannot modify a bucket while iterating it
+	legacy := make(map[string][]byte)
+
+	if err := b.ForEach(func(k, v []byte) error {
+		if len(v) == 8 {
+			legacy[string(k)] = append([]byte{}, v...)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for k, v := range legacy {
+
+		converted, err := convert([]byte(k), v)
+		if err != nil {
+			return err
+		}
+
+		convertedBytes, err := json.Marshal(converted)
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put([]byte(k), convertedBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
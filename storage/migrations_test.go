@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets every migrated DB has, as paths from the root
+var migratedBuckets = [][]string{
+	{CONFIG_BUCKET, ENDPOINTS_BUCKET},
+	{CONFIG_BUCKET, NOTIFICATIONS_BUCKET},
+	{ENDORSING_BUCKET},
+	{BAKING_BUCKET},
+	{NONCE_BUCKET},
+	{RIGHTS_BUCKET},
+	{PAYOUTS_BUCKET},
+	{HISTORY_BUCKET},
+	{AUTH_BUCKET, USERS_BUCKET},
+	{AUTH_BUCKET, SESSIONS_BUCKET},
+	{AUTH_BUCKET, TOKENS_BUCKET},
+	{OUTBOX_BUCKET},
+	{NOTIFICATION_HISTORY_BUCKET},
+	{DELEGATOR_RULES_BUCKET},
+	{CARRIED_REWARDS_BUCKET},
+	{META_BUCKET},
+}
+
+func checkMigrated(t *testing.T, s *Storage) {
+
+	t.Helper()
+
+	if version, err := s.GetSchemaVersion(); err != nil || version != SCHEMA_VERSION {
+		t.Errorf("Schema version %d, %v; expected %d", version, err, SCHEMA_VERSION)
+	}
+
+	if err := s.View(func(tx *bolt.Tx) error {
+		for _, path := range migratedBuckets {
+			if bucketAt(tx, path) == nil {
+				t.Errorf("No %s bucket", strings.Join(path, "/"))
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func bucketAt(tx *bolt.Tx, path []string) *bolt.Bucket {
+
+	b := tx.Bucket([]byte(path[0]))
+	for _, p := range path[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket([]byte(p))
+	}
+
+	return b
+}
+
+func backups(t *testing.T, dir string) []string {
+
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, DATABASE_FILE+".v*.bak"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestMigrateNewDB(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s, err := InitStorage(dir+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkMigrated(t, s)
+
+	// Nothing to back up
+	if files := backups(t, dir); len(files) != 0 {
+		t.Errorf("Backups of a new DB: %v", files)
+	}
+}
+
+// A DB from before schema versions, with only the buckets created then, and legacy rights
+func TestMigrateBaselineDB(t *testing.T) {
+
+	dir := t.TempDir()
+
+	db, err := bolt.Open(filepath.Join(dir, DATABASE_FILE), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+
+		cfg, _ := tx.CreateBucket([]byte(CONFIG_BUCKET))
+		endpoints, _ := cfg.CreateBucket([]byte(ENDPOINTS_BUCKET))
+		_, _ = cfg.CreateBucket([]byte(NOTIFICATIONS_BUCKET))
+
+		if _, err := endpoints.NextSequence(); err != nil {
+			return err
+		}
+		_ = endpoints.Put(Itob(1), []byte("http://127.0.0.1:8732"))
+
+		for _, n := range []string{ENDORSING_BUCKET, NONCE_BUCKET, PAYOUTS_BUCKET} {
+			if _, err := tx.CreateBucket([]byte(n)); err != nil {
+				return err
+			}
+		}
+
+		baking, _ := tx.CreateBucket([]byte(BAKING_BUCKET))
+		_ = baking.SetSequence(40000)
+		_ = baking.Put(Itob(40000), []byte("BLockHash"))
+
+		rights, _ := tx.CreateBucket([]byte(RIGHTS_BUCKET))
+		endorsingRights, _ := rights.CreateBucket([]byte(ENDORSING_RIGHTS_BUCKET))
+		bakingRights, _ := rights.CreateBucket([]byte(BAKING_RIGHTS_BUCKET))
+
+		_ = endorsingRights.Put(Itob(41000), Itob(10))
+		return bakingRights.Put(Itob(41001), Itob(2))
+
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := InitStorage(dir+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkMigrated(t, s)
+
+	if r, found, err := s.GetEndorsingRight(41000); err != nil || !found || r.Cycle != 10 {
+		t.Errorf("Endorsing right %+v, %v, %v", r, found, err)
+	}
+
+	if r, found, err := s.GetBakingRight(41001); err != nil || !found || r.Priority != 2 || r.Cycle != 10 {
+		t.Errorf("Baking right %+v, %v, %v", r, found, err)
+	}
+
+	if w, err := s.GetBakingWatermark(); err != nil || w != 40000 {
+		t.Errorf("Baking watermark %d, %v", w, err)
+	}
+
+	// Backed up before migrating, as it was
+	files := backups(t, dir)
+	if len(files) != 1 || !strings.Contains(files[0], ".v0-") {
+		t.Fatalf("Backups %v", files)
+	}
+
+	backup, err := bolt.Open(files[0], 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+
+	if err := backup.View(func(tx *bolt.Tx) error {
+
+		if getSchemaVersion(tx) != 0 || tx.Bucket([]byte(HISTORY_BUCKET)) != nil {
+			t.Error("Backup was migrated")
+		}
+
+		if v := bucketAt(tx, []string{RIGHTS_BUCKET, ENDORSING_RIGHTS_BUCKET}).Get(Itob(41000)); Btoi(v) != 10 {
+			t.Errorf("Backup endorsing right %x", v)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Already migrated; Not backed up again
+	s.Close()
+
+	s2, err := InitStorage(dir+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+
+	if files := backups(t, dir); len(files) != 1 {
+		t.Errorf("Backups %v", files)
+	}
+}
+
+// Older code may misread or damage a newer schema, so refuses to open it
+func TestMigrateNewerDB(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s, err := InitStorage(dir+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Update(func(tx *bolt.Tx) error {
+		return setSchemaVersion(tx, SCHEMA_VERSION+1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if s, err := InitStorage(dir+"/", "hangzhounet"); err == nil {
+		s.Close()
+		t.Fatal("Opened a DB with a newer schema")
+	} else if !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Error = %v", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, DATABASE_FILE), 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := getSchemaVersion(tx); v != SCHEMA_VERSION+1 {
+			t.Errorf("Schema version changed to %d", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := backups(t, dir); len(files) != 0 {
+		t.Errorf("Backups %v", files)
+	}
+}
@@ -34,3 +34,25 @@ func (s *Storage) SaveNotifiersConfig(notifier string, config []byte) error {
 		return b.Put([]byte(notifier), config)
 	})
 }
+
+// GetNotificationRouting returns the JSON routing rules, or nil if never saved
+func (s *Storage) GetNotificationRouting() ([]byte, error) {
+
+	var routing []byte
+
+	err := s.View(func(tx *bolt.Tx) error {
+		// Only valid during the transaction
+		if v := tx.Bucket([]byte(CONFIG_BUCKET)).Get([]byte(NOTIFICATION_ROUTING)); v != nil {
+			routing = append([]byte{}, v...)
+		}
+		return nil
+	})
+
+	return routing, err
+}
+
+func (s *Storage) SaveNotificationRouting(routing []byte) error {
+	return s.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CONFIG_BUCKET)).Put([]byte(NOTIFICATION_ROUTING), routing)
+	})
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Outcomes of a notification
+	NOTIFICATION_SENT       = "sent"
+	NOTIFICATION_FAILED     = "failed"
+	NOTIFICATION_SUPPRESSED = "suppressed"
+
+	// Older delivery records are deleted as new ones are added
+	NOTIFICATION_HISTORY_MAX = 1000
+)
+
+// OutboxEntry is a notification waiting to be delivered by one notifier
+type OutboxEntry struct {
+	Id           int             `json:"id"`
+	Notifier     string          `json:"notifier"`
+	Notification json.RawMessage `json:"notification"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  time.Time       `json:"nextattempt"`
+	LastError    string          `json:"lasterror,omitempty"`
+	Delivered    []string        `json:"delivered,omitempty"` // Chats or rooms already sent to, which retries skip
+	Created      time.Time       `json:"created"`
+}
+
+// NotificationRecord is the outcome of a notification
+type NotificationRecord struct {
+	Id        int       `json:"id"`
+	Notifier  string    `json:"notifier,omitempty"` // Empty when suppressed, as no notifier was chosen
+	Category  string    `json:"category"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"ts"`
+}
+
+// AddToOutbox saves a new entry, setting its Id
+func (s *Storage) AddToOutbox(entry *OutboxEntry) error {
+
+	return s.Update(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(OUTBOX_BUCKET))
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return errors.Wrap(err, "Unable to get outbox sequence")
+		}
+
+		entry.Id = int(id)
+
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			return errors.Wrap(err, "Unable to encode outbox entry")
+		}
+
+		return b.Put(Itob(entry.Id), entryBytes)
+	})
+}
+
+// UpdateOutboxEntry saves an entry after a failed attempt
+func (s *Storage) UpdateOutboxEntry(entry OutboxEntry) error {
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode outbox entry")
+	}
+
+	return s.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(OUTBOX_BUCKET)).Put(Itob(entry.Id), entryBytes)
+	})
+}
+
+// GetOutbox returns all undelivered entries, oldest first
+func (s *Storage) GetOutbox() ([]OutboxEntry, error) {
+
+	entries := make([]OutboxEntry, 0)
+
+	err := s.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(OUTBOX_BUCKET)).ForEach(func(k, v []byte) error {
+
+			var entry OutboxEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return errors.Wrap(err, "Unable to decode outbox entry")
+			}
+
+			entries = append(entries, entry)
+
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+// CompleteOutboxEntry removes an entry from the outbox and records its outcome, together,
+// so a restart can neither lose nor repeat the record
+func (s *Storage) CompleteOutboxEntry(id int, record NotificationRecord) error {
+
+	return s.Update(func(tx *bolt.Tx) error {
+
+		if err := tx.Bucket([]byte(OUTBOX_BUCKET)).Delete(Itob(id)); err != nil {
+			return err
+		}
+
+		return addNotificationRecord(tx, record)
+	})
+}
+
+func (s *Storage) AddNotificationRecord(record NotificationRecord) error {
+	return s.Update(func(tx *bolt.Tx) error {
+		return addNotificationRecord(tx, record)
+	})
+}
+
+func addNotificationRecord(tx *bolt.Tx, record NotificationRecord) error {
+
+	b := tx.Bucket([]byte(NOTIFICATION_HISTORY_BUCKET))
+
+	id, err := b.NextSequence()
+	if err != nil {
+		return errors.Wrap(err, "Unable to get notification history sequence")
+	}
+
+	record.Id = int(id)
+
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now().UTC()
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode notification record")
+	}
+
+	if err := b.Put(Itob(record.Id), recordBytes); err != nil {
+		return err
+	}
+
+	// Ids are sequential, so anything at or below this is too old
+	oldest := record.Id - NOTIFICATION_HISTORY_MAX
+
+	// Cannot modify a bucket while iterating it
+	var expired [][]byte
+
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && Btoi(k) <= oldest; k, _ = c.Next() {
+		expired = append(expired, append([]byte{}, k...))
+	}
+
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetNotificationHistory returns up to limit records, newest first, optionally only those with status
+func (s *Storage) GetNotificationHistory(limit int, status string) ([]NotificationRecord, error) {
+
+	records := make([]NotificationRecord, 0)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		c := tx.Bucket([]byte(NOTIFICATION_HISTORY_BUCKET)).Cursor()
+
+		for k, v := c.Last(); k != nil && len(records) < limit; k, v = c.Prev() {
+
+			var record NotificationRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return errors.Wrap(err, "Unable to decode notification record")
+			}
+
+			if status == "" || record.Status == status {
+				records = append(records, record)
+			}
+		}
+
+		return nil
+	})
+
+	return records, err
+}
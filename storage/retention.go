@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets keyed by level, and by cycle, which grow with every cycle. Watermarks are
+// kept in the bucket sequences, which are never pruned.
+var (
+	levelBuckets = [][]string{
+		{BAKING_BUCKET},
+		{ENDORSING_BUCKET},
+		{RIGHTS_BUCKET, BAKING_RIGHTS_BUCKET},
+		{RIGHTS_BUCKET, ENDORSING_RIGHTS_BUCKET},
+		{HISTORY_BUCKET, BAKING_RIGHTS_BUCKET},
+		{HISTORY_BUCKET, ENDORSING_RIGHTS_BUCKET},
+	}
+
+	cycleBuckets = [][]string{
+		{NONCE_BUCKET},
+		{PAYOUTS_BUCKET},
+		{RIGHTS_BUCKET, RIGHTS_PROGRESS_BUCKET, BAKING_RIGHTS_BUCKET},
+		{RIGHTS_BUCKET, RIGHTS_PROGRESS_BUCKET, ENDORSING_RIGHTS_BUCKET},
+	}
+)
+
+// MinRetentionCycles is the fewest cycles which can be kept; Nonces are revealed, and
+// payouts made, for cycles this far back
+func (s *Storage) MinRetentionCycles() int {
+	return s.networkConstants.PreservedCycles + 3
+}
+
+// PruneKeeping deletes everything from before the last keep cycles, up to and including
+// cycle, keeping at least MinRetentionCycles. Returns the first cycle kept, and the number
+// of keys deleted.
+func (s *Storage) PruneKeeping(cycle, keep int) (int, int, error) {
+
+	if minKeep := s.MinRetentionCycles(); keep < minKeep {
+		keep = minKeep
+	}
+
+	before := cycle - keep
+	if before <= 0 {
+		return 0, 0, nil
+	}
+
+	deleted, err := s.Prune(before)
+
+	return before, deleted, err
+}
+
+// Prune deletes everything from before cycle, returning the number of keys deleted
+func (s *Storage) Prune(cycle int) (int, error) {
+
+	firstLevel := Itob(s.networkConstants.FirstLevelOfCycle(cycle))
+	firstCycle := Itob(cycle)
+
+	var deleted int
+
+	// One transaction per bucket, to keep each small
+	for _, path := range levelBuckets {
+		n, err := s.pruneBucket(path, firstLevel, nil)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+
+	for _, path := range cycleBuckets {
+
+		var keep func(*bolt.Bucket, []byte) bool
+		if path[0] == PAYOUTS_BUCKET {
+			keep = unpaidPayoutCycle
+		}
+
+		n, err := s.pruneBucket(path, firstCycle, keep)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+// unpaidPayoutCycle returns true if rewards were calculated for the cycle, but not all paid;
+// These are what each delegator is still owed, so are kept however old
+func unpaidPayoutCycle(b *bolt.Bucket, cycle []byte) bool {
+
+	cb := b.Bucket(cycle)
+	if cb == nil {
+		return false
+	}
+
+	metadataBytes := cb.Get([]byte(PAYOUTS_METADATA_KEY))
+	if metadataBytes == nil {
+		return false
+	}
+
+	var metadata struct {
+		Status string `json:"st"`
+	}
+
+	// Keep what can't be read, rather than lose it
+	if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+		return true
+	}
+
+	return metadata.Status != PAYOUTS_STATUS_DONE
+}
+
+// pruneBucket deletes all keys, and nested buckets, below before in the bucket at path,
+// except those keep, if set, returns true for
+func (s *Storage) pruneBucket(path []string, before []byte, keep func(*bolt.Bucket, []byte) bool) (int, error) {
+
+	var deleted int
+
+	err := s.Update(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(path[0]))
+		for _, p := range path[1:] {
+			if b == nil {
+				break
+			}
+			b = b.Bucket([]byte(p))
+		}
+
+		if b == nil {
+			return nil
+		}
+
+		// Cannot delete while iterating
+		var keys, buckets [][]byte
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k, before) < 0; k, v = c.Next() {
+			if keep != nil && keep(b, k) {
+				continue
+			}
+			if v == nil {
+				buckets = append(buckets, append([]byte{}, k...))
+			} else {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for _, k := range buckets {
+			if err := b.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+
+		deleted = len(keys) + len(buckets)
+
+		return nil
+	})
+
+	return deleted, errors.Wrapf(err, "Unable to prune %v", path)
+}
+
+// CompactTo writes a copy of the DB to a new file without the free pages left behind
+// by deletes, which bbolt never returns to the filesystem
+func (s *Storage) CompactTo(path string) error {
+
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errors.Wrap(err, "Unable to create database")
+	}
+
+	err = s.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, src *bolt.Bucket) error {
+
+				b, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+
+				return copyBucket(b, src)
+			})
+		})
+	})
+
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path)
+		return errors.Wrap(err, "Unable to compact database")
+	}
+
+	return nil
+}
+
+func copyBucket(dst, src *bolt.Bucket) error {
+
+	// Keys are sequential, so fill pages completely
+	dst.FillPercent = 1.0
+
+	if err := src.ForEach(func(k, v []byte) error {
+
+		// Nil values are nested buckets
+		if v == nil {
+			nested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(nested, src.Bucket(k))
+		}
+
+		return dst.Put(k, v)
+	}); err != nil {
+		return err
+	}
+
+	return dst.SetSequence(src.Sequence())
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// On hangzhounet, with 4096 blocks per cycle
+const (
+	lastLevelOfCycle9   = 40960
+	firstLevelOfCycle10 = 40961
+)
+
+func TestPrune(t *testing.T) {
+
+	s, err := InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Something in every level and cycle bucket, either side of the boundary
+	for _, level := range []int{lastLevelOfCycle9, firstLevelOfCycle10} {
+
+		cycle := 9
+		if level == firstLevelOfCycle10 {
+			cycle = 10
+		}
+
+		if err := s.RecordBakedBlock(level, "BLock"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.RecordEndorsement(level, "oo"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.RecordBakingOutcome(RightOutcome{Level: level, Cycle: cycle, Outcome: OUTCOME_BAKED}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.RecordEndorsingOutcome(RightOutcome{Level: level, Cycle: cycle, Outcome: OUTCOME_ENDORSED}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.SaveEndorsingRightsForLevels(cycle, []int{level}, []rpc.EndorsingRights{{Level: level, Slots: []int{1}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.SaveBakingRightsForLevels(cycle, []int{level}, []rpc.BakingRights{{Level: level}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.SaveNonce(cycle, level, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Update(func(tx *bolt.Tx) error {
+			_, err := tx.Bucket([]byte(PAYOUTS_BUCKET)).CreateBucket(Itob(cycle))
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.SetRightsFetchComplete(BAKING_RIGHTS_BUCKET, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.Prune(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each level bucket, and cycle bucket; Payouts, nonces and both rights checkpoints
+	if expected := len(levelBuckets) + len(cycleBuckets); deleted != expected {
+		t.Errorf("Deleted %d, expected %d", deleted, expected)
+	}
+
+	checkPruned := func(db *bolt.DB) {
+
+		t.Helper()
+
+		if err := db.View(func(tx *bolt.Tx) error {
+
+			for _, path := range levelBuckets {
+				b := bucketAt(tx, path)
+				if b.Get(Itob(lastLevelOfCycle9)) != nil || b.Get(Itob(firstLevelOfCycle10)) == nil {
+					t.Errorf("%v: level boundary not respected", path)
+				}
+			}
+
+			for _, path := range [][]string{{NONCE_BUCKET}, {PAYOUTS_BUCKET}} {
+				b := bucketAt(tx, path)
+				if b.Bucket(Itob(9)) != nil || b.Bucket(Itob(10)) == nil {
+					t.Errorf("%v: cycle boundary not respected", path)
+				}
+			}
+
+			progress := bucketAt(tx, []string{RIGHTS_BUCKET, RIGHTS_PROGRESS_BUCKET, ENDORSING_RIGHTS_BUCKET})
+			if progress.Bucket(Itob(9)) != nil || progress.Bucket(Itob(10)) == nil {
+				t.Error("Rights checkpoints: cycle boundary not respected")
+			}
+
+			// Watermarks, and the highest fetched rights cycle, are bucket sequences
+			for path, sequence := range map[string]uint64{
+				BAKING_BUCKET:    firstLevelOfCycle10,
+				ENDORSING_BUCKET: firstLevelOfCycle10,
+			} {
+				if b := tx.Bucket([]byte(path)); b.Sequence() != sequence {
+					t.Errorf("%s sequence %d, expected %d", path, b.Sequence(), sequence)
+				}
+			}
+
+			if b := bucketAt(tx, []string{RIGHTS_BUCKET, BAKING_RIGHTS_BUCKET}); b.Sequence() != 10 {
+				t.Errorf("Highest fetched baking rights cycle %d", b.Sequence())
+			}
+
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkPruned(s.DB)
+
+	// Compacting keeps everything left, including sequences
+	compacted := filepath.Join(t.TempDir(), DATABASE_FILE)
+	if err := s.CompactTo(compacted); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(compacted, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	checkPruned(db)
+
+	if w, err := s.GetEndorsingWatermark(); err != nil || w != firstLevelOfCycle10 {
+		t.Errorf("Endorsing watermark %d, %v", w, err)
+	}
+}
+
+func TestPruneKeepsMinimum(t *testing.T) {
+
+	s, err := InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Preserved cycles + 3
+	if s.MinRetentionCycles() != 6 {
+		t.Fatalf("Minimum retention %d", s.MinRetentionCycles())
+	}
+
+	for cycle := 10; cycle <= 20; cycle++ {
+		if err := s.SaveNonce(cycle, cycle*4096, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Asking for 1 cycle keeps 6
+	before, deleted, err := s.PruneKeeping(20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before != 14 || deleted != 4 {
+		t.Errorf("Pruned %d before cycle %d, expected 4 before 14", deleted, before)
+	}
+
+	// Too early to prune anything
+	if before, deleted, err := s.PruneKeeping(5, 1); before != 0 || deleted != 0 || err != nil {
+		t.Errorf("Pruned %d before cycle %d, %v", deleted, before, err)
+	}
+
+	if err := s.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(NONCE_BUCKET))
+		for cycle := 10; cycle <= 20; cycle++ {
+			if kept := b.Bucket(Itob(cycle)) != nil; kept != (cycle >= 14) {
+				t.Errorf("Cycle %d kept: %v", cycle, kept)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// What delegators are owed is kept until it is paid, however old
+func TestPruneKeepsUnpaidPayouts(t *testing.T) {
+
+	s, err := InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	statuses := map[int]string{5: PAYOUTS_STATUS_DONE, 6: "calc", 7: "err", 8: "inprog", 9: ""}
+
+	if err := s.Update(func(tx *bolt.Tx) error {
+		for cycle, status := range statuses {
+			cb, err := tx.Bucket([]byte(PAYOUTS_BUCKET)).CreateBucket(Itob(cycle))
+			if err != nil {
+				return err
+			}
+
+			// No metadata; Nothing was calculated
+			if status == "" {
+				continue
+			}
+
+			if err := cb.Put([]byte(PAYOUTS_METADATA_KEY), []byte(`{"st":"`+status+`"}`)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Prune(10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(PAYOUTS_BUCKET))
+		for cycle, status := range statuses {
+			unpaid := status != PAYOUTS_STATUS_DONE && status != ""
+			if kept := b.Bucket(Itob(cycle)) != nil; kept != unpaid {
+				t.Errorf("Cycle %d, status %q, kept: %v", cycle, status, kept)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
-	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
 
 	"github.com/bakingbacon/go-tezos/v4/rpc"
 
@@ -13,9 +15,28 @@ import (
 const (
 	ENDORSING_RIGHTS_BUCKET = "endorsing"
 	BAKING_RIGHTS_BUCKET    = "baking"
+	RIGHTS_PROGRESS_BUCKET  = "progress"
 )
 
-func (s *Storage) SaveEndorsingRightsForCycle(cycle int, endorsingRights []rpc.EndorsingRights) error {
+type EndorsingRight struct {
+	Level         int       `json:"level"`
+	Cycle         int       `json:"cycle"`
+	Slots         []int     `json:"slots"`
+	NumSlots      int       `json:"numslots"`
+	EstimatedTime time.Time `json:"estimatedtime"`
+}
+
+type BakingRight struct {
+	Level         int       `json:"level"`
+	Cycle         int       `json:"cycle"`
+	Priority      int       `json:"priority"`
+	EstimatedTime time.Time `json:"estimatedtime"`
+}
+
+// SaveEndorsingRightsForLevels saves a batch of fetched endorsing rights and records
+// each of the fetched levels as checkpoints so that an interrupted fetch can resume.
+// Levels with no rights must still be included in fetchedLevels.
+func (s *Storage) SaveEndorsingRightsForLevels(cycle int, fetchedLevels []int, endorsingRights []rpc.EndorsingRights) error {
 
 	return s.Update(func(tx *bolt.Tx) error {
 
@@ -24,23 +45,45 @@ func (s *Storage) SaveEndorsingRightsForCycle(cycle int, endorsingRights []rpc.E
 			return errors.Wrap(err, "Unable to create endorsing rights bucket")
 		}
 
-		// Use the bucket's sequence to save the highest cycle for which rights have been fetched
-		if err := b.SetSequence(uint64(cycle)); err != nil {
-			return err
-		}
-
 		// Keys of values are not related to the sequence
 		for _, r := range endorsingRights {
-			if err := b.Put(Itob(r.Level), Itob(cycle)); err != nil {
+
+			rightBytes, err := json.Marshal(EndorsingRight{
+				Level:         r.Level,
+				Cycle:         cycle,
+				Slots:         r.Slots,
+				NumSlots:      len(r.Slots),
+				EstimatedTime: r.EstimatedTime,
+			})
+			if err != nil {
+				return errors.Wrap(err, "Unable to encode endorsing right")
+			}
+
+			if err := b.Put(Itob(r.Level), rightBytes); err != nil {
 				return err
 			}
 		}
 
-		return nil
+		return saveFetchedLevels(tx, ENDORSING_RIGHTS_BUCKET, cycle, fetchedLevels)
 	})
 }
 
-func (s *Storage) SaveBakingRightsForCycle(cycle int, bakingRights []rpc.BakingRights) error {
+// SaveBakingRightsForLevels saves a batch of fetched baking rights, and records
+// the fetched levels as checkpoints. See SaveEndorsingRightsForLevels. Each level's
+// rights are saved together, in priority order, so all of a level's rights must be
+// in the same batch.
+func (s *Storage) SaveBakingRightsForLevels(cycle int, fetchedLevels []int, bakingRights []rpc.BakingRights) error {
+
+	levelRights := make(map[int][]BakingRight)
+
+	for _, r := range bakingRights {
+		levelRights[r.Level] = append(levelRights[r.Level], BakingRight{
+			Level:         r.Level,
+			Cycle:         cycle,
+			Priority:      r.Priority,
+			EstimatedTime: r.EstimatedTime,
+		})
+	}
 
 	return s.Update(func(tx *bolt.Tx) error {
 
@@ -49,20 +92,197 @@ func (s *Storage) SaveBakingRightsForCycle(cycle int, bakingRights []rpc.BakingR
 			return errors.Wrap(err, "Unable to create baking rights bucket")
 		}
 
-		// Use the bucket's sequence to save the highest cycle for which rights have been fetched
-		if err := b.SetSequence(uint64(cycle)); err != nil {
-			return err
+		// Keys of values are not related to the sequence
+		for level, rights := range levelRights {
+
+			sort.Slice(rights, func(i, j int) bool {
+				return rights[i].Priority < rights[j].Priority
+			})
+
+			rightsBytes, err := json.Marshal(rights)
+			if err != nil {
+				return errors.Wrap(err, "Unable to encode baking rights")
+			}
+
+			if err := b.Put(Itob(level), rightsBytes); err != nil {
+				return err
+			}
 		}
 
-		// Keys of values are not related to the sequence
-		for _, r := range bakingRights {
-			if err := b.Put(Itob(r.Level), Itob(r.Priority)); err != nil {
+		return saveFetchedLevels(tx, BAKING_RIGHTS_BUCKET, cycle, fetchedLevels)
+	})
+}
+
+// GetFetchedRightsLevels returns the set of levels within cycle which have previously
+// been fetched for the rights type (ENDORSING_RIGHTS_BUCKET or BAKING_RIGHTS_BUCKET)
+func (s *Storage) GetFetchedRightsLevels(rightsType string, cycle int) (map[int]bool, error) {
+
+	fetchedLevels := make(map[int]bool)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		pb := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(RIGHTS_PROGRESS_BUCKET))
+		if pb == nil {
+			return nil
+		}
+
+		tb := pb.Bucket([]byte(rightsType))
+		if tb == nil {
+			return nil
+		}
+
+		cb := tb.Bucket(Itob(cycle))
+		if cb == nil {
+			return nil
+		}
+
+		return cb.ForEach(func(k, _ []byte) error {
+			fetchedLevels[Btoi(k)] = true
+			return nil
+		})
+	})
+
+	return fetchedLevels, err
+}
+
+// SetRightsFetchComplete marks cycle as completely fetched for the rights type, and
+// removes the per-level checkpoints. This must only be called once every level of
+// the cycle has been saved.
+func (s *Storage) SetRightsFetchComplete(rightsType string, cycle int) error {
+
+	return s.Update(func(tx *bolt.Tx) error {
+
+		b, err := tx.Bucket([]byte(RIGHTS_BUCKET)).CreateBucketIfNotExists([]byte(rightsType))
+		if err != nil {
+			return errors.Wrap(err, "Unable to create rights bucket")
+		}
+
+		// Use the bucket's sequence to save the highest cycle for which rights have been fetched
+		if uint64(cycle) > b.Sequence() {
+			if err := b.SetSequence(uint64(cycle)); err != nil {
 				return err
 			}
 		}
 
+		// Checkpoints are no longer needed
+		tb := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(RIGHTS_PROGRESS_BUCKET))
+		if tb != nil {
+			tb = tb.Bucket([]byte(rightsType))
+		}
+
+		if tb != nil && tb.Bucket(Itob(cycle)) != nil {
+			return tb.DeleteBucket(Itob(cycle))
+		}
+
+		return nil
+	})
+}
+
+// GetHighestFetchedRightsCycle returns the highest cycle for which all rights of
+// the rights type have been fetched
+func (s *Storage) GetHighestFetchedRightsCycle(rightsType string) (int, error) {
+
+	var highestFetchCycle int
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(rightsType))
+		if b != nil {
+			highestFetchCycle = int(b.Sequence())
+		}
+
+		return nil
+	})
+
+	return highestFetchCycle, err
+}
+
+// StartRightsFetch records that fetching cycle's rights has begun, so that the fetch is
+// resumed if interrupted, even before any levels are saved
+func (s *Storage) StartRightsFetch(rightsType string, cycle int) error {
+
+	return s.Update(func(tx *bolt.Tx) error {
+		return saveFetchedLevels(tx, rightsType, cycle, nil)
+	})
+}
+
+// GetIncompleteRightsCycles returns the cycles for which a fetch of the rights type was
+// started, but not completed
+func (s *Storage) GetIncompleteRightsCycles(rightsType string) ([]int, error) {
+
+	cycles := make([]int, 0)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		pb := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(RIGHTS_PROGRESS_BUCKET))
+		if pb == nil {
+			return nil
+		}
+
+		tb := pb.Bucket([]byte(rightsType))
+		if tb == nil {
+			return nil
+		}
+
+		// Each cycle's checkpoints are removed once complete
+		return tb.ForEach(func(k, v []byte) error {
+			if v == nil {
+				cycles = append(cycles, Btoi(k))
+			}
+			return nil
+		})
+	})
+
+	return cycles, err
+}
+
+// IsRightsFetchIncomplete returns true if a fetch of the rights type for cycle was started,
+// but not completed
+func (s *Storage) IsRightsFetchIncomplete(rightsType string, cycle int) (bool, error) {
+
+	var incomplete bool
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		pb := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(RIGHTS_PROGRESS_BUCKET))
+		if pb == nil {
+			return nil
+		}
+
+		if tb := pb.Bucket([]byte(rightsType)); tb != nil {
+			incomplete = tb.Bucket(Itob(cycle)) != nil
+		}
+
 		return nil
 	})
+
+	return incomplete, err
+}
+
+func saveFetchedLevels(tx *bolt.Tx, rightsType string, cycle int, fetchedLevels []int) error {
+
+	pb, err := tx.Bucket([]byte(RIGHTS_BUCKET)).CreateBucketIfNotExists([]byte(RIGHTS_PROGRESS_BUCKET))
+	if err != nil {
+		return errors.Wrap(err, "Unable to create rights progress bucket")
+	}
+
+	tb, err := pb.CreateBucketIfNotExists([]byte(rightsType))
+	if err != nil {
+		return errors.Wrap(err, "Unable to create rights progress bucket")
+	}
+
+	cb, err := tb.CreateBucketIfNotExists(Itob(cycle))
+	if err != nil {
+		return errors.Wrap(err, "Unable to create rights progress cycle bucket")
+	}
+
+	for _, l := range fetchedLevels {
+		if err := cb.Put(Itob(l), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // GetNextEndorsingRight returns the level of the next endorsing opportunity,
@@ -74,8 +294,6 @@ func (s *Storage) GetNextEndorsingRight(curLevel int) (int, int, error) {
 		highestFetchCycle int
 	)
 
-	curLevelBytes := Itob(curLevel)
-
 	err := s.View(func(tx *bolt.Tx) error {
 
 		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(ENDORSING_RIGHTS_BUCKET))
@@ -85,29 +303,18 @@ func (s *Storage) GetNextEndorsingRight(curLevel int) (int, int, error) {
 
 		highestFetchCycle = int(b.Sequence())
 
-		c := b.Cursor()
-
-		for k, _ := c.First(); k != nil && nextLevel == 0; k, _ = c.Next() {
-			switch o := bytes.Compare(curLevelBytes, k); o {
-			case 1, 0:
-				// k is less than, or equal to current level, loop to next entry
-				continue
-			case -1:
-				nextLevel = Btoi(k)
-			}
+		// First right above the current level, if any
+		if k, _ := b.Cursor().Seek(Itob(curLevel + 1)); k != nil {
+			nextLevel = Btoi(k)
 		}
 
 		return nil
 	})
 
-	// Two conditions can happen. We scanned through all rights:
-	//  1. .. and found next highest
-	//  2. .. or found none
-
 	return nextLevel, highestFetchCycle, err
 }
 
-// GetNextBakingRight returns the level of the next baking opportunity, with it's priority,
+// GetNextBakingRight returns the level of the next baking opportunity, with it's best priority,
 // and also the highest cycle for which rights have been previously fetched.
 func (s *Storage) GetNextBakingRight(curLevel int) (int, int, int, error) {
 
@@ -117,8 +324,6 @@ func (s *Storage) GetNextBakingRight(curLevel int) (int, int, int, error) {
 		highestFetchCycle int
 	)
 
-	curLevelBytes := Itob(curLevel)
-
 	err := s.View(func(tx *bolt.Tx) error {
 
 		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(BAKING_RIGHTS_BUCKET))
@@ -128,27 +333,242 @@ func (s *Storage) GetNextBakingRight(curLevel int) (int, int, int, error) {
 
 		highestFetchCycle = int(b.Sequence())
 
+		// First right above the current level, if any
+		if k, v := b.Cursor().Seek(Itob(curLevel + 1)); k != nil {
+
+			bakingRights, err := decodeBakingRights(v)
+			if err != nil {
+				return err
+			}
+
+			nextLevel = bakingRights[0].Level
+			nextPriority = bakingRights[0].Priority
+		}
+
+		return nil
+	})
+
+	return nextLevel, nextPriority, highestFetchCycle, err
+}
+
+// GetEndorsingRight returns the stored endorsing right at level, if one exists
+func (s *Storage) GetEndorsingRight(level int) (EndorsingRight, bool, error) {
+
+	var (
+		endorsingRight EndorsingRight
+		found          bool
+	)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(ENDORSING_RIGHTS_BUCKET))
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get(Itob(level))
+		if v == nil {
+			return nil
+		}
+
+		var err error
+		endorsingRight, err = decodeEndorsingRight(v)
+		found = err == nil
+
+		return err
+	})
+
+	return endorsingRight, found, err
+}
+
+// GetBakingRight returns the stored baking right at level with the best priority, if one exists
+func (s *Storage) GetBakingRight(level int) (BakingRight, bool, error) {
+
+	var (
+		bakingRight BakingRight
+		found       bool
+	)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(BAKING_RIGHTS_BUCKET))
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get(Itob(level))
+		if v == nil {
+			return nil
+		}
+
+		bakingRights, err := decodeBakingRights(v)
+		if err != nil {
+			return err
+		}
+
+		bakingRight, found = bakingRights[0], true
+
+		return nil
+	})
+
+	return bakingRight, found, err
+}
+
+// GetEndorsingRightsForCycle returns all stored endorsing rights for cycle, in level order
+func (s *Storage) GetEndorsingRightsForCycle(cycle int) ([]EndorsingRight, error) {
+
+	endorsingRights := make([]EndorsingRight, 0)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(ENDORSING_RIGHTS_BUCKET))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		for k, v := c.Seek(Itob(s.networkConstants.FirstLevelOfCycle(cycle))); k != nil; k, v = c.Next() {
+
+			endorsingRight, err := decodeEndorsingRight(v)
+			if err != nil {
+				return err
+			}
+
+			if endorsingRight.Cycle > cycle {
+				break
+			}
+
+			if endorsingRight.Cycle == cycle {
+				endorsingRights = append(endorsingRights, endorsingRight)
+			}
+		}
+
+		return nil
+	})
+
+	return endorsingRights, err
+}
+
+// GetBakingRightsForCycle returns all stored baking rights for cycle, of every priority,
+// in level then priority order
+func (s *Storage) GetBakingRightsForCycle(cycle int) ([]BakingRight, error) {
+	return s.getBakingRightsForCycle(cycle, false)
+}
+
+// GetBakingLevelsForCycle returns the best priority baking right of each level in cycle,
+// in level order; Only one block can be baked at each level
+func (s *Storage) GetBakingLevelsForCycle(cycle int) ([]BakingRight, error) {
+	return s.getBakingRightsForCycle(cycle, true)
+}
+
+func (s *Storage) getBakingRightsForCycle(cycle int, bestOnly bool) ([]BakingRight, error) {
+
+	bakingRights := make([]BakingRight, 0)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(BAKING_RIGHTS_BUCKET))
+		if b == nil {
+			return nil
+		}
+
 		c := b.Cursor()
 
-		for k, v := c.First(); k != nil && nextLevel == 0; k, v = c.Next() {
-			switch o := bytes.Compare(curLevelBytes, k); o {
-			case 1, 0:
-				// k is less than, or equal to current level, loop to next entry
+		for k, v := c.Seek(Itob(s.networkConstants.FirstLevelOfCycle(cycle))); k != nil; k, v = c.Next() {
+
+			levelRights, err := decodeBakingRights(v)
+			if err != nil {
+				return err
+			}
+
+			if levelRights[0].Cycle > cycle {
+				break
+			}
+
+			if levelRights[0].Cycle != cycle {
 				continue
-			case -1:
-				nextLevel = Btoi(k)
-				nextPriority = Btoi(v)
 			}
+
+			if bestOnly {
+				levelRights = levelRights[:1]
+			}
+
+			bakingRights = append(bakingRights, levelRights...)
 		}
 
 		return nil
 	})
 
-	// Two conditions can happen. We scanned through all rights:
-	//  1. .. and found next highest
-	//  2. .. or found none
+	return bakingRights, err
+}
 
-	return nextLevel, nextPriority, highestFetchCycle, err
+// GetUpcomingBakingRights returns all stored baking rights above curLevel, of every priority,
+// in level then priority order
+func (s *Storage) GetUpcomingBakingRights(curLevel int) ([]BakingRight, error) {
+
+	bakingRights := make([]BakingRight, 0)
+
+	err := s.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(RIGHTS_BUCKET)).Bucket([]byte(BAKING_RIGHTS_BUCKET))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		for k, v := c.Seek(Itob(curLevel + 1)); k != nil; k, v = c.Next() {
+
+			levelRights, err := decodeBakingRights(v)
+			if err != nil {
+				return err
+			}
+
+			bakingRights = append(bakingRights, levelRights...)
+		}
+
+		return nil
+	})
+
+	return bakingRights, err
+}
+
+// Legacy values are converted by migrateLegacyRights
+func decodeEndorsingRight(v []byte) (EndorsingRight, error) {
+
+	var endorsingRight EndorsingRight
+	if err := json.Unmarshal(v, &endorsingRight); err != nil {
+		return endorsingRight, errors.Wrap(err, "Unable to decode endorsing right")
+	}
+
+	return endorsingRight, nil
+}
+
+// decodeBakingRights returns the rights of a level, best priority first. Levels saved
+// before every priority was kept hold a single right.
+func decodeBakingRights(v []byte) ([]BakingRight, error) {
+
+	var bakingRights []BakingRight
+
+	if len(v) > 0 && v[0] == '{' {
+		bakingRights = make([]BakingRight, 1)
+		if err := json.Unmarshal(v, &bakingRights[0]); err != nil {
+			return nil, errors.Wrap(err, "Unable to decode baking right")
+		}
+
+		return bakingRights, nil
+	}
+
+	if err := json.Unmarshal(v, &bakingRights); err != nil {
+		return nil, errors.Wrap(err, "Unable to decode baking rights")
+	}
+
+	if len(bakingRights) == 0 {
+		return nil, errors.New("No baking rights in level")
+	}
+
+	return bakingRights, nil
 }
 
 // GetRecentEndorsement returns the level of the most recent endorsement
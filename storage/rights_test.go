@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Every priority of a level is kept, while the next bake is the best of them
+func TestBakingRightsAllPriorities(t *testing.T) {
+
+	s, err := InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.SaveBakingRightsForLevels(10, []int{40961, 40962, 40963}, []rpc.BakingRights{
+		{Level: 40961, Priority: 3},
+		{Level: 40961, Priority: 0},
+		{Level: 40961, Priority: 2},
+		{Level: 40963, Priority: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := s.GetBakingRightsForCycle(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct{ level, priority int }{{40961, 0}, {40961, 2}, {40961, 3}, {40963, 1}}
+	if len(all) != len(expected) {
+		t.Fatalf("Rights = %+v", all)
+	}
+
+	for i, e := range expected {
+		if all[i].Level != e.level || all[i].Priority != e.priority || all[i].Cycle != 10 {
+			t.Errorf("Right %d = %+v, expected level %d priority %d", i, all[i], e.level, e.priority)
+		}
+	}
+
+	if upcoming, _ := s.GetUpcomingBakingRights(40960); len(upcoming) != len(expected) {
+		t.Errorf("Upcoming = %+v", upcoming)
+	}
+
+	levels, err := s.GetBakingLevelsForCycle(10)
+	if err != nil || len(levels) != 2 || levels[0].Priority != 0 || levels[1].Priority != 1 {
+		t.Errorf("Levels = %+v, %v", levels, err)
+	}
+
+	if level, priority, _, err := s.GetNextBakingRight(40960); err != nil || level != 40961 || priority != 0 {
+		t.Errorf("Next bake %d, priority %d, %v", level, priority, err)
+	}
+
+	if r, found, err := s.GetBakingRight(40961); err != nil || !found || r.Priority != 0 {
+		t.Errorf("Right = %+v, %v, %v", r, found, err)
+	}
+}
+
+// Levels saved before every priority was kept hold a single right
+func TestBakingRightSingle(t *testing.T) {
+
+	s, err := InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte(RIGHTS_BUCKET)).CreateBucketIfNotExists([]byte(BAKING_RIGHTS_BUCKET))
+		if err != nil {
+			return err
+		}
+		v, _ := json.Marshal(BakingRight{Level: 40961, Cycle: 10, Priority: 1})
+		return b.Put(Itob(40961), v)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rights, err := s.GetBakingRightsForCycle(10); err != nil || len(rights) != 1 || rights[0].Priority != 1 {
+		t.Errorf("Rights = %+v, %v", rights, err)
+	}
+}
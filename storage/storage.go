@@ -8,6 +8,8 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
+
+	"bakinbacon/util"
 )
 
 const (
@@ -21,10 +23,28 @@ const (
 	ENDPOINTS_BUCKET     = "endpoints"
 	NOTIFICATIONS_BUCKET = "notifs"
 	PAYOUTS_BUCKET       = "payouts"
+	HISTORY_BUCKET       = "history"
+	AUTH_BUCKET          = "auth"
+	USERS_BUCKET         = "users"
+	SESSIONS_BUCKET      = "sessions"
+	TOKENS_BUCKET        = "tokens"
+
+	OUTBOX_BUCKET               = "outbox"
+	NOTIFICATION_HISTORY_BUCKET = "notifhistory"
+
+	DELEGATOR_RULES_BUCKET = "delegatorrules"
+	CARRIED_REWARDS_BUCKET = "carriedrewards"
+
+	// Each payouts cycle's metadata, and its status once all are paid; Not pruned until then
+	PAYOUTS_METADATA_KEY = "metadata"
+	PAYOUTS_STATUS_DONE  = "done"
 )
 
 type Storage struct {
 	*bolt.DB
+
+	// For finding the levels of a cycle
+	networkConstants *util.NetworkConstants
 }
 
 func InitStorage(dataDir, network string) (*Storage, error) {
@@ -34,56 +54,22 @@ func InitStorage(dataDir, network string) (*Storage, error) {
 		return nil, errors.Wrap(err, "Failed to init db")
 	}
 
-	// Ensure some buckets exist, and migrations
-	err = db.Update(func(tx *bolt.Tx) error {
-
-		// Config bucket
-		cfgBkt, err := tx.CreateBucketIfNotExists([]byte(CONFIG_BUCKET))
-		if err != nil {
-			return errors.Wrap(err, "Cannot create config bucket")
-		}
-
-		// Nested bucket inside config
-		if _, err := cfgBkt.CreateBucketIfNotExists([]byte(ENDPOINTS_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create endpoints bucket")
-		}
-
-		// Nested bucket inside config
-		if _, err := cfgBkt.CreateBucketIfNotExists([]byte(NOTIFICATIONS_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create notifications bucket")
-		}
-
-		//
-		// Root buckets
-		if _, err := tx.CreateBucketIfNotExists([]byte(ENDORSING_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create endorsing bucket")
-		}
-
-		if _, err := tx.CreateBucketIfNotExists([]byte(BAKING_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create baking bucket")
-		}
-
-		if _, err := tx.CreateBucketIfNotExists([]byte(NONCE_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create nonce bucket")
-		}
-
-		if _, err := tx.CreateBucketIfNotExists([]byte(RIGHTS_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create rights bucket")
-		}
-
-		if _, err := tx.CreateBucketIfNotExists([]byte(PAYOUTS_BUCKET)); err != nil {
-			return errors.Wrap(err, "Cannot create payouts bucket")
-		}
-
-		return nil
-	})
+	networkConstants, err := util.GetNetworkConstants(network)
 	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Create buckets, and upgrade older DBs
+	if err := migrate(db, networkConstants); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	// set variable so main program can access
 	storage := &Storage{
-		DB: db,
+		DB:               db,
+		networkConstants: networkConstants,
 	}
 
 	// Add the default endpoints only on brand new setup
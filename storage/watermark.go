@@ -42,3 +42,34 @@ func (s *Storage) recordOperation(opBucket string, level int, opHash string) err
 		return b.Put(Itob(level), []byte(opHash)) // Save the level:opHash
 	})
 }
+
+// RaiseWatermarks sets both watermarks to at least level, without recording an operation.
+// Used when the DB may not know of everything signed up to level, eg after a restore.
+func (s *Storage) RaiseWatermarks(level int) error {
+	return s.raiseWatermark(level, BAKING_BUCKET, ENDORSING_BUCKET)
+}
+
+func (s *Storage) RaiseBakingWatermark(level int) error {
+	return s.raiseWatermark(level, BAKING_BUCKET)
+}
+
+func (s *Storage) RaiseEndorsingWatermark(level int) error {
+	return s.raiseWatermark(level, ENDORSING_BUCKET)
+}
+
+func (s *Storage) raiseWatermark(level int, opBuckets ...string) error {
+	return s.Update(func(tx *bolt.Tx) error {
+		for _, opBucket := range opBuckets {
+			b := tx.Bucket([]byte(opBucket))
+			if b.Sequence() >= uint64(level) {
+				continue
+			}
+
+			if err := b.SetSequence(uint64(level)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -52,6 +52,33 @@ func GetNetworkConstants(network string) (*NetworkConstants, error) {
 	return nil, fmt.Errorf("No such network '%s' exists", network)
 }
 
+// CycleFromLevel returns the cycle containing level
+func (nc *NetworkConstants) CycleFromLevel(l int) int {
+
+	gal := nc.GranadaActivationLevel
+	gac := nc.GranadaActivationCycle
+
+	// If level is before Granada activation, calculation is simple
+	if l <= gal {
+		return int(l / nc.BlocksPerCycle)
+	}
+
+	// If level is after Granada activation, must take in to account the
+	// change in number of blocks per cycle
+	return int(((l - gal) / nc.BlocksPerCycle) + gac)
+}
+
+// FirstLevelOfCycle returns the first level of cycle on the chain. Cycles before
+// Granada are not supported, and return the first level after activation.
+func (nc *NetworkConstants) FirstLevelOfCycle(cycle int) int {
+
+	if cycle < nc.GranadaActivationCycle {
+		cycle = nc.GranadaActivationCycle
+	}
+
+	return nc.GranadaActivationLevel + 1 + (cycle-nc.GranadaActivationCycle)*nc.BlocksPerCycle
+}
+
 func IsValidNetwork(maybeNetwork string) bool {
 	return maybeNetwork == NETWORK_MAINNET || maybeNetwork == NETWORK_GRANADANET || maybeNetwork == NETWORK_HANGZHOUNET
 }
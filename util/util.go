@@ -48,3 +48,17 @@ func StripQuote(s string) string {
 func AvailableNetworks() string {
 	return strings.Join([]string{NETWORK_MAINNET, NETWORK_GRANADANET, NETWORK_HANGZHOUNET}, ",")
 }
+
+// SplitList splits a comma-separated list, dropping empty entries
+func SplitList(s string) []string {
+
+	var list []string
+
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/nonce"
+	"bakinbacon/notifications"
+	"bakinbacon/storage"
+)
+
+const (
+	// Number of blocks after injection to search for our operations
+	VERIFY_NUM_BLOCKS = 5
+)
+
+// Only one verification may run at a time; if a previous verification is still
+// running when a new block arrives, skip as the next block will catch up
+var verifyRunning int32
+
+// verifyInclusions is called on each new block. It scans the blocks following the injection
+// of our endorsements and nonce reveals to confirm they were included, and confirms that
+// our baked blocks are part of the canonical chain. Records are updated to reflect this,
+// so that "injected" can be told apart from "actually earned rewards".
+func (bb *BakinBacon) verifyInclusions(wg *sync.WaitGroup, block rpc.Block) {
+
+	// Decrement waitGroup on exit
+	defer wg.Done()
+
+	// Handle panic gracefully
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("Message", r).Error("Panic recovered in verifyInclusions")
+		}
+	}()
+
+	if !atomic.CompareAndSwapInt32(&verifyRunning, 0, 1) {
+		log.Debug("Previous verification still running")
+		return
+	}
+	defer atomic.StoreInt32(&verifyRunning, 0)
+
+	headLevel := block.Header.Level
+
+	// Operation hashes of each block scanned; shared across all checks of this run
+	opHashesCache := make(map[int]map[string]bool)
+
+	opHashesAtLevel := func(level int) (map[string]bool, error) {
+
+		if opHashes, ok := opHashesCache[level]; ok {
+			return opHashes, nil
+		}
+
+		levelBlockID := rpc.BlockIDLevel(level)
+		_, operationHashes, err := bb.Current.OperationHashes(rpc.OperationHashesInput{
+			BlockID: &levelBlockID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		opHashes := make(map[string]bool, len(operationHashes))
+		for _, h := range operationHashes {
+			opHashes[h] = true
+		}
+
+		opHashesCache[level] = opHashes
+
+		return opHashes, nil
+	}
+
+	// Search the blocks after injectedLevel for opHash. Returns VERIFIED_INCLUDED if found,
+	// VERIFIED_MISSING if not found after VERIFY_NUM_BLOCKS, or "" if we need to wait longer.
+	findOperation := func(opHash string, injectedLevel int) (string, error) {
+
+		for level := injectedLevel + 1; level <= injectedLevel+VERIFY_NUM_BLOCKS && level <= headLevel; level++ {
+
+			opHashes, err := opHashesAtLevel(level)
+			if err != nil {
+				return "", err
+			}
+
+			if opHashes[opHash] {
+				return storage.VERIFIED_INCLUDED, nil
+			}
+		}
+
+		if headLevel >= injectedLevel+VERIFY_NUM_BLOCKS {
+			return storage.VERIFIED_MISSING, nil
+		}
+
+		return "", nil
+	}
+
+	bb.verifyEndorsements(headLevel, findOperation)
+	bb.verifyBakes(headLevel)
+	bb.verifyNonceReveals(block.Metadata.Level.Cycle-1, findOperation)
+}
+
+func (bb *BakinBacon) verifyEndorsements(headLevel int, findOperation func(string, int) (string, error)) {
+
+	// Look back far enough to catch up after a short outage
+	endorsements, err := bb.Storage.GetUnverifiedEndorsingOutcomes(headLevel - VERIFY_NUM_BLOCKS*4)
+	if err != nil {
+		log.WithError(err).Error("Unable to get unverified endorsements")
+		return
+	}
+
+	for _, e := range endorsements {
+
+		// Endorsements are injected at the level being endorsed
+		verified, err := findOperation(e.Hash, e.Level)
+		if err != nil {
+			log.WithError(err).WithField("Level", e.Level).Error("Unable to verify endorsement")
+			return
+		}
+
+		if verified == "" {
+			continue
+		}
+
+		e.Verified = verified
+		if err := bb.Storage.RecordEndorsingOutcome(e); err != nil {
+			log.WithError(err).Error("Unable to record endorsement verification")
+		}
+
+		if verified == storage.VERIFIED_MISSING {
+
+			msg := fmt.Sprintf("Endorsement for level %d was not included in the chain", e.Level)
+			log.WithField("OpHash", e.Hash).Warn(msg)
+			bb.SendNotification(msg, notifications.ENDORSE_FAIL)
+
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"Level": e.Level, "OpHash": e.Hash,
+		}).Debug("Endorsement verified")
+	}
+}
+
+func (bb *BakinBacon) verifyBakes(headLevel int) {
+
+	bakes, err := bb.Storage.GetUnverifiedBakingOutcomes(headLevel - VERIFY_NUM_BLOCKS*4)
+	if err != nil {
+		log.WithError(err).Error("Unable to get unverified bakes")
+		return
+	}
+
+	for _, b := range bakes {
+
+		// Give the chain a few blocks to settle any forks
+		if headLevel < b.Level+VERIFY_NUM_BLOCKS {
+			continue
+		}
+
+		levelBlockID := rpc.BlockIDLevel(b.Level)
+		_, canonicalHash, err := bb.Current.Hash(&levelBlockID)
+		if err != nil {
+			log.WithError(err).WithField("Level", b.Level).Error("Unable to verify baked block")
+			return
+		}
+
+		if canonicalHash == b.Hash {
+			b.Outcome = storage.OUTCOME_BAKED
+			b.Verified = storage.VERIFIED_INCLUDED
+		} else {
+			b.Outcome = storage.OUTCOME_LOST
+			b.Verified = storage.VERIFIED_ORPHANED
+		}
+
+		if err := bb.Storage.RecordBakingOutcome(b); err != nil {
+			log.WithError(err).Error("Unable to record bake verification")
+		}
+
+		if b.Verified == storage.VERIFIED_ORPHANED {
+
+			msg := fmt.Sprintf("Baked block at level %d is not part of the canonical chain", b.Level)
+			log.WithFields(log.Fields{
+				"Ours": b.Hash, "Canonical": canonicalHash,
+			}).Warn(msg)
+			bb.SendNotification(msg, notifications.BAKING_FAIL)
+
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"Level": b.Level, "Hash": b.Hash,
+		}).Debug("Baked block verified")
+	}
+}
+
+func (bb *BakinBacon) verifyNonceReveals(nonceCycle int, findOperation func(string, int) (string, error)) {
+
+	noncesRawBytes, err := bb.GetNoncesForCycle(nonceCycle)
+	if err != nil {
+		log.WithError(err).WithField("Cycle", nonceCycle).Error("Unable to get nonces from DB")
+		return
+	}
+
+	for _, b := range noncesRawBytes {
+
+		var n nonce.Nonce
+		if err := json.Unmarshal(b, &n); err != nil {
+			log.WithError(err).Error("Unable to unmarshal nonce")
+			continue
+		}
+
+		// Only verify reveals which have been injected
+		if n.RevealOp == "" || n.RevealLevel == 0 || n.RevealVerified != "" {
+			continue
+		}
+
+		verified, err := findOperation(n.RevealOp, n.RevealLevel)
+		if err != nil {
+			log.WithError(err).WithField("Level", n.Level).Error("Unable to verify nonce reveal")
+			return
+		}
+
+		if verified == "" {
+			continue
+		}
+
+		n.RevealVerified = verified
+
+		if verified == storage.VERIFIED_MISSING {
+
+			msg := fmt.Sprintf("Nonce reveal for level %d was not included in the chain; Will reveal again", n.Level)
+			log.WithField("OpHash", n.RevealOp).Warn(msg)
+			bb.SendNotification(msg, notifications.NONCE)
+
+			// Clear the reveal so that it is injected again
+			n.RevealOp = ""
+		}
+
+		nonceBytes, err := json.Marshal(n)
+		if err != nil {
+			log.WithError(err).Error("Unable to marshal nonce")
+			continue
+		}
+
+		if err := bb.SaveNonce(nonceCycle, n.Level, nonceBytes); err != nil {
+			log.WithError(err).Error("Unable to save nonce verification to DB")
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Keep idle connections (and any proxies) from timing out
+	EVENTS_HEARTBEAT = 15 * time.Second
+)
+
+// streamEvents pushes events from the internal event bus to the client
+// using Server-Sent Events, until the client disconnects
+func (ws *WebServer) streamEvents(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - streamEvents")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiErrorStatus(errors.New("Streaming not supported"), w, http.StatusInternalServerError)
+		return
+	}
+
+	subId, eventsChan := ws.eventBus.Subscribe()
+	defer ws.eventBus.Unsubscribe(subId)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(EVENTS_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-eventsChan:
+			if !ok {
+				return
+			}
+
+			eventBytes, err := json.Marshal(event)
+			if err != nil {
+				log.WithError(err).WithField("Type", event.Type).Error("Unable to marshal event")
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, eventBytes); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+
+		case <-ws.shutdown:
+			return
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,122 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+const (
+	DEFAULT_SUMMARY_CYCLES = 5
+	MAX_SUMMARY_CYCLES     = 100
+)
+
+// getHistory returns the efficiency summary of a cycle, along with the outcome
+// of every baking and endorsing right. Defaults to the current cycle.
+func (ws *WebServer) getHistory(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getHistory")
+
+	cycle := ws.baconClient.Status.Cycle
+
+	if c := r.URL.Query().Get("cycle"); c != "" {
+		var err error
+		if cycle, err = strconv.Atoi(c); err != nil {
+			apiError(errors.Wrap(err, "Unable to parse cycle"), w)
+			return
+		}
+	}
+
+	summary, err := ws.storage.GetCycleSummary(cycle, ws.baconClient.Status.Level)
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get cycle summary"), w)
+		return
+	}
+
+	bakingOutcomes, err := ws.storage.GetBakingOutcomesForCycle(cycle)
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get baking history"), w)
+		return
+	}
+
+	endorsingOutcomes, err := ws.storage.GetEndorsingOutcomesForCycle(cycle)
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get endorsing history"), w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"summary":   summary,
+		"baking":    sortedOutcomes(bakingOutcomes),
+		"endorsing": sortedOutcomes(endorsingOutcomes),
+	}); err != nil {
+		log.WithError(err).Error("UI Return getHistory Failure")
+	}
+}
+
+// getHistorySummaries returns the efficiency summaries of the most recent
+// cycles, including the current cycle
+func (ws *WebServer) getHistorySummaries(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getHistorySummaries")
+
+	numCycles := DEFAULT_SUMMARY_CYCLES
+
+	if n := r.URL.Query().Get("n"); n != "" {
+		var err error
+		if numCycles, err = strconv.Atoi(n); err != nil || numCycles < 1 {
+			apiError(errors.New("Unable to parse number of cycles"), w)
+			return
+		}
+
+		if numCycles > MAX_SUMMARY_CYCLES {
+			apiError(errors.Errorf("At most %d cycles", MAX_SUMMARY_CYCLES), w)
+			return
+		}
+	}
+
+	curCycle := ws.baconClient.Status.Cycle
+	curLevel := ws.baconClient.Status.Level
+
+	summaries := make([]storage.CycleSummary, 0)
+
+	for cycle := curCycle; cycle > curCycle-numCycles && cycle >= 0; cycle-- {
+
+		summary, err := ws.storage.GetCycleSummary(cycle, curLevel)
+		if err != nil {
+			apiError(errors.Wrap(err, "Unable to get cycle summary"), w)
+			return
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"summaries": summaries,
+	}); err != nil {
+		log.WithError(err).Error("UI Return getHistorySummaries Failure")
+	}
+}
+
+func sortedOutcomes(outcomes map[int]storage.RightOutcome) []storage.RightOutcome {
+
+	sorted := make([]storage.RightOutcome, 0, len(outcomes))
+	for _, o := range outcomes {
+		sorted = append(sorted, o)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Level < sorted[j].Level
+	})
+
+	return sorted
+}
@@ -0,0 +1,54 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+// getNotificationHistory returns the most recent delivery records, newest first, and the
+// notifications waiting to be sent or retried. Filter with ?status=sent|failed|suppressed,
+// and ?limit=N, up to storage.NOTIFICATION_HISTORY_MAX.
+func (ws *WebServer) getNotificationHistory(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getNotificationHistory")
+
+	var limit int
+
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > storage.NOTIFICATION_HISTORY_MAX {
+			apiError(errors.Errorf("Limit must be between 1 and %d", storage.NOTIFICATION_HISTORY_MAX), w)
+			return
+		}
+	}
+
+	status := r.URL.Query().Get("status")
+
+	switch status {
+	case "", storage.NOTIFICATION_SENT, storage.NOTIFICATION_FAILED, storage.NOTIFICATION_SUPPRESSED:
+	default:
+		apiError(errors.Errorf("Unknown status '%s'", status), w)
+		return
+	}
+
+	history, pending, err := ws.notificationHandler.GetHistory(limit, status)
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get notification history"), w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"history": history,
+		"pending": pending,
+	}); err != nil {
+		log.WithError(err).Error("UI Return getNotificationHistory Failure")
+	}
+}
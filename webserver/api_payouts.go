@@ -8,6 +8,8 @@ import (
 	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/payouts"
 )
 
 func (ws *WebServer) getPayouts(w http.ResponseWriter, r *http.Request) {
@@ -114,3 +116,67 @@ func (ws *WebServer) sendCyclePayouts(w http.ResponseWriter, r *http.Request) {
 
 	apiReturnOk(w)
 }
+
+func (ws *WebServer) getDelegatorRules(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getDelegatorRules")
+
+	rules, err := ws.payoutsHandler.GetDelegatorRules()
+	if err != nil {
+		log.WithError(err).Error("API - getDelegatorRules")
+		apiError(errors.Wrap(err, "Unable to get delegator rules from DB"), w)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rules); err != nil {
+		log.WithError(err).Error("UI Return getDelegatorRules Failure")
+	}
+}
+
+func (ws *WebServer) saveDelegatorRule(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - saveDelegatorRule")
+
+	var rule payouts.DelegatorRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for saveDelegatorRule"), w)
+
+		return
+	}
+
+	if err := ws.payoutsHandler.SaveDelegatorRule(rule); err != nil {
+		log.WithError(err).Error("Unable to save delegator rule")
+		apiError(errors.Wrap(err, "Unable to save delegator rule"), w)
+
+		return
+	}
+
+	log.WithField("Delegator", rule.Address).Info("Saved delegator payout rule")
+
+	apiReturnOk(w)
+}
+
+func (ws *WebServer) deleteDelegatorRule(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - deleteDelegatorRule")
+
+	k := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for deleteDelegatorRule"), w)
+
+		return
+	}
+
+	if err := ws.payoutsHandler.DeleteDelegatorRule(k["address"]); err != nil {
+		log.WithError(err).Error("Unable to delete delegator rule")
+		apiError(errors.Wrap(err, "Unable to delete delegator rule"), w)
+
+		return
+	}
+
+	log.WithField("Delegator", k["address"]).Info("Deleted delegator payout rule")
+
+	apiReturnOk(w)
+}
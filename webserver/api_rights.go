@@ -0,0 +1,123 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ICS_TIME_FORMAT = "20060102T150405Z"
+)
+
+// getRights returns all stored baking and endorsing rights for a cycle. If
+// the cycle parameter is not provided, the current cycle is used.
+func (ws *WebServer) getRights(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getRights")
+
+	cycle := ws.baconClient.Status.Cycle
+
+	if c := r.URL.Query().Get("cycle"); c != "" {
+		var err error
+		if cycle, err = strconv.Atoi(c); err != nil {
+			apiError(errors.Wrap(err, "Unable to parse cycle"), w)
+			return
+		}
+	}
+
+	bakingRights, err := ws.storage.GetBakingRightsForCycle(cycle)
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get baking rights from DB"), w)
+		return
+	}
+
+	endorsingRights, err := ws.storage.GetEndorsingRightsForCycle(cycle)
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get endorsing rights from DB"), w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"cycle":     cycle,
+		"baking":    bakingRights,
+		"endorsing": endorsingRights,
+	}); err != nil {
+		log.WithError(err).Error("UI Return getRights Failure")
+	}
+}
+
+// getRightsCalendar returns all upcoming baking rights as an iCalendar (RFC 5545)
+// feed which can be subscribed to by calendar applications
+func (ws *WebServer) getRightsCalendar(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getRightsCalendar")
+
+	bakingRights, err := ws.storage.GetUpcomingBakingRights(ws.baconClient.Status.Level)
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get baking rights from DB"), w)
+		return
+	}
+
+	_, pkh, err := ws.storage.GetDelegate()
+	if err != nil {
+		apiError(errors.Wrap(err, "Cannot get delegate"), w)
+		return
+	}
+
+	now := time.Now().UTC().Format(ICS_TIME_FORMAT)
+
+	var ics strings.Builder
+
+	writeLine := func(line string) {
+		ics.WriteString(line)
+		ics.WriteString("\r\n")
+	}
+
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//BakinBacon//Rights Calendar//EN")
+	writeLine("CALSCALE:GREGORIAN")
+	writeLine("X-WR-CALNAME:" + icsEscape(fmt.Sprintf("BakinBacon %s", pkh)))
+
+	for _, b := range bakingRights {
+
+		// Rights without an estimated time cannot be placed on a calendar
+		if b.EstimatedTime.IsZero() {
+			continue
+		}
+
+		start := b.EstimatedTime.UTC()
+
+		writeLine("BEGIN:VEVENT")
+		writeLine(fmt.Sprintf("UID:bake-%d-%d-%s@bakinbacon", b.Level, b.Priority, pkh))
+		writeLine("DTSTAMP:" + now)
+		writeLine("DTSTART:" + start.Format(ICS_TIME_FORMAT))
+		writeLine("DTEND:" + start.Add(time.Minute).Format(ICS_TIME_FORMAT))
+		writeLine("SUMMARY:" + icsEscape(fmt.Sprintf("Bake level %d (priority %d)", b.Level, b.Priority)))
+		writeLine("DESCRIPTION:" + icsEscape(fmt.Sprintf("Cycle %d, level %d, priority %d", b.Cycle, b.Level, b.Priority)))
+		writeLine("END:VEVENT")
+	}
+
+	writeLine("END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"bakinbacon-rights.ics\"")
+
+	if _, err := w.Write([]byte(ics.String())); err != nil {
+		log.WithError(err).Error("UI Return getRightsCalendar Failure")
+	}
+}
+
+// Escape text values per RFC 5545, section 3.3.11
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\n", `\n`).Replace(s)
+}
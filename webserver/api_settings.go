@@ -5,9 +5,12 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/notifications"
 )
 
 func (ws *WebServer) saveBakerSettings(w http.ResponseWriter, r *http.Request) {
@@ -62,6 +65,71 @@ func (ws *WebServer) saveTelegram(w http.ResponseWriter, r *http.Request) {
 }
 
 func (ws *WebServer) saveEmail(w http.ResponseWriter, r *http.Request) {
+	ws.saveNotifierConfig(notifications.EMAIL, w, r)
+}
+
+// saveNotifier saves the config of any registered notifier
+func (ws *WebServer) saveNotifier(w http.ResponseWriter, r *http.Request) {
+	ws.saveNotifierConfig(mux.Vars(r)["notifier"], w, r)
+}
+
+func (ws *WebServer) saveNotifierConfig(notifier string, w http.ResponseWriter, r *http.Request) {
+
+	log.WithField("Notifier", notifier).Trace("API - SaveNotifier")
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.WithError(err).Error("API SaveNotifier")
+		apiError(errors.Wrap(err, "Failed to parse body"), w)
+
+		return
+	}
+
+	// Send string to configure for JSON unmarshaling; make sure to save config to db
+	if err := ws.notificationHandler.Configure(notifier, body, true); err != nil {
+		log.WithError(err).Error("API SaveNotifier")
+		apiError(errors.Wrapf(err, "Failed to configure %s", notifier), w)
+
+		return
+	}
+
+	apiReturnOk(w)
+}
+
+// testNotifier sends a test message using the saved config, returning any delivery error
+func (ws *WebServer) testNotifier(w http.ResponseWriter, r *http.Request) {
+
+	notifier := mux.Vars(r)["notifier"]
+
+	log.WithField("Notifier", notifier).Trace("API - TestNotifier")
+
+	if err := ws.notificationHandler.TestSend(notifier, "Test message from BakinBacon"); err != nil {
+		log.WithError(err).Error("API TestNotifier")
+		apiError(errors.Wrapf(err, "Failed to send test %s message", notifier), w)
+
+		return
+	}
+
+	apiReturnOk(w)
+}
+
+// saveRouting replaces the notification routing rules
+func (ws *WebServer) saveRouting(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - SaveRouting")
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		apiError(errors.Wrap(err, "Failed to parse body"), w)
+		return
+	}
+
+	if err := ws.notificationHandler.SetRouting(body, true); err != nil {
+		log.WithError(err).Error("API SaveRouting")
+		apiError(errors.Wrap(err, "Failed to save notification routing"), w)
+		return
+	}
+
 	apiReturnOk(w)
 }
 
@@ -77,6 +145,10 @@ func (ws *WebServer) getSettings(w http.ResponseWriter, r *http.Request) {
 	}
 	log.WithField("Endpoints", endpoints).Debug("API Settings Endpoints")
 
+	// Names of the available notifiers and categories; Before the local variable hides the package
+	notifiers := notifications.RegisteredNotifiers()
+	categories := notifications.Categories()
+
 	// Get Notification settings
 	notifications, err := ws.notificationHandler.GetConfig() // Returns json.RawMessage
 	if err != nil {
@@ -96,6 +168,10 @@ func (ws *WebServer) getSettings(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
 		"endpoints":     endpoints,
 		"notifications": notifications,
+		"notifiers":     notifiers,
+		"categories":    categories,
+		"routing":       ws.notificationHandler.GetRouting(),
+		"suppressed":    ws.notificationHandler.GetSuppressed(),
 		"baker": bakerSettings,
 	}); err != nil {
 		log.WithError(err).Error("UI Return Encode Failure")
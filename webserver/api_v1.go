@@ -0,0 +1,910 @@
+package webserver
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/payouts"
+	"bakinbacon/storage"
+)
+
+// The /api/v1 surface is a stable, documented API for automation. Every request and
+// response has a typed struct below, which is described in openapi.json. The older
+// /api routes remain for the web UI.
+
+var (
+	//go:embed openapi.json
+	openApiSpec []byte
+)
+
+type ErrorV1 struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+type HealthV1 struct {
+	Ok bool `json:"ok"`
+}
+
+type MeV1 struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type RightRefV1 struct {
+	Level    int    `json:"level"`
+	Cycle    int    `json:"cycle"`
+	Priority int    `json:"priority,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+type StatusV1 struct {
+	Network             string     `json:"network"`
+	Delegate            string     `json:"delegate"`
+	State               string     `json:"state"`
+	Error               string     `json:"error,omitempty"`
+	HeadHash            string     `json:"headHash"`
+	Level               int        `json:"level"`
+	Cycle               int        `json:"cycle"`
+	CyclePosition       int        `json:"cyclePosition"`
+	NextEndorsement     RightRefV1 `json:"nextEndorsement"`
+	NextBake            RightRefV1 `json:"nextBake"`
+	PreviousEndorsement RightRefV1 `json:"previousEndorsement"`
+	PreviousBake        RightRefV1 `json:"previousBake"`
+	Timestamp           time.Time  `json:"timestamp"`
+}
+
+type BakingRightV1 struct {
+	Level         int       `json:"level"`
+	Cycle         int       `json:"cycle"`
+	Priority      int       `json:"priority"`
+	EstimatedTime time.Time `json:"estimatedTime"`
+}
+
+type EndorsingRightV1 struct {
+	Level         int       `json:"level"`
+	Cycle         int       `json:"cycle"`
+	Slots         []int     `json:"slots"`
+	EstimatedTime time.Time `json:"estimatedTime"`
+}
+
+type RightsV1 struct {
+	Cycle     int                `json:"cycle"`
+	Baking    []BakingRightV1    `json:"baking"`
+	Endorsing []EndorsingRightV1 `json:"endorsing"`
+}
+
+type OutcomeV1 struct {
+	Level     int       `json:"level"`
+	Cycle     int       `json:"cycle"`
+	Priority  int       `json:"priority"`
+	NumSlots  int       `json:"numSlots"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+	Verified  string    `json:"verified,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type CycleSummaryV1 struct {
+	Cycle               int            `json:"cycle"`
+	BakingRights        int            `json:"bakingRights"`
+	Baked               int            `json:"baked"`
+	BakesMissed         map[string]int `json:"bakesMissed"`
+	BakingEfficiency    float64        `json:"bakingEfficiency"`
+	EndorsingRights     int            `json:"endorsingRights"`
+	EndorsingSlots      int            `json:"endorsingSlots"`
+	Endorsed            int            `json:"endorsed"`
+	EndorsedSlots       int            `json:"endorsedSlots"`
+	EndorsesMissed      map[string]int `json:"endorsesMissed"`
+	EndorsingEfficiency float64        `json:"endorsingEfficiency"`
+}
+
+type HistoryV1 struct {
+	Summary   CycleSummaryV1 `json:"summary"`
+	Baking    []OutcomeV1    `json:"baking"`
+	Endorsing []OutcomeV1    `json:"endorsing"`
+}
+
+type HistorySummariesV1 struct {
+	Summaries []CycleSummaryV1 `json:"summaries"`
+}
+
+type EndpointV1 struct {
+	Id  int    `json:"id"`
+	Url string `json:"url"`
+}
+
+type EndpointsV1 struct {
+	Endpoints []EndpointV1 `json:"endpoints"`
+}
+
+type AddEndpointRequestV1 struct {
+	Url string `json:"url"`
+}
+
+type BakerSettingsV1 struct {
+	BakerFee   int    `json:"bakerFee"`
+	UiExplorer string `json:"uiExplorer"`
+}
+
+type PayoutCycleV1 struct {
+	Cycle            int     `json:"cycle"`
+	Status           string  `json:"status"`
+	SnapshotIndex    int     `json:"snapshotIndex"`
+	SnapshotLevel    int     `json:"snapshotLevel"`
+	UnfrozenLevel    int     `json:"unfrozenLevel"`
+	BakerFee         float64 `json:"bakerFee"`
+	NumDelegators    int     `json:"numDelegators"`
+	Balance          int     `json:"balance"`
+	StakingBalance   int     `json:"stakingBalance"`
+	DelegatedBalance int     `json:"delegatedBalance"`
+	BlockRewards     int     `json:"blockRewards"`
+	FeeRewards       int     `json:"feeRewards"`
+	AutoPayHeld      string  `json:"autoPayHeld,omitempty"`
+}
+
+type PayoutsV1 struct {
+	Enabled bool            `json:"enabled"`
+	Cycles  []PayoutCycleV1 `json:"cycles"`
+}
+
+type DelegatorRewardV1 struct {
+	Delegator string  `json:"delegator"`
+	Balance   int     `json:"balance"`
+	SharePct  float64 `json:"sharePct"`
+	Share     int     `json:"share"`
+	Reward    int     `json:"reward"`
+	OpHash    string  `json:"opHash,omitempty"`
+	Fee       float64 `json:"fee"`
+	Payee     string  `json:"payee,omitempty"`
+	CarriedIn int     `json:"carriedIn,omitempty"`
+	Withheld  int     `json:"withheld,omitempty"`
+	Excluded  bool    `json:"excluded,omitempty"`
+	Note      string  `json:"note,omitempty"`
+}
+
+type PayoutCycleDetailV1 struct {
+	Cycle   PayoutCycleV1       `json:"cycle"`
+	Rewards []DelegatorRewardV1 `json:"rewards"`
+}
+
+type SendPayoutsResponseV1 struct {
+	Cycle  int    `json:"cycle"`
+	Status string `json:"status"`
+}
+
+type ImportKeyRequestV1 struct {
+	SecretKey string `json:"secretKey"`
+}
+
+type KeyV1 struct {
+	Pkh string `json:"pkh"`
+}
+
+type ConfirmLedgerRequestV1 struct {
+	BipPath string `json:"bipPath,omitempty"`
+}
+
+type LedgerV1 struct {
+	Pkh     string `json:"pkh"`
+	BipPath string `json:"bipPath"`
+	Version string `json:"version"`
+}
+
+type UpvoteRequestV1 struct {
+	Proposal string `json:"proposal"`
+	Period   int    `json:"period"`
+}
+
+type OperationV1 struct {
+	OpHash string `json:"opHash"`
+}
+
+// The export document belongs to storage, which also restores it
+type ExportV1 = storage.Export
+
+func (ws *WebServer) registerApiV1(v1Router *mux.Router) {
+
+	v1Router.HandleFunc("/openapi.json", ws.getOpenApiSpec).Methods("GET")
+	v1Router.HandleFunc("/health", ws.getHealthV1).Methods("GET")
+	v1Router.HandleFunc("/me", ws.requireRoleV1(ROLE_VIEWER, ws.getMeV1)).Methods("GET")
+	v1Router.HandleFunc("/status", ws.requireRoleV1(ROLE_VIEWER, ws.getStatusV1)).Methods("GET")
+	v1Router.HandleFunc("/rights", ws.requireRoleV1(ROLE_VIEWER, ws.getRightsV1)).Methods("GET")
+	v1Router.HandleFunc("/history", ws.requireRoleV1(ROLE_VIEWER, ws.getHistoryV1)).Methods("GET")
+	v1Router.HandleFunc("/history/summary", ws.requireRoleV1(ROLE_VIEWER, ws.getHistorySummariesV1)).Methods("GET")
+	v1Router.HandleFunc("/endpoints", ws.requireRoleV1(ROLE_VIEWER, ws.listEndpointsV1)).Methods("GET")
+	v1Router.HandleFunc("/endpoints", ws.requireRoleV1(ROLE_ADMIN, ws.addEndpointV1)).Methods("POST")
+	v1Router.HandleFunc("/endpoints/{id:[0-9]+}", ws.requireRoleV1(ROLE_ADMIN, ws.deleteEndpointV1)).Methods("DELETE")
+	v1Router.HandleFunc("/settings/baker", ws.requireRoleV1(ROLE_VIEWER, ws.getBakerSettingsV1)).Methods("GET")
+	v1Router.HandleFunc("/settings/baker", ws.requireRoleV1(ROLE_ADMIN, ws.putBakerSettingsV1)).Methods("PUT")
+	v1Router.HandleFunc("/payouts", ws.requireRoleV1(ROLE_VIEWER, ws.listPayoutsV1)).Methods("GET")
+	v1Router.HandleFunc("/payouts/{cycle:[0-9]+}", ws.requireRoleV1(ROLE_VIEWER, ws.getPayoutCycleV1)).Methods("GET")
+	v1Router.HandleFunc("/payouts/{cycle:[0-9]+}/send", ws.requireRoleV1(ROLE_OPERATOR, ws.sendPayoutsV1)).Methods("POST")
+	v1Router.HandleFunc("/key/import", ws.requireRoleV1(ROLE_ADMIN, ws.importKeyV1)).Methods("POST")
+	v1Router.HandleFunc("/ledger/confirm", ws.requireRoleV1(ROLE_ADMIN, ws.confirmLedgerV1)).Methods("POST")
+	v1Router.HandleFunc("/voting/upvote", ws.requireRoleV1(ROLE_OPERATOR, ws.upvoteV1)).Methods("POST")
+	v1Router.HandleFunc("/backup", ws.requireRoleV1(ROLE_ADMIN, ws.getBackupV1)).Methods("GET")
+	v1Router.HandleFunc("/export", ws.requireRoleV1(ROLE_ADMIN, ws.getExportV1)).Methods("GET")
+
+	// Unknown v1 routes return a v1 error rather than the default 404 page
+	v1Router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiErrorV1(errors.New("Not found"), w, http.StatusNotFound)
+	})
+}
+
+// requireRoleV1 is requireRole, with failures returned as ErrorV1
+func (ws *WebServer) requireRoleV1(role string, next http.HandlerFunc) http.HandlerFunc {
+	return ws.requireRoleWith(apiErrorV1, role, next)
+}
+
+func apiReturnV1(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if v == nil {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Error("API v1 Return Encode Failure")
+	}
+}
+
+func apiErrorV1(err error, w http.ResponseWriter, status int) {
+	apiReturnV1(w, status, ErrorV1{
+		Error:  err.Error(),
+		Status: status,
+	})
+}
+
+// decodeBodyV1 strictly decodes the request body; unknown fields are rejected
+func decodeBodyV1(r *http.Request, v interface{}) error {
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return errors.Wrap(err, "Invalid request body")
+	}
+
+	return nil
+}
+
+// cycleParamV1 parses the optional cycle query parameter, defaulting to the current cycle
+func (ws *WebServer) cycleParamV1(r *http.Request) (int, error) {
+
+	c := r.URL.Query().Get("cycle")
+	if c == "" {
+		return ws.baconClient.Status.Cycle, nil
+	}
+
+	cycle, err := strconv.Atoi(c)
+	if err != nil || cycle < 0 {
+		return 0, errors.Errorf("Invalid cycle %q", c)
+	}
+
+	return cycle, nil
+}
+
+func (ws *WebServer) getOpenApiSpec(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(openApiSpec); err != nil {
+		log.WithError(err).Error("API v1 Return OpenAPI Failure")
+	}
+}
+
+func (ws *WebServer) getHealthV1(w http.ResponseWriter, r *http.Request) {
+	apiReturnV1(w, http.StatusOK, HealthV1{Ok: true})
+}
+
+func (ws *WebServer) getMeV1(w http.ResponseWriter, r *http.Request) {
+
+	p := getPrincipal(r)
+
+	apiReturnV1(w, http.StatusOK, MeV1{
+		Name: p.Name,
+		Role: p.Role,
+	})
+}
+
+func (ws *WebServer) getStatusV1(w http.ResponseWriter, r *http.Request) {
+
+	_, pkh, err := ws.storage.GetDelegate()
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot get delegate"), w, http.StatusInternalServerError)
+		return
+	}
+
+	s := ws.baconClient.Status
+
+	apiReturnV1(w, http.StatusOK, StatusV1{
+		Network:       s.Network,
+		Delegate:      pkh,
+		State:         s.State,
+		Error:         s.ErrorMsg,
+		HeadHash:      s.Hash,
+		Level:         s.Level,
+		Cycle:         s.Cycle,
+		CyclePosition: s.CyclePosition,
+		NextEndorsement: RightRefV1{
+			Level: s.NextEndorsementLevel,
+			Cycle: s.NextEndorsementCycle,
+		},
+		NextBake: RightRefV1{
+			Level:    s.NextBakingLevel,
+			Cycle:    s.NextBakingCycle,
+			Priority: s.NextBakingPriority,
+		},
+		PreviousEndorsement: RightRefV1{
+			Level: s.PreviousEndorsementLevel,
+			Cycle: s.PreviousEndorsementCycle,
+			Hash:  s.PreviousEndorsementHash,
+		},
+		PreviousBake: RightRefV1{
+			Level: s.PreviousBakeLevel,
+			Cycle: s.PreviousBakeCycle,
+			Hash:  s.PreviousBakeHash,
+		},
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+func (ws *WebServer) getRightsV1(w http.ResponseWriter, r *http.Request) {
+
+	cycle, err := ws.cycleParamV1(r)
+	if err != nil {
+		apiErrorV1(err, w, http.StatusBadRequest)
+		return
+	}
+
+	bakingRights, err := ws.storage.GetBakingRightsForCycle(cycle)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get baking rights"), w, http.StatusInternalServerError)
+		return
+	}
+
+	endorsingRights, err := ws.storage.GetEndorsingRightsForCycle(cycle)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get endorsing rights"), w, http.StatusInternalServerError)
+		return
+	}
+
+	rights := RightsV1{
+		Cycle:     cycle,
+		Baking:    make([]BakingRightV1, 0, len(bakingRights)),
+		Endorsing: make([]EndorsingRightV1, 0, len(endorsingRights)),
+	}
+
+	for _, b := range bakingRights {
+		rights.Baking = append(rights.Baking, BakingRightV1{
+			Level:         b.Level,
+			Cycle:         b.Cycle,
+			Priority:      b.Priority,
+			EstimatedTime: b.EstimatedTime,
+		})
+	}
+
+	for _, e := range endorsingRights {
+
+		slots := e.Slots
+		if slots == nil {
+			slots = make([]int, 0)
+		}
+
+		rights.Endorsing = append(rights.Endorsing, EndorsingRightV1{
+			Level:         e.Level,
+			Cycle:         e.Cycle,
+			Slots:         slots,
+			EstimatedTime: e.EstimatedTime,
+		})
+	}
+
+	apiReturnV1(w, http.StatusOK, rights)
+}
+
+func (ws *WebServer) getHistoryV1(w http.ResponseWriter, r *http.Request) {
+
+	cycle, err := ws.cycleParamV1(r)
+	if err != nil {
+		apiErrorV1(err, w, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := ws.storage.GetCycleSummary(cycle, ws.baconClient.Status.Level)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get cycle summary"), w, http.StatusInternalServerError)
+		return
+	}
+
+	bakingOutcomes, err := ws.storage.GetBakingOutcomesForCycle(cycle)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get baking history"), w, http.StatusInternalServerError)
+		return
+	}
+
+	endorsingOutcomes, err := ws.storage.GetEndorsingOutcomesForCycle(cycle)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get endorsing history"), w, http.StatusInternalServerError)
+		return
+	}
+
+	apiReturnV1(w, http.StatusOK, HistoryV1{
+		Summary:   toCycleSummaryV1(summary),
+		Baking:    toOutcomesV1(bakingOutcomes),
+		Endorsing: toOutcomesV1(endorsingOutcomes),
+	})
+}
+
+func (ws *WebServer) getHistorySummariesV1(w http.ResponseWriter, r *http.Request) {
+
+	numCycles := DEFAULT_SUMMARY_CYCLES
+
+	if n := r.URL.Query().Get("n"); n != "" {
+		var err error
+		if numCycles, err = strconv.Atoi(n); err != nil || numCycles < 1 || numCycles > MAX_SUMMARY_CYCLES {
+			apiErrorV1(errors.Errorf("Invalid number of cycles %q; Must be 1 to %d", n, MAX_SUMMARY_CYCLES), w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	curCycle := ws.baconClient.Status.Cycle
+	curLevel := ws.baconClient.Status.Level
+
+	summaries := HistorySummariesV1{
+		Summaries: make([]CycleSummaryV1, 0),
+	}
+
+	for cycle := curCycle; cycle > curCycle-numCycles && cycle >= 0; cycle-- {
+
+		summary, err := ws.storage.GetCycleSummary(cycle, curLevel)
+		if err != nil {
+			apiErrorV1(errors.Wrap(err, "Unable to get cycle summary"), w, http.StatusInternalServerError)
+			return
+		}
+
+		summaries.Summaries = append(summaries.Summaries, toCycleSummaryV1(summary))
+	}
+
+	apiReturnV1(w, http.StatusOK, summaries)
+}
+
+func toCycleSummaryV1(s storage.CycleSummary) CycleSummaryV1 {
+
+	// Always return objects, never null
+	if s.BakesMissed == nil {
+		s.BakesMissed = make(map[string]int)
+	}
+	if s.EndorsesMissed == nil {
+		s.EndorsesMissed = make(map[string]int)
+	}
+
+	return CycleSummaryV1{
+		Cycle:               s.Cycle,
+		BakingRights:        s.BakingRights,
+		Baked:               s.Baked,
+		BakesMissed:         s.BakesMissed,
+		BakingEfficiency:    s.BakingEff,
+		EndorsingRights:     s.EndorsingRights,
+		EndorsingSlots:      s.EndorsingSlots,
+		Endorsed:            s.Endorsed,
+		EndorsedSlots:       s.EndorsedSlots,
+		EndorsesMissed:      s.EndorsesMissed,
+		EndorsingEfficiency: s.EndorsingEff,
+	}
+}
+
+func toOutcomesV1(outcomes map[int]storage.RightOutcome) []OutcomeV1 {
+
+	sorted := make([]OutcomeV1, 0, len(outcomes))
+
+	for _, o := range sortedOutcomes(outcomes) {
+		sorted = append(sorted, OutcomeV1{
+			Level:     o.Level,
+			Cycle:     o.Cycle,
+			Priority:  o.Priority,
+			NumSlots:  o.NumSlots,
+			Outcome:   o.Outcome,
+			Reason:    o.Reason,
+			Hash:      o.Hash,
+			Verified:  o.Verified,
+			Timestamp: o.Timestamp,
+		})
+	}
+
+	return sorted
+}
+
+func (ws *WebServer) listEndpointsV1(w http.ResponseWriter, r *http.Request) {
+
+	endpoints, err := ws.storage.GetRPCEndpoints()
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot get endpoints"), w, http.StatusInternalServerError)
+		return
+	}
+
+	list := EndpointsV1{
+		Endpoints: make([]EndpointV1, 0, len(endpoints)),
+	}
+
+	for id, u := range endpoints {
+		list.Endpoints = append(list.Endpoints, EndpointV1{Id: id, Url: u})
+	}
+
+	sort.Slice(list.Endpoints, func(i, j int) bool {
+		return list.Endpoints[i].Id < list.Endpoints[j].Id
+	})
+
+	apiReturnV1(w, http.StatusOK, list)
+}
+
+func (ws *WebServer) addEndpointV1(w http.ResponseWriter, r *http.Request) {
+
+	var req AddEndpointRequestV1
+	if err := decodeBodyV1(r, &req); err != nil {
+		apiErrorV1(err, w, http.StatusBadRequest)
+		return
+	}
+
+	if u, err := url.Parse(req.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		apiErrorV1(errors.Errorf("Invalid endpoint URL %q", req.Url), w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := ws.storage.AddRPCEndpoint(req.Url)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot add endpoint"), w, http.StatusInternalServerError)
+		return
+	}
+
+	// Id is 0 when the endpoint already exists
+	if id == 0 {
+		apiErrorV1(errors.New("Endpoint already exists"), w, http.StatusConflict)
+		return
+	}
+
+	// Init new bacon watcher for this RPC
+	ws.baconClient.AddRpc(id, req.Url)
+
+	log.WithField("Endpoint", req.Url).Debug("API v1 Added Endpoint")
+
+	apiReturnV1(w, http.StatusCreated, EndpointV1{Id: id, Url: req.Url})
+}
+
+func (ws *WebServer) deleteEndpointV1(w http.ResponseWriter, r *http.Request) {
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apiErrorV1(errors.New("Invalid endpoint id"), w, http.StatusBadRequest)
+		return
+	}
+
+	endpoints, err := ws.storage.GetRPCEndpoints()
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot get endpoints"), w, http.StatusInternalServerError)
+		return
+	}
+
+	if _, ok := endpoints[id]; !ok {
+		apiErrorV1(errors.Errorf("Unknown endpoint %d", id), w, http.StatusNotFound)
+		return
+	}
+
+	// Need to shutdown the RPC client first
+	if err := ws.baconClient.ShutdownRpc(id); err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot shutdown RPC client"), w, http.StatusInternalServerError)
+		return
+	}
+
+	if err := ws.storage.DeleteRPCEndpoint(id); err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot delete endpoint"), w, http.StatusInternalServerError)
+		return
+	}
+
+	log.WithField("Endpoint", endpoints[id]).Debug("API v1 Deleted Endpoint")
+
+	apiReturnV1(w, http.StatusNoContent, nil)
+}
+
+func (ws *WebServer) getBakerSettingsV1(w http.ResponseWriter, r *http.Request) {
+
+	settings, err := ws.storage.GetBakerSettings()
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot get baker settings"), w, http.StatusInternalServerError)
+		return
+	}
+
+	// Stored fee is returned as a string for the web UI
+	bakerFee, _ := strconv.Atoi(settings[storage.BAKER_FEE].(string))
+	uiExplorer, _ := settings[storage.UI_EXPLORER].(string)
+
+	apiReturnV1(w, http.StatusOK, BakerSettingsV1{
+		BakerFee:   bakerFee,
+		UiExplorer: uiExplorer,
+	})
+}
+
+func (ws *WebServer) putBakerSettingsV1(w http.ResponseWriter, r *http.Request) {
+
+	var req BakerSettingsV1
+	if err := decodeBodyV1(r, &req); err != nil {
+		apiErrorV1(err, w, http.StatusBadRequest)
+		return
+	}
+
+	if req.BakerFee < 1 || req.BakerFee > 99 {
+		apiErrorV1(errors.New("Baker fee must be between 1 and 99"), w, http.StatusBadRequest)
+		return
+	}
+
+	if err := ws.storage.SaveBakerSettings(map[string]string{
+		storage.BAKER_FEE:   strconv.Itoa(req.BakerFee),
+		storage.UI_EXPLORER: req.UiExplorer,
+	}); err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot save baker settings"), w, http.StatusInternalServerError)
+		return
+	}
+
+	apiReturnV1(w, http.StatusOK, req)
+}
+
+func (ws *WebServer) listPayoutsV1(w http.ResponseWriter, r *http.Request) {
+
+	payoutsMetadata, err := ws.payoutsHandler.GetPayoutsMetadataAll()
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get payouts"), w, http.StatusInternalServerError)
+		return
+	}
+
+	list := PayoutsV1{
+		Enabled: !ws.payoutsHandler.Disabled,
+		Cycles:  make([]PayoutCycleV1, 0, len(payoutsMetadata)),
+	}
+
+	for cycle, m := range payoutsMetadata {
+		list.Cycles = append(list.Cycles, NewPayoutCycleV1(cycle, m))
+	}
+
+	sort.Slice(list.Cycles, func(i, j int) bool {
+		return list.Cycles[i].Cycle < list.Cycles[j].Cycle
+	})
+
+	apiReturnV1(w, http.StatusOK, list)
+}
+
+func (ws *WebServer) getPayoutCycleV1(w http.ResponseWriter, r *http.Request) {
+
+	cycle, err := strconv.Atoi(mux.Vars(r)["cycle"])
+	if err != nil {
+		apiErrorV1(errors.New("Invalid cycle"), w, http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := ws.payoutsHandler.GetRewardMetadataForCycle(cycle)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get cycle payouts"), w, http.StatusInternalServerError)
+		return
+	}
+
+	// Metadata is always saved with a status
+	if metadata.Status == "" {
+		apiErrorV1(errors.Errorf("No payouts for cycle %d", cycle), w, http.StatusNotFound)
+		return
+	}
+
+	rewards, err := ws.payoutsHandler.GetDelegatorRewardAllForCycle(cycle)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get cycle rewards"), w, http.StatusInternalServerError)
+		return
+	}
+
+	detail := PayoutCycleDetailV1{
+		Cycle:   NewPayoutCycleV1(cycle, metadata),
+		Rewards: make([]DelegatorRewardV1, 0, len(rewards)),
+	}
+
+	for _, d := range rewards {
+		detail.Rewards = append(detail.Rewards, DelegatorRewardV1{
+			Delegator: d.Delegator,
+			Balance:   d.Balance,
+			SharePct:  d.SharePct,
+			Share:     d.Share,
+			Reward:    d.Reward,
+			OpHash:    d.OpHash,
+			Fee:       d.Fee,
+			Payee:     d.Payee,
+			CarriedIn: d.CarriedIn,
+			Withheld:  d.Withheld,
+			Excluded:  d.Excluded,
+			Note:      d.Note,
+		})
+	}
+
+	sort.Slice(detail.Rewards, func(i, j int) bool {
+		return detail.Rewards[i].Delegator < detail.Rewards[j].Delegator
+	})
+
+	apiReturnV1(w, http.StatusOK, detail)
+}
+
+func (ws *WebServer) sendPayoutsV1(w http.ResponseWriter, r *http.Request) {
+
+	cycle, err := strconv.Atoi(mux.Vars(r)["cycle"])
+	if err != nil {
+		apiErrorV1(errors.New("Invalid cycle"), w, http.StatusBadRequest)
+		return
+	}
+
+	if ws.payoutsHandler.Disabled {
+		apiErrorV1(errors.New("Payouts are disabled"), w, http.StatusConflict)
+		return
+	}
+
+	metadata, err := ws.payoutsHandler.GetRewardMetadataForCycle(cycle)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to get cycle payouts"), w, http.StatusInternalServerError)
+		return
+	}
+
+	switch metadata.Status {
+	case "":
+		apiErrorV1(errors.Errorf("No payouts for cycle %d", cycle), w, http.StatusNotFound)
+		return
+	case payouts.IN_PROGRESS, payouts.DONE:
+		apiErrorV1(errors.Errorf("Payouts for cycle %d already sent", cycle), w, http.StatusConflict)
+		return
+	}
+
+	if err := ws.payoutsHandler.SendCyclePayouts(cycle); err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to send cycle payouts"), w, http.StatusInternalServerError)
+		return
+	}
+
+	// Payouts are injected in the background
+	apiReturnV1(w, http.StatusAccepted, SendPayoutsResponseV1{
+		Cycle:  cycle,
+		Status: payouts.IN_PROGRESS,
+	})
+}
+
+// NewPayoutCycleV1 converts stored cycle metadata for the API
+func NewPayoutCycleV1(cycle int, m payouts.CycleRewardMetadata) PayoutCycleV1 {
+	return PayoutCycleV1{
+		Cycle:            cycle,
+		Status:           m.Status,
+		SnapshotIndex:    m.SnapshotIndex,
+		SnapshotLevel:    m.SnapshotLevel,
+		UnfrozenLevel:    m.UnfrozenLevel,
+		BakerFee:         m.BakerFee,
+		NumDelegators:    m.NumDelegators,
+		Balance:          m.Balance,
+		StakingBalance:   m.StakingBalance,
+		DelegatedBalance: m.DelegatedBalance,
+		BlockRewards:     m.BlockRewards,
+		FeeRewards:       m.FeeRewards,
+		AutoPayHeld:      m.AutoPayHeld,
+	}
+}
+
+func (ws *WebServer) importKeyV1(w http.ResponseWriter, r *http.Request) {
+
+	var req ImportKeyRequestV1
+	if err := decodeBodyV1(r, &req); err != nil {
+		apiErrorV1(err, w, http.StatusBadRequest)
+		return
+	}
+
+	// Saves the key and sets the signer type to wallet
+	_, pkh, err := ws.baconClient.Signer.ImportSecretKey(req.SecretKey)
+	if err != nil {
+		apiErrorV1(err, w, http.StatusBadRequest)
+		return
+	}
+
+	log.WithField("PKH", pkh).Info("Imported secret key-pair")
+
+	// Update bacon status with the new delegate
+	_ = ws.baconClient.CanBake(false)
+
+	apiReturnV1(w, http.StatusOK, KeyV1{Pkh: pkh})
+}
+
+func (ws *WebServer) confirmLedgerV1(w http.ResponseWriter, r *http.Request) {
+
+	var req ConfirmLedgerRequestV1
+	if err := decodeBodyV1(r, &req); err != nil {
+		apiErrorV1(err, w, http.StatusBadRequest)
+		return
+	}
+
+	ledgerInfo, err := ws.baconClient.Signer.TestLedger()
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Unable to access ledger"), w, http.StatusInternalServerError)
+		return
+	}
+
+	// Default to the path already authorized on the device
+	bipPath := req.BipPath
+	if bipPath == "" {
+		bipPath = ledgerInfo.BipPath
+	}
+
+	// Prompts user on device to push button; saves to DB on success
+	if err := ws.baconClient.Signer.ConfirmBakingPkh(ledgerInfo.Pkh, bipPath); err != nil {
+		apiErrorV1(err, w, http.StatusInternalServerError)
+		return
+	}
+
+	_ = ws.baconClient.CanBake(false)
+
+	apiReturnV1(w, http.StatusOK, LedgerV1{
+		Pkh:     ledgerInfo.Pkh,
+		BipPath: bipPath,
+		Version: ledgerInfo.Version,
+	})
+}
+
+func (ws *WebServer) upvoteV1(w http.ResponseWriter, r *http.Request) {
+
+	var req UpvoteRequestV1
+	if err := decodeBodyV1(r, &req); err != nil {
+		apiErrorV1(err, w, http.StatusBadRequest)
+		return
+	}
+
+	if req.Proposal == "" {
+		apiErrorV1(errors.New("Missing proposal"), w, http.StatusBadRequest)
+		return
+	}
+
+	opHash, err := ws.baconClient.UpvoteProposal(req.Proposal, req.Period)
+	if err != nil {
+		apiErrorV1(errors.Wrap(err, "Cannot cast upvote"), w, http.StatusInternalServerError)
+		return
+	}
+
+	log.WithField("OpHash", opHash).Info("Injected voting operation")
+
+	apiReturnV1(w, http.StatusOK, OperationV1{OpHash: opHash})
+}
+
+// Backups and exports contain the secret key, if using a wallet, hence admin only
+
+func (ws *WebServer) getBackupV1(w http.ResponseWriter, r *http.Request) {
+
+	fileName := fmt.Sprintf("bakinbacon-%s-%s.db", ws.baconClient.Status.Network, time.Now().UTC().Format("20060102-150405"))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	// Too late for an error response once writing has started
+	n, err := ws.storage.Backup(w)
+	if err != nil {
+		log.WithError(err).Error("API v1 Backup Failure")
+		return
+	}
+
+	log.WithField("Bytes", n).Info("Sent database backup")
+}
+
+func (ws *WebServer) getExportV1(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := ws.storage.Export(w); err != nil {
+		log.WithError(err).Error("API v1 Export Failure")
+	}
+}
@@ -0,0 +1,537 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bakingbacon/go-tezos/v4/rpc"
+	"github.com/gorilla/mux"
+
+	"bakinbacon/baconclient"
+	"bakinbacon/baconsigner"
+	"bakinbacon/payouts"
+	"bakinbacon/storage"
+)
+
+// Contract tests for /api/v1. Every response is checked against the
+// schemas in the embedded openapi.json, so the document and the
+// handlers cannot drift apart.
+
+const (
+	TEST_VIEWER_TOKEN   = "bb_viewer"
+	TEST_OPERATOR_TOKEN = "bb_operator"
+	TEST_ADMIN_TOKEN    = "bb_admin"
+)
+
+type testApi struct {
+	t       *testing.T
+	spec    map[string]interface{}
+	router  *mux.Router
+	db      *storage.Storage
+	payouts *payouts.PayoutsHandler
+}
+
+func newTestApi(t *testing.T) *testApi {
+
+	db, err := storage.InitStorage(t.TempDir()+"/", "hangzhounet")
+	if err != nil {
+		t.Fatalf("Unable to init storage: %s", err)
+	}
+	t.Cleanup(db.CloseDb)
+
+	for token, role := range map[string]string{
+		TEST_VIEWER_TOKEN:   ROLE_VIEWER,
+		TEST_OPERATOR_TOKEN: ROLE_OPERATOR,
+		TEST_ADMIN_TOKEN:    ROLE_ADMIN,
+	} {
+		if err := db.SaveApiToken(hashSecret(token), storage.ApiToken{
+			Id: role, Name: role, Role: role, Created: time.Now().UTC(),
+		}); err != nil {
+			t.Fatalf("Unable to save token: %s", err)
+		}
+	}
+
+	bc := &baconclient.BaconClient{
+		Status: &baconclient.BaconStatus{
+			Network: "hangzhounet",
+			Hash:    "BLockHash",
+			Level:   10000,
+			Cycle:   2,
+			State:   "can-bake",
+		},
+	}
+
+	if bc.Signer, err = baconsigner.New(db); err != nil {
+		t.Fatalf("Unable to create signer: %s", err)
+	}
+
+	ph, err := payouts.NewPayoutsHandler(bc, db, nil, nil, true)
+	if err != nil {
+		t.Fatalf("Unable to create payouts handler: %s", err)
+	}
+
+	ws := &WebServer{
+		baconClient:    bc,
+		payoutsHandler: ph,
+		storage:        db,
+	}
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openApiSpec, &spec); err != nil {
+		t.Fatalf("Unable to parse openapi.json: %s", err)
+	}
+
+	router := mux.NewRouter()
+	ws.registerApiV1(router.PathPrefix("/api").Subrouter().PathPrefix("/v1").Subrouter())
+
+	return &testApi{
+		t:       t,
+		spec:    spec,
+		router:  router,
+		db:      db,
+		payouts: ph,
+	}
+}
+
+// call makes a request and validates the response against the documented
+// operation. The decoded body is returned.
+func (a *testApi) call(method, path, token string, body interface{}, expectStatus int) interface{} {
+
+	a.t.Helper()
+
+	var reqBody []byte
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reqBody = []byte(b)
+	default:
+		reqBody, _ = json.Marshal(b)
+	}
+
+	req := httptest.NewRequest(method, "/api/v1"+path, bytes.NewReader(reqBody))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+
+	if rec.Code != expectStatus {
+		a.t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, expectStatus, rec.Code, rec.Body.String())
+	}
+
+	operation := a.findOperation(method, strings.SplitN(path, "?", 2)[0])
+
+	responses := operation["responses"].(map[string]interface{})
+	response, ok := responses[fmt.Sprintf("%d", rec.Code)].(map[string]interface{})
+	if !ok {
+		a.t.Fatalf("%s %s: status %d is not documented", method, path, rec.Code)
+	}
+
+	content, ok := response["content"].(map[string]interface{})
+	if !ok {
+		if rec.Body.Len() > 0 {
+			a.t.Fatalf("%s %s: undocumented response body: %s", method, path, rec.Body.String())
+		}
+		return nil
+	}
+
+	// Files are returned as is
+	if _, ok := content["application/octet-stream"]; ok {
+		if ct := rec.Header().Get("Content-Type"); ct != "application/octet-stream" {
+			a.t.Fatalf("%s %s: expected a file, got %q", method, path, ct)
+		}
+		return rec.Body.Bytes()
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		a.t.Fatalf("%s %s: expected JSON, got %q", method, path, ct)
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		a.t.Fatalf("%s %s: invalid JSON: %s", method, path, err)
+	}
+
+	if schema, ok := content["application/json"].(map[string]interface{})["schema"]; ok {
+		if err := a.validate(schema.(map[string]interface{}), decoded, "body"); err != nil {
+			a.t.Fatalf("%s %s: response does not match schema: %s", method, path, err)
+		}
+	}
+
+	return decoded
+}
+
+// findOperation matches a concrete path against the templated paths in the document
+func (a *testApi) findOperation(method, path string) map[string]interface{} {
+
+	a.t.Helper()
+
+	paramRe := regexp.MustCompile(`\\\{[^}]+\}`)
+
+	for specPath, item := range a.spec["paths"].(map[string]interface{}) {
+
+		pathRe := regexp.MustCompile("^" + paramRe.ReplaceAllString(regexp.QuoteMeta(specPath), `[^/]+`) + "$")
+		if !pathRe.MatchString(path) {
+			continue
+		}
+
+		if operation, ok := item.(map[string]interface{})[strings.ToLower(method)]; ok {
+			return operation.(map[string]interface{})
+		}
+	}
+
+	a.t.Fatalf("%s %s is not documented", method, path)
+	return nil
+}
+
+// validate checks the subset of JSON Schema used by openapi.json
+func (a *testApi) validate(schema map[string]interface{}, value interface{}, where string) error {
+
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		return a.validate(a.spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{}), value, where)
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", where, value)
+		}
+
+		if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+			for k, v := range obj {
+				if err := a.validate(additional, v, where+"."+k); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		props, _ := schema["properties"].(map[string]interface{})
+
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := obj[r.(string)]; !ok {
+					return fmt.Errorf("%s: missing required property %q", where, r)
+				}
+			}
+		}
+
+		for k, v := range obj {
+			propSchema, ok := props[k]
+			if !ok {
+				return fmt.Errorf("%s: undocumented property %q", where, k)
+			}
+			if err := a.validate(propSchema.(map[string]interface{}), v, where+"."+k); err != nil {
+				return err
+			}
+		}
+
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", where, value)
+		}
+		for i, v := range arr {
+			if err := a.validate(schema["items"].(map[string]interface{}), v, fmt.Sprintf("%s[%d]", where, i)); err != nil {
+				return err
+			}
+		}
+
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: expected integer, got %v", where, value)
+		}
+
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected number, got %T", where, value)
+		}
+
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string, got %T", where, value)
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok {
+			for _, e := range enum {
+				if e == s {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: %q is not one of %v", where, s, enum)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", where, value)
+		}
+	}
+
+	return nil
+}
+
+func TestApiV1SpecMatchesRoutes(t *testing.T) {
+
+	a := newTestApi(t)
+
+	// Strip mux regexps, eg {id:[0-9]+} -> {id}
+	muxVarRe := regexp.MustCompile(`\{([^}:]+):[^}]+\}`)
+
+	var routes []string
+	if err := a.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		path := muxVarRe.ReplaceAllString(strings.TrimPrefix(tmpl, "/api/v1"), "{$1}")
+		for _, m := range methods {
+			routes = append(routes, strings.ToLower(m)+" "+path)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatalf("Unable to walk routes: %s", err)
+	}
+
+	var documented []string
+	for path, item := range a.spec["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			documented = append(documented, method+" "+path)
+		}
+	}
+
+	sort.Strings(routes)
+	sort.Strings(documented)
+
+	if strings.Join(routes, "\n") != strings.Join(documented, "\n") {
+		t.Fatalf("Routes and openapi.json differ\nRoutes:\n%s\nDocumented:\n%s", strings.Join(routes, "\n"), strings.Join(documented, "\n"))
+	}
+}
+
+func TestApiV1OpenApiServed(t *testing.T) {
+
+	a := newTestApi(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	body, _ := ioutil.ReadAll(rec.Body)
+	if !bytes.Equal(body, openApiSpec) {
+		t.Fatal("Served document differs from embedded openapi.json")
+	}
+}
+
+func TestApiV1Auth(t *testing.T) {
+
+	a := newTestApi(t)
+
+	a.call("GET", "/health", "", nil, http.StatusOK)
+	a.call("GET", "/status", "", nil, http.StatusUnauthorized)
+	a.call("GET", "/status", "bb_unknown", nil, http.StatusUnauthorized)
+	a.call("PUT", "/settings/baker", TEST_VIEWER_TOKEN, BakerSettingsV1{BakerFee: 10}, http.StatusForbidden)
+	a.call("POST", "/payouts/1/send", TEST_VIEWER_TOKEN, nil, http.StatusForbidden)
+	a.call("DELETE", "/endpoints/1", TEST_OPERATOR_TOKEN, nil, http.StatusForbidden)
+
+	me := a.call("GET", "/me", TEST_OPERATOR_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if me["role"] != ROLE_OPERATOR {
+		t.Errorf("Expected role %q, got %v", ROLE_OPERATOR, me["role"])
+	}
+}
+
+func TestApiV1Read(t *testing.T) {
+
+	a := newTestApi(t)
+
+	if err := a.db.SaveBakingRightsForLevels(2, []int{9000}, []rpc.BakingRights{{Level: 9000, Priority: 1}}); err != nil {
+		t.Fatalf("Unable to save baking right: %s", err)
+	}
+
+	if err := a.db.RecordBakingOutcome(storage.RightOutcome{Level: 9000, Cycle: 2, Priority: 1, Outcome: storage.OUTCOME_BAKED}); err != nil {
+		t.Fatalf("Unable to record outcome: %s", err)
+	}
+
+	status := a.call("GET", "/status", TEST_VIEWER_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if status["level"] != float64(10000) {
+		t.Errorf("Expected level 10000, got %v", status["level"])
+	}
+
+	rights := a.call("GET", "/rights", TEST_VIEWER_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if n := len(rights["baking"].([]interface{})); n != 1 {
+		t.Errorf("Expected 1 baking right, got %d", n)
+	}
+
+	a.call("GET", "/rights?cycle=1", TEST_VIEWER_TOKEN, nil, http.StatusOK)
+	a.call("GET", "/rights?cycle=abc", TEST_VIEWER_TOKEN, nil, http.StatusBadRequest)
+
+	history := a.call("GET", "/history?cycle=2", TEST_VIEWER_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if n := len(history["baking"].([]interface{})); n != 1 {
+		t.Errorf("Expected 1 baking outcome, got %d", n)
+	}
+
+	summaries := a.call("GET", "/history/summary?n=2", TEST_VIEWER_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if n := len(summaries["summaries"].([]interface{})); n != 2 {
+		t.Errorf("Expected 2 summaries, got %d", n)
+	}
+
+	a.call("GET", "/history/summary?n=0", TEST_VIEWER_TOKEN, nil, http.StatusBadRequest)
+	a.call("GET", "/history/summary?n=101", TEST_VIEWER_TOKEN, nil, http.StatusBadRequest)
+	a.call("GET", "/history/summary?n=9223372036854775807", TEST_VIEWER_TOKEN, nil, http.StatusBadRequest)
+}
+
+func TestApiV1Endpoints(t *testing.T) {
+
+	a := newTestApi(t)
+
+	id, err := a.db.AddRPCEndpoint("http://127.0.0.1:8732")
+	if err != nil {
+		t.Fatalf("Unable to add endpoint: %s", err)
+	}
+
+	endpoints, _ := a.db.GetRPCEndpoints()
+
+	list := a.call("GET", "/endpoints", TEST_VIEWER_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if n := len(list["endpoints"].([]interface{})); n != len(endpoints) {
+		t.Errorf("Expected %d endpoints, got %d", len(endpoints), n)
+	}
+
+	a.call("POST", "/endpoints", TEST_ADMIN_TOKEN, AddEndpointRequestV1{Url: "http://127.0.0.1:8732"}, http.StatusConflict)
+	a.call("POST", "/endpoints", TEST_ADMIN_TOKEN, AddEndpointRequestV1{Url: "ftp://example.com"}, http.StatusBadRequest)
+	a.call("POST", "/endpoints", TEST_ADMIN_TOKEN, `{"url": "http://127.0.0.1:8732", "extra": 1}`, http.StatusBadRequest)
+	a.call("POST", "/endpoints", TEST_ADMIN_TOKEN, `not json`, http.StatusBadRequest)
+
+	a.call("DELETE", fmt.Sprintf("/endpoints/%d", id+100), TEST_ADMIN_TOKEN, nil, http.StatusNotFound)
+}
+
+func TestApiV1BakerSettings(t *testing.T) {
+
+	a := newTestApi(t)
+
+	a.call("PUT", "/settings/baker", TEST_ADMIN_TOKEN, BakerSettingsV1{BakerFee: 0}, http.StatusBadRequest)
+	a.call("PUT", "/settings/baker", TEST_ADMIN_TOKEN, BakerSettingsV1{BakerFee: 8, UiExplorer: "tzkt"}, http.StatusOK)
+
+	settings := a.call("GET", "/settings/baker", TEST_VIEWER_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if settings["bakerFee"] != float64(8) || settings["uiExplorer"] != "tzkt" {
+		t.Errorf("Settings not saved: %v", settings)
+	}
+}
+
+func TestApiV1Payouts(t *testing.T) {
+
+	a := newTestApi(t)
+
+	if err := a.payouts.SaveRewardMetadataForCycle(3, payouts.CycleRewardMetadata{
+		PayoutCycle: 3, Status: payouts.CALCULATED, BakerFee: 0.1, NumDelegators: 1,
+	}); err != nil {
+		t.Fatalf("Unable to save metadata: %s", err)
+	}
+
+	if err := a.payouts.SaveDelegatorReward(3, payouts.DelegatorReward{
+		Delegator: "tz1MTZEJE7YH3wzo8YYiAGd8sgiCTxNRHczR", Balance: 1000, SharePct: 1, Reward: 500,
+	}); err != nil {
+		t.Fatalf("Unable to save reward: %s", err)
+	}
+
+	if err := a.payouts.SaveRewardMetadataForCycle(4, payouts.CycleRewardMetadata{
+		PayoutCycle: 4, Status: payouts.DONE,
+	}); err != nil {
+		t.Fatalf("Unable to save metadata: %s", err)
+	}
+
+	list := a.call("GET", "/payouts", TEST_VIEWER_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if n := len(list["cycles"].([]interface{})); n != 2 {
+		t.Errorf("Expected 2 cycles, got %d", n)
+	}
+
+	detail := a.call("GET", "/payouts/3", TEST_VIEWER_TOKEN, nil, http.StatusOK).(map[string]interface{})
+	if n := len(detail["rewards"].([]interface{})); n != 1 {
+		t.Errorf("Expected 1 reward, got %d", n)
+	}
+
+	a.call("GET", "/payouts/9", TEST_VIEWER_TOKEN, nil, http.StatusNotFound)
+
+	// Handler was created with payouts disabled
+	a.call("POST", "/payouts/3/send", TEST_OPERATOR_TOKEN, nil, http.StatusConflict)
+
+	a.payouts.Disabled = false
+	a.call("POST", "/payouts/4/send", TEST_OPERATOR_TOKEN, nil, http.StatusConflict)
+	a.call("POST", "/payouts/9/send", TEST_OPERATOR_TOKEN, nil, http.StatusNotFound)
+}
+
+func TestApiV1Setup(t *testing.T) {
+
+	a := newTestApi(t)
+
+	a.call("POST", "/key/import", TEST_OPERATOR_TOKEN, ImportKeyRequestV1{SecretKey: "edsk"}, http.StatusForbidden)
+	a.call("POST", "/key/import", TEST_ADMIN_TOKEN, ImportKeyRequestV1{SecretKey: "notakey"}, http.StatusBadRequest)
+	a.call("POST", "/voting/upvote", TEST_OPERATOR_TOKEN, UpvoteRequestV1{Period: 1}, http.StatusBadRequest)
+}
+
+func TestApiV1Backup(t *testing.T) {
+
+	a := newTestApi(t)
+
+	if err := a.db.RecordBakedBlock(100, "BLockHash"); err != nil {
+		t.Fatalf("Unable to record bake: %s", err)
+	}
+
+	a.call("GET", "/backup", TEST_OPERATOR_TOKEN, nil, http.StatusForbidden)
+	a.call("GET", "/export", TEST_OPERATOR_TOKEN, nil, http.StatusForbidden)
+
+	backup := a.call("GET", "/backup", TEST_ADMIN_TOKEN, nil, http.StatusOK).([]byte)
+	a.call("GET", "/export", TEST_ADMIN_TOKEN, nil, http.StatusOK)
+
+	req := httptest.NewRequest("GET", "/api/v1/export", nil)
+	req.Header.Set("Authorization", "Bearer "+TEST_ADMIN_TOKEN)
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	export := rec.Body.Bytes()
+
+	// Both restore to a DB with the same watermark
+	for name, contents := range map[string][]byte{"backup": backup, "export": export} {
+
+		dir := t.TempDir() + "/"
+		if err := ioutil.WriteFile(dir+name, contents, 0600); err != nil {
+			t.Fatalf("Unable to write %s: %s", name, err)
+		}
+
+		if err := storage.RestoreToFile(dir+name, dir+storage.DATABASE_FILE); err != nil {
+			t.Fatalf("Unable to restore %s: %s", name, err)
+		}
+
+		db, err := storage.InitStorage(dir, "hangzhounet")
+		if err != nil {
+			t.Fatalf("Unable to open restored %s: %s", name, err)
+		}
+
+		watermark, err := db.GetBakingWatermark()
+		db.CloseDb()
+
+		if err != nil || watermark != 100 {
+			t.Errorf("Restored %s: expected watermark 100, got %d (%v)", name, watermark, err)
+		}
+	}
+}
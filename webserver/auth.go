@@ -0,0 +1,730 @@
+package webserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	log "github.com/sirupsen/logrus"
+
+	"bakinbacon/storage"
+)
+
+const (
+	ROLE_VIEWER   = "viewer"
+	ROLE_OPERATOR = "operator"
+	ROLE_ADMIN    = "admin"
+
+	DEFAULT_ADMIN_USER    = "admin"
+	INITIAL_PASSWORD_FILE = "initial-admin-password"
+
+	SESSION_COOKIE   = "bakinbacon_session"
+	SESSION_LIFETIME = 24 * time.Hour
+	CSRF_HEADER      = "X-CSRF-Token"
+
+	API_TOKEN_PREFIX    = "bb_"
+	MIN_PASSWORD_LENGTH = 8
+)
+
+// Each role may do everything the roles below it can do
+var roleLevels = map[string]int{
+	ROLE_VIEWER:   1,
+	ROLE_OPERATOR: 2,
+	ROLE_ADMIN:    3,
+}
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// principal is whoever is making an authenticated request; either a
+// logged-in user, or the holder of an API token
+type principal struct {
+	Name    string
+	Role    string
+	Session *storage.Session
+
+	sessionKey string
+}
+
+// ensureAdminUser creates the initial admin login on brand new setups. The generated
+// password is written to a file only the owner can read, which is deleted once the
+// password is changed.
+func (ws *WebServer) ensureAdminUser() error {
+
+	users, err := ws.storage.GetUsers()
+	if err != nil {
+		return errors.Wrap(err, "Unable to get users")
+	}
+
+	if len(users) > 0 {
+		return nil
+	}
+
+	password, err := randomHex(12)
+	if err != nil {
+		return err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "Unable to hash password")
+	}
+
+	if err := ws.storage.SaveUser(storage.User{
+		Username:     DEFAULT_ADMIN_USER,
+		PasswordHash: string(passwordHash),
+		Role:         ROLE_ADMIN,
+		Created:      time.Now().UTC(),
+	}); err != nil {
+		return errors.Wrap(err, "Unable to save admin user")
+	}
+
+	passwordFile := ws.initialPasswordFile()
+	if err := ioutil.WriteFile(passwordFile, []byte(password+"\n"), 0600); err != nil {
+		return errors.Wrap(err, "Unable to save initial password")
+	}
+
+	log.WithFields(log.Fields{
+		"Username": DEFAULT_ADMIN_USER, "PasswordFile": passwordFile,
+	}).Warn("Created initial web UI login; Please change this password after logging in")
+
+	return nil
+}
+
+func (ws *WebServer) initialPasswordFile() string {
+	return filepath.Join(filepath.Dir(ws.storage.Path()), INITIAL_PASSWORD_FILE)
+}
+
+// requireRole wraps an API handler so that it is only executed for authenticated
+// requests having at least the given role. Requests authenticated by session cookie
+// must also present the session's CSRF token when changing state.
+func (ws *WebServer) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return ws.requireRoleWith(apiErrorStatus, role, next)
+}
+
+// requireRoleWith is requireRole, with failures written by errorFunc
+func (ws *WebServer) requireRoleWith(errorFunc func(error, http.ResponseWriter, int), role string, next http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// CORS preflight carries no credentials
+		if r.Method == http.MethodOptions {
+			return
+		}
+
+		p, err := ws.authenticate(r)
+		if err != nil {
+			log.WithError(err).Error("Unable to authenticate request")
+			errorFunc(errors.New("Unable to authenticate request"), w, http.StatusInternalServerError)
+			return
+		}
+
+		if p == nil {
+			errorFunc(errors.New("Authentication required"), w, http.StatusUnauthorized)
+			return
+		}
+
+		if roleLevels[p.Role] < roleLevels[role] {
+			errorFunc(errors.Errorf("Requires %s role", role), w, http.StatusForbidden)
+			return
+		}
+
+		if p.Session != nil && !isSafeMethod(r.Method) {
+			csrfToken := r.Header.Get(CSRF_HEADER)
+			if subtle.ConstantTimeCompare([]byte(csrfToken), []byte(p.Session.CsrfToken)) != 1 {
+				errorFunc(errors.New("Invalid CSRF token"), w, http.StatusForbidden)
+				return
+			}
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey, p)))
+	}
+}
+
+// authenticate looks for an API token in the Authorization header, then for a
+// session cookie. Returns nil principal if neither are present or valid.
+func (ws *WebServer) authenticate(r *http.Request) (*principal, error) {
+
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+
+		token, found, err := ws.storage.GetApiToken(hashSecret(strings.TrimPrefix(authHeader, "Bearer ")))
+		if err != nil || !found {
+			return nil, err
+		}
+
+		return &principal{
+			Name: token.Name,
+			Role: token.Role,
+		}, nil
+	}
+
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil {
+		return nil, nil
+	}
+
+	sessionKey := hashSecret(cookie.Value)
+
+	session, found, err := ws.storage.GetSession(sessionKey)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	// Role is looked up on every request so that changes take effect immediately
+	user, found, err := ws.storage.GetUser(session.Username)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &principal{
+		Name:    user.Username,
+		Role:    user.Role,
+		Session: &session,
+
+		sessionKey: sessionKey,
+	}, nil
+}
+
+// checkOrigin rejects state-changing requests coming from a browser page on a
+// foreign origin, unless that origin is in the allow-list
+func (ws *WebServer) checkOrigin(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && !isSafeMethod(r.Method) && !isSameOrigin(origin, r) && !ws.isOriginAllowed(origin) {
+			log.WithFields(log.Fields{
+				"Origin": origin, "Path": r.URL.Path,
+			}).Warn("Rejected request from disallowed origin")
+
+			apiErrorStatus(errors.New("Origin not allowed"), w, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (ws *WebServer) isOriginAllowed(origin string) bool {
+
+	for _, o := range ws.allowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSameOrigin(origin string, r *http.Request) bool {
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func getPrincipal(r *http.Request) *principal {
+	p, _ := r.Context().Value(principalKey).(*principal)
+	return p
+}
+
+// hashSecret returns the hex sha256 of a session id or API token; only hashes are stored
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func randomHex(numBytes int) (string, error) {
+
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "Unable to generate random bytes")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func isValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+//
+// Auth API
+//
+
+func (ws *WebServer) login(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - login")
+
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for login"), w)
+		return
+	}
+
+	user, found, err := ws.storage.GetUser(creds.Username)
+	if err != nil {
+		apiErrorStatus(errors.Wrap(err, "Unable to get user"), w, http.StatusInternalServerError)
+		return
+	}
+
+	if !found || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)) != nil {
+		log.WithField("Username", creds.Username).Warn("Failed web UI login")
+		apiErrorStatus(errors.New("Invalid username or password"), w, http.StatusUnauthorized)
+		return
+	}
+
+	sessionId, err := randomHex(32)
+	if err != nil {
+		apiErrorStatus(err, w, http.StatusInternalServerError)
+		return
+	}
+
+	csrfToken, err := randomHex(32)
+	if err != nil {
+		apiErrorStatus(err, w, http.StatusInternalServerError)
+		return
+	}
+
+	expires := time.Now().UTC().Add(SESSION_LIFETIME)
+
+	if err := ws.storage.SaveSession(hashSecret(sessionId), storage.Session{
+		Username:  user.Username,
+		CsrfToken: csrfToken,
+		Expires:   expires,
+	}); err != nil {
+		apiErrorStatus(errors.Wrap(err, "Unable to save session"), w, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    sessionId,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	log.WithField("Username", user.Username).Info("Web UI login")
+
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"username":  user.Username,
+		"role":      user.Role,
+		"csrfToken": csrfToken,
+	}); err != nil {
+		log.WithError(err).Error("UI Return login Failure")
+	}
+}
+
+func (ws *WebServer) logout(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - logout")
+
+	if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+		if err := ws.storage.DeleteSession(hashSecret(cookie.Value)); err != nil {
+			log.WithError(err).Error("Unable to delete session")
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	apiReturnOk(w)
+}
+
+// getMe returns the currently authenticated user, and the CSRF token for their session
+func (ws *WebServer) getMe(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - getMe")
+
+	p := getPrincipal(r)
+
+	me := map[string]string{
+		"username": p.Name,
+		"role":     p.Role,
+	}
+
+	if p.Session != nil {
+		me["csrfToken"] = p.Session.CsrfToken
+	}
+
+	if err := json.NewEncoder(w).Encode(me); err != nil {
+		log.WithError(err).Error("UI Return getMe Failure")
+	}
+}
+
+func (ws *WebServer) changePassword(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - changePassword")
+
+	p := getPrincipal(r)
+	if p.Session == nil {
+		apiErrorStatus(errors.New("Only logged in users may change their password"), w, http.StatusForbidden)
+		return
+	}
+
+	var k struct {
+		Current string `json:"current"`
+		New     string `json:"new"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for password change"), w)
+		return
+	}
+
+	user, found, err := ws.storage.GetUser(p.Name)
+	if err != nil || !found {
+		apiErrorStatus(errors.New("Unable to get user"), w, http.StatusInternalServerError)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(k.Current)) != nil {
+		apiErrorStatus(errors.New("Current password is incorrect"), w, http.StatusForbidden)
+		return
+	}
+
+	if err := ws.setPassword(&user, k.New); err != nil {
+		apiError(err, w)
+		return
+	}
+
+	if err := ws.storage.SaveUser(user); err != nil {
+		apiErrorStatus(errors.Wrap(err, "Unable to save user"), w, http.StatusInternalServerError)
+		return
+	}
+
+	// Anyone else logged in with the old password is logged out
+	if err := ws.storage.DeleteUserSessions(user.Username, p.sessionKey); err != nil {
+		apiErrorStatus(errors.Wrap(err, "Unable to log out other sessions"), w, http.StatusInternalServerError)
+		return
+	}
+
+	// No longer the initial password
+	if user.Username == DEFAULT_ADMIN_USER {
+		if err := os.Remove(ws.initialPasswordFile()); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Error("Unable to delete initial password file")
+		}
+	}
+
+	apiReturnOk(w)
+}
+
+func (ws *WebServer) setPassword(user *storage.User, password string) error {
+
+	if len(password) < MIN_PASSWORD_LENGTH {
+		return errors.Errorf("Password must be at least %d characters", MIN_PASSWORD_LENGTH)
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "Unable to hash password")
+	}
+
+	user.PasswordHash = string(passwordHash)
+
+	return nil
+}
+
+func (ws *WebServer) listUsers(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - listUsers")
+
+	users, err := ws.storage.GetUsers()
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get users"), w)
+		return
+	}
+
+	type userInfo struct {
+		Username string    `json:"username"`
+		Role     string    `json:"role"`
+		Created  time.Time `json:"created"`
+	}
+
+	// Never return password hashes
+	list := make([]userInfo, 0, len(users))
+	for _, u := range users {
+		list = append(list, userInfo{u.Username, u.Role, u.Created})
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": list,
+	}); err != nil {
+		log.WithError(err).Error("UI Return listUsers Failure")
+	}
+}
+
+// saveUser creates a new user, or updates the role and/or password of an existing user
+func (ws *WebServer) saveUser(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - saveUser")
+
+	var k struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for user"), w)
+		return
+	}
+
+	if k.Username == "" {
+		apiError(errors.New("Username is required"), w)
+		return
+	}
+
+	if !isValidRole(k.Role) {
+		apiError(errors.Errorf("Unknown role %s", k.Role), w)
+		return
+	}
+
+	user, found, err := ws.storage.GetUser(k.Username)
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get user"), w)
+		return
+	}
+
+	if !found {
+		user = storage.User{
+			Username: k.Username,
+			Created:  time.Now().UTC(),
+		}
+	}
+
+	if !found || k.Password != "" {
+		if err := ws.setPassword(&user, k.Password); err != nil {
+			apiError(err, w)
+			return
+		}
+	}
+
+	if user.Role == ROLE_ADMIN && k.Role != ROLE_ADMIN {
+		if err := ws.ensureOtherAdmin(user.Username); err != nil {
+			apiError(err, w)
+			return
+		}
+	}
+
+	user.Role = k.Role
+
+	if err := ws.storage.SaveUser(user); err != nil {
+		apiError(errors.Wrap(err, "Unable to save user"), w)
+		return
+	}
+
+	// A new password logs out the user's sessions
+	if found && k.Password != "" {
+		if err := ws.storage.DeleteUserSessions(user.Username, getPrincipal(r).sessionKey); err != nil {
+			apiError(errors.Wrap(err, "Unable to log out user"), w)
+			return
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"Username": user.Username, "Role": user.Role, "By": getPrincipal(r).Name,
+	}).Info("Saved web UI user")
+
+	apiReturnOk(w)
+}
+
+func (ws *WebServer) deleteUser(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - deleteUser")
+
+	var k struct {
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for user"), w)
+		return
+	}
+
+	user, found, err := ws.storage.GetUser(k.Username)
+	if err != nil || !found {
+		apiError(errors.Errorf("Unknown user %s", k.Username), w)
+		return
+	}
+
+	if user.Role == ROLE_ADMIN {
+		if err := ws.ensureOtherAdmin(user.Username); err != nil {
+			apiError(err, w)
+			return
+		}
+	}
+
+	if err := ws.storage.DeleteUser(user.Username); err != nil {
+		apiError(errors.Wrap(err, "Unable to delete user"), w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Username": user.Username, "By": getPrincipal(r).Name,
+	}).Info("Deleted web UI user")
+
+	apiReturnOk(w)
+}
+
+// ensureOtherAdmin prevents locking everyone out by removing the last admin
+func (ws *WebServer) ensureOtherAdmin(username string) error {
+
+	users, err := ws.storage.GetUsers()
+	if err != nil {
+		return errors.Wrap(err, "Unable to get users")
+	}
+
+	for _, u := range users {
+		if u.Role == ROLE_ADMIN && u.Username != username {
+			return nil
+		}
+	}
+
+	return errors.New("Cannot remove the last admin")
+}
+
+func (ws *WebServer) listApiTokens(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - listApiTokens")
+
+	tokens, err := ws.storage.GetApiTokens()
+	if err != nil {
+		apiError(errors.Wrap(err, "Unable to get API tokens"), w)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens": tokens,
+	}); err != nil {
+		log.WithError(err).Error("UI Return listApiTokens Failure")
+	}
+}
+
+// createApiToken generates a new API token. The token itself is only returned
+// here; only its hash is stored.
+func (ws *WebServer) createApiToken(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - createApiToken")
+
+	var k struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for API token"), w)
+		return
+	}
+
+	if k.Name == "" {
+		apiError(errors.New("Token name is required"), w)
+		return
+	}
+
+	if !isValidRole(k.Role) {
+		apiError(errors.Errorf("Unknown role %s", k.Role), w)
+		return
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		apiError(err, w)
+		return
+	}
+
+	id, err := randomHex(4)
+	if err != nil {
+		apiError(err, w)
+		return
+	}
+
+	token := storage.ApiToken{
+		Id:      id,
+		Name:    k.Name,
+		Role:    k.Role,
+		Created: time.Now().UTC(),
+	}
+
+	tokenString := API_TOKEN_PREFIX + secret
+
+	if err := ws.storage.SaveApiToken(hashSecret(tokenString), token); err != nil {
+		apiError(errors.Wrap(err, "Unable to save API token"), w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Id": token.Id, "Name": token.Name, "Role": token.Role, "By": getPrincipal(r).Name,
+	}).Info("Created API token")
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    token.Id,
+		"name":  token.Name,
+		"role":  token.Role,
+		"token": tokenString,
+	}); err != nil {
+		log.WithError(err).Error("UI Return createApiToken Failure")
+	}
+}
+
+func (ws *WebServer) deleteApiToken(w http.ResponseWriter, r *http.Request) {
+
+	log.Trace("API - deleteApiToken")
+
+	var k struct {
+		Id string `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiError(errors.Wrap(err, "Cannot decode body for API token"), w)
+		return
+	}
+
+	if err := ws.storage.DeleteApiToken(k.Id); err != nil {
+		apiError(errors.Wrap(err, "Unable to delete API token"), w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Id": k.Id, "By": getPrincipal(r).Name,
+	}).Info("Deleted API token")
+
+	apiReturnOk(w)
+}
@@ -0,0 +1,107 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"bakinbacon/storage"
+)
+
+// login returns the session cookie value of a new login
+func login(t *testing.T, ws *WebServer, username, password string) string {
+
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+
+	rec := httptest.NewRecorder()
+	ws.login(rec, httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body)))
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == SESSION_COOKIE {
+			return c.Value
+		}
+	}
+
+	t.Fatalf("Login failed: %d %s", rec.Code, rec.Body.String())
+
+	return ""
+}
+
+func loggedIn(t *testing.T, db *storage.Storage, sessionId string) bool {
+
+	t.Helper()
+
+	_, found, err := db.GetSession(hashSecret(sessionId))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return found
+}
+
+func TestInitialPasswordAndChange(t *testing.T) {
+
+	dir := t.TempDir()
+
+	db, err := storage.InitStorage(dir+"/", "hangzhounet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ws := &WebServer{storage: db}
+
+	if err := ws.ensureAdminUser(); err != nil {
+		t.Fatal(err)
+	}
+
+	passwordFile := dir + "/" + INITIAL_PASSWORD_FILE
+
+	info, err := os.Stat(passwordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Password file mode %v", info.Mode().Perm())
+	}
+
+	passwordBytes, _ := ioutil.ReadFile(passwordFile)
+	password := strings.TrimSpace(string(passwordBytes))
+
+	current := login(t, ws, DEFAULT_ADMIN_USER, password)
+	other := login(t, ws, DEFAULT_ADMIN_USER, password)
+
+	session, _, _ := db.GetSession(hashSecret(current))
+
+	body, _ := json.Marshal(map[string]string{"current": password, "new": "new-password"})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/password", bytes.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: current})
+	req.Header.Set(CSRF_HEADER, session.CsrfToken)
+
+	rec := httptest.NewRecorder()
+	ws.requireRole(ROLE_VIEWER, ws.changePassword)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Change password: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Only the session which changed the password is still logged in
+	if !loggedIn(t, db, current) || loggedIn(t, db, other) {
+		t.Errorf("Logged in: current %v, other %v", loggedIn(t, db, current), loggedIn(t, db, other))
+	}
+
+	if _, err := os.Stat(passwordFile); !os.IsNotExist(err) {
+		t.Errorf("Initial password file not deleted: %v", err)
+	}
+
+	login(t, ws, DEFAULT_ADMIN_USER, "new-password")
+}
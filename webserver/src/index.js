@@ -11,13 +11,14 @@ import Tab from 'react-bootstrap/Tab';
 
 import BakinDashboard from './dashboard.js'
 import DelegateRegister from './delegateregister.js'
+import Login from './login.js'
 import Settings, { GetUiExplorer } from './settings'
 import SetupWizard from './wizards'
 import Payouts from './payouts'
 import Voting from './voting.js'
 
 import ToasterContext, { ToasterContextProvider } from './toaster.js';
-import { NO_SIGNER, NOT_REGISTERED, apiRequest } from './util.js';
+import { NO_SIGNER, NOT_REGISTERED, UNAUTHORIZED_EVENT, apiRequest, setCsrfToken } from './util.js';
 
 import '../node_modules/bootstrap/dist/css/bootstrap.min.css';
 import './index.css';
@@ -25,7 +26,12 @@ import './index.css';
 import logo from './logo512.png';
 
 
-const Bakinbacon = () => {
+// Server-sent events which change what the dashboard displays
+const STATUS_EVENTS = ["head", "rights", "bake", "endorse", "error", "endpoint"];
+
+const Bakinbacon = (props) => {
+
+	const { user, onLogout } = props;
 
 	const [ delegate, setDelegate ] = useState("");
 	const [ status, setStatus ] = useState({});
@@ -48,11 +54,16 @@ const Bakinbacon = () => {
 		fetchStatus();
 		GetUiExplorer(setUiExplorer);
 
-		// Update every 10 seconds
-		const idTimer = setInterval(() => fetchStatus(), 10000);
+		// Status changes are pushed as they happen; refresh on each event
+		const eventSource = new EventSource(window.BASE_URL + "/api/events", { withCredentials: true });
+		STATUS_EVENTS.forEach((e) => eventSource.addEventListener(e, () => fetchStatus()));
+
+		// Fallback in case of missed events; Update every 60 seconds
+		const idTimer = setInterval(() => fetchStatus(), 60000);
 		fetchStatusTimer.current = idTimer;
 		return () => {
 			// componentWillUnmount()
+			eventSource.close();
 			clearInterval(fetchStatusTimer.current);
 		};
 		// eslint-disable-next-line react-hooks/exhaustive-deps
@@ -122,6 +133,7 @@ const Bakinbacon = () => {
 					<Navbar.Brand><img src={logo} width="55" height="45" alt="BakinBacon Logo" />{' '}Bakin'Bacon</Navbar.Brand>
 					<Navbar.Collapse className="justify-content-end">
 						<Navbar.Text>{delegate}</Navbar.Text>
+						<Navbar.Text className="ml-3">{user.username} (<a href="#logout" onClick={onLogout}>Logout</a>)</Navbar.Text>
 					</Navbar.Collapse>
 				</Navbar>
 			  </Col>
@@ -162,4 +174,60 @@ const Bakinbacon = () => {
 	);
 }
 
-ReactDOM.render(<ToasterContextProvider><Bakinbacon /></ToasterContextProvider>, document.getElementById('bakinbacon'));
+// Requires a logged-in user before displaying anything else
+const App = () => {
+
+	const [ user, setUser ] = useState(null);
+	const [ authChecked, setAuthChecked ] = useState(false);
+
+	useEffect(() => {
+
+		// Existing session?
+		apiRequest(window.BASE_URL + "/api/auth/me")
+		.then((me) => {
+			setCsrfToken(me.csrfToken);
+			setUser(me);
+		})
+		.catch(() => setUser(null))
+		.finally(() => setAuthChecked(true));
+
+		// Session expired, or logged out elsewhere
+		const onUnauthorized = () => setUser(null);
+		window.addEventListener(UNAUTHORIZED_EVENT, onUnauthorized);
+		return () => {
+			window.removeEventListener(UNAUTHORIZED_EVENT, onUnauthorized);
+		};
+	}, []);
+
+	const doLogout = (event) => {
+		event.preventDefault();
+		apiRequest(window.BASE_URL + "/api/auth/logout", { method: 'POST' })
+		.finally(() => {
+			setCsrfToken("");
+			setUser(null);
+		});
+	}
+
+	if (!authChecked) {
+		return null;
+	}
+
+	if (!user) {
+		return (
+			<Container>
+				<Row>
+				  <Col md="12">
+					<Navbar bg="light">
+						<Navbar.Brand><img src={logo} width="55" height="45" alt="BakinBacon Logo" />{' '}Bakin'Bacon</Navbar.Brand>
+					</Navbar>
+				  </Col>
+				</Row>
+				<Login onLogin={setUser} />
+			</Container>
+		);
+	}
+
+	return <Bakinbacon user={user} onLogout={doLogout} />
+}
+
+ReactDOM.render(<ToasterContextProvider><App /></ToasterContextProvider>, document.getElementById('bakinbacon'));
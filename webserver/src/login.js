@@ -0,0 +1,70 @@
+import React, { useState } from 'react';
+
+import Button from 'react-bootstrap/Button';
+import Card from 'react-bootstrap/Card';
+import Col from 'react-bootstrap/Col';
+import Form from 'react-bootstrap/Form'
+import Row from 'react-bootstrap/Row';
+
+import { BaconAlert, apiRequest, setCsrfToken } from './util.js';
+
+
+const Login = (props) => {
+
+	const { onLogin } = props;
+
+	const [ username, setUsername ] = useState("");
+	const [ password, setPassword ] = useState("");
+	const [ alert, setAlert ] = useState({});
+
+	const doLogin = (event) => {
+
+		event.preventDefault();
+		setAlert({});
+
+		const loginApiUrl = window.BASE_URL + "/api/auth/login";
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({"username": username, "password": password})
+		};
+
+		apiRequest(loginApiUrl, requestOptions)
+		.then((user) => {
+			setCsrfToken(user.csrfToken);
+			onLogin(user);
+		})
+		.catch((errMsg) => {
+			setAlert({
+				type: "danger",
+				msg: errMsg,
+			});
+		});
+	}
+
+	return (
+		<>
+		<BaconAlert alert={alert} />
+		<Row>
+		  <Col md={{ span: 6, offset: 3 }}>
+			<Card>
+				<Card.Header as="h5">Login</Card.Header>
+				<Card.Body>
+					<Form onSubmit={doLogin}>
+						<Form.Group>
+							<Form.Control type="text" placeholder="Username" value={username} onChange={(e) => setUsername(e.target.value)} />
+						</Form.Group>
+						<Form.Group>
+							<Form.Control type="password" placeholder="Password" value={password} onChange={(e) => setPassword(e.target.value)} />
+						</Form.Group>
+						<Button variant="primary" type="submit" size="sm">Login</Button>
+					</Form>
+				</Card.Body>
+			</Card>
+		  </Col>
+		</Row>
+		</>
+	)
+}
+
+export default Login
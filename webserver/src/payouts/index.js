@@ -156,9 +156,9 @@ const Payouts = (props) => {
 		})
 	}
 
-	const paidStatusIcon = (amount, opHash) => {
+	const paidStatusIcon = (amount, opHash, note) => {
 		if (amount === 0) {
-			return <FiMinusCircle alt="0 XTZ Reward" title="0 XTZ Reward"/>
+			return <FiMinusCircle alt="0 XTZ Reward" title={note || "0 XTZ Reward"}/>
 		}
 		if (opHash !== "") {
 			return <a href={"https://"+uiExplorer+"/"+opHash} target={"_blank"} rel={"noreferrer"}><FaCheckCircle /></a>
@@ -192,8 +192,8 @@ const Payouts = (props) => {
 		const cycle = payoutsDetail["cycle"]
 		const bBalance = formatter.format(muToTez(payoutsDetail["metadata"]["b"]))
 		const bReward = formatter.format(muToTez(payoutsDetail["metadata"]["br"]))
-		const bFee = parseInt(payoutsDetail["metadata"]["f"])
 		const cycleStatus = payoutsDetail["metadata"]["st"]
+		const autoPayHeld = payoutsDetail["metadata"]["ah"]
 		var totalPayouts = 0;
 
 		return (
@@ -215,7 +215,10 @@ const Payouts = (props) => {
 								  <li>Faiure to acknowledge a payout will time-out the device and may have unforseen consequences.</li>
 								</ul>
 							</Alert>
-							<Card.Text><FiMinusCircle />: Delegator reward is 0.00 XTZ; No payout.</Card.Text>
+							{ autoPayHeld && cycleStatus !== DONE &&
+							<Alert variant="danger"><b>Not sent automatically:</b> {autoPayHeld}</Alert>
+							}
+							<Card.Text><FiMinusCircle />: Delegator reward is 0.00 XTZ, or withheld by a delegator rule; No payout.</Card.Text>
 							<BaconAlert alert={alert} />
 							{ processing && <>
 							<Card.Text className="text-center" as="div">
@@ -249,10 +252,10 @@ const Payouts = (props) => {
 										const d = payoutsDetail["rewards"][k];
 										var reward = muToTez(d["r"])
 										totalPayouts += reward
-										const icon = paidStatusIcon(d["r"], d["o"])
+										const icon = paidStatusIcon(d["r"], d["o"], d["n"])
 										return (
 											<tr key={d["d"]}>
-												<td>{substr(d["d"])}...</td>
+												<td>{substr(d["d"])}...{ d["pe"] && <><br/><small className="text-muted">Paid to {substr(d["pe"])}...</small></> }</td>
 												<td>{formatter.format(muToTez(d["b"]))}&#42793;</td>
 												<td>/</td>
 												<td>{bBalance}&#42793;</td>
@@ -261,9 +264,9 @@ const Payouts = (props) => {
 												<td>*</td>
 												<td>{bReward}&#42793;</td>
 												<td>-</td>
-												<td>{bFee}%</td>
+												<td>{d["f"]}%</td>
 												<td>=</td>
-												<td>{formatter.format(reward)}&#42793;</td>
+												<td>{formatter.format(reward)}&#42793;{ d["ci"] > 0 && <><br/><small className="text-muted">Incl. {formatter.format(muToTez(d["ci"]))}&#42793; carried</small></> }{ d["n"] && <><br/><small className="text-muted">{d["n"]}</small></> }</td>
 												<td>{ icon }</td>
 											</tr>
 										)
@@ -0,0 +1,76 @@
+import React, { useState, useEffect } from 'react';
+
+import Button from 'react-bootstrap/Button';
+import Col from 'react-bootstrap/Col';
+import Card from 'react-bootstrap/Card';
+import Form from 'react-bootstrap/Form'
+
+
+// Settings card for notifiers that only need a few text fields. Fields with
+// list set are edited as comma-separated text, and saved as an array.
+const ChatNotifier = (props) => {
+
+	const { title, fields, config, onSave, onTest } = props;
+
+	const [values, setValues] = useState({});
+
+	useEffect(() => {
+		const v = { ...(config || {}) };
+		fields.forEach((f) => {
+			if (f.list) {
+				v[f.name] = Array.isArray(v[f.name]) ? v[f.name].join(',') : "";
+			}
+		});
+		setValues(v);
+	}, [config]); // eslint-disable-line react-hooks/exhaustive-deps
+
+	const handleChange = (e) => {
+		let { name, value } = e.target;
+		if (name === "enabled") {
+			value = !values.enabled
+		}
+		setValues((prev) => ({
+			...prev,
+			[name]: value
+		}));
+	}
+
+	const save = () => {
+		const postData = { enabled: !!values.enabled };
+		fields.forEach((f) => {
+			const value = values[f.name] || "";
+			postData[f.name] = f.list ? value.split(/[ ,]/).filter((i) => i !== "") : value;
+		});
+		onSave(postData);
+	}
+
+	return (
+		<Card>
+		  <Card.Header as="h5">{title}</Card.Header>
+		  <Card.Body>
+		    {fields.map((f) =>
+		    <Form.Row key={f.name}>
+		      <Form.Group as={Col}>
+		        <Form.Text as="span">{f.label}</Form.Text>
+		        <Form.Control type={f.type || "text"} name={f.name} value={values[f.name] || ""} onChange={handleChange} />
+		        {f.help && <Form.Text className="text-muted">{f.help}</Form.Text>}
+		      </Form.Group>
+		    </Form.Row>
+		    )}
+		    <Form.Row>
+		      <Form.Group as={Col}>
+		        <Form.Check type="checkbox" name="enabled" checked={!!values.enabled} onChange={handleChange} label="Enabled" />
+		      </Form.Group>
+		    </Form.Row>
+		    <Form.Row>
+		      <Form.Group as={Col}>
+		        <Button variant="primary" onClick={save} type="button" size="sm">Save</Button>{' '}
+		        <Button variant="secondary" onClick={onTest} type="button" size="sm">Send Test</Button>
+		      </Form.Group>
+		    </Form.Row>
+		  </Card.Body>
+		</Card>
+	)
+}
+
+export default ChatNotifier
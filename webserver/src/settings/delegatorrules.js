@@ -0,0 +1,174 @@
+import React, { useState, useContext, useEffect } from 'react';
+
+import Button from 'react-bootstrap/Button';
+import Card from 'react-bootstrap/Card';
+import Col from 'react-bootstrap/Col';
+import Form from 'react-bootstrap/Form'
+import Table from 'react-bootstrap/Table';
+
+import ToasterContext from '../toaster.js';
+import { apiRequest, muToTez } from '../util.js';
+
+const EMPTY_RULE = {address: "", fee: "", minBalance: "", minPayout: "", redirect: "", excluded: false}
+
+// Per-delegator overrides of the baker fee, minimums, payout address, or exclusion from payouts
+const DelegatorRules = () => {
+
+	const [rules, setRules] = useState({});
+	const [rule, setRule] = useState(EMPTY_RULE);
+	const addToast = useContext(ToasterContext);
+
+	const loadRules = () => {
+		apiRequest(window.BASE_URL + "/api/payouts/rules")
+			.then((data) => {
+				setRules(data || {});
+			})
+			.catch((errMsg) => {
+				console.log(errMsg);
+				addToast({
+					title: "Loading Delegator Rules Error",
+					msg: errMsg,
+					type: "danger",
+				});
+			});
+	}
+
+	useEffect(() => {
+		loadRules();
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, []);
+
+	const handleChange = (event) => {
+		const { name, value, type, checked } = event.target;
+		setRule((prev) => ({ ...prev, [name]: type === "checkbox" ? checked : value }));
+	}
+
+	// Fields are in XTZ and percent; Blank fee uses the baker fee
+	const editRule = (r) => {
+		setRule({
+			address: r.address,
+			fee: r.fee === undefined ? "" : String(r.fee),
+			minBalance: r.minBalance ? String(muToTez(r.minBalance)) : "",
+			minPayout: r.minPayout ? String(muToTez(r.minPayout)) : "",
+			redirect: r.redirect,
+			excluded: r.excluded,
+		});
+	}
+
+	const postRule = (url, data, msg) => {
+
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify(data)
+		};
+
+		apiRequest(url, requestOptions)
+			.then(() => {
+				addToast({
+					title: "Delegator Rules",
+					msg: msg,
+					type: "success",
+					autohide: 3000,
+				});
+				setRule(EMPTY_RULE);
+				loadRules();
+			})
+			.catch((errMsg) => {
+				console.log(errMsg);
+				addToast({
+					title: "Delegator Rules Error",
+					msg: errMsg,
+					type: "danger",
+				});
+			});
+	}
+
+	const saveRule = () => {
+		postRule(window.BASE_URL + "/api/payouts/saverule", {
+			address: rule.address.trim(),
+			fee: rule.fee === "" ? undefined : Number(rule.fee),
+			minBalance: Math.round(Number(rule.minBalance) * 1e6),
+			minPayout: Math.round(Number(rule.minPayout) * 1e6),
+			redirect: rule.redirect.trim(),
+			excluded: rule.excluded,
+		}, "Saved rule for " + rule.address);
+	}
+
+	const deleteRule = (address) => {
+		postRule(window.BASE_URL + "/api/payouts/deleterule", {address: address}, "Deleted rule for " + address);
+	}
+
+	return (
+		<Card>
+		  <Card.Header as="h5">Delegator Payout Rules</Card.Header>
+		  <Card.Body>
+		    <Card.Text>Rules override how individual delegators are paid. Rewards below a delegator's minimum payout are carried to later cycles. Rules apply to payouts calculated after they are saved.</Card.Text>
+		    <Table size="sm" responsive>
+		      <thead>
+		        <tr>
+		          <th>Delegator</th>
+		          <th>Fee</th>
+		          <th>Min Balance</th>
+		          <th>Min Payout</th>
+		          <th>Pay To</th>
+		          <th>Excluded</th>
+		          <th>&nbsp;</th>
+		        </tr>
+		      </thead>
+		      <tbody>
+		        {Object.keys(rules).sort().map((k) => {
+		          const r = rules[k];
+		          return (
+		          <tr key={k}>
+		            <td>{r.address}</td>
+		            <td>{r.fee === undefined ? "Baker fee" : r.fee + "%"}</td>
+		            <td>{muToTez(r.minBalance)}&#42793;</td>
+		            <td>{muToTez(r.minPayout)}&#42793;</td>
+		            <td>{r.redirect || "-"}</td>
+		            <td>{r.excluded ? "Yes" : "No"}</td>
+		            <td>
+		              <Button variant="secondary" size="sm" onClick={() => editRule(r)}>Edit</Button>{' '}
+		              <Button variant="danger" size="sm" onClick={() => deleteRule(r.address)}>X</Button>
+		            </td>
+		          </tr>
+		          )
+		        })}
+		      </tbody>
+		    </Table>
+		    <Form.Row>
+		      <Form.Group as={Col} md="4">
+		        <Form.Control type="text" name="address" placeholder="tz1..." value={rule.address} onChange={handleChange} />
+		        <Form.Text className="text-muted">Delegator</Form.Text>
+		      </Form.Group>
+		      <Form.Group as={Col} md="2">
+		        <Form.Control type="text" name="fee" placeholder="Baker fee" value={rule.fee} onChange={handleChange} />
+		        <Form.Text className="text-muted">Fee %</Form.Text>
+		      </Form.Group>
+		      <Form.Group as={Col} md="3">
+		        <Form.Control type="text" name="minBalance" placeholder="0" value={rule.minBalance} onChange={handleChange} />
+		        <Form.Text className="text-muted">Minimum Balance (XTZ)</Form.Text>
+		      </Form.Group>
+		      <Form.Group as={Col} md="3">
+		        <Form.Control type="text" name="minPayout" placeholder="0" value={rule.minPayout} onChange={handleChange} />
+		        <Form.Text className="text-muted">Minimum Payout (XTZ)</Form.Text>
+		      </Form.Group>
+		    </Form.Row>
+		    <Form.Row>
+		      <Form.Group as={Col} md="4">
+		        <Form.Control type="text" name="redirect" placeholder="Delegator's address" value={rule.redirect} onChange={handleChange} />
+		        <Form.Text className="text-muted">Pay To</Form.Text>
+		      </Form.Group>
+		      <Form.Group as={Col} md="4">
+		        <Form.Check type="checkbox" name="excluded" label="Exclude from payouts" checked={rule.excluded} onChange={handleChange} />
+		      </Form.Group>
+		      <Form.Group as={Col} md="4">
+		        <Button variant="primary" size="sm" onClick={saveRule} disabled={rule.address === ""}>Save Rule</Button>
+		      </Form.Group>
+		    </Form.Row>
+		  </Card.Body>
+		</Card>
+	)
+}
+
+export default DelegatorRules
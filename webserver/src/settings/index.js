@@ -4,8 +4,11 @@ import Col from 'react-bootstrap/Col';
 import Row from 'react-bootstrap/Row';
 
 import Notifications from './notifications.js'
+import NotificationHistory from './notificationhistory.js'
+import Routing from './routing.js'
 import Rpcservers from './rpcservers.js'
 import BakerSettings from './bakersettings.js'
+import DelegatorRules from './delegatorrules.js'
 
 import ToasterContext from '../toaster.js';
 import { apiRequest } from '../util.js';
@@ -58,11 +61,26 @@ const Settings = (props) => {
 			<BakerSettings settings={settings} loadSettings={loadSettings} />
 		  </Col>
 		</Row>
-		<Row>
+		<Row className="mt-3">
+		  <Col>
+		    <DelegatorRules />
+		  </Col>
+		</Row>
+		<Row className="mt-3">
 		  <Col>
 		    <Notifications settings={settings} loadSettings={loadSettings} />
 		  </Col>
 		</Row>
+		<Row className="mt-3">
+		  <Col>
+		    <Routing settings={settings} loadSettings={loadSettings} />
+		  </Col>
+		</Row>
+		<Row className="mt-3">
+		  <Col>
+		    <NotificationHistory />
+		  </Col>
+		</Row>
 		</>
 	)
 }
@@ -0,0 +1,93 @@
+import React, { useState, useEffect } from 'react';
+
+import Button from 'react-bootstrap/Button';
+import Card from 'react-bootstrap/Card';
+import Form from 'react-bootstrap/Form'
+import Table from 'react-bootstrap/Table';
+
+import { apiRequest } from '../util.js';
+
+
+// Recent notifications, whether each was sent, failed or suppressed, and those waiting to be retried
+const NotificationHistory = () => {
+
+	const [history, setHistory] = useState([]);
+	const [pending, setPending] = useState([]);
+	const [status, setStatus] = useState("");
+	const [error, setError] = useState("");
+
+	const loadHistory = () => {
+		apiRequest(window.BASE_URL + "/api/notifications/history?limit=50&status=" + status)
+			.then((data) => {
+				setHistory(data.history || []);
+				setPending(data.pending || []);
+				setError("");
+			})
+			.catch((errMsg) => {
+				console.log(errMsg);
+				setError(errMsg);
+			});
+	}
+
+	useEffect(() => {
+		loadHistory();
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, [status]);
+
+	const statusClass = (s) => {
+		switch (s) {
+			case "sent": return "text-success";
+			case "failed": return "text-danger";
+			default: return "text-muted";
+		}
+	}
+
+	return (
+		<Card>
+		  <Card.Header as="h5">Notification History</Card.Header>
+		  <Card.Body>
+		    <Form inline className="mb-2">
+		      <Form.Control size="sm" as="select" value={status} onChange={(e) => setStatus(e.target.value)}>
+		        <option value="">All</option>
+		        <option value="sent">Sent</option>
+		        <option value="failed">Failed</option>
+		        <option value="suppressed">Suppressed</option>
+		      </Form.Control>
+		      <Button className="ml-2" variant="secondary" size="sm" onClick={loadHistory}>Refresh</Button>
+		    </Form>
+		    {error && <Card.Text className="text-danger">{error}</Card.Text>}
+		    {pending.length > 0 &&
+		    <Card.Text className="text-muted">
+		      Waiting to retry: {pending.map((p) => p.notifier + " (" + p.attempts + " attempts; " + p.lasterror + ")").join(", ")}
+		    </Card.Text>
+		    }
+		    <Table size="sm" responsive>
+		      <thead>
+		        <tr>
+		          <th>Time</th>
+		          <th>Notifier</th>
+		          <th>Category</th>
+		          <th>Message</th>
+		          <th>Status</th>
+		        </tr>
+		      </thead>
+		      <tbody>
+		        {history.map((h) =>
+		        <tr key={h.id}>
+		          <td>{new Date(h.ts).toLocaleString()}</td>
+		          <td>{h.notifier || "-"}</td>
+		          <td>{h.category}</td>
+		          <td>{h.message}</td>
+		          <td className={statusClass(h.status)} title={h.error || ""}>
+		            {h.status}{h.attempts > 1 ? " (" + h.attempts + " attempts)" : ""}
+		          </td>
+		        </tr>
+		        )}
+		      </tbody>
+		    </Table>
+		  </Card.Body>
+		</Card>
+	)
+}
+
+export default NotificationHistory
@@ -6,16 +6,20 @@ import Card from 'react-bootstrap/Card';
 import Form from 'react-bootstrap/Form'
 import Row from 'react-bootstrap/Row';
 
+import ChatNotifier from './chatnotifier.js';
 import ToasterContext from '../toaster.js';
 import { apiRequest } from '../util.js';
 
+const SECRET_MASK = "********"; // bakinbacon/notifications/secrets.go
+
 
 const Notifications = (props) => {
 
 	const { settings, loadSettings } = props;
 
 	const [telegramConfig, setTelegramConfig] = useState(settings.notifications.telegram);
-	// const [emailConfig, setEmailConfig] = useState(settings.notifications.email);
+	const [emailConfig, setEmailConfig] = useState(settings.notifications.email);
+	const [webhookConfig, setWebhookConfig] = useState(settings.notifications.webhook || {});
 	const addToast = useContext(ToasterContext);
 
 	useEffect(() => {
@@ -32,14 +36,39 @@ const Notifications = (props) => {
 			setTelegramConfig(tConfig)
 		}
 
-		// setEmailConfig(config.email)
+		const eConfig = config.email;
+		if (Array.isArray(eConfig.to)) {
+			eConfig.to = eConfig.to.join(',')
+		}
+		if (eConfig.to == null) {
+			eConfig.to = ""
+		}
+
+		if (Object.keys(eConfig).length !== 0) {
+			setEmailConfig(eConfig)
+		}
+
+		const wConfig = config.webhook || {};
+		if (Array.isArray(wConfig.urls)) {
+			wConfig.urls = wConfig.urls.join(',')
+		}
+		if (wConfig.urls == null) {
+			wConfig.urls = ""
+		}
+		if (typeof wConfig.headers === "object") {
+			wConfig.headers = Object.entries(wConfig.headers || {}).map(([k, v]) => k + ": " + v).join("\n")
+		}
+
+		if (Object.keys(wConfig).length !== 0) {
+			setWebhookConfig(wConfig)
+		}
 
 	}, [settings]);
 
 	const handleTelegramChange = (e) => {
 		let { name, value } = e.target;
-		if (name === "enabled") {
-			value = !telegramConfig.enabled
+		if (name === "enabled" || name === "commands" || name === "payoutcommands") {
+			value = !telegramConfig[name]
 		}
 		setTelegramConfig((prev) => ({
 			...prev,
@@ -47,13 +76,16 @@ const Notifications = (props) => {
 		}));
 	}
 
-	// const handleEmailChange = (e) => {
-	// 	const { name, value } = e.target;
-	// 	setEmailConfig((prev) => ({
-	// 		...prev,
-	// 		[name]: value
-	// 	}));
-	// }
+	const handleEmailChange = (e) => {
+		let { name, value } = e.target;
+		if (name === "enabled") {
+			value = !emailConfig.enabled
+		}
+		setEmailConfig((prev) => ({
+			...prev,
+			[name]: value
+		}));
+	}
 
 	const saveTelegram = (e) => {
 
@@ -74,7 +106,7 @@ const Notifications = (props) => {
 
 		const botapikey = telegramConfig.apikey;
 		const regex = new RegExp(/\d{9}:[0-9A-Za-z_-]{35}/);
-		if (!regex.test(botapikey)) {
+		if (botapikey !== SECRET_MASK && !regex.test(botapikey)) {
 			addToast({
 				title: "Invalid Bot API Key",
 				msg: "Provided API key does not match known pattern.",
@@ -90,6 +122,8 @@ const Notifications = (props) => {
 			chatids: chatIds,
 			apikey: botapikey,
 			enabled: telegramConfig.enabled,
+			commands: !!telegramConfig.commands,
+			payoutcommands: !!telegramConfig.payoutcommands,
 		};
 		handlePostAPI(apiUrl, postData).then(() => {
 			addToast({
@@ -101,6 +135,147 @@ const Notifications = (props) => {
 		})
 	}
 
+	const handleWebhookChange = (e) => {
+		let { name, value } = e.target;
+		if (name === "enabled") {
+			value = !webhookConfig.enabled
+		}
+		setWebhookConfig((prev) => ({
+			...prev,
+			[name]: value
+		}));
+	}
+
+	const saveWebhook = (e) => {
+
+		const urls = (webhookConfig.urls || "").split(/[ ,]/).filter((u) => u !== "");
+
+		// One "Name: value" per line
+		const headers = {};
+		const lines = (webhookConfig.headers || "").split("\n").filter((l) => l.trim() !== "");
+		for (let i = 0; i < lines.length; i++) {
+			const idx = lines[i].indexOf(":");
+			if (idx < 1) {
+				addToast({
+					title: "Invalid Header",
+					msg: "Headers must be one 'Name: value' per line.",
+					type: "danger",
+					autohide: 6000,
+				});
+				return;
+			}
+			headers[lines[i].slice(0, idx).trim()] = lines[i].slice(idx + 1).trim();
+		}
+
+		const apiUrl = window.BASE_URL + "/api/settings/notifiers/webhook"
+		const postData = {
+			urls: urls,
+			headers: headers,
+			template: webhookConfig.template || "",
+			secret: webhookConfig.secret || "",
+			retries: Number(webhookConfig.retries) || 0,
+			enabled: webhookConfig.enabled,
+		};
+		handlePostAPI(apiUrl, postData).then(() => {
+			addToast({
+				title: "Save Webhook Success",
+				msg: "Saved webhook config. Use 'Send Test' to check it.",
+				type: "success",
+				autohide: 3000,
+			});
+		})
+	}
+
+	const testNotifier = (name, title) => {
+
+		const apiUrl = window.BASE_URL + "/api/settings/notifiers/" + name + "/test"
+		const requestOptions = {
+			method: 'POST',
+		};
+
+		apiRequest(apiUrl, requestOptions)
+			.then(() => {
+				addToast({
+					title: title + " Test Sent",
+					msg: "The test message was accepted.",
+					type: "success",
+					autohide: 3000,
+				});
+			})
+			.catch((errMsg) => {
+				console.log(errMsg);
+				addToast({
+					title: title + " Test Failed",
+					msg: errMsg,
+					type: "danger",
+				});
+			});
+	}
+
+	const saveChatNotifier = (name, title) => (postData) => {
+		const apiUrl = window.BASE_URL + "/api/settings/notifiers/" + name
+		handlePostAPI(apiUrl, postData).then(() => {
+			addToast({
+				title: "Save " + title + " Success",
+				msg: "Saved " + title + " config. Use 'Send Test' to check it.",
+				type: "success",
+				autohide: 3000,
+			});
+		})
+	}
+
+	// Only those compiled in are shown
+	const chatNotifiers = [
+		{ name: "slack", title: "Slack", fields: [
+			{ name: "webhookurl", label: "Incoming Webhook URL", help: "https://hooks.slack.com/services/..." },
+		]},
+		{ name: "discord", title: "Discord", fields: [
+			{ name: "webhookurl", label: "Webhook URL", help: "https://discord.com/api/webhooks/..." },
+			{ name: "username", label: "Username", help: "Defaults to BakinBacon" },
+		]},
+		{ name: "matrix", title: "Matrix", fields: [
+			{ name: "homeserver", label: "Homeserver URL", help: "eg https://matrix.org" },
+			{ name: "accesstoken", label: "Access Token", type: "password" },
+			{ name: "roomids", label: "Room Ids", list: true, help: "eg !abc123:matrix.org; The user must have joined the rooms. Separate multiple rooms with ','" },
+		]},
+	].filter((c) => (settings.notifiers || []).includes(c.name));
+
+	const saveEmail = (e) => {
+
+		const to = emailConfig.to.split(/[ ,]/).filter((t) => t !== "");
+		const port = Number(emailConfig.smtpport);
+		if (isNaN(port) || port < 1 || port > 65535) {
+			addToast({
+				title: "Invalid SMTP Port",
+				msg: "SMTP port must be a number between 1 and 65535.",
+				type: "danger",
+				autohide: 6000,
+			});
+			return;
+		}
+
+		const apiUrl = window.BASE_URL + "/api/settings/notifiers/email"
+		const postData = {
+			smtphost: emailConfig.smtphost,
+			smtpport: port,
+			security: emailConfig.security || "starttls",
+			auth: emailConfig.auth || "",
+			username: emailConfig.username,
+			password: emailConfig.password,
+			from: emailConfig.from,
+			to: to,
+			enabled: emailConfig.enabled,
+		};
+		handlePostAPI(apiUrl, postData).then(() => {
+			addToast({
+				title: "Save Email Success",
+				msg: "Saved email config. Use 'Send Test' to check it.",
+				type: "success",
+				autohide: 3000,
+			});
+		})
+	}
+
 	// Add/Delete RPC, and Save Telegram/Email RPCs use POST and only care if failure.
 	// On 200 OK, refresh settings
 	const handlePostAPI = (url, data) => {
@@ -154,6 +329,12 @@ const Notifications = (props) => {
                         <Form.Check type="checkbox" name="enabled" defaultChecked={telegramConfig.enabled} onChange={handleTelegramChange} label="Enabled" />
                       </Form.Group>
                     </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Check type="checkbox" name="commands" defaultChecked={telegramConfig.commands} onChange={handleTelegramChange} label="Answer commands (/status, /rights, /balance, /payouts)" />
+                        <Form.Check type="checkbox" name="payoutcommands" defaultChecked={telegramConfig.payoutcommands} onChange={handleTelegramChange} label="Allow /sendpayouts, with confirmation" />
+                      </Form.Group>
+                    </Form.Row>
                     <Form.Row>
                       <Form.Group as={Col}>
                         <Button variant="primary" onClick={saveTelegram} type="button" size="sm">Save</Button>
@@ -166,15 +347,132 @@ const Notifications = (props) => {
                 <Card>
                   <Card.Header as="h5">Email</Card.Header>
                   <Card.Body>
+                    <Form.Row>
+                      <Form.Group as={Col} md="8">
+                        <Form.Text as="span">SMTP Host</Form.Text>
+                        <Form.Control type="text" name="smtphost" value={emailConfig.smtphost || ""} onChange={handleEmailChange} />
+                      </Form.Group>
+                      <Form.Group as={Col} md="4">
+                        <Form.Text as="span">Port</Form.Text>
+                        <Form.Control type="text" name="smtpport" value={emailConfig.smtpport || ""} onChange={handleEmailChange} />
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Text as="span">Security</Form.Text>
+                        <Form.Control as="select" name="security" value={emailConfig.security || "starttls"} onChange={handleEmailChange}>
+                          <option value="starttls">STARTTLS (587)</option>
+                          <option value="tls">TLS (465)</option>
+                          <option value="none">None (localhost only)</option>
+                        </Form.Control>
+                      </Form.Group>
+                      <Form.Group as={Col}>
+                        <Form.Text as="span">Authentication</Form.Text>
+                        <Form.Control as="select" name="auth" value={emailConfig.auth || ""} onChange={handleEmailChange}>
+                          <option value="">Automatic</option>
+                          <option value="plain">PLAIN</option>
+                          <option value="login">LOGIN</option>
+                        </Form.Control>
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Text as="span">Username</Form.Text>
+                        <Form.Control type="text" name="username" value={emailConfig.username || ""} onChange={handleEmailChange} />
+                      </Form.Group>
+                      <Form.Group as={Col}>
+                        <Form.Text as="span">Password</Form.Text>
+                        <Form.Control type="password" name="password" value={emailConfig.password || ""} onChange={handleEmailChange} />
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Text as="span">From</Form.Text>
+                        <Form.Control type="text" name="from" value={emailConfig.from || ""} onChange={handleEmailChange} />
+                        <Form.Text className="text-muted">Defaults to the username</Form.Text>
+                      </Form.Group>
+                    </Form.Row>
                     <Form.Row>
                       <Form.Group as={Col}>
-                        <Form.Text as="span">COMING SOON!</Form.Text>
+                        <Form.Text as="span">To</Form.Text>
+                        <Form.Control type="text" name="to" value={emailConfig.to || ""} onChange={handleEmailChange} />
+                        <Form.Text className="text-muted">Separate multiple addresses with ','</Form.Text>
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Check type="checkbox" name="enabled" defaultChecked={emailConfig.enabled} onChange={handleEmailChange} label="Enabled" />
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Button variant="primary" onClick={saveEmail} type="button" size="sm">Save</Button>{' '}
+                        <Button variant="secondary" onClick={() => testNotifier("email", "Email")} type="button" size="sm">Send Test</Button>
                       </Form.Group>
                     </Form.Row>
                   </Card.Body>
                 </Card>
               </Col>
             </Row>
+            <Row className="mt-3">
+              <Col md="6">
+                <Card>
+                  <Card.Header as="h5">Webhook</Card.Header>
+                  <Card.Body>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Text as="span">URLs</Form.Text>
+                        <Form.Control type="text" name="urls" value={webhookConfig.urls || ""} onChange={handleWebhookChange} />
+                        <Form.Text className="text-muted">Separate multiple URLs with ','</Form.Text>
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Text as="span">Headers</Form.Text>
+                        <Form.Control as="textarea" rows={2} name="headers" value={webhookConfig.headers || ""} onChange={handleWebhookChange} />
+                        <Form.Text className="text-muted">One 'Name: value' per line</Form.Text>
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Text as="span">Body Template</Form.Text>
+                        <Form.Control as="textarea" rows={3} name="template" value={webhookConfig.template || ""} onChange={handleWebhookChange} />
+                        <Form.Text className="text-muted">Optional Go template; Fields are .Category, .Message, .Level, .Cycle, .Delegate and .Timestamp. Empty sends them as JSON.</Form.Text>
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col} md="8">
+                        <Form.Text as="span">Signing Secret</Form.Text>
+                        <Form.Control type="password" name="secret" value={webhookConfig.secret || ""} onChange={handleWebhookChange} />
+                      </Form.Group>
+                      <Form.Group as={Col} md="4">
+                        <Form.Text as="span">Retries</Form.Text>
+                        <Form.Control type="text" name="retries" value={webhookConfig.retries || 0} onChange={handleWebhookChange} />
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Form.Check type="checkbox" name="enabled" defaultChecked={webhookConfig.enabled} onChange={handleWebhookChange} label="Enabled" />
+                      </Form.Group>
+                    </Form.Row>
+                    <Form.Row>
+                      <Form.Group as={Col}>
+                        <Button variant="primary" onClick={saveWebhook} type="button" size="sm">Save</Button>{' '}
+                        <Button variant="secondary" onClick={() => testNotifier("webhook", "Webhook")} type="button" size="sm">Send Test</Button>
+                      </Form.Group>
+                    </Form.Row>
+                  </Card.Body>
+                </Card>
+              </Col>
+            </Row>
+            <Row>
+              {chatNotifiers.map((c) =>
+              <Col md="6" className="mt-3" key={c.name}>
+                <ChatNotifier title={c.title} fields={c.fields} config={settings.notifications[c.name]}
+                  onSave={saveChatNotifier(c.name, c.title)} onTest={() => testNotifier(c.name, c.title)} />
+              </Col>
+              )}
+            </Row>
           </Card.Body>
         </Card>
         </>
@@ -0,0 +1,162 @@
+import React, { useState, useEffect, useContext } from 'react';
+
+import Button from 'react-bootstrap/Button';
+import Card from 'react-bootstrap/Card';
+import Form from 'react-bootstrap/Form'
+import Table from 'react-bootstrap/Table';
+
+import ToasterContext from '../toaster.js';
+import { apiRequest } from '../util.js';
+
+
+// Which notifiers get each category, the least severe level each notifier gets,
+// and how long repeats of a message are suppressed.
+const Routing = (props) => {
+
+	const { settings, loadSettings } = props;
+
+	const categories = settings.categories || [];
+	const notifiers = settings.notifiers || [];
+
+	const [routes, setRoutes] = useState({});
+	const [minLevels, setMinLevels] = useState({});
+	const [throttle, setThrottle] = useState({});
+	const addToast = useContext(ToasterContext);
+
+	useEffect(() => {
+		const routing = settings.routing || {};
+		setRoutes(routing.routes || {});
+		setMinLevels(routing.minlevels || {});
+
+		// Shown in minutes
+		const t = {};
+		Object.entries(routing.throttle || {}).forEach(([c, s]) => { t[c] = String(s / 60) });
+		setThrottle(t);
+	}, [settings]);
+
+	// A category without a route goes to every notifier
+	const isRouted = (category, notifier) => {
+		return !(category in routes) || routes[category].includes(notifier);
+	}
+
+	const toggleRoute = (category, notifier) => {
+		setRoutes((prev) => {
+			const current = (category in prev) ? prev[category] : notifiers;
+			const next = current.includes(notifier) ? current.filter((n) => n !== notifier) : [...current, notifier];
+			const updated = { ...prev, [category]: next };
+			if (notifiers.every((n) => next.includes(n))) {
+				delete updated[category];
+			}
+			return updated;
+		});
+	}
+
+	const save = () => {
+
+		const throttleSecs = {};
+		for (const [category, minutes] of Object.entries(throttle)) {
+			if (minutes === "") {
+				continue;
+			}
+			const m = Number(minutes);
+			if (isNaN(m) || m < 0) {
+				addToast({
+					title: "Invalid Throttle",
+					msg: "Throttle for " + category + " must be a number of minutes.",
+					type: "danger",
+					autohide: 6000,
+				});
+				return;
+			}
+			throttleSecs[category] = Math.round(m * 60);
+		}
+
+		const postData = {
+			...(settings.routing || {}),
+			routes: routes,
+			minlevels: minLevels,
+			throttle: throttleSecs,
+		};
+
+		const requestOptions = {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify(postData)
+		};
+
+		apiRequest(window.BASE_URL + "/api/settings/routing", requestOptions)
+			.then(() => {
+				loadSettings();
+				addToast({
+					title: "Save Routing Success",
+					msg: "Saved notification routing.",
+					type: "success",
+					autohide: 3000,
+				});
+			})
+			.catch((errMsg) => {
+				console.log(errMsg);
+				addToast({
+					title: "Settings Error",
+					msg: errMsg,
+					type: "danger",
+				});
+			});
+	}
+
+	return (
+		<Card>
+		  <Card.Header as="h5">Notification Routing</Card.Header>
+		  <Card.Body>
+		    <Card.Text>Choose which notifiers receive each category. Repeats of the same message are suppressed for the throttle time, 10 minutes if blank; A summary of suppressed repeats is sent afterwards.</Card.Text>
+		    <Table size="sm" responsive>
+		      <thead>
+		        <tr>
+		          <th>Category</th>
+		          {notifiers.map((n) => <th key={n}>{n}</th>)}
+		          <th>Throttle (min)</th>
+		        </tr>
+		      </thead>
+		      <tbody>
+		        {categories.map((c) =>
+		        <tr key={c}>
+		          <td>{c}</td>
+		          {notifiers.map((n) =>
+		          <td key={n}>
+		            <Form.Check type="checkbox" checked={isRouted(c, n)} onChange={() => toggleRoute(c, n)} />
+		          </td>
+		          )}
+		          <td>
+		            <Form.Control size="sm" type="text" value={throttle[c] || ""} placeholder="10"
+		              onChange={(e) => { const v = e.target.value; setThrottle((prev) => ({ ...prev, [c]: v })) }} />
+		          </td>
+		        </tr>
+		        )}
+		        <tr>
+		          <td>Minimum level</td>
+		          {notifiers.map((n) =>
+		          <td key={n}>
+		            <Form.Control size="sm" as="select" value={minLevels[n] || "info"}
+		              onChange={(e) => { const v = e.target.value; setMinLevels((prev) => ({ ...prev, [n]: v })) }}>
+		              <option value="info">info</option>
+		              <option value="warning">warning</option>
+		              <option value="error">error</option>
+		            </Form.Control>
+		          </td>
+		          )}
+		          <td></td>
+		        </tr>
+		      </tbody>
+		    </Table>
+		    {(settings.suppressed || []).length > 0 &&
+		    <Card.Text className="text-muted">
+		      Currently suppressed: {settings.suppressed.map((s) => s.suppressed + "x " + s.category + " '" + s.message + "'").join("; ")}
+		    </Card.Text>
+		    }
+		    <Button variant="primary" onClick={save} type="button" size="sm">Save</Button>
+		  </Card.Body>
+		</Card>
+	)
+}
+
+export default Routing
@@ -18,11 +18,25 @@ export const CHAINIDS = {
 	"hangzhounet": "NetXuXoGoLxNK6o",
 };
 
+// Fired when the API says we are no longer logged in
+export const UNAUTHORIZED_EVENT = "bakinbacon-unauthorized"
+
+// CSRF token of the current session; must accompany all state-changing API requests
+let csrfToken = "";
+
+export function setCsrfToken(token) {
+	csrfToken = token || "";
+}
+
 // Copied from https://github.com/github/fetch/issues/203#issuecomment-266034180
 function parseJSON(response) {
 
+	if (response.status === 401) {
+		window.dispatchEvent(new Event(UNAUTHORIZED_EVENT));
+	}
+
 	// No JSON to parse, return custom object
-	if (response.status !== 200 && response.status !== 400 && response.status !== 502) {
+	if (response.status !== 200 && response.status !== 400 && response.status !== 401 && response.status !== 403 && response.status !== 502) {
 		return new Promise((resolve) => resolve({
 			status: response.status,
 			ok: response.ok,
@@ -41,6 +55,15 @@ function parseJSON(response) {
 }
 
 export function apiRequest(url, options) {
+
+	// Send session cookie and CSRF token to our API, but not to external RPCs
+	if (url.startsWith(window.BASE_URL + "/api")) {
+		options = { ...options, credentials: 'include' };
+		if (options.method && options.method !== 'GET') {
+			options.headers = { ...options.headers, 'X-CSRF-Token': csrfToken };
+		}
+	}
+
 	return new Promise((resolve, reject) => {
 		fetch(url, options)
 			.then(parseJSON)
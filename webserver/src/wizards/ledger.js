@@ -30,7 +30,7 @@ const WizardLedger = (props) => {
 		setIsLoading(true);
 
 		const testLedgerApiUrl = window.BASE_URL + "/api/wizard/testLedger";
-		apiRequest(testLedgerApiUrl)
+		apiRequest(testLedgerApiUrl, { method: 'POST' })
 		.then((data) => {
 			// Ledger and baking app detected by BB; enable continue button
 			console.log(data);
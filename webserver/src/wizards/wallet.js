@@ -22,7 +22,7 @@ const WizardWallet = (props) => {
 	
 	const generateNewKey = () => {
 		const generateKeyApiUrl = window.BASE_URL + "/api/wizard/generateNewKey";
-		apiRequest(generateKeyApiUrl)
+		apiRequest(generateKeyApiUrl, { method: 'POST' })
 		.then((data) => {
 			setEdsk(data.edsk);
 			setPkh(data.pkh);
@@ -36,7 +36,7 @@ const WizardWallet = (props) => {
 	
 	const exitWizardWallet = () => {
 		const finishWizardApiUrl = window.BASE_URL + "/api/wizard/finishWallet";
-		apiRequest(finishWizardApiUrl)
+		apiRequest(finishWizardApiUrl, { method: 'POST' })
 		.then(() => {
 			// Ignore response body; just need 200 OK
 			// Call parent finish wizard to exit this sub-wizard
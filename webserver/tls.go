@@ -0,0 +1,271 @@
+package webserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SELF_SIGNED_CERT_FILE = "webui-cert.pem"
+	SELF_SIGNED_KEY_FILE  = "webui-key.pem"
+	SELF_SIGNED_VALIDITY  = 365 * 24 * time.Hour
+
+	// Regenerate the self-signed certificate when it is this close to expiring
+	SELF_SIGNED_RENEW_BEFORE = 7 * 24 * time.Hour
+)
+
+type TlsArgs struct {
+	Enabled bool
+
+	// If both empty, use a self-signed certificate persisted in DataDir
+	CertFile string
+	KeyFile  string
+	DataDir  string
+
+	// If set, clients must present a certificate signed by this CA
+	ClientCAFile string
+
+	// If > 0, plain HTTP on this port redirects to HTTPS
+	RedirectPort int
+}
+
+func (t *TlsArgs) Validate() error {
+
+	if !t.Enabled {
+		if t.ClientCAFile != "" || t.RedirectPort > 0 {
+			return errors.New("Client certificates and HTTP redirect require TLS")
+		}
+
+		return nil
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("Both TLS certificate and key must be provided")
+	}
+
+	return nil
+}
+
+// buildTlsConfig loads the server certificate, creating a self-signed one if none was
+// provided, and sets up client certificate verification if requested
+func buildTlsConfig(args TlsArgs, bindAddr string) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if args.CertFile == "" {
+
+		// Checked on each handshake, so it is renewed without a restart
+		selfSigned := &selfSignedCert{
+			certFile: filepath.Join(args.DataDir, SELF_SIGNED_CERT_FILE),
+			keyFile:  filepath.Join(args.DataDir, SELF_SIGNED_KEY_FILE),
+			bindAddr: bindAddr,
+		}
+
+		if _, err := selfSigned.GetCertificate(nil); err != nil {
+			return nil, err
+		}
+
+		tlsConfig.GetCertificate = selfSigned.GetCertificate
+
+	} else {
+
+		cert, err := tls.LoadX509KeyPair(args.CertFile, args.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to load TLS certificate")
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if args.ClientCAFile != "" {
+
+		caBytes, err := ioutil.ReadFile(args.ClientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read client CA")
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBytes) {
+			return nil, errors.New("No certificates found in client CA")
+		}
+
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		log.WithField("ClientCA", args.ClientCAFile).Info("Web UI requires client certificates")
+	}
+
+	return tlsConfig, nil
+}
+
+// selfSignedCert serves the self-signed certificate, regenerating it when it is close to expiring
+type selfSignedCert struct {
+	sync.Mutex
+
+	certFile, keyFile, bindAddr string
+
+	cert     *tls.Certificate
+	notAfter time.Time
+}
+
+func (s *selfSignedCert) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.cert != nil && time.Now().Add(SELF_SIGNED_RENEW_BEFORE).Before(s.notAfter) {
+		return s.cert, nil
+	}
+
+	if err := s.load(); err != nil {
+
+		// Keep serving the current one while it is still valid
+		if s.cert != nil && time.Now().Before(s.notAfter) {
+			log.WithError(err).Error("Unable to renew self-signed certificate")
+			return s.cert, nil
+		}
+
+		return nil, err
+	}
+
+	return s.cert, nil
+}
+
+func (s *selfSignedCert) load() error {
+
+	if err := ensureSelfSignedCert(s.certFile, s.keyFile, s.bindAddr); err != nil {
+		return errors.Wrap(err, "Unable to create self-signed certificate")
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load TLS certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return errors.Wrap(err, "Unable to parse TLS certificate")
+	}
+
+	cert.Leaf = leaf
+	s.cert, s.notAfter = &cert, leaf.NotAfter
+
+	return nil
+}
+
+// ensureSelfSignedCert generates a new self-signed certificate unless a valid one
+// already exists in certFile/keyFile
+func ensureSelfSignedCert(certFile, keyFile, bindAddr string) error {
+
+	if certBytes, err := ioutil.ReadFile(certFile); err == nil {
+
+		if block, _ := pem.Decode(certBytes); block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil &&
+				time.Now().Add(SELF_SIGNED_RENEW_BEFORE).Before(cert.NotAfter) {
+				return nil
+			}
+		}
+
+		log.WithField("Cert", certFile).Warn("Self-signed certificate invalid or expiring; Regenerating")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "Unable to generate key")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return errors.Wrap(err, "Unable to generate serial number")
+	}
+
+	notBefore := time.Now().Add(-1 * time.Hour)
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"BakinBacon"}, CommonName: "BakinBacon Web UI"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(SELF_SIGNED_VALIDITY),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	// Include the bind address, unless binding to all interfaces
+	if ip := net.ParseIP(bindAddr); ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if ip == nil && bindAddr != "localhost" {
+		template.DNSNames = append(template.DNSNames, bindAddr)
+	}
+
+	// Also include this machine's hostname
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create certificate")
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal key")
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		return errors.Wrap(err, "Unable to write key")
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0644); err != nil {
+		return errors.Wrap(err, "Unable to write certificate")
+	}
+
+	log.WithFields(log.Fields{
+		"Cert": certFile, "Expires": template.NotAfter.Format(time.RFC3339),
+	}).Info("Generated self-signed web UI certificate")
+
+	return nil
+}
+
+// newRedirectServer returns a plain HTTP server which redirects everything to HTTPS on httpsPort
+func newRedirectServer(bindAddr string, redirectPort, httpsPort int) *http.Server {
+
+	return &http.Server{
+		Addr: fmt.Sprintf("%s:%d", bindAddr, redirectPort),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+
+			target := fmt.Sprintf("https://%s%s", net.JoinHostPort(host, fmt.Sprintf("%d", httpsPort)), r.URL.RequestURI())
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		}),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+}
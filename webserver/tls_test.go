@@ -0,0 +1,95 @@
+package webserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate expiring at notAfter
+func writeCert(t *testing.T, certFile, keyFile string, notAfter time.Time) tls.Certificate {
+
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    notAfter.Add(-SELF_SIGNED_VALIDITY),
+		NotAfter:     notAfter,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, _ := x509.MarshalECPrivateKey(key)
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// A long running server renews the self-signed certificate, rather than serving it expired
+func TestSelfSignedCertRenewed(t *testing.T) {
+
+	dir := t.TempDir()
+
+	tlsConfig, err := buildTlsConfig(TlsArgs{Enabled: true, DataDir: dir}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if validFor := time.Until(cert.Leaf.NotAfter); validFor < SELF_SIGNED_VALIDITY-2*time.Hour {
+		t.Errorf("New certificate valid for %v", validFor)
+	}
+
+	// As if the server had been running for most of a year
+	certFile, keyFile := filepath.Join(dir, SELF_SIGNED_CERT_FILE), filepath.Join(dir, SELF_SIGNED_KEY_FILE)
+	expiring := writeCert(t, certFile, keyFile, time.Now().Add(24*time.Hour))
+
+	selfSigned := &selfSignedCert{certFile: certFile, keyFile: keyFile, bindAddr: "127.0.0.1"}
+	selfSigned.cert, selfSigned.notAfter = &expiring, time.Now().Add(24*time.Hour)
+
+	renewed, err := selfSigned.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Until(renewed.Leaf.NotAfter) < SELF_SIGNED_RENEW_BEFORE {
+		t.Fatalf("Certificate not renewed, expires %v", renewed.Leaf.NotAfter)
+	}
+
+	// Persisted, for the next start
+	if onDisk, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil || string(onDisk.Certificate[0]) != string(renewed.Certificate[0]) {
+		t.Errorf("Renewed certificate not saved: %v", err)
+	}
+}
@@ -16,8 +16,10 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"bakinbacon/baconclient"
+	"bakinbacon/events"
 	"bakinbacon/notifications"
 	"bakinbacon/payouts"
 	"bakinbacon/storage"
@@ -43,15 +45,20 @@ type TemplateVars struct {
 type WebServer struct {
 	// Global vars for the webserver package
 	httpSvr             *http.Server
+	redirectSvr         *http.Server
 	baconClient         *baconclient.BaconClient
 	notificationHandler *notifications.NotificationHandler
 	payoutsHandler      *payouts.PayoutsHandler
+	eventBus            *events.EventBus
 	storage             *storage.Storage
+	allowedOrigins      []string
+	shutdown            <-chan interface{}
 }
 
 type WebServerArgs struct {
 	Client              *baconclient.BaconClient
 	NotificationHandler *notifications.NotificationHandler
+	EventBus            *events.EventBus
 	PayoutsHandler      *payouts.PayoutsHandler
 	Storage             *storage.Storage
 
@@ -59,6 +66,11 @@ type WebServerArgs struct {
 	BindPort     int
 	TemplateVars TemplateVars
 
+	// Cross-origin requests are only allowed from these origins
+	AllowedOrigins []string
+
+	Tls TlsArgs
+
 	ShutdownChannel <-chan interface{}
 	WG              *sync.WaitGroup
 }
@@ -74,7 +86,15 @@ func Start(args WebServerArgs) error {
 		baconClient:         args.Client,
 		notificationHandler: args.NotificationHandler,
 		payoutsHandler:      args.PayoutsHandler,
+		eventBus:            args.EventBus,
 		storage:             args.Storage,
+		allowedOrigins:      args.AllowedOrigins,
+		shutdown:            args.ShutdownChannel,
+	}
+
+	// Brand new setups need an initial login
+	if err := ws.ensureAdminUser(); err != nil {
+		return errors.Wrap(err, "Could not create initial login")
 	}
 
 	// Repoint web ui down one directory
@@ -99,76 +119,156 @@ func Start(args WebServerArgs) error {
 		}
 	})
 
+	// Prometheus metrics; scrape using an API token
+	router.HandleFunc("/metrics", ws.requireRole(ROLE_VIEWER, promhttp.Handler().ServeHTTP)).Methods("GET")
+
 	// Root APIs
 	apiRouter := router.PathPrefix("/api").Subrouter()
-	apiRouter.HandleFunc("/status", ws.getStatus).Methods("GET")
-	apiRouter.HandleFunc("/delegate", ws.setDelegate).Methods("POST")
+	apiRouter.HandleFunc("/status", ws.requireRole(ROLE_VIEWER, ws.getStatus)).Methods("GET")
+	apiRouter.HandleFunc("/delegate", ws.requireRole(ROLE_ADMIN, ws.setDelegate)).Methods("POST")
 	apiRouter.HandleFunc("/health", ws.getHealth).Methods("GET")
+	apiRouter.HandleFunc("/events", ws.requireRole(ROLE_VIEWER, ws.streamEvents)).Methods("GET")
+
+	// Versioned API for automation; see openapi.json
+	ws.registerApiV1(apiRouter.PathPrefix("/v1").Subrouter())
+
+	// Authentication
+	authRouter := apiRouter.PathPrefix("/auth").Subrouter()
+	authRouter.HandleFunc("/login", ws.login).Methods("POST")
+	authRouter.HandleFunc("/logout", ws.logout).Methods("POST")
+	authRouter.HandleFunc("/me", ws.requireRole(ROLE_VIEWER, ws.getMe)).Methods("GET")
+	authRouter.HandleFunc("/password", ws.requireRole(ROLE_VIEWER, ws.changePassword)).Methods("POST")
+	authRouter.HandleFunc("/users", ws.requireRole(ROLE_ADMIN, ws.listUsers)).Methods("GET")
+	authRouter.HandleFunc("/users", ws.requireRole(ROLE_ADMIN, ws.saveUser)).Methods("POST")
+	authRouter.HandleFunc("/users/delete", ws.requireRole(ROLE_ADMIN, ws.deleteUser)).Methods("POST")
+	authRouter.HandleFunc("/tokens", ws.requireRole(ROLE_ADMIN, ws.listApiTokens)).Methods("GET")
+	authRouter.HandleFunc("/tokens", ws.requireRole(ROLE_ADMIN, ws.createApiToken)).Methods("POST")
+	authRouter.HandleFunc("/tokens/delete", ws.requireRole(ROLE_ADMIN, ws.deleteApiToken)).Methods("POST")
+
+	// Rights
+	apiRouter.HandleFunc("/rights", ws.requireRole(ROLE_VIEWER, ws.getRights)).Methods("GET")
+	apiRouter.HandleFunc("/rights/calendar.ics", ws.requireRole(ROLE_VIEWER, ws.getRightsCalendar)).Methods("GET")
+
+	// Performance history
+	apiRouter.HandleFunc("/history", ws.requireRole(ROLE_VIEWER, ws.getHistory)).Methods("GET")
+	apiRouter.HandleFunc("/history/summary", ws.requireRole(ROLE_VIEWER, ws.getHistorySummaries)).Methods("GET")
+
+	// Notification delivery
+	apiRouter.HandleFunc("/notifications/history", ws.requireRole(ROLE_VIEWER, ws.getNotificationHistory)).Methods("GET")
 
 	// Settings tab
 	settingsRouter := apiRouter.PathPrefix("/settings").Subrouter()
-	settingsRouter.HandleFunc("/", ws.getSettings).Methods("GET")
-	settingsRouter.HandleFunc("/savetelegram", ws.saveTelegram).Methods("POST")
-	settingsRouter.HandleFunc("/saveemail", ws.saveEmail).Methods("POST")
-	settingsRouter.HandleFunc("/addendpoint", ws.addEndpoint).Methods("POST")
-	settingsRouter.HandleFunc("/listendpoints", ws.listEndpoints).Methods("GET")
-	settingsRouter.HandleFunc("/deleteendpoint", ws.deleteEndpoint).Methods("POST")
-	settingsRouter.HandleFunc("/bakersettings", ws.saveBakerSettings).Methods("POST")
+	settingsRouter.HandleFunc("/", ws.requireRole(ROLE_VIEWER, ws.getSettings)).Methods("GET")
+	settingsRouter.HandleFunc("/savetelegram", ws.requireRole(ROLE_ADMIN, ws.saveTelegram)).Methods("POST")
+	settingsRouter.HandleFunc("/saveemail", ws.requireRole(ROLE_ADMIN, ws.saveEmail)).Methods("POST")
+	settingsRouter.HandleFunc("/notifiers/{notifier}", ws.requireRole(ROLE_ADMIN, ws.saveNotifier)).Methods("POST")
+	settingsRouter.HandleFunc("/notifiers/{notifier}/test", ws.requireRole(ROLE_ADMIN, ws.testNotifier)).Methods("POST")
+	settingsRouter.HandleFunc("/routing", ws.requireRole(ROLE_ADMIN, ws.saveRouting)).Methods("POST")
+	settingsRouter.HandleFunc("/addendpoint", ws.requireRole(ROLE_ADMIN, ws.addEndpoint)).Methods("POST")
+	settingsRouter.HandleFunc("/listendpoints", ws.requireRole(ROLE_VIEWER, ws.listEndpoints)).Methods("GET")
+	settingsRouter.HandleFunc("/deleteendpoint", ws.requireRole(ROLE_ADMIN, ws.deleteEndpoint)).Methods("POST")
+	settingsRouter.HandleFunc("/bakersettings", ws.requireRole(ROLE_ADMIN, ws.saveBakerSettings)).Methods("POST")
 
 	// Payouts tab
 	payoutsRouter := apiRouter.PathPrefix("/payouts").Subrouter()
-	payoutsRouter.HandleFunc("/list", ws.getPayouts).Methods("GET")
-	payoutsRouter.HandleFunc("/cycledetail", ws.getCyclePayouts).Methods("GET")
-	payoutsRouter.HandleFunc("/sendpayouts", ws.sendCyclePayouts).Methods("POST")
+	payoutsRouter.HandleFunc("/list", ws.requireRole(ROLE_VIEWER, ws.getPayouts)).Methods("GET")
+	payoutsRouter.HandleFunc("/cycledetail", ws.requireRole(ROLE_VIEWER, ws.getCyclePayouts)).Methods("GET")
+	payoutsRouter.HandleFunc("/sendpayouts", ws.requireRole(ROLE_OPERATOR, ws.sendCyclePayouts)).Methods("POST")
+	payoutsRouter.HandleFunc("/rules", ws.requireRole(ROLE_VIEWER, ws.getDelegatorRules)).Methods("GET")
+	payoutsRouter.HandleFunc("/saverule", ws.requireRole(ROLE_ADMIN, ws.saveDelegatorRule)).Methods("POST")
+	payoutsRouter.HandleFunc("/deleterule", ws.requireRole(ROLE_ADMIN, ws.deleteDelegatorRule)).Methods("POST")
 
 	// Voting tab
 	votingRouter := apiRouter.PathPrefix("/voting").Subrouter()
-	votingRouter.HandleFunc("/upvote", ws.handleUpvote).Methods("POST", "OPTIONS")
+	votingRouter.HandleFunc("/upvote", ws.requireRole(ROLE_OPERATOR, ws.handleUpvote)).Methods("POST", "OPTIONS")
 
 	// Setup wizards
 	wizardRouter := apiRouter.PathPrefix("/wizard").Subrouter()
-	wizardRouter.HandleFunc("/testLedger", ws.testLedger)
-	wizardRouter.HandleFunc("/confirmBakingPkh", ws.confirmBakingPkh)
-	wizardRouter.HandleFunc("/generateNewKey", ws.generateNewKey)
-	wizardRouter.HandleFunc("/importKey", ws.importSecretKey).Methods("POST", "OPTIONS")
-	wizardRouter.HandleFunc("/registerBaker", ws.registerBaker).Methods("POST", "OPTIONS")
-	wizardRouter.HandleFunc("/finishWallet", ws.finishWalletWizard)
+	wizardRouter.HandleFunc("/testLedger", ws.requireRole(ROLE_ADMIN, ws.testLedger)).Methods("POST", "OPTIONS")
+	wizardRouter.HandleFunc("/confirmBakingPkh", ws.requireRole(ROLE_ADMIN, ws.confirmBakingPkh)).Methods("POST", "OPTIONS")
+	wizardRouter.HandleFunc("/generateNewKey", ws.requireRole(ROLE_ADMIN, ws.generateNewKey)).Methods("POST", "OPTIONS")
+	wizardRouter.HandleFunc("/importKey", ws.requireRole(ROLE_ADMIN, ws.importSecretKey)).Methods("POST", "OPTIONS")
+	wizardRouter.HandleFunc("/registerBaker", ws.requireRole(ROLE_ADMIN, ws.registerBaker)).Methods("POST", "OPTIONS")
+	wizardRouter.HandleFunc("/finishWallet", ws.requireRole(ROLE_ADMIN, ws.finishWalletWizard)).Methods("POST", "OPTIONS")
 
 	// For static content (js, images)
 	router.PathPrefix("/static/").Handler(http.FileServer(http.FS(staticContent)))
 
+	// The event stream is long-lived, and backups stream the whole DB, which TimeoutHandler
+	// would buffer and cut off; all other requests time out
+	untimedPaths := map[string]bool{
+		"/api/events":    true,
+		"/api/v1/backup": true,
+		"/api/v1/export": true,
+	}
+
+	timeoutRouter := http.TimeoutHandler(router, 15*time.Second, `{"error":"Request timed out"}`)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if untimedPaths[r.URL.Path] {
+			router.ServeHTTP(w, r)
+			return
+		}
+		timeoutRouter.ServeHTTP(w, r)
+	})
+
 	// Make the http server
 	httpAddr := fmt.Sprintf("%s:%d", args.BindAddr, args.BindPort)
 	ws.httpSvr = &http.Server{
 		Handler: handlers.CORS(
-			handlers.AllowedHeaders([]string{"Content-Type"}),
-			handlers.AllowedOrigins([]string{"*"}),
-			handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS"}),
-		)(router),
-		Addr:         httpAddr,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+			handlers.AllowedHeaders([]string{"Content-Type", "Authorization", CSRF_HEADER}),
+			handlers.AllowedOriginValidator(ws.isOriginAllowed),
+			handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+			handlers.AllowCredentials(),
+		)(ws.checkOrigin(handler)),
+		Addr:        httpAddr,
+		ReadTimeout: 15 * time.Second,
+	}
+
+	if args.Tls.Enabled {
+		ws.httpSvr.TLSConfig, err = buildTlsConfig(args.Tls, args.BindAddr)
+		if err != nil {
+			return errors.Wrap(err, "Could not set up TLS")
+		}
+
+		// Plain HTTP redirects to HTTPS
+		if args.Tls.RedirectPort > 0 {
+			ws.redirectSvr = newRedirectServer(args.BindAddr, args.Tls.RedirectPort, args.BindPort)
+		}
 	}
 
-	log.WithField("Addr", httpAddr).Info("Bakin'Bacon WebUI Listening")
+	log.WithFields(log.Fields{
+		"Addr": httpAddr, "TLS": args.Tls.Enabled,
+	}).Info("Bakin'Bacon WebUI Listening")
 
 	// Launch webserver in background
 	args.WG.Add(1)
 	go func() {
-		// TODO: SSL for localhost?
-		// var err error
-		// if wantSSL {
-		//   err = httpSvr.ListenAndServeTLS("ssl/cert.pem", "ssl/key.pem")
-		// } else {
-		//   err = httpSvr.ListenAndServe()
-		// }
-		if err := ws.httpSvr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if args.Tls.Enabled {
+			// Certificates are already in TLSConfig
+			err = ws.httpSvr.ListenAndServeTLS("", "")
+		} else {
+			err = ws.httpSvr.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
 			log.WithError(err).Errorf("Httpserver: ListenAndServe()")
 		}
 
 		log.Info("Httpserver: Shutdown")
 	}()
 
+	if ws.redirectSvr != nil {
+
+		log.WithField("Addr", ws.redirectSvr.Addr).Info("Redirecting HTTP to HTTPS")
+
+		go func() {
+			if err := ws.redirectSvr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Errorf("Httpserver: Redirect ListenAndServe()")
+			}
+		}()
+	}
+
 	// Wait for shutdown signal on channel
 	go func() {
 		defer args.WG.Done()
@@ -180,6 +280,12 @@ func Start(args WebServerArgs) error {
 		if err := ws.httpSvr.Shutdown(ctx); err != nil {
 			log.WithError(err).Errorf("Httpserver: Shutdown()")
 		}
+
+		if ws.redirectSvr != nil {
+			if err := ws.redirectSvr.Shutdown(ctx); err != nil {
+				log.WithError(err).Errorf("Httpserver: Redirect Shutdown()")
+			}
+		}
 	}()
 
 	return nil
@@ -190,6 +296,11 @@ func apiError(err error, w http.ResponseWriter) {
 	http.Error(w, string(e), http.StatusBadRequest)
 }
 
+func apiErrorStatus(err error, w http.ResponseWriter, status int) {
+	e, _ := json.Marshal(ApiError{err.Error()})
+	http.Error(w, string(e), status)
+}
+
 func apiReturnOk(w http.ResponseWriter) {
 	if err := json.NewEncoder(w).Encode(map[string]string{"ok": "ok"}); err != nil {
 		log.WithError(err).Error("UI Return Encode Failure")
@@ -202,6 +313,14 @@ func (a *WebServerArgs) Validate() error {
 		return errors.New("BaconClient is not instantiated")
 	}
 
+	if a.EventBus == nil {
+		return errors.New("EventBus is not instantiated")
+	}
+
+	if a.Storage == nil {
+		return errors.New("Storage is not instantiated")
+	}
+
 	if a.BindAddr == "" {
 		return errors.New("Bind address empty")
 	}
@@ -214,5 +333,9 @@ func (a *WebServerArgs) Validate() error {
 		return errors.New("WaitGroup is not instantiated")
 	}
 
+	if err := a.Tls.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }